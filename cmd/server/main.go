@@ -1,9 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"zpe-cloud-user-management-service/config"
+	"zpe-cloud-user-management-service/internal/idempotency"
+	"zpe-cloud-user-management-service/internal/ids"
+	"zpe-cloud-user-management-service/internal/jwt"
+	"zpe-cloud-user-management-service/internal/mail"
+	"zpe-cloud-user-management-service/internal/metrics"
+	"zpe-cloud-user-management-service/internal/policy"
+	"zpe-cloud-user-management-service/internal/ratelimit"
+	"zpe-cloud-user-management-service/internal/token"
 	"zpe-cloud-user-management-service/internal/user"
 )
 
@@ -11,17 +21,164 @@ func main() {
 	cfg := config.LoadConfig()
 
 	user.InitializeStorage()
+	idGenerator, err := ids.New(cfg.UserIDGenerator)
+	if err != nil {
+		log.Fatalf("Invalid USER_ID_GENERATOR: %v", err)
+	}
+	user.SetIDGenerator(idGenerator)
+	user.SetEmailPolicy(user.EmailPolicy{
+		AllowedDomains: cfg.EmailAllowedDomains,
+		DeniedDomains:  cfg.EmailDeniedDomains,
+		ProviderRules:  cfg.EmailProviderRules,
+	})
+	if err := user.SetRoleApprovalPolicy(user.RoleApprovalPolicy{
+		Threshold: cfg.RoleApprovalThreshold,
+		TTL:       cfg.RoleChangeRequestTTL,
+	}); err != nil {
+		log.Fatalf("Invalid ROLE_APPROVAL_THRESHOLD: %v", err)
+	}
+	mailer, err := mail.New(mail.Config{
+		Kind:         cfg.MailSender,
+		From:         cfg.MailFrom,
+		Dir:          cfg.MailDir,
+		SMTPAddr:     cfg.SMTPAddr,
+		SMTPUsername: cfg.SMTPUsername,
+		SMTPPassword: cfg.SMTPPassword,
+	})
+	if err != nil {
+		log.Fatalf("Invalid MAIL_SENDER: %v", err)
+	}
+	user.SetMailer(mailer)
+	if cfg.TokenSigningKey != "" {
+		user.SetTokenSigner(token.NewSigner([]byte(cfg.TokenSigningKey)))
+	} else {
+		log.Printf("TOKEN_SIGNING_KEY is not set; emailed links will stop working after a restart")
+	}
+	user.SetInvitationConfig(user.InvitationConfig{
+		Enabled:   cfg.UserInvitations,
+		TTL:       cfg.InvitationTTL,
+		AcceptURL: cfg.InvitationAcceptURL,
+	})
+	user.SetRecoveryConfig(user.RecoveryConfig{
+		VerificationTTL: cfg.EmailVerificationTTL,
+		VerificationURL: cfg.EmailVerificationURL,
+		ResetTTL:        cfg.PasswordResetTTL,
+		ResetURL:        cfg.PasswordResetURL,
+		ResetLimit:      cfg.PasswordResetLimit,
+		ResetWindow:     cfg.PasswordResetWindow,
+	})
+	if err := user.SetMFAPolicy(user.MFAPolicy{RequiredRoles: cfg.MFARequiredRoles, Issuer: cfg.MFAIssuer}); err != nil {
+		log.Fatalf("Invalid MFA_REQUIRED_ROLES: %v", err)
+	}
+	user.SetSessionConfig(user.SessionConfig{TTL: cfg.SessionTTL, IdleTimeout: cfg.SessionIdleTimeout})
+	user.SetImpersonationConfig(user.ImpersonationConfig{MaxTTL: cfg.ImpersonationMaxTTL})
+	user.SetLockoutPolicy(user.LockoutPolicy{
+		AccountThreshold: cfg.LoginLockoutThreshold,
+		AccountLockout:   cfg.LoginLockoutDuration,
+		IPThreshold:      cfg.LoginIPThreshold,
+		IPWindow:         cfg.LoginIPWindow,
+		IPLockout:        cfg.LoginIPBlockDuration,
+		Delay:            cfg.LoginDelay,
+		MaxDelay:         cfg.LoginMaxDelay,
+	})
+	if cfg.OIDCIssuer != "" {
+		var clients []user.OIDCClient
+		if cfg.OIDCClients != "" {
+			if err := json.Unmarshal([]byte(cfg.OIDCClients), &clients); err != nil {
+				log.Fatalf("Invalid OIDC_CLIENTS: %v", err)
+			}
+		}
+		// Retired keys are kept as long as the tokens they signed can be valid.
+		keys, err := jwt.NewKeySet(cfg.OIDCTokenTTL)
+		if err != nil {
+			log.Fatalf("Failed to generate OIDC signing key: %v", err)
+		}
+		user.SetOIDCProvider(user.OIDCConfig{Issuer: cfg.OIDCIssuer, Clients: clients, TokenTTL: cfg.OIDCTokenTTL}, keys)
+		user.StartOIDCKeyRotation(context.Background(), keys, cfg.OIDCKeyRotationInterval)
+	}
+	if cfg.FederationProviders != "" {
+		var providers []user.FederationProvider
+		if err := json.Unmarshal([]byte(cfg.FederationProviders), &providers); err != nil {
+			log.Fatalf("Invalid FEDERATION_PROVIDERS: %v", err)
+		}
+		if err := user.SetFederationProviders(providers, nil); err != nil {
+			log.Fatalf("Invalid FEDERATION_PROVIDERS: %v", err)
+		}
+	}
+	if cfg.PolicyFile != "" {
+		engine, err := policy.Load(cfg.PolicyFile)
+		if err != nil {
+			log.Fatalf("Invalid POLICY_FILE: %v", err)
+		}
+		user.SetPolicyEngine(engine)
+		engine.Watch(context.Background(), cfg.PolicyReloadInterval)
+	}
+	user.StartPurger(context.Background(), cfg.PurgeInterval, cfg.DeletedUserRetention)
+	user.StartRoleGrantSweeper(context.Background(), cfg.RoleGrantSweepInterval)
+
+	rateLimitRules, err := ratelimit.ParseRules(cfg.RateLimitRules)
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMIT_RULES: %v", err)
+	}
+	limiter, err := ratelimit.New(ratelimit.Limit{Rate: cfg.RateLimitRate, Burst: cfg.RateLimitBurst}, rateLimitRules)
+	if err != nil {
+		log.Fatalf("Invalid rate limits: %v", err)
+	}
+	addressLimiter, err := ratelimit.New(ratelimit.Limit{Rate: cfg.RateLimitIPRate, Burst: cfg.RateLimitIPBurst}, nil)
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMIT_IP_BURST: %v", err)
+	}
 
 	mux := http.NewServeMux()
 
-	setupRoutes(mux)
+	setupRoutes(mux, idempotency.NewStore(cfg.IdempotencyTTL))
+
+	// Metrics are only served on the internal address, never next to the API.
+	if cfg.MetricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		go func() {
+			log.Printf("Metrics served on %s", cfg.MetricsAddr)
+			log.Fatal(http.ListenAndServe(cfg.MetricsAddr, metricsMux))
+		}()
+	}
 
 	log.Printf("Server running on port %s", cfg.ServerPort)
-	log.Fatal(http.ListenAndServe(":"+cfg.ServerPort, mux))
+	log.Fatal(http.ListenAndServe(":"+cfg.ServerPort, addressLimiter.AddressMiddleware(user.AuthMiddleware(limiter.Middleware(mux)))))
 }
 
-func setupRoutes(mux *http.ServeMux) {
-	mux.Handle("/users", http.HandlerFunc(user.HandleUsers))
-	mux.Handle("/users/", http.HandlerFunc(user.HandleUser))
-	mux.Handle("/users/roles/", http.HandlerFunc(user.HandleUserRoles))
+func setupRoutes(mux *http.ServeMux, idempotencyStore *idempotency.Store) {
+	mux.Handle("/users", idempotencyStore.Middleware(http.HandlerFunc(user.HandleUsers)))
+	mux.Handle("/users/", idempotencyStore.Middleware(http.HandlerFunc(user.HandleUser)))
+	mux.Handle("/users/roles/", idempotencyStore.Middleware(http.HandlerFunc(user.HandleUserRoles)))
+	mux.Handle("/orgs", idempotencyStore.Middleware(http.HandlerFunc(user.HandleOrganizations)))
+	mux.Handle("/orgs/", idempotencyStore.Middleware(http.HandlerFunc(user.HandleOrganization)))
+	mux.Handle("/role-grants/", http.HandlerFunc(user.HandleRoleGrants))
+	mux.Handle("/role-change-requests", http.HandlerFunc(user.HandleRoleChangeRequests))
+	mux.Handle("/role-change-requests/", idempotencyStore.Middleware(http.HandlerFunc(user.HandleRoleChangeRequest)))
+	mux.Handle("/invitations", http.HandlerFunc(user.HandleInvitations))
+	mux.Handle("/invitations/", idempotencyStore.Middleware(http.HandlerFunc(user.HandleInvitation)))
+	mux.Handle("/email-verification/", http.HandlerFunc(user.HandleEmailVerification))
+	mux.Handle("/password-reset", http.HandlerFunc(user.HandlePasswordReset))
+	mux.Handle("/password-reset/", http.HandlerFunc(user.HandlePasswordReset))
+	mux.Handle("/login", http.HandlerFunc(user.HandleLogin))
+	mux.Handle("/.well-known/openid-configuration", http.HandlerFunc(user.HandleOIDCDiscovery))
+	mux.Handle("/oauth2/jwks", http.HandlerFunc(user.HandleJWKS))
+	mux.Handle("/oauth2/authorize", http.HandlerFunc(user.HandleAuthorize))
+	mux.Handle("/oauth2/token", http.HandlerFunc(user.HandleToken))
+	mux.Handle("/oauth2/userinfo", http.HandlerFunc(user.HandleUserInfo))
+	mux.Handle("/federation", http.HandlerFunc(user.HandleFederation))
+	mux.Handle("/federation/", http.HandlerFunc(user.HandleFederation))
+	mux.Handle("/service-accounts", idempotencyStore.Middleware(http.HandlerFunc(user.HandleServiceAccounts)))
+	mux.Handle("/service-accounts/", idempotencyStore.Middleware(http.HandlerFunc(user.HandleServiceAccount)))
+	mux.Handle("/scim/v2/", http.HandlerFunc(user.HandleSCIM))
+	mux.Handle("/groups", idempotencyStore.Middleware(http.HandlerFunc(user.HandleGroups)))
+	mux.Handle("/groups/", idempotencyStore.Middleware(http.HandlerFunc(user.HandleGroup)))
+	mux.Handle("/attributes", http.HandlerFunc(user.HandleAttributes))
+	mux.Handle("/attributes/", http.HandlerFunc(user.HandleAttribute))
+	mux.Handle("/access-reviews", http.HandlerFunc(user.HandleAccessReviews))
+	mux.Handle("/access-reviews/", http.HandlerFunc(user.HandleAccessReview))
+	mux.Handle("/authz/check", http.HandlerFunc(user.HandleAuthzCheck))
+	mux.Handle("/ip-blocks", http.HandlerFunc(user.HandleIPBlocks))
+	mux.Handle("/ip-blocks/", http.HandlerFunc(user.HandleIPBlocks))
 }
@@ -4,10 +4,93 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	ServerPort string
+	ServerPort           string
+	DeletedUserRetention time.Duration
+	PurgeInterval        time.Duration
+	IdempotencyTTL       time.Duration
+	UserIDGenerator      string
+	EmailAllowedDomains  []string
+	EmailDeniedDomains   []string
+	EmailProviderRules   bool
+	// RoleGrantSweepInterval controls how often expired time-bound role grants are removed.
+	RoleGrantSweepInterval time.Duration
+	// RoleApprovalThreshold is the lowest role whose assignment needs a second Admin's
+	// approval. Empty disables the approval workflow.
+	RoleApprovalThreshold string
+	RoleChangeRequestTTL  time.Duration
+	// UserInvitations makes POST /users invite new users by email instead of activating them.
+	UserInvitations     bool
+	InvitationTTL       time.Duration
+	InvitationAcceptURL string
+	// TokenSigningKey signs the tokens in emailed links. A random key is used when empty.
+	TokenSigningKey string
+	// MailSender is "stdout", "file" or "smtp"; the file sender writes messages into MailDir.
+	MailSender   string
+	MailFrom     string
+	MailDir      string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	// EmailVerificationURL and PasswordResetURL receive the token as their "token" query parameter.
+	EmailVerificationTTL time.Duration
+	EmailVerificationURL string
+	PasswordResetTTL     time.Duration
+	PasswordResetURL     string
+	// PasswordResetLimit is the number of reset emails per address within PasswordResetWindow.
+	PasswordResetLimit  int
+	PasswordResetWindow time.Duration
+	// MFARequiredRoles lists the roles that cannot log in without multi-factor authentication.
+	MFARequiredRoles []string
+	MFAIssuer        string
+	// OIDCIssuer is the public base URL of the OpenID Connect provider. Empty disables it.
+	OIDCIssuer string
+	// OIDCClients is a JSON array of {"client_id", "client_secret", "redirect_uris"} objects.
+	OIDCClients             string
+	OIDCTokenTTL            time.Duration
+	OIDCKeyRotationInterval time.Duration
+	// FederationProviders is a JSON array of upstream OpenID Connect providers users can
+	// log in with; see user.FederationProvider for the fields.
+	FederationProviders string
+	// SessionTTL is the absolute lifetime of a login session; SessionIdleTimeout ends
+	// sessions that have not been used for that long.
+	SessionTTL         time.Duration
+	SessionIdleTimeout time.Duration
+	// LoginLockoutThreshold consecutive failed logins lock an account for
+	// LoginLockoutDuration. LoginIPThreshold failed logins from one address within
+	// LoginIPWindow block it for LoginIPBlockDuration. After a failed login the account
+	// must wait LoginDelay, doubling with each further failure up to LoginMaxDelay.
+	LoginLockoutThreshold int
+	LoginLockoutDuration  time.Duration
+	LoginIPThreshold      int
+	LoginIPWindow         time.Duration
+	LoginIPBlockDuration  time.Duration
+	LoginDelay            time.Duration
+	LoginMaxDelay         time.Duration
+	// ImpersonationMaxTTL is the longest an impersonation session can last.
+	ImpersonationMaxTTL time.Duration
+	// RateLimitRate and RateLimitBurst are the token bucket each caller gets per client
+	// address: RateLimitBurst requests at once, refilled at RateLimitRate per second.
+	// RateLimitRules is a JSON array of per-route and per-role overrides.
+	RateLimitRate  float64
+	RateLimitBurst int
+	RateLimitRules string
+	// RateLimitIPRate and RateLimitIPBurst limit each client address before
+	// authentication, whatever identity its requests claim.
+	RateLimitIPRate  float64
+	RateLimitIPBurst int
+	// PolicyFile is a JSON file of attribute-based access rules applied after the role
+	// checks. Empty disables the policy. It is reloaded every PolicyReloadInterval when
+	// it changes.
+	PolicyFile           string
+	PolicyReloadInterval time.Duration
+	// MetricsAddr is the internal address /metrics is served on, apart from the API.
+	// Empty disables it.
+	MetricsAddr string
 	// Others can be added here
 }
 
@@ -22,6 +105,128 @@ func LoadConfig() Config {
 	}
 
 	return Config{
-		ServerPort: port,
+		ServerPort:              port,
+		DeletedUserRetention:    durationEnv("DELETED_USER_RETENTION", 30*24*time.Hour),
+		PurgeInterval:           durationEnv("PURGE_INTERVAL", time.Hour),
+		IdempotencyTTL:          durationEnv("IDEMPOTENCY_TTL", 24*time.Hour),
+		UserIDGenerator:         stringEnv("USER_ID_GENERATOR", "uuidv7"),
+		EmailAllowedDomains:     listEnv("EMAIL_ALLOWED_DOMAINS"),
+		EmailDeniedDomains:      listEnv("EMAIL_DENIED_DOMAINS"),
+		EmailProviderRules:      boolEnv("EMAIL_PROVIDER_RULES", false),
+		RoleGrantSweepInterval:  durationEnv("ROLE_GRANT_SWEEP_INTERVAL", time.Minute),
+		RoleApprovalThreshold:   os.Getenv("ROLE_APPROVAL_THRESHOLD"),
+		RoleChangeRequestTTL:    durationEnv("ROLE_CHANGE_REQUEST_TTL", 72*time.Hour),
+		UserInvitations:         boolEnv("USER_INVITATIONS", false),
+		InvitationTTL:           durationEnv("INVITATION_TTL", 7*24*time.Hour),
+		InvitationAcceptURL:     stringEnv("INVITATION_ACCEPT_URL", "http://localhost:"+port+"/invitations/accept"),
+		TokenSigningKey:         os.Getenv("TOKEN_SIGNING_KEY"),
+		MailSender:              stringEnv("MAIL_SENDER", "stdout"),
+		MailFrom:                stringEnv("MAIL_FROM", "noreply@localhost"),
+		MailDir:                 stringEnv("MAIL_DIR", "mail"),
+		SMTPAddr:                os.Getenv("SMTP_ADDR"),
+		SMTPUsername:            os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:            os.Getenv("SMTP_PASSWORD"),
+		EmailVerificationTTL:    durationEnv("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		EmailVerificationURL:    stringEnv("EMAIL_VERIFICATION_URL", "http://localhost:"+port+"/email-verification/confirm"),
+		PasswordResetTTL:        durationEnv("PASSWORD_RESET_TTL", time.Hour),
+		PasswordResetURL:        stringEnv("PASSWORD_RESET_URL", "http://localhost:"+port+"/password-reset/confirm"),
+		PasswordResetLimit:      intEnv("PASSWORD_RESET_LIMIT", 3),
+		PasswordResetWindow:     durationEnv("PASSWORD_RESET_WINDOW", time.Hour),
+		MFARequiredRoles:        listEnv("MFA_REQUIRED_ROLES"),
+		MFAIssuer:               stringEnv("MFA_ISSUER", "ZPE Cloud"),
+		OIDCIssuer:              os.Getenv("OIDC_ISSUER"),
+		OIDCClients:             os.Getenv("OIDC_CLIENTS"),
+		OIDCTokenTTL:            durationEnv("OIDC_TOKEN_TTL", time.Hour),
+		OIDCKeyRotationInterval: durationEnv("OIDC_KEY_ROTATION_INTERVAL", 30*24*time.Hour),
+		FederationProviders:     os.Getenv("FEDERATION_PROVIDERS"),
+		SessionTTL:              durationEnv("SESSION_TTL", 12*time.Hour),
+		SessionIdleTimeout:      durationEnv("SESSION_IDLE_TIMEOUT", time.Hour),
+		LoginLockoutThreshold:   intEnv("LOGIN_LOCKOUT_THRESHOLD", 5),
+		LoginLockoutDuration:    durationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginIPThreshold:        intEnv("LOGIN_IP_THRESHOLD", 20),
+		LoginIPWindow:           durationEnv("LOGIN_IP_WINDOW", 15*time.Minute),
+		LoginIPBlockDuration:    durationEnv("LOGIN_IP_BLOCK_DURATION", 15*time.Minute),
+		LoginDelay:              durationEnv("LOGIN_DELAY", time.Second),
+		LoginMaxDelay:           durationEnv("LOGIN_MAX_DELAY", 30*time.Second),
+		ImpersonationMaxTTL:     durationEnv("IMPERSONATION_MAX_TTL", 15*time.Minute),
+		RateLimitRate:           floatEnv("RATE_LIMIT_RATE", 10),
+		RateLimitBurst:          intEnv("RATE_LIMIT_BURST", 20),
+		RateLimitRules:          os.Getenv("RATE_LIMIT_RULES"),
+		RateLimitIPRate:         floatEnv("RATE_LIMIT_IP_RATE", 50),
+		RateLimitIPBurst:        intEnv("RATE_LIMIT_IP_BURST", 100),
+		PolicyFile:              os.Getenv("POLICY_FILE"),
+		PolicyReloadInterval:    durationEnv("POLICY_RELOAD_INTERVAL", 10*time.Second),
+		MetricsAddr:             stringEnv("METRICS_ADDR", "127.0.0.1:9090"),
 	}
 }
+
+// stringEnv reads a string from the environment, falling back to def when unset.
+func stringEnv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// listEnv reads a comma-separated list from the environment, skipping empty entries.
+func listEnv(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// boolEnv reads a boolean from the environment, falling back to def when unset.
+func boolEnv(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid boolean for %s: %s", key, value)
+	}
+	return b
+}
+
+// intEnv reads a positive integer from the environment, falling back to def when unset.
+func intEnv(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid integer for %s: %s", key, value)
+	}
+	return n
+}
+
+// floatEnv reads a positive number from the environment, falling back to def when unset.
+func floatEnv(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 {
+		log.Fatalf("Invalid number for %s: %s", key, value)
+	}
+	return f
+}
+
+// durationEnv reads a positive time.Duration from the environment, falling back to def when unset.
+func durationEnv(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Fatalf("Invalid duration for %s: %s", key, value)
+	}
+	return d
+}
@@ -0,0 +1,71 @@
+// Package audit records security-relevant events such as role changes and lockouts.
+// Events are written to the service log and kept in a bounded in-memory buffer.
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event is a single audit record.
+type Event struct {
+	Time    time.Time         `json:"time"`
+	Type    string            `json:"type"`
+	OrgID   string            `json:"org_id,omitempty"`
+	Actor   string            `json:"actor"`
+	Target  string            `json:"target,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// maxEvents bounds the in-memory buffer; older events are dropped first.
+const maxEvents = 10000
+
+var (
+	mu     sync.Mutex
+	events []Event
+)
+
+// Record stores an event and writes it to the log. A zero Time is set to now.
+func Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	mu.Lock()
+	events = append(events, e)
+	if len(events) > maxEvents {
+		events = events[len(events)-maxEvents:]
+	}
+	mu.Unlock()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Audit: failed to encode event: %v", err)
+		return
+	}
+	log.Printf("Audit: %s", line)
+}
+
+// Events returns the recorded events of the given type, oldest first.
+// An empty type returns every event.
+func Events(eventType string) []Event {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var result []Event
+	for _, e := range events {
+		if eventType == "" || e.Type == eventType {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Reset discards all recorded events.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	events = nil
+}
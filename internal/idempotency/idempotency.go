@@ -0,0 +1,226 @@
+// Package idempotency lets clients safely retry mutating requests by sending an
+// Idempotency-Key header. The first response for a key is stored and replayed
+// verbatim for repeats of the same request until it expires.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// HeaderKey is the request header carrying the client-chosen idempotency key.
+const HeaderKey = "Idempotency-Key"
+
+// HeaderReplayed is set on responses that were replayed from the store.
+const HeaderReplayed = "Idempotent-Replayed"
+
+// record is a stored response for one idempotency key.
+type record struct {
+	fingerprint string
+	inFlight    bool
+	status      int
+	header      http.Header
+	body        []byte
+	expiresAt   time.Time
+}
+
+// Store keeps recorded responses in memory for a fixed TTL.
+type Store struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	records   map[string]*record
+	lastSweep time.Time
+}
+
+// sweepInterval is how often expired responses are dropped.
+const sweepInterval = time.Minute
+
+// MaxBodyBytes is the largest request body that is read to fingerprint a request.
+const MaxBodyBytes = 1 << 20
+
+// NewStore creates a Store that keeps responses for ttl.
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:     ttl,
+		records: make(map[string]*record),
+	}
+}
+
+// Middleware wraps next so that non-GET requests carrying an Idempotency-Key are
+// executed at most once per key. Repeats with the same body get the stored response,
+// repeats with a different body get 422 and concurrent repeats get 409.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, internalMsgs.ErrRequestTooLarge)
+			} else {
+				writeError(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+			}
+			log.Printf("BadRequest: %v", err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scopedKey := scope(r) + "|" + key
+		fingerprint := fingerprint(r, body)
+
+		existing, err := s.begin(scopedKey, fingerprint)
+		if err != nil {
+			status := http.StatusConflict
+			if errors.Is(err, internalMsgs.ErrIdempotencyKeyReused) {
+				status = http.StatusUnprocessableEntity
+			}
+			writeError(w, status, err)
+			log.Printf("Idempotency: key=%s %v", key, err)
+			return
+		}
+		if existing != nil {
+			replay(w, existing)
+			log.Printf("Idempotency: replayed response for key=%s", key)
+			return
+		}
+
+		// A handler that panics must not leave the key reserved until it expires.
+		finished := false
+		defer func() {
+			if !finished {
+				s.release(scopedKey)
+			}
+		}()
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		s.finish(scopedKey, rec)
+		finished = true
+	})
+}
+
+// begin reserves key for a new request, or returns the stored record for a repeat.
+func (s *Store) begin(key, fingerprint string) (*record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.evictExpired(now)
+		s.lastSweep = now
+	}
+
+	// Expired responses are only swept now and then, so they are skipped here.
+	if existing, ok := s.records[key]; ok && (existing.inFlight || now.Before(existing.expiresAt)) {
+		if existing.fingerprint != fingerprint {
+			return nil, internalMsgs.ErrIdempotencyKeyReused
+		}
+		if existing.inFlight {
+			return nil, internalMsgs.ErrIdempotencyKeyInProgress
+		}
+		return existing, nil
+	}
+
+	s.records[key] = &record{fingerprint: fingerprint, inFlight: true}
+	return nil, nil
+}
+
+// finish stores the recorded response for key. Server errors are not stored so that
+// clients can retry them.
+func (s *Store) finish(key string, rec *recorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec.status >= http.StatusInternalServerError {
+		delete(s.records, key)
+		return
+	}
+
+	stored := s.records[key]
+	stored.inFlight = false
+	stored.status = rec.status
+	stored.header = rec.Header().Clone()
+	stored.body = rec.body.Bytes()
+	stored.expiresAt = time.Now().Add(s.ttl)
+}
+
+// release forgets key without storing a response, so that the request can be retried.
+func (s *Store) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+}
+
+func (s *Store) evictExpired(now time.Time) {
+	for key, rec := range s.records {
+		if !rec.inFlight && now.After(rec.expiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// scope namespaces keys per caller so that one caller can never replay another's response.
+func scope(r *http.Request) string {
+	return r.Header.Get("X-Org-ID") + "|" + r.Header.Get("X-User-Type") + "|" + r.Header.Get("X-User-ID")
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, rec *record) {
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(rec.status)
+	if _, err := w.Write(rec.body); err != nil {
+		log.Printf("Failed to replay response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": err.Error()}); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// recorder passes the response through to the client while keeping a copy.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,153 @@
+package idempotency
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	var calls int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call":%d}`, n)
+	})
+	handler := NewStore(time.Hour).Middleware(next)
+
+	tests := []struct {
+		name             string
+		key              string
+		userType         string
+		body             string
+		expectedStatus   int
+		expectedBody     string
+		expectedReplayed bool
+	}{
+		{
+			name:           "First request is executed",
+			key:            "abc",
+			userType:       "Admin",
+			body:           `{"name":"Yoda"}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"call":1}`,
+		},
+		{
+			name:             "Repeat with same key and body is replayed",
+			key:              "abc",
+			userType:         "Admin",
+			body:             `{"name":"Yoda"}`,
+			expectedStatus:   http.StatusCreated,
+			expectedBody:     `{"call":1}`,
+			expectedReplayed: true,
+		},
+		{
+			name:           "Repeat with different body is rejected",
+			key:            "abc",
+			userType:       "Admin",
+			body:           `{"name":"Luke"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedBody:   `{"message":"idempotency key reused with a different request"}`,
+		},
+		{
+			name:           "Same key from another caller is executed",
+			key:            "abc",
+			userType:       "Modifier",
+			body:           `{"name":"Yoda"}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"call":2}`,
+		},
+		{
+			name:           "Request without key is always executed",
+			userType:       "Admin",
+			body:           `{"name":"Yoda"}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `{"call":3}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/users", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-User-Type", tt.userType)
+			if tt.key != "" {
+				req.Header.Set(HeaderKey, tt.key)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if body := strings.TrimSpace(rr.Body.String()); body != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", body, tt.expectedBody)
+			}
+			if replayed := rr.Header().Get(HeaderReplayed) == "true"; replayed != tt.expectedReplayed {
+				t.Errorf("handler returned wrong replay marker: got %v want %v", replayed, tt.expectedReplayed)
+			}
+		})
+	}
+}
+
+func TestMiddlewareReleasesKeyOnPanic(t *testing.T) {
+	var calls int32
+	handler := NewStore(time.Hour).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"Yoda"}`))
+		req.Header.Set(HeaderKey, "abc")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the panic to propagate")
+			}
+		}()
+		do()
+	}()
+	if rr := do(); rr.Code != http.StatusCreated {
+		t.Errorf("expected the retry to be executed, got %v", rr.Code)
+	}
+}
+
+func TestMiddlewareLimitsBodySize(t *testing.T) {
+	var calls int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	})
+	handler := NewStore(time.Hour).Middleware(next)
+
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(strings.Repeat("a", MaxBodyBytes+1)))
+	req.Header.Set(HeaderKey, "big")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge || calls != 0 {
+		t.Errorf("expected 413 without calling the handler, got %v after %d calls", rr.Code, calls)
+	}
+}
+
+func TestStoreSkipsExpiredResponses(t *testing.T) {
+	s := NewStore(time.Hour)
+	s.lastSweep = time.Now()
+	s.records["k"] = &record{fingerprint: "f", status: http.StatusCreated, expiresAt: time.Now().Add(-time.Second)}
+	if existing, err := s.begin("k", "other"); existing != nil || err != nil {
+		t.Errorf("expected an expired response to be ignored, got %v %v", existing, err)
+	}
+}
@@ -0,0 +1,174 @@
+// Package ids provides the generators used to assign user IDs.
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Generator produces unique identifiers. Implementations are safe for concurrent use.
+type Generator interface {
+	NewID() string
+}
+
+// Supported generator kinds accepted by New.
+const (
+	KindSequential = "sequential"
+	KindUUIDv7     = "uuidv7"
+	KindULID       = "ulid"
+)
+
+// New returns the generator for the given kind.
+func New(kind string) (Generator, error) {
+	switch kind {
+	case KindSequential:
+		return NewSequential(), nil
+	case KindUUIDv7:
+		return NewUUIDv7(), nil
+	case KindULID:
+		return NewULID(), nil
+	default:
+		return nil, fmt.Errorf("unknown ID generator: %s", kind)
+	}
+}
+
+// Sequential generates the decimal IDs "1", "2", "3", ... It is predictable and meant
+// for tests and local fixtures only.
+type Sequential struct {
+	mu      sync.Mutex
+	counter int
+}
+
+// NewSequential creates a Sequential generator starting at 1.
+func NewSequential() *Sequential {
+	return &Sequential{}
+}
+
+func (g *Sequential) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.counter++
+	return strconv.Itoa(g.counter)
+}
+
+// monotonic holds the last timestamp and random payload so that IDs generated within
+// the same millisecond still sort in creation order. Only the low bits of the 80-bit
+// payload are random; the rest stay zero.
+type monotonic struct {
+	mu      sync.Mutex
+	bits    int
+	lastMs  uint64
+	entropy [10]byte
+	now     func() time.Time
+}
+
+// next returns the timestamp and a random payload that is strictly greater than the
+// previous payload whenever the timestamp did not advance.
+func (m *monotonic) next() (uint64, [10]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := uint64(m.now().UnixMilli())
+	if ms > m.lastMs {
+		m.lastMs = ms
+		if _, err := rand.Read(m.entropy[:]); err != nil {
+			panic(fmt.Sprintf("ids: reading random bytes: %v", err))
+		}
+		// Clear the unused bits plus one more so the payload has room to be incremented.
+		for i, clear := 0, len(m.entropy)*8-m.bits+1; clear > 0; i, clear = i+1, clear-8 {
+			if clear >= 8 {
+				m.entropy[i] = 0
+			} else {
+				m.entropy[i] &= 0xff >> clear
+			}
+		}
+		return ms, m.entropy
+	}
+
+	for i := len(m.entropy) - 1; i >= 0; i-- {
+		m.entropy[i]++
+		if m.entropy[i] != 0 {
+			break
+		}
+	}
+	return m.lastMs, m.entropy
+}
+
+// UUIDv7 generates time-ordered RFC 9562 version 7 UUIDs.
+type UUIDv7 struct {
+	state monotonic
+}
+
+// NewUUIDv7 creates a UUIDv7 generator.
+func NewUUIDv7() *UUIDv7 {
+	// 12 bits of rand_a plus 62 bits of rand_b.
+	return &UUIDv7{state: monotonic{bits: 74, now: time.Now}}
+}
+
+func (g *UUIDv7) NewID() string {
+	ms, entropy := g.state.next()
+
+	hi := uint64(binary.BigEndian.Uint16(entropy[0:2]))
+	lo := binary.BigEndian.Uint64(entropy[2:10])
+	randA := (hi&0x3ff)<<2 | lo>>62
+	randB := lo & (1<<62 - 1)
+
+	var u [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(u[0:6], ts[2:8])
+	binary.BigEndian.PutUint16(u[6:8], 0x7000|uint16(randA)) // version 7
+	binary.BigEndian.PutUint64(u[8:16], 1<<63|randB)         // RFC 9562 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:36], u[10:16])
+	return string(buf[:])
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates lexicographically sortable ULIDs.
+type ULID struct {
+	state monotonic
+}
+
+// NewULID creates a ULID generator.
+func NewULID() *ULID {
+	return &ULID{state: monotonic{bits: 80, now: time.Now}}
+}
+
+func (g *ULID) NewID() string {
+	ms, entropy := g.state.next()
+
+	var id [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(id[0:6], ts[2:8])
+	copy(id[6:16], entropy[:])
+
+	// Encode the 128-bit value as 26 base32 characters, most significant bits first.
+	// The first character only carries the top 3 bits.
+	var out [26]byte
+	hi := binary.BigEndian.Uint64(id[0:8])
+	lo := binary.BigEndian.Uint64(id[8:16])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
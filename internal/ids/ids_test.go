@@ -0,0 +1,64 @@
+package ids
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		kind    string
+		pattern *regexp.Regexp
+	}{
+		{kind: KindSequential, pattern: regexp.MustCompile(`^[1-9][0-9]*$`)},
+		{kind: KindUUIDv7, pattern: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		{kind: KindULID, pattern: regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			g, err := New(tt.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seen := make(map[string]bool)
+			for i := 0; i < 1000; i++ {
+				id := g.NewID()
+				if !tt.pattern.MatchString(id) {
+					t.Fatalf("generated malformed ID: %s", id)
+				}
+				if seen[id] {
+					t.Fatalf("generated duplicate ID: %s", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+
+	if _, err := New("unknown"); err == nil {
+		t.Errorf("expected error for unknown generator")
+	}
+}
+
+func TestTimeSortableGeneratorsAreMonotonic(t *testing.T) {
+	frozen := time.UnixMilli(1700000000000)
+	generators := map[string]Generator{
+		KindUUIDv7: &UUIDv7{state: monotonic{bits: 74, now: func() time.Time { return frozen }}},
+		KindULID:   &ULID{state: monotonic{bits: 80, now: func() time.Time { return frozen }}},
+	}
+
+	for kind, g := range generators {
+		t.Run(kind, func(t *testing.T) {
+			prev := g.NewID()
+			for i := 0; i < 1000; i++ {
+				id := g.NewID()
+				if id <= prev {
+					t.Fatalf("IDs within the same millisecond are not increasing: %s then %s", prev, id)
+				}
+				prev = id
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+// Package jwt signs and verifies RS256 JSON Web Tokens with a rotating set of RSA keys
+// and publishes the public keys as a JSON Web Key Set.
+package jwt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// KeyBits is the size of generated RSA keys.
+const KeyBits = 2048
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid"`
+}
+
+// registered holds the claims Verify checks for every token.
+type registered struct {
+	ExpiresAt int64 `json:"exp"`
+}
+
+type key struct {
+	id        string
+	private   *rsa.PrivateKey
+	retiredAt time.Time
+}
+
+// KeySet holds the signing key and the recently retired keys. Tokens are signed with
+// the newest key; retired keys stay available for verification for the retention
+// period so that tokens issued before a rotation remain valid until they expire.
+type KeySet struct {
+	mu        sync.RWMutex
+	keys      []*key
+	retention time.Duration
+	now       func() time.Time
+}
+
+// JWK is the public part of an RSA key as published in a JSON Web Key Set.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySet returns a key set with a freshly generated signing key. Retired keys are
+// kept for retention, which should be at least the lifetime of the issued tokens.
+func NewKeySet(retention time.Duration) (*KeySet, error) {
+	ks := &KeySet{retention: retention, now: time.Now}
+	if err := ks.Rotate(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Rotate generates a new signing key, retires the current one and drops keys that
+// have been retired for longer than the retention period.
+func (ks *KeySet) Rotate() error {
+	private, err := rsa.GenerateKey(rand.Reader, KeyBits)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := ks.now()
+	keys := []*key{{id: hex.EncodeToString(id), private: private}}
+	for i, k := range ks.keys {
+		if i == 0 {
+			k.retiredAt = now
+		}
+		if now.Sub(k.retiredAt) < ks.retention {
+			keys = append(keys, k)
+		}
+	}
+	ks.keys = keys
+	return nil
+}
+
+// KeyIDs returns the IDs of the keys in the set, the signing key first.
+func (ks *KeySet) KeyIDs() []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	ids := make([]string, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		ids = append(ids, k.id)
+	}
+	return ids
+}
+
+// JWKS returns the public keys of the set.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, k := range ks.keys {
+		set.Keys = append(set.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: k.id,
+			N:   base64.RawURLEncoding.EncodeToString(k.private.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.private.E)).Bytes()),
+		})
+	}
+	return set
+}
+
+// Sign returns claims as a compact JWT signed with the current signing key.
+func (ks *KeySet) Sign(claims any) (string, error) {
+	ks.mu.RLock()
+	signing := ks.keys[0]
+	ks.mu.RUnlock()
+
+	h, err := json.Marshal(header{Alg: "RS256", Typ: "JWT", Kid: signing.id})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, signing.private, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks the signature and expiry of tok and decodes its claims into claims.
+func (ks *KeySet) Verify(tok string, claims any) error {
+	return verify(tok, ks.publicKey, ks.now(), claims)
+}
+
+func (ks *KeySet) publicKey(kid string) *rsa.PublicKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	for _, k := range ks.keys {
+		if k.id == kid {
+			return &k.private.PublicKey
+		}
+	}
+	return nil
+}
+
+// Verify checks the signature and expiry of tok against the keys of the set, as
+// published by another issuer, and decodes its claims into claims.
+func (set JWKS) Verify(tok string, claims any) error {
+	return verify(tok, set.publicKey, time.Now(), claims)
+}
+
+func (set JWKS) publicKey(kid string) *rsa.PublicKey {
+	for _, k := range set.Keys {
+		if k.Kid != kid || k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			return nil
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return nil
+}
+
+// HasKey reports whether the set contains a key with the given ID.
+func (set JWKS) HasKey(kid string) bool {
+	return set.publicKey(kid) != nil
+}
+
+// KeyID returns the ID of the key that signed tok, without verifying anything.
+func KeyID(tok string) string {
+	segment, _, _ := strings.Cut(tok, ".")
+	var h header
+	if decodeSegment(segment, &h) != nil {
+		return ""
+	}
+	return h.Kid
+}
+
+func verify(tok string, publicKey func(kid string) *rsa.PublicKey, now time.Time, claims any) error {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return internalMsgs.ErrInvalidToken
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil || h.Alg != "RS256" {
+		return internalMsgs.ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return internalMsgs.ErrInvalidToken
+	}
+
+	public := publicKey(h.Kid)
+	if public == nil {
+		return internalMsgs.ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], sig); err != nil {
+		return internalMsgs.ErrInvalidToken
+	}
+
+	var reg registered
+	if err := decodeSegment(parts[1], &reg); err != nil || now.Unix() >= reg.ExpiresAt {
+		return internalMsgs.ErrInvalidToken
+	}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return internalMsgs.ErrInvalidToken
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,125 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+type testClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func TestSignAndVerify(t *testing.T) {
+	ks, err := NewKeySet(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	ks.now = func() time.Time { return now }
+
+	tok, err := ks.Sign(testClaims{Subject: "42", ExpiresAt: now.Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got testClaims
+	if err := ks.Verify(tok, &got); err != nil || got.Subject != "42" {
+		t.Fatalf("expected token to verify, got %+v: %v", got, err)
+	}
+
+	expired, _ := ks.Sign(testClaims{Subject: "42", ExpiresAt: now.Unix()})
+	parts := strings.Split(tok, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","exp":9999999999}`)) + "." + parts[2]
+	other, _ := NewKeySet(time.Hour)
+	foreign, _ := other.Sign(testClaims{Subject: "42", ExpiresAt: now.Add(time.Minute).Unix()})
+
+	for name, tok := range map[string]string{"Expired": expired, "Tampered": tampered, "Unknown key": foreign, "Malformed": "a.b"} {
+		t.Run(name, func(t *testing.T) {
+			if err := ks.Verify(tok, &got); !errors.Is(err, internalMsgs.ErrInvalidToken) {
+				t.Errorf("expected ErrInvalidToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ks, err := NewKeySet(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	ks.now = func() time.Time { return now }
+
+	old, _ := ks.Sign(testClaims{Subject: "42", ExpiresAt: now.Add(2 * time.Hour).Unix()})
+	if err := ks.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if ids := ks.KeyIDs(); len(ids) != 2 {
+		t.Fatalf("expected the retired key to be kept, got %v", ids)
+	}
+	var got testClaims
+	if err := ks.Verify(old, &got); err != nil {
+		t.Errorf("expected token signed before rotation to verify: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := ks.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if ids := ks.KeyIDs(); len(ids) != 2 {
+		t.Fatalf("expected the oldest key to be dropped after the retention period, got %v", ids)
+	}
+	if err := ks.Verify(old, &got); err == nil {
+		t.Error("expected token signed with a dropped key to be rejected")
+	}
+}
+
+func TestJWKSVerifiesSignatures(t *testing.T) {
+	ks, err := NewKeySet(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, _ := ks.Sign(testClaims{Subject: "42", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+
+	jwk := ks.JWKS().Keys[0]
+	n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	parts := strings.Split(tok, ".")
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("expected the published key to verify the signature: %v", err)
+	}
+}
+
+func TestJWKSVerify(t *testing.T) {
+	ks, err := NewKeySet(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, _ := ks.Sign(testClaims{Subject: "42", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+
+	set := ks.JWKS()
+	if !set.HasKey(KeyID(tok)) {
+		t.Fatalf("expected the signing key %q to be published", KeyID(tok))
+	}
+	var got testClaims
+	if err := set.Verify(tok, &got); err != nil || got.Subject != "42" {
+		t.Errorf("expected the published keys to verify the token, got %+v: %v", got, err)
+	}
+
+	other, _ := NewKeySet(time.Hour)
+	if err := other.JWKS().Verify(tok, &got); !errors.Is(err, internalMsgs.ErrInvalidToken) {
+		t.Errorf("expected a foreign key set to reject the token, got %v", err)
+	}
+}
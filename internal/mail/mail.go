@@ -0,0 +1,153 @@
+// Package mail delivers notification emails such as invitations and password resets.
+package mail
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sender kinds accepted by New.
+const (
+	KindStdout = "stdout"
+	KindFile   = "file"
+	KindSMTP   = "smtp"
+)
+
+// Config selects and configures a sender.
+type Config struct {
+	Kind string
+	// From is the sender address; it is required for SMTP.
+	From string
+	// Dir is the directory the file sender writes into.
+	Dir string
+	// SMTPAddr is the host:port of the SMTP server. SMTPUsername and SMTPPassword enable
+	// PLAIN authentication, which net/smtp only performs over TLS or to localhost.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(msg Message) error
+}
+
+// New returns the sender described by cfg.
+func New(cfg Config) (Sender, error) {
+	switch cfg.Kind {
+	case KindStdout:
+		s := NewWriterSender(os.Stdout)
+		s.From = cfg.From
+		return s, nil
+	case KindFile:
+		if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+			return nil, fmt.Errorf("creating mail directory: %w", err)
+		}
+		s := NewFileSender(cfg.Dir)
+		s.From = cfg.From
+		return s, nil
+	case KindSMTP:
+		if cfg.SMTPAddr == "" || cfg.From == "" {
+			return nil, fmt.Errorf("smtp sender needs a server address and a from address")
+		}
+		return NewSMTPSender(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From)
+	default:
+		return nil, fmt.Errorf("unknown mail sender: %s", cfg.Kind)
+	}
+}
+
+// format renders msg as an RFC 5322 style message.
+func format(from string, msg Message, at time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Date: %s\r\n", at.Format(time.RFC1123Z))
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(msg.Body)
+	if !strings.HasSuffix(msg.Body, "\n") {
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// WriterSender writes every message to an io.Writer, which is handy for local development.
+type WriterSender struct {
+	From string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSender(w io.Writer) *WriterSender {
+	return &WriterSender{w: w}
+}
+
+func (s *WriterSender) Send(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.w, format(s.From, msg, time.Now())+"\r\n")
+	return err
+}
+
+// FileSender drops every message as a separate .eml file into a directory.
+type FileSender struct {
+	From string
+
+	mu  sync.Mutex
+	dir string
+	seq int
+}
+
+func NewFileSender(dir string) *FileSender {
+	return &FileSender{dir: dir}
+}
+
+func (s *FileSender) Send(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	s.seq++
+	name := fmt.Sprintf("%s-%06d.eml", now.Format("20060102T150405.000000000"), s.seq)
+	return os.WriteFile(filepath.Join(s.dir, name), []byte(format(s.From, msg, now)), 0o640)
+}
+
+// SMTPSender delivers messages through an SMTP server.
+type SMTPSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPSender(addr, username, password, from string) (*SMTPSender, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp address: %w", err)
+	}
+	s := &SMTPSender{addr: addr, from: from}
+	if username != "" {
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s, nil
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{msg.To}, []byte(format(s.from, msg, time.Now())))
+}
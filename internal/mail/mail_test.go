@@ -0,0 +1,120 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSenders(t *testing.T) {
+	msg := Message{To: "leia@example.com", Subject: "Welcome", Body: "Hello there"}
+
+	var buf bytes.Buffer
+	if err := NewWriterSender(&buf).Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: leia@example.com\r\n", "Subject: Welcome\r\n", "Hello there"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected output to contain %q, got %q", want, buf.String())
+		}
+	}
+
+	dir := t.TempDir()
+	sender, err := New(Config{Kind: KindFile, Dir: filepath.Join(dir, "outbox")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sender.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := os.ReadDir(filepath.Join(dir, "outbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expected 2 message files, got %d", len(files))
+	}
+
+	if _, err := New(Config{Kind: "pigeon"}); err == nil {
+		t.Error("expected an error for an unknown sender")
+	}
+	if _, err := New(Config{Kind: KindSMTP, SMTPAddr: "localhost:25"}); err == nil {
+		t.Error("expected an error for an smtp sender without a from address")
+	}
+}
+
+func TestSMTPSender(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go serveSMTP(ln, received)
+
+	sender, err := New(Config{Kind: KindSMTP, SMTPAddr: ln.Addr().String(), From: "noreply@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(Message{To: "leia@example.com", Subject: "Reset", Body: "Use this link"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := <-received
+	for _, want := range []string{"From: noreply@example.com\r\n", "To: leia@example.com\r\n", "Use this link"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("expected message to contain %q, got %q", want, data)
+		}
+	}
+}
+
+// serveSMTP accepts one connection and speaks just enough SMTP for net/smtp.SendMail,
+// sending the message data to received.
+func serveSMTP(ln net.Listener, received chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			received <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
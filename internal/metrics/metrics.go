@@ -0,0 +1,123 @@
+// Package metrics keeps process-wide counters and serves them in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Counter is a monotonically increasing count, split by the values of its labels.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*Counter)
+)
+
+// NewCounter registers a counter with the given label names. Registering a name twice
+// returns the existing counter.
+func NewCounter(name, help string, labels ...string) *Counter {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if c, exists := registry[name]; exists {
+		return c
+	}
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]uint64)}
+	registry[name] = c
+	return c
+}
+
+// Inc adds one to the count for the given label values, one per label name.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds n to the count for the given label values, one per label name.
+func (c *Counter) Add(n uint64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", c.name, len(c.labels), len(labelValues)))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\xff")] += n
+}
+
+// Value returns the count for the given label values.
+func (c *Counter) Value(labelValues ...string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+// write writes the counter in the text exposition format.
+func (c *Counter) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var labels string
+		if len(c.labels) > 0 {
+			pairs := make([]string, len(c.labels))
+			for i, value := range strings.Split(key, "\xff") {
+				pairs[i] = fmt.Sprintf("%s=%q", c.labels[i], value)
+			}
+			labels = "{" + strings.Join(pairs, ",") + "}"
+		}
+		if _, err := fmt.Fprintf(w, "%s%s %d\n", c.name, labels, c.values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteText writes all registered counters, ordered by name, in the text exposition format.
+func WriteText(w io.Writer) error {
+	registryMu.Lock()
+	counters := make([]*Counter, 0, len(registry))
+	for _, c := range registry {
+		counters = append(counters, c)
+	}
+	registryMu.Unlock()
+
+	sort.Slice(counters, func(i, j int) bool { return counters[i].name < counters[j].name })
+	for _, c := range counters {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the registered counters at GET requests.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	logins := NewCounter("test_logins_total", "Logins by result.", "result")
+	if again := NewCounter("test_logins_total", "Logins by result.", "result"); again != logins {
+		t.Error("expected registering a name twice to return the same counter")
+	}
+	logins.Inc("success")
+	logins.Add(2, "failure")
+	logins.Inc("failure")
+	NewCounter("test_events_total", "Events.").Inc()
+
+	if got := logins.Value("failure"); got != 3 {
+		t.Errorf("expected 3 failures, got %d", got)
+	}
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	want := `# HELP test_events_total Events.
+# TYPE test_events_total counter
+test_events_total 1
+# HELP test_logins_total Logins by result.
+# TYPE test_logins_total counter
+test_logins_total{result="failure"} 3
+test_logins_total{result="success"} 1
+`
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("unexpected exposition %v:\n%s", rr.Code, rr.Body.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a wrong number of label values to panic")
+		}
+	}()
+	logins.Inc()
+}
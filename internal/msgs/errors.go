@@ -3,12 +3,88 @@ package msgs
 import "errors"
 
 var (
-	ErrUserNotFound            = errors.New("user not found")
-	ErrInvalidRequestPayload   = errors.New("invalid request payload")
-	ErrForbidden               = errors.New("forbidden")
-	ErrInternalServerError     = errors.New("internal server error")
-	ErrUserAlreadyExists       = errors.New("user already exists")
-	ErrInvalidRole             = errors.New("invalid role")
-	ErrInsufficientPermissions = errors.New("insufficient permissions to assign role")
-	ErrMethodNotAllowed        = errors.New("method not allowed")
+	ErrUserNotFound                = errors.New("user not found")
+	ErrInvalidRequestPayload       = errors.New("invalid request payload")
+	ErrForbidden                   = errors.New("forbidden")
+	ErrInternalServerError         = errors.New("internal server error")
+	ErrUserAlreadyExists           = errors.New("user already exists")
+	ErrInvalidRole                 = errors.New("invalid role")
+	ErrInsufficientPermissions     = errors.New("insufficient permissions to assign role")
+	ErrMethodNotAllowed            = errors.New("method not allowed")
+	ErrNotFound                    = errors.New("not found")
+	ErrInvalidQueryParameter       = errors.New("invalid query parameter")
+	ErrPreconditionFailed          = errors.New("precondition failed")
+	ErrIdempotencyKeyReused        = errors.New("idempotency key reused with a different request")
+	ErrIdempotencyKeyInProgress    = errors.New("a request with this idempotency key is in progress")
+	ErrRequestTooLarge             = errors.New("request body too large")
+	ErrInvalidEmail                = errors.New("invalid email address")
+	ErrEmailDomainNotAllowed       = errors.New("email domain not allowed")
+	ErrInvalidAttributeDefinition  = errors.New("invalid attribute definition")
+	ErrAttributeAlreadyExists      = errors.New("attribute already exists")
+	ErrAttributeNotFound           = errors.New("attribute not found")
+	ErrInvalidAttribute            = errors.New("invalid attribute")
+	ErrUnknownAttribute            = errors.New("unknown attribute")
+	ErrMissingAttribute            = errors.New("attributes required")
+	ErrAttributeNotUnique          = errors.New("attribute value already in use")
+	ErrAttributeValuesInvalid      = errors.New("users do not satisfy the attribute definition")
+	ErrGroupNotFound               = errors.New("group not found")
+	ErrGroupAlreadyExists          = errors.New("group already exists")
+	ErrGroupMemberNotFound         = errors.New("user is not a member of the group")
+	ErrOrganizationNotFound        = errors.New("organization not found")
+	ErrOrganizationAlreadyExists   = errors.New("organization already exists")
+	ErrOrganizationNotEmpty        = errors.New("organization is not empty")
+	ErrInvalidOrganizationID       = errors.New("organization id must be lower-case letters, digits or hyphens")
+	ErrInvalidRoleGrant            = errors.New("invalid role grant")
+	ErrRoleGrantNotFound           = errors.New("role grant not found")
+	ErrUserNotDeleted              = errors.New("user is not deleted")
+	ErrInvalidToken                = errors.New("invalid or expired token")
+	ErrWeakPassword                = errors.New("password does not meet the password policy")
+	ErrEmailAlreadyVerified        = errors.New("email address is already verified")
+	ErrTooManyRequests             = errors.New("too many requests")
+	ErrInvalidCredentials          = errors.New("invalid credentials")
+	ErrMFARequired                 = errors.New("multi-factor authentication code required")
+	ErrMFAEnrollmentRequired       = errors.New("multi-factor authentication enrollment required")
+	ErrInvalidMFACode              = errors.New("invalid multi-factor authentication code")
+	ErrMFAAlreadyEnabled           = errors.New("multi-factor authentication is already enabled")
+	ErrMFANotEnabled               = errors.New("multi-factor authentication is not enabled")
+	ErrMFAEnrollmentNotStarted     = errors.New("no pending multi-factor authentication enrollment")
+	ErrInvalidClient               = errors.New("invalid client")
+	ErrInvalidRedirectURI          = errors.New("invalid redirect URI")
+	ErrInvalidGrant                = errors.New("invalid or expired authorization code")
+	ErrInvalidAuthorizationRequest = errors.New("invalid authorization request")
+	ErrServiceAccountNotFound      = errors.New("service account not found")
+	ErrServiceAccountAlreadyExists = errors.New("service account already exists")
+	ErrAPIKeyNotFound              = errors.New("API key not found")
+	ErrInvalidAPIKey               = errors.New("invalid API key")
+	ErrAPIKeyIPNotAllowed          = errors.New("API key is not allowed from this address")
+	ErrFederationProviderNotFound  = errors.New("identity provider not found")
+	ErrFederatedLoginFailed        = errors.New("federated login failed")
+	ErrFederatedRoleNotMapped      = errors.New("no role is mapped for this identity")
+	ErrFederatedUserNotFound       = errors.New("no user is linked to this identity")
+	ErrFederatedIdentityLinked     = errors.New("identity is already linked to another user")
+	ErrFederatedIdentityNotFound   = errors.New("no identity of this provider is linked to the user")
+	ErrFederatedMFARequired        = errors.New("identity provider did not report multi-factor authentication")
+	ErrSessionNotFound             = errors.New("session not found")
+	ErrAuthenticationRequired      = errors.New("authentication with a session or API key required")
+	ErrAPIKeyRequired              = errors.New("API key required")
+	ErrImpersonationNotAllowed     = errors.New("impersonation of this user is not allowed")
+	ErrImpersonationReadOnly       = errors.New("impersonation sessions are read-only")
+	ErrInvalidImpersonationTTL     = errors.New("impersonation lifetime exceeds the maximum")
+	ErrAccountLocked               = errors.New("account is temporarily locked after too many failed logins")
+	ErrInvitationNotFound          = errors.New("invitation not found")
+	ErrInvitationNotPending        = errors.New("invitation is not pending")
+	ErrRoleChangeRequestNotFound   = errors.New("role change request not found")
+	ErrRoleChangeNotPending        = errors.New("role change request is not pending")
+	ErrApprovalRequired            = errors.New("role change needs approval")
+	ErrGroupRolesNeedApproval      = errors.New("roles that need approval can only be added to a group without members")
+	ErrAccessReviewNotFound        = errors.New("access review not found")
+	ErrAccessReviewItemNotFound    = errors.New("access review item not found")
+	ErrAccessReviewClosed          = errors.New("access review is closed")
+	ErrInvalidReviewer             = errors.New("reviewers must be active admins of the organization")
+	ErrNoEligibleReviewer          = errors.New("no reviewer may review this assignment")
+	ErrNotAssignedReviewer         = errors.New("only the assigned reviewer can decide this assignment")
+	ErrSelfApproval                = errors.New("role change request must be approved by a different admin")
+	ErrServiceAccountApprover      = errors.New("role change requests cannot be approved by a service account")
+	ErrUnknownAuthzAction          = errors.New("unknown authorization action")
+	ErrPolicyDenied                = errors.New("denied by access policy")
 )
@@ -0,0 +1,259 @@
+// Package oidcclient is a minimal OpenID Connect relying party for the authorization
+// code flow with PKCE, used to log users in through an upstream identity provider.
+package oidcclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/jwt"
+)
+
+// ErrInvalidIDToken is returned when an ID token fails verification.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Config describes a client registration at an upstream provider.
+type Config struct {
+	// Issuer is the provider's issuer URL; its discovery document is fetched from
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+}
+
+// Metadata is the part of a provider's discovery document the client uses.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the claims of a verified ID token, keyed by claim name.
+type Claims map[string]any
+
+// String returns the string claim name, or "" if it is missing or not a string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the claim name as a list; a single string counts as a list of one.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// jwksRefreshInterval is the minimum time between fetches of the provider's keys
+// triggered by an unknown key ID.
+const jwksRefreshInterval = 10 * time.Second
+
+// Client talks to one upstream provider. Its discovery document and keys are fetched
+// on first use and cached; the keys are fetched again when a token names an unknown key.
+type Client struct {
+	cfg  Config
+	http *http.Client
+	now  func() time.Time
+
+	mu          sync.Mutex
+	metadata    *Metadata
+	jwks        jwt.JWKS
+	jwksFetched time.Time
+}
+
+// New returns a client for cfg. A nil httpClient uses one with a 10 second timeout.
+func New(cfg Config, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &Client{cfg: cfg, http: httpClient, now: time.Now}
+}
+
+// NewVerifier returns a random PKCE code verifier, also suitable as a state or nonce.
+func NewVerifier() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("oidcclient: reading random bytes: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Challenge returns the S256 PKCE code challenge for verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider URL the user agent is sent to in order to log in.
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	md, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	target, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidcclient: authorization endpoint: %w", err)
+	}
+	query := target.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.cfg.ClientID)
+	query.Set("redirect_uri", c.cfg.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, c.cfg.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	target.RawQuery = query.Encode()
+	return target.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the claims of the verified ID token.
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Claims, error) {
+	md, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+
+	var resp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := c.do(req, &resp); err != nil {
+		return nil, fmt.Errorf("oidcclient: token request: %w", err)
+	}
+	return c.VerifyIDToken(ctx, resp.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token.
+func (c *Client) VerifyIDToken(ctx context.Context, idToken, nonce string) (Claims, error) {
+	keys, err := c.keys(ctx, jwt.KeyID(idToken))
+	if err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := keys.Verify(idToken, &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	if claims.String("iss") != c.cfg.Issuer || !containsString(claims.Strings("aud"), c.cfg.ClientID) {
+		return nil, ErrInvalidIDToken
+	}
+	if claims.String("nonce") != nonce || claims.String("sub") == "" {
+		return nil, ErrInvalidIDToken
+	}
+	return claims, nil
+}
+
+// discover returns the provider metadata, fetching it on first use.
+func (c *Client) discover(ctx context.Context) (*Metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var md Metadata
+	if err := c.do(req, &md); err != nil {
+		return nil, fmt.Errorf("oidcclient: discovery: %w", err)
+	}
+	if strings.TrimSuffix(md.Issuer, "/") != c.cfg.Issuer {
+		return nil, fmt.Errorf("oidcclient: discovery document is for issuer %q", md.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, errors.New("oidcclient: discovery document lacks required endpoints")
+	}
+	c.metadata = &md
+	return c.metadata, nil
+}
+
+// keys returns the provider's signing keys, fetching them again if kid is unknown.
+func (c *Client) keys(ctx context.Context, kid string) (jwt.JWKS, error) {
+	md, err := c.discover(ctx)
+	if err != nil {
+		return jwt.JWKS{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.jwks.HasKey(kid) || c.now().Sub(c.jwksFetched) < jwksRefreshInterval {
+		return c.jwks, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, md.JWKSURI, nil)
+	if err != nil {
+		return jwt.JWKS{}, err
+	}
+	var jwks jwt.JWKS
+	if err := c.do(req, &jwks); err != nil {
+		return jwt.JWKS{}, fmt.Errorf("oidcclient: fetching keys: %w", err)
+	}
+	c.jwks, c.jwksFetched = jwks, c.now()
+	return c.jwks, nil
+}
+
+// do sends req and decodes a successful JSON response into v.
+func (c *Client) do(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
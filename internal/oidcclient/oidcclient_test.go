@@ -0,0 +1,94 @@
+package oidcclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/jwt"
+)
+
+type testIDToken struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  string   `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	Nonce     string   `json:"nonce"`
+	Groups    []string `json:"groups"`
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	keys, err := jwt.NewKeySet(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issuer string
+	var challenge, nonce string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Metadata{Issuer: issuer, AuthorizationEndpoint: issuer + "/authorize", TokenEndpoint: issuer + "/token", JWKSURI: issuer + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(keys.JWKS())
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "zpe" || secret != "s3cret" || r.PostFormValue("code") != "the-code" || Challenge(r.PostFormValue("code_verifier")) != challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		tok, _ := keys.Sign(testIDToken{Issuer: issuer, Subject: "u-1", Audience: "zpe", ExpiresAt: time.Now().Add(time.Minute).Unix(), Nonce: nonce, Groups: []string{"admins"}})
+		json.NewEncoder(w).Encode(map[string]string{"id_token": tok, "token_type": "Bearer"})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	issuer = srv.URL
+
+	client := New(Config{Issuer: issuer, ClientID: "zpe", ClientSecret: "s3cret", RedirectURL: "https://app.example.com/callback", Scopes: []string{"email"}}, srv.Client())
+	verifier := NewVerifier()
+	nonce = NewVerifier()
+	authURL, err := client.AuthCodeURL(context.Background(), "the-state", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	query := u.Query()
+	challenge = query.Get("code_challenge")
+	if u.Path != "/authorize" || query.Get("state") != "the-state" || query.Get("scope") != "openid email" || challenge != Challenge(verifier) {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+
+	claims, err := client.Exchange(context.Background(), "the-code", verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.String("sub") != "u-1" || len(claims.Strings("groups")) != 1 || claims.Strings("aud")[0] != "zpe" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+
+	if _, err := client.Exchange(context.Background(), "the-code", verifier, "another-nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expected a nonce mismatch to be rejected, got %v", err)
+	}
+	if _, err := client.Exchange(context.Background(), "the-code", NewVerifier(), nonce); err == nil {
+		t.Error("expected a wrong code verifier to be rejected")
+	}
+
+	// A rotated key is fetched when a token names it.
+	if err := keys.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	tok, _ := keys.Sign(testIDToken{Issuer: issuer, Subject: "u-1", Audience: "zpe", ExpiresAt: time.Now().Add(time.Minute).Unix(), Nonce: nonce})
+	client.now = func() time.Time { return time.Now().Add(time.Minute) }
+	if _, err := client.VerifyIDToken(context.Background(), tok, nonce); err != nil {
+		t.Errorf("expected a token signed with a rotated key to verify, got %v", err)
+	}
+
+	tok, _ = keys.Sign(testIDToken{Issuer: issuer, Subject: "u-1", Audience: "someone-else", ExpiresAt: time.Now().Add(time.Minute).Unix(), Nonce: nonce})
+	if _, err := client.VerifyIDToken(context.Background(), tok, nonce); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expected a token for another audience to be rejected, got %v", err)
+	}
+}
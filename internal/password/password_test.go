@@ -0,0 +1,38 @@
+package password
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// Test vector from RFC 7914, section 11.
+	got := hex.EncodeToString(pbkdf2([]byte("passwd"), []byte("salt"), 1, 64))
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got != want {
+		t.Errorf("unexpected key: got %s want %s", got, want)
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(hash, "correct horse battery staple") {
+		t.Error("expected the password to match its hash")
+	}
+	if Verify(hash, "Correct horse battery staple") {
+		t.Error("expected a different password not to match")
+	}
+	if Verify("md5$abc", "anything") {
+		t.Error("expected an unknown scheme not to match")
+	}
+
+	if err := Validate("short"); err == nil {
+		t.Error("expected a short password to be rejected")
+	}
+	if err := Validate("long enough"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/metrics"
+)
+
+var (
+	denialsTotal = metrics.NewCounter("policy_denials_total", "Requests denied by the access policy, by rule.", "rule")
+	reloadsTotal = metrics.NewCounter("policy_reloads_total", "Reloads of the policy file, by result.", "result")
+)
+
+// Engine evaluates the current policy. Engines loaded from a file pick up changes to
+// it; a file that fails to parse leaves the previous policy in force.
+type Engine struct {
+	path string
+
+	mu      sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+	size    int64
+}
+
+// NewEngine returns an Engine that always evaluates p.
+func NewEngine(p *Policy) *Engine {
+	return &Engine{policy: p}
+}
+
+// Load returns an Engine for the policy file at path.
+func Load(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	if _, err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Policy returns the policy in force.
+func (e *Engine) Policy() *Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.policy
+}
+
+// Evaluate evaluates in against the policy in force.
+func (e *Engine) Evaluate(in Input) Decision {
+	d := e.Policy().Evaluate(in)
+	if !d.Allowed {
+		denialsTotal.Inc(d.Rule)
+	}
+	return d
+}
+
+// Reload reads the policy file again if it changed since it was last read, and
+// reports whether a new policy is in force.
+func (e *Engine) Reload() (bool, error) {
+	if e.path == "" {
+		return false, nil
+	}
+	info, err := os.Stat(e.path)
+	if err != nil {
+		reloadsTotal.Inc("error")
+		return false, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.policy != nil && info.ModTime().Equal(e.modTime) && info.Size() == e.size {
+		return false, nil
+	}
+
+	// The file is not read again until it changes, so a broken file is reported once.
+	e.modTime, e.size = info.ModTime(), info.Size()
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		reloadsTotal.Inc("error")
+		return false, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		reloadsTotal.Inc("error")
+		return false, err
+	}
+	e.policy = p
+	reloadsTotal.Inc("ok")
+	return true, nil
+}
+
+// Watch runs a background loop that reloads the policy file every interval until ctx
+// is cancelled.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reloaded, err := e.Reload()
+				if err != nil {
+					log.Printf("Failed to reload policy %s, keeping the previous policy: %v", e.path, err)
+				} else if reloaded {
+					log.Printf("Reloaded policy %s: %d rules", e.path, len(e.Policy().Rules))
+				}
+			}
+		}
+	}()
+}
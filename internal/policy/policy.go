@@ -0,0 +1,355 @@
+// Package policy evaluates attribute-based access control rules. A policy is a list of
+// deny rules over the actor, the target user, the action and the request context; it
+// can only narrow what the role hierarchy already allows.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Policy is the set of rules loaded from a policy file.
+type Policy struct {
+	// Timezone is the IANA time zone in which the time of day and the weekday of a
+	// request are evaluated. It defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+	Rules    []Rule `json:"rules"`
+
+	loc *time.Location
+}
+
+// Rule denies the actions matching one of Actions when every condition of When holds.
+// Actions are patterns in the syntax of path.Match, such as "users.*" or "*".
+type Rule struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Actions     []string    `json:"actions"`
+	When        []Condition `json:"when,omitempty"`
+}
+
+// Condition compares the attribute at Attr with Value or with the attribute at Ref.
+// Attributes are dotted paths below "actor", "target" or "context", such as
+// "target.attributes.department". A condition may instead combine conditions with Any
+// or All. A missing attribute is equal to nothing, so "ne" and "not_in" hold for it.
+type Condition struct {
+	Attr  string      `json:"attr,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value any         `json:"value,omitempty"`
+	Ref   string      `json:"ref,omitempty"`
+	Any   []Condition `json:"any,omitempty"`
+	All   []Condition `json:"all,omitempty"`
+}
+
+// Operators a condition can use. "contains" holds when the attribute is a list
+// containing the value; "in" when the value is a list containing the attribute.
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpIn       = "in"
+	OpNotIn    = "not_in"
+	OpContains = "contains"
+	OpLt       = "lt"
+	OpLe       = "le"
+	OpGt       = "gt"
+	OpGe       = "ge"
+)
+
+// Input is a request to authorize. Time is added to the context as "hour", "minute",
+// "weekday" (such as "Monday"), "clock" ("15:04") and "date" ("2006-01-02") in the
+// policy time zone.
+type Input struct {
+	Action  string
+	Actor   map[string]any
+	Target  map[string]any
+	Context map[string]any
+	Time    time.Time
+}
+
+// Decision is the outcome of evaluating a policy. Rule names the rule that denied.
+type Decision struct {
+	Allowed     bool   `json:"allowed"`
+	Rule        string `json:"rule,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Parse parses and validates a policy from its JSON representation.
+func Parse(data []byte) (*Policy, error) {
+	p := &Policy{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(p); err != nil {
+			return nil, fmt.Errorf("invalid policy: %w", err)
+		}
+	}
+
+	p.loc = time.UTC
+	if p.Timezone != "" {
+		loc, err := time.LoadLocation(p.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy timezone %q: %w", p.Timezone, err)
+		}
+		p.loc = loc
+	}
+	names := make(map[string]bool)
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %s: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.Actions) == 0 {
+			return nil, fmt.Errorf("rule %s: actions are required", rule.Name)
+		}
+		for _, pattern := range rule.Actions {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %s: invalid action pattern %q", rule.Name, pattern)
+			}
+		}
+		for _, cond := range rule.When {
+			if err := cond.validate(); err != nil {
+				return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+func (c Condition) validate() error {
+	if len(c.Any) > 0 || len(c.All) > 0 {
+		if c.Attr != "" || c.Op != "" || len(c.Any) > 0 && len(c.All) > 0 {
+			return fmt.Errorf("a condition combines conditions with any or all, or compares an attribute")
+		}
+		for _, cond := range append(c.Any, c.All...) {
+			if err := cond.validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, attr := range []string{c.Attr, c.Ref} {
+		root, _, _ := strings.Cut(attr, ".")
+		if attr != "" && root != "actor" && root != "target" && root != "context" {
+			return fmt.Errorf("attribute %q must start with actor, target or context", attr)
+		}
+	}
+	if c.Attr == "" {
+		return fmt.Errorf("condition needs an attr")
+	}
+	if (c.Value == nil) == (c.Ref == "") {
+		return fmt.Errorf("condition on %s needs either a value or a ref", c.Attr)
+	}
+	switch c.Op {
+	case OpEq, OpNe, OpContains, OpLt, OpLe, OpGt, OpGe:
+	case OpIn, OpNotIn:
+		if _, ok := toList(c.Value); c.Ref == "" && !ok {
+			return fmt.Errorf("condition on %s: %s needs a list", c.Attr, c.Op)
+		}
+	default:
+		return fmt.Errorf("condition on %s: unknown operator %q", c.Attr, c.Op)
+	}
+	return nil
+}
+
+// Evaluate denies in if a rule matches it, and allows it otherwise. Rules are tried in
+// order and the first match decides.
+func (p *Policy) Evaluate(in Input) Decision {
+	attrs := map[string]any{"actor": in.Actor, "target": in.Target, "context": p.context(in)}
+	for _, rule := range p.Rules {
+		if rule.matches(in.Action, attrs) {
+			return Decision{Rule: rule.Name, Description: rule.Description}
+		}
+	}
+	return Decision{Allowed: true}
+}
+
+// context adds the time of the request to its context.
+func (p *Policy) context(in Input) map[string]any {
+	ctx := make(map[string]any, len(in.Context)+5)
+	for k, v := range in.Context {
+		ctx[k] = v
+	}
+	if !in.Time.IsZero() {
+		loc := p.loc
+		if loc == nil {
+			loc = time.UTC
+		}
+		t := in.Time.In(loc)
+		ctx["hour"] = t.Hour()
+		ctx["minute"] = t.Minute()
+		ctx["weekday"] = t.Weekday().String()
+		ctx["clock"] = t.Format("15:04")
+		ctx["date"] = t.Format(time.DateOnly)
+	}
+	return ctx
+}
+
+func (r Rule) matches(action string, attrs map[string]any) bool {
+	matched := false
+	for _, pattern := range r.Actions {
+		if ok, _ := path.Match(pattern, action); ok {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	for _, cond := range r.When {
+		if !cond.holds(attrs) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) holds(attrs map[string]any) bool {
+	if len(c.Any) > 0 {
+		for _, cond := range c.Any {
+			if cond.holds(attrs) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(c.All) > 0 {
+		for _, cond := range c.All {
+			if !cond.holds(attrs) {
+				return false
+			}
+		}
+		return true
+	}
+
+	got, found := lookup(attrs, c.Attr)
+	want := c.Value
+	if c.Ref != "" {
+		var ok bool
+		if want, ok = lookup(attrs, c.Ref); !ok {
+			// Nothing is equal to a missing attribute.
+			return c.Op == OpNe || c.Op == OpNotIn
+		}
+	}
+	switch c.Op {
+	case OpEq:
+		return found && equal(got, want)
+	case OpNe:
+		return !found || !equal(got, want)
+	case OpIn:
+		return found && contains(want, got)
+	case OpNotIn:
+		return !found || !contains(want, got)
+	case OpContains:
+		return found && contains(got, want)
+	}
+	if !found {
+		return false
+	}
+	cmp, ok := compare(got, want)
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case OpLt:
+		return cmp < 0
+	case OpLe:
+		return cmp <= 0
+	case OpGt:
+		return cmp > 0
+	case OpGe:
+		return cmp >= 0
+	}
+	return false
+}
+
+// lookup resolves a dotted attribute path in nested maps.
+func lookup(attrs map[string]any, attr string) (any, bool) {
+	var v any = attrs
+	for _, key := range strings.Split(attr, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok || v == nil {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func equal(a, b any) bool {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func contains(list, v any) bool {
+	items, ok := toList(list)
+	if !ok {
+		return false
+	}
+	for _, item := range items {
+		if equal(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare orders two numbers or two strings.
+func compare(a, b any) (int, bool) {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	x, ok := a.(string)
+	y, ok2 := b.(string)
+	if !ok || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+func toNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func toList(v any) ([]any, bool) {
+	switch l := v.(type) {
+	case []any:
+		return l, true
+	case []string:
+		items := make([]any, len(l))
+		for i, s := range l {
+			items[i] = s
+		}
+		return items, true
+	}
+	return nil, false
+}
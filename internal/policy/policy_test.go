@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPolicy = `{
+	"timezone": "America/Sao_Paulo",
+	"rules": [
+		{
+			"name": "modifiers-own-department",
+			"description": "Modifiers may only manage users in their own department",
+			"actions": ["users.*", "groups.*"],
+			"when": [
+				{"attr": "actor.role", "op": "eq", "value": "Modifier"},
+				{"attr": "target.attributes.department", "op": "ne", "ref": "actor.attributes.department"}
+			]
+		},
+		{
+			"name": "business-hours-deletes",
+			"actions": ["users.delete"],
+			"when": [
+				{"any": [
+					{"attr": "context.weekday", "op": "in", "value": ["Saturday", "Sunday"]},
+					{"attr": "context.clock", "op": "lt", "value": "09:00"},
+					{"attr": "context.hour", "op": "ge", "value": 18}
+				]}
+			]
+		}
+	]
+}`
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	modifier := map[string]any{"role": "Modifier", "attributes": map[string]any{"department": "sales"}}
+	admin := map[string]any{"role": "Admin"}
+	sales := map[string]any{"attributes": map[string]any{"department": "sales"}}
+	support := map[string]any{"attributes": map[string]any{"department": "support"}}
+	// 14:00 on a Wednesday in São Paulo.
+	workday := time.Date(2026, 3, 4, 17, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		in     Input
+		denied string
+	}{
+		{"Same department", Input{Action: "users.update_attributes", Actor: modifier, Target: sales, Time: workday}, ""},
+		{"Other department", Input{Action: "groups.add_member", Actor: modifier, Target: support, Time: workday}, "modifiers-own-department"},
+		{"Target without a department", Input{Action: "users.delete", Actor: modifier, Target: map[string]any{}, Time: workday}, "modifiers-own-department"},
+		{"Admins are not restricted to a department", Input{Action: "users.delete", Actor: admin, Target: support, Time: workday}, ""},
+		{"Action outside the rule", Input{Action: "authz.other", Actor: modifier, Target: support, Time: workday}, ""},
+		{"Delete at night", Input{Action: "users.delete", Actor: admin, Target: support, Time: workday.Add(7 * time.Hour)}, "business-hours-deletes"},
+		{"Delete before the office opens", Input{Action: "users.delete", Actor: admin, Target: support, Time: workday.Add(-6 * time.Hour)}, "business-hours-deletes"},
+		{"Delete on a Saturday", Input{Action: "users.delete", Actor: admin, Target: support, Time: workday.AddDate(0, 0, 3)}, "business-hours-deletes"},
+		{"Other actions at night", Input{Action: "users.lockout", Actor: admin, Target: support, Time: workday.Add(7 * time.Hour)}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Evaluate(tt.in)
+			if d.Allowed != (tt.denied == "") || d.Rule != tt.denied {
+				t.Errorf("got %+v, want denial by %q", d, tt.denied)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"Unknown field":         `{"rules": [{"name": "a", "actions": ["*"], "effect": "allow"}]}`,
+		"Missing name":          `{"rules": [{"actions": ["*"]}]}`,
+		"Duplicate name":        `{"rules": [{"name": "a", "actions": ["*"]}, {"name": "a", "actions": ["*"]}]}`,
+		"Missing actions":       `{"rules": [{"name": "a"}]}`,
+		"Bad action pattern":    `{"rules": [{"name": "a", "actions": ["users.["]}]}`,
+		"Unknown operator":      `{"rules": [{"name": "a", "actions": ["*"], "when": [{"attr": "actor.role", "op": "like", "value": "A"}]}]}`,
+		"Unknown root":          `{"rules": [{"name": "a", "actions": ["*"], "when": [{"attr": "user.role", "op": "eq", "value": "A"}]}]}`,
+		"Value and ref":         `{"rules": [{"name": "a", "actions": ["*"], "when": [{"attr": "actor.role", "op": "eq", "value": "A", "ref": "target.role"}]}]}`,
+		"In without a list":     `{"rules": [{"name": "a", "actions": ["*"], "when": [{"attr": "actor.role", "op": "in", "value": "A"}]}]}`,
+		"Any mixed with a test": `{"rules": [{"name": "a", "actions": ["*"], "when": [{"attr": "actor.role", "any": [{"attr": "actor.id", "op": "eq", "value": "1"}]}]}]}`,
+		"Unknown timezone":      `{"timezone": "Mars/Olympus", "rules": []}`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if p, err := Parse(nil); err != nil || !p.Evaluate(Input{Action: "users.delete"}).Allowed {
+		t.Errorf("expected an empty policy to allow everything, got %v", err)
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	write := func(data string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := Input{Action: "users.delete", Actor: map[string]any{"id": "2"}}
+	start := time.Now().Add(-time.Hour)
+
+	write(`{"rules": []}`, start)
+	e, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !e.Evaluate(in).Allowed {
+		t.Fatal("expected the empty policy to allow the delete")
+	}
+	if reloaded, err := e.Reload(); reloaded || err != nil {
+		t.Errorf("expected an unchanged file not to be reloaded, got %t %v", reloaded, err)
+	}
+
+	write(`{"rules": [{"name": "no-deletes", "actions": ["users.delete"]}]}`, start.Add(time.Minute))
+	if reloaded, err := e.Reload(); !reloaded || err != nil {
+		t.Fatalf("expected the changed file to be reloaded, got %t %v", reloaded, err)
+	}
+	if d := e.Evaluate(in); d.Allowed || d.Rule != "no-deletes" {
+		t.Errorf("expected the new rule to deny the delete, got %+v", d)
+	}
+	if got := denialsTotal.Value("no-deletes"); got != 1 {
+		t.Errorf("expected 1 denial to be counted, got %d", got)
+	}
+
+	write(`{"rules": [`, start.Add(2*time.Minute))
+	if _, err := e.Reload(); err == nil {
+		t.Error("expected a broken file to be reported")
+	}
+	if e.Evaluate(in).Allowed {
+		t.Error("expected a broken file to keep the previous policy")
+	}
+	if reloaded, err := e.Reload(); reloaded || err != nil {
+		t.Errorf("expected a broken file to be reported once, got %t %v", reloaded, err)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected a missing file to be reported")
+	}
+}
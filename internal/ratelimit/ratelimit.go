@@ -0,0 +1,261 @@
+// Package ratelimit throttles requests per caller with token buckets. Callers are told
+// their quota in RateLimit-* headers and get 429 with Retry-After once it is used up.
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/metrics"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// Limit is a token bucket: Burst requests at once, refilled at Rate requests per
+// second. A Rate of zero or less lifts the limit.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Rule overrides the default limit for requests to Path made with the role Role.
+// Path follows http.ServeMux: a path ending in a slash matches every path below it,
+// any other path only itself. An empty Path or Role matches all requests.
+type Rule struct {
+	Path string `json:"path"`
+	Role string `json:"role"`
+	Limit
+}
+
+var limitedTotal = metrics.NewCounter("http_rate_limited_total", "Requests refused by the rate limiter, by rule path.", "path")
+
+// bucket holds the tokens of one caller under one rule.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter keeps a token bucket per caller, client IP address and matching rule.
+type Limiter struct {
+	def   Limit
+	rules []Rule
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// sweepInterval is how often buckets that have refilled completely are dropped.
+const sweepInterval = time.Minute
+
+// New creates a Limiter that applies def unless one of rules matches.
+func New(def Limit, rules []Rule) (*Limiter, error) {
+	if def.Rate > 0 && def.Burst < 1 {
+		return nil, fmt.Errorf("default limit: burst must be at least 1")
+	}
+	for i, rule := range rules {
+		if rule.Rate > 0 && rule.Burst < 1 {
+			return nil, fmt.Errorf("rule %d: burst must be at least 1", i)
+		}
+		if rule.Path != "" && !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("rule %d: path %q must start with a slash", i, rule.Path)
+		}
+	}
+	return &Limiter{def: def, rules: rules, now: time.Now, buckets: make(map[string]*bucket)}, nil
+}
+
+// ParseRules parses rules from their JSON representation. Empty input has no rules.
+func ParseRules(data string) ([]Rule, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var rules []Rule
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Middleware wraps next so that each caller is limited as configured. It must run after
+// authentication, since callers are identified by the X-API-Key-ID, X-User-ID and
+// X-User-Type headers, in that order, together with the client IP address. Since a
+// client can claim any X-User-ID, each address should also be limited by
+// AddressMiddleware.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, limit := l.match(r.URL.Path, r.Header.Get("X-User-Type"))
+		if limit.Rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		remaining, reset, retryAfter := l.take(strconv.Itoa(rule)+"|"+callerKey(r), limit)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(reset)))
+		if retryAfter > 0 {
+			path := "*"
+			if rule >= 0 && l.rules[rule].Path != "" {
+				path = l.rules[rule].Path
+			}
+			limitedTotal.Inc(path)
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+			writeError(w, http.StatusTooManyRequests, internalMsgs.ErrTooManyRequests)
+			log.Printf("TooManyRequests: %s %s from %s", r.Method, r.URL.Path, callerKey(r))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AddressMiddleware wraps next so that each client IP address is limited by the default
+// limit, whoever it claims to be. It runs before authentication, so that requests
+// cannot escape it by changing the identity they claim. Rules do not apply.
+func (l *Limiter) AddressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.def.Rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if _, _, retryAfter := l.take("ip|"+ip, l.def); retryAfter > 0 {
+			limitedTotal.Inc("address")
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+			writeError(w, http.StatusTooManyRequests, internalMsgs.ErrTooManyRequests)
+			log.Printf("TooManyRequests: %s %s from address %s", r.Method, r.URL.Path, ip)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// match returns the index of the most specific rule for path and role, or -1 for the
+// default limit. Longer paths are more specific, and a rule naming the role is more
+// specific than one with the same path that does not. Ties go to the earlier rule.
+func (l *Limiter) match(path, role string) (int, Limit) {
+	best, bestScore := -1, -1
+	for i, rule := range l.rules {
+		if !pathMatches(rule.Path, path) || (rule.Role != "" && rule.Role != role) {
+			continue
+		}
+		score := 2 * len(rule.Path)
+		if rule.Role != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return -1, l.def
+	}
+	return best, l.rules[best].Limit
+}
+
+func pathMatches(pattern, path string) bool {
+	if pattern == "" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(path, pattern)
+	}
+	return path == pattern
+}
+
+// take removes a token from the bucket under key. It returns the tokens left, the time
+// until the bucket is full again and, when no token was left, the time until one is.
+func (l *Limiter) take(key string, limit Limit) (remaining int, reset, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	burst := float64(limit.Burst)
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		retryAfter = durationFor(1-b.tokens, limit.Rate)
+	} else {
+		b.tokens--
+	}
+	return int(b.tokens), durationFor(burst-b.tokens, limit.Rate), retryAfter
+}
+
+// sweep drops the buckets that are full again, which is the same as having none. The
+// caller must hold l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		limit := l.limitOf(key)
+		if limit.Rate <= 0 || b.tokens+now.Sub(b.updated).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// limitOf returns the limit of the rule a bucket key was created under.
+func (l *Limiter) limitOf(key string) Limit {
+	prefix, _, _ := strings.Cut(key, "|")
+	rule, err := strconv.Atoi(prefix)
+	if err != nil || rule < 0 || rule >= len(l.rules) {
+		return l.def
+	}
+	return l.rules[rule].Limit
+}
+
+// callerKey identifies the caller of r: by API key, user or role, and client IP address.
+func callerKey(r *http.Request) string {
+	var caller string
+	switch {
+	case r.Header.Get("X-API-Key-ID") != "":
+		caller = "key:" + r.Header.Get("X-API-Key-ID")
+	case r.Header.Get("X-User-ID") != "":
+		caller = "user:" + r.Header.Get("X-Org-ID") + "/" + r.Header.Get("X-User-ID")
+	case r.Header.Get("X-User-Type") != "":
+		caller = "role:" + r.Header.Get("X-Org-ID") + "/" + r.Header.Get("X-User-Type")
+	default:
+		caller = "anonymous"
+	}
+	return caller + "|" + clientIP(r)
+}
+
+// clientIP returns the client IP address of r.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func durationFor(tokens, rate float64) time.Duration {
+	return time.Duration(tokens / rate * float64(time.Second))
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": err.Error()}); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
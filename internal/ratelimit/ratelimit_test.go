@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	rules, err := ParseRules(`[
+		{"path": "/login", "rate": 0.5, "burst": 2},
+		{"path": "/users/", "role": "SuperAdmin", "rate": 0},
+		{"role": "Watcher", "rate": 1, "burst": 1}
+	]`)
+	if err != nil {
+		t.Fatalf("unexpected error parsing rules: %v", err)
+	}
+	limiter, err := New(Limit{Rate: 1, Burst: 3}, rules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	do := func(path, remoteAddr string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	admin := map[string]string{"X-User-Type": "Admin", "X-User-ID": "1", "X-Org-ID": "default"}
+
+	for i, remaining := range []string{"2", "1", "0"} {
+		rr := do("/users", "192.0.2.1:1000", admin)
+		if rr.Code != http.StatusNoContent || rr.Header().Get("RateLimit-Limit") != "3" || rr.Header().Get("RateLimit-Remaining") != remaining {
+			t.Errorf("request %d: unexpected response %v %v", i+1, rr.Code, rr.Header())
+		}
+	}
+	rr := do("/users", "192.0.2.1:1000", admin)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" || rr.Header().Get("RateLimit-Reset") != "3" {
+		t.Errorf("expected the burst to be used up, got %v %v", rr.Code, rr.Header())
+	}
+	if rr := do("/users", "192.0.2.2:1000", admin); rr.Code != http.StatusNoContent {
+		t.Errorf("expected the same caller from another address to have its own bucket, got %v", rr.Code)
+	}
+	if rr := do("/users", "192.0.2.1:1000", map[string]string{"X-User-Type": "Admin", "X-User-ID": "6", "X-Org-ID": "default"}); rr.Code != http.StatusNoContent {
+		t.Errorf("expected another user to have its own bucket, got %v", rr.Code)
+	}
+	if rr := do("/login", "192.0.2.1:1000", admin); rr.Code != http.StatusNoContent || rr.Header().Get("RateLimit-Limit") != "2" {
+		t.Errorf("expected the route rule to have its own bucket, got %v %v", rr.Code, rr.Header())
+	}
+	now = now.Add(time.Second)
+	if rr := do("/users", "192.0.2.1:1000", admin); rr.Code != http.StatusNoContent {
+		t.Errorf("expected a token after a second, got %v", rr.Code)
+	}
+
+	// Role rules apply to every route that has no more specific rule.
+	watcher := map[string]string{"X-User-Type": "Watcher"}
+	if rr := do("/groups", "192.0.2.1:1000", watcher); rr.Code != http.StatusNoContent || rr.Header().Get("RateLimit-Limit") != "1" {
+		t.Errorf("expected the role rule to apply, got %v %v", rr.Code, rr.Header())
+	}
+	if rr := do("/groups", "192.0.2.1:1000", watcher); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the role rule to limit the caller, got %v", rr.Code)
+	}
+	for i := 0; i < 10; i++ {
+		if rr := do("/users/1", "192.0.2.1:1000", map[string]string{"X-User-Type": "SuperAdmin"}); rr.Code != http.StatusNoContent || rr.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("expected a rate of zero to lift the limit, got %v %v", rr.Code, rr.Header())
+		}
+	}
+	if got := limitedTotal.Value("*"); got != 2 {
+		t.Errorf("expected two refused requests, got %d", got)
+	}
+
+	// Full buckets are dropped.
+	now = now.Add(time.Hour)
+	do("/users", "192.0.2.1:1000", admin)
+	if len(limiter.buckets) != 1 {
+		t.Errorf("expected idle buckets to be dropped, got %d", len(limiter.buckets))
+	}
+
+	if _, err := New(Limit{Rate: 1}, nil); err == nil {
+		t.Error("expected a limit without burst to be rejected")
+	}
+	if _, err := New(Limit{Rate: 1, Burst: 1}, []Rule{{Path: "users", Limit: Limit{Rate: 1, Burst: 1}}}); err == nil {
+		t.Error("expected a relative path to be rejected")
+	}
+}
+
+func TestAddressMiddleware(t *testing.T) {
+	limiter, err := New(Limit{Rate: 1, Burst: 2}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	handler := limiter.AddressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	do := func(remoteAddr, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/users", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-User-ID", userID)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Claiming another user on every request does not get a fresh bucket.
+	for i, userID := range []string{"1", "2"} {
+		if rr := do("192.0.2.1:1000", userID); rr.Code != http.StatusNoContent {
+			t.Errorf("request %d: expected to be allowed, got %v", i+1, rr.Code)
+		}
+	}
+	if rr := do("192.0.2.1:2000", "3"); rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected the address to be limited whoever it claims to be, got %v %v", rr.Code, rr.Header())
+	}
+	if rr := do("192.0.2.2:1000", "1"); rr.Code != http.StatusNoContent {
+		t.Errorf("expected another address to have its own bucket, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,347 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// Filter is a parsed filter expression (RFC 7644 section 3.4.2.2).
+type Filter interface {
+	// Match reports whether the resource, in its generic JSON form, matches the filter.
+	Match(resource map[string]any) bool
+}
+
+type logical struct {
+	op          string
+	left, right Filter
+}
+
+func (f logical) Match(resource map[string]any) bool {
+	if f.op == "and" {
+		return f.left.Match(resource) && f.right.Match(resource)
+	}
+	return f.left.Match(resource) || f.right.Match(resource)
+}
+
+type not struct {
+	filter Filter
+}
+
+func (f not) Match(resource map[string]any) bool {
+	return !f.filter.Match(resource)
+}
+
+// valuePath filters on the elements of a multi-valued attribute, as in emails[type eq "work"].
+type valuePath struct {
+	attr   string
+	filter Filter
+}
+
+func (f valuePath) Match(resource map[string]any) bool {
+	for _, element := range elements(resource[lookupKey(resource, f.attr)]) {
+		if m, ok := element.(map[string]any); ok && f.filter.Match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+type comparison struct {
+	path  []string
+	op    string
+	value any
+}
+
+func (f comparison) Match(resource map[string]any) bool {
+	values := resolve(resource, f.path)
+	switch f.op {
+	case "pr":
+		for _, v := range values {
+			if v != nil && v != "" {
+				return true
+			}
+		}
+		return false
+	case "ne":
+		for _, v := range values {
+			if compare(v, "eq", f.value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range values {
+		if compare(v, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns every value found at path, descending into multi-valued attributes.
+func resolve(value any, path []string) []any {
+	if len(path) == 0 {
+		return elements(value)
+	}
+	var result []any
+	for _, element := range elements(value) {
+		if m, ok := element.(map[string]any); ok {
+			result = append(result, resolve(m[lookupKey(m, path[0])], path[1:])...)
+		}
+	}
+	return result
+}
+
+// elements returns the values of a multi-valued attribute, or the value itself.
+func elements(value any) []any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		return v
+	default:
+		return []any{v}
+	}
+}
+
+// compare applies a comparison operator. Strings compare case-insensitively.
+func compare(actual any, op string, expected any) bool {
+	switch a := actual.(type) {
+	case string:
+		e, ok := expected.(string)
+		if !ok {
+			return false
+		}
+		a, e = strings.ToLower(a), strings.ToLower(e)
+		switch op {
+		case "eq":
+			return a == e
+		case "co":
+			return strings.Contains(a, e)
+		case "sw":
+			return strings.HasPrefix(a, e)
+		case "ew":
+			return strings.HasSuffix(a, e)
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	case float64:
+		e, ok := expected.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return a == e
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	case bool:
+		return op == "eq" && actual == expected
+	}
+	return false
+}
+
+var comparisonOperators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+// ParseFilter parses a filter expression such as
+// `userName eq "leia@example.com" and (active eq true or emails[type eq "work"] pr)`.
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, NewError(400, ErrorInvalidFilter, "unexpected %q in filter", p.tokens[p.pos])
+	}
+	return f, nil
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *parser) expect(token string) error {
+	if got := p.next(); got != token {
+		return NewError(400, ErrorInvalidFilter, "expected %q in filter, got %q", token, got)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Filter, error) {
+	if !strings.EqualFold(p.peek(), "not") {
+		return p.parseAtom()
+	}
+	p.next()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return not{filter: f}, nil
+}
+
+func (p *parser) parseAtom() (Filter, error) {
+	token := p.next()
+	switch {
+	case token == "(":
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	case token == "" || !isAttrPath(token):
+		return nil, NewError(400, ErrorInvalidFilter, "expected an attribute path in filter, got %q", token)
+	}
+
+	attr := stripSchema(token)
+	if p.peek() == "[" {
+		p.next()
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return valuePath{attr: attr, filter: f}, nil
+	}
+
+	op := strings.ToLower(p.next())
+	if op == "pr" {
+		return comparison{path: strings.Split(attr, "."), op: op}, nil
+	}
+	if !comparisonOperators[op] {
+		return nil, NewError(400, ErrorInvalidFilter, "unknown operator %q in filter", op)
+	}
+	value, err := parseValue(p.next())
+	if err != nil {
+		return nil, err
+	}
+	return comparison{path: strings.Split(attr, "."), op: op, value: value}, nil
+}
+
+// parseValue parses a comparison value: a JSON string, number, boolean or null.
+func parseValue(token string) (any, error) {
+	var value any
+	if err := json.Unmarshal([]byte(token), &value); err != nil {
+		return nil, NewError(400, ErrorInvalidFilter, "invalid value %q in filter", token)
+	}
+	return value, nil
+}
+
+func isAttrPath(token string) bool {
+	for _, r := range token {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(":._-$", r) {
+			return false
+		}
+	}
+	return unicode.IsLetter(rune(token[0]))
+}
+
+// tokenize splits a filter into words, quoted strings, parentheses and brackets.
+func tokenize(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.IndexByte("()[]", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(expr) && expr[j] != '"'; j++ {
+				if expr[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(expr) {
+				return nil, NewError(400, ErrorInvalidFilter, "unterminated string in filter")
+			}
+			tokens = append(tokens, expr[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(expr) && strings.IndexByte(" \t()[]\"", expr[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,208 @@
+package scim
+
+import (
+	"reflect"
+	"strings"
+)
+
+// path is a parsed PATCH path: attr, attr.sub, attr[filter] or attr[filter].sub.
+type path struct {
+	attr   string
+	filter Filter
+	sub    string
+}
+
+func parsePath(raw string) (path, error) {
+	raw = strings.TrimSpace(raw)
+	var p path
+	if open := strings.IndexByte(raw, '['); open >= 0 {
+		closing := strings.LastIndexByte(raw, ']')
+		if closing < open {
+			return p, NewError(400, ErrorInvalidPath, "invalid path %q", raw)
+		}
+		f, err := ParseFilter(raw[open+1 : closing])
+		if err != nil {
+			return p, NewError(400, ErrorInvalidPath, "invalid path %q: %v", raw, err)
+		}
+		p.attr, p.filter = stripSchema(raw[:open]), f
+		rest := raw[closing+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") || strings.Contains(rest[1:], ".") {
+				return p, NewError(400, ErrorInvalidPath, "invalid path %q", raw)
+			}
+			p.sub = rest[1:]
+		}
+	} else {
+		attr, sub, _ := strings.Cut(stripSchema(raw), ".")
+		if strings.Contains(sub, ".") {
+			return p, NewError(400, ErrorInvalidPath, "invalid path %q", raw)
+		}
+		p.attr, p.sub = attr, sub
+	}
+	if p.attr == "" || !isAttrPath(p.attr) || (p.sub != "" && !isAttrPath(p.sub)) {
+		return p, NewError(400, ErrorInvalidPath, "invalid path %q", raw)
+	}
+	return p, nil
+}
+
+// ApplyPatch applies PATCH operations to a resource in its generic JSON form. The
+// resource is modified in place; on error it may be partially modified.
+func ApplyPatch(resource map[string]any, ops []PatchOperation) error {
+	for _, op := range ops {
+		if err := applyOperation(resource, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyOperation(resource map[string]any, op PatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return NewError(400, ErrorInvalidSyntax, "unknown operation %q", op.Op)
+	}
+
+	if op.Path == "" {
+		if kind == "remove" {
+			return NewError(400, ErrorNoTarget, "remove requires a path")
+		}
+		values, ok := op.Value.(map[string]any)
+		if !ok {
+			return NewError(400, ErrorInvalidValue, "%s without a path requires an object value", kind)
+		}
+		// Some providers send attribute paths such as "name.givenName" as keys.
+		for key, value := range values {
+			if err := applyOperation(resource, PatchOperation{Op: kind, Path: key, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	p, err := parsePath(op.Path)
+	if err != nil {
+		return err
+	}
+	key := lookupKey(resource, p.attr)
+	if p.filter != nil {
+		return applyFiltered(resource, key, p, kind, op.Value)
+	}
+
+	if p.sub != "" {
+		switch current := resource[key].(type) {
+		case []any:
+			for _, element := range current {
+				if m, ok := element.(map[string]any); ok {
+					setOrRemove(m, p.sub, kind, op.Value)
+				}
+			}
+		case map[string]any:
+			setOrRemove(current, p.sub, kind, op.Value)
+		case nil:
+			if kind != "remove" {
+				resource[key] = map[string]any{p.sub: op.Value}
+			}
+		default:
+			return NewError(400, ErrorInvalidPath, "%s has no sub-attributes", p.attr)
+		}
+		return nil
+	}
+
+	switch kind {
+	case "add":
+		current, multi := resource[key].([]any)
+		if !multi {
+			resource[key] = op.Value
+			return nil
+		}
+		for _, value := range elements(op.Value) {
+			if !containsValue(current, value) {
+				current = append(current, value)
+			}
+		}
+		resource[key] = current
+	case "replace":
+		resource[key] = op.Value
+	case "remove":
+		current, multi := resource[key].([]any)
+		if !multi || op.Value == nil {
+			delete(resource, key)
+			return nil
+		}
+		// Some providers remove members by listing them in the value instead of a filter.
+		kept := current[:0]
+		for _, element := range current {
+			if !containsValue(elements(op.Value), element) {
+				kept = append(kept, element)
+			}
+		}
+		resource[key] = kept
+	}
+	return nil
+}
+
+// applyFiltered applies an operation to the elements of a multi-valued attribute that
+// match the path's filter.
+func applyFiltered(resource map[string]any, key string, p path, kind string, value any) error {
+	current, _ := resource[key].([]any)
+	matched := false
+	kept := make([]any, 0, len(current))
+	for _, element := range current {
+		m, ok := element.(map[string]any)
+		if !ok || !p.filter.Match(m) {
+			kept = append(kept, element)
+			continue
+		}
+		matched = true
+		switch {
+		case p.sub != "":
+			setOrRemove(m, p.sub, kind, value)
+		case kind == "remove":
+			continue
+		case kind == "replace":
+			replacement, ok := value.(map[string]any)
+			if !ok {
+				return NewError(400, ErrorInvalidValue, "replacing %s requires an object value", p.attr)
+			}
+			m = replacement
+		default:
+			values, ok := value.(map[string]any)
+			if !ok {
+				return NewError(400, ErrorInvalidValue, "adding to %s requires an object value", p.attr)
+			}
+			for k, v := range values {
+				m[lookupKey(m, k)] = v
+			}
+		}
+		kept = append(kept, m)
+	}
+	if !matched {
+		return NewError(400, ErrorNoTarget, "no %s matched the filter", p.attr)
+	}
+	resource[key] = kept
+	return nil
+}
+
+func setOrRemove(m map[string]any, name, kind string, value any) {
+	if kind == "remove" {
+		delete(m, lookupKey(m, name))
+		return
+	}
+	m[lookupKey(m, name)] = value
+}
+
+// containsValue reports whether list holds value. Complex values are compared by their
+// "value" sub-attribute, so that members and emails are not added twice.
+func containsValue(list []any, value any) bool {
+	for _, element := range list {
+		if reflect.DeepEqual(element, value) {
+			return true
+		}
+		em, ok1 := element.(map[string]any)
+		vm, ok2 := value.(map[string]any)
+		if ok1 && ok2 && em[lookupKey(em, "value")] != nil && em[lookupKey(em, "value")] == vm[lookupKey(vm, "value")] {
+			return true
+		}
+	}
+	return false
+}
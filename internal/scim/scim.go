@@ -0,0 +1,161 @@
+// Package scim implements the protocol parts of SCIM 2.0 (RFC 7643 and RFC 7644) that do
+// not depend on the resources being provisioned: messages, errors, filter expressions and
+// PATCH operations. Resources are handled in their generic JSON form, map[string]any.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schema URNs used by the service.
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Error types from RFC 7644 section 3.12.
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorNoTarget      = "noTarget"
+	ErrorUniqueness    = "uniqueness"
+	ErrorMutability    = "mutability"
+)
+
+// Error is a SCIM error response. It doubles as a Go error so that protocol failures can
+// be returned from parsing and patching and written out unchanged.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError returns a SCIM error with the given HTTP status.
+func NewError(status int, scimType, format string, args ...any) *Error {
+	return &Error{
+		Schemas:  []string{SchemaError},
+		Status:   fmt.Sprint(status),
+		ScimType: scimType,
+		Detail:   fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// Meta is the resource metadata.
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Version      string     `json:"version,omitempty"`
+}
+
+// ListResponse is the result of a query.
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// PatchOperation is one operation of a PATCH request.
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+// PatchRequest is the body of a PATCH request.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// Page returns the 1-based startIndex and the count of a query, applying the defaults
+// and limits of RFC 7644 section 3.4.2.4: a startIndex below 1 is treated as 1 and a
+// negative count as 0.
+func Page(startIndex, count string, defaultCount, maxCount int) (int, int, error) {
+	start, n := 1, defaultCount
+	if startIndex != "" {
+		if _, err := fmt.Sscan(startIndex, &start); err != nil {
+			return 0, 0, NewError(400, ErrorInvalidValue, "invalid startIndex %q", startIndex)
+		}
+		start = max(start, 1)
+	}
+	if count != "" {
+		if _, err := fmt.Sscan(count, &n); err != nil {
+			return 0, 0, NewError(400, ErrorInvalidValue, "invalid count %q", count)
+		}
+		n = max(n, 0)
+	}
+	return start, min(n, maxCount), nil
+}
+
+// ToMap converts a resource to its generic JSON form.
+func ToMap(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FromMap converts a resource in its generic JSON form back into v.
+func FromMap(m map[string]any, v any) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return NewError(400, ErrorInvalidValue, "invalid resource: %v", err)
+	}
+	return nil
+}
+
+// lookupKey returns the key of m matching name case-insensitively, as attribute names
+// are case-insensitive in SCIM. It returns name itself when m has no such key.
+func lookupKey(m map[string]any, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+// stripSchema removes a schema URN prefix such as
+// "urn:ietf:params:scim:schemas:core:2.0:User:" from an attribute path.
+func stripSchema(path string) string {
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		if i := strings.LastIndex(path, ":"); i >= 0 {
+			return path[i+1:]
+		}
+	}
+	return path
+}
@@ -0,0 +1,163 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func resource(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestFilter(t *testing.T) {
+	user := resource(t, `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"id": "1",
+		"userName": "Leia@Example.com",
+		"name": {"formatted": "Leia Organa"},
+		"active": true,
+		"emails": [{"value": "leia@example.com", "type": "work", "primary": true}],
+		"roles": [{"value": "Admin"}],
+		"meta": {"lastModified": "2024-07-01T12:00:00Z"}
+	}`)
+
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{`userName eq "leia@example.com"`, true},
+		{`USERNAME Eq "LEIA@EXAMPLE.COM"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "leia@example.com"`, true},
+		{`userName ne "leia@example.com"`, false},
+		{`name.formatted sw "Leia"`, true},
+		{`name.formatted ew "Skywalker"`, false},
+		{`emails.value co "example"`, true},
+		{`emails[type eq "work" and primary eq true]`, true},
+		{`emails[type eq "home"]`, false},
+		{`roles.value eq "Admin" and active eq true`, true},
+		{`roles.value eq "Watcher" or (active eq true and not (id eq "2"))`, true},
+		{`externalId pr`, false},
+		{`name pr`, true},
+		{`meta.lastModified gt "2024-01-01T00:00:00Z"`, true},
+		{`meta.lastModified lt "2024-01-01T00:00:00Z"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(user); got != tt.match {
+				t.Errorf("got %v want %v", got, tt.match)
+			}
+		})
+	}
+
+	for _, invalid := range []string{``, `userName`, `userName xx "a"`, `userName eq`, `userName eq "a`, `(userName eq "a"`, `userName eq "a" extra`, `emails[type eq "work"`} {
+		t.Run("invalid "+invalid, func(t *testing.T) {
+			_, err := ParseFilter(invalid)
+			var scimErr *Error
+			if !errors.As(err, &scimErr) || scimErr.ScimType != ErrorInvalidFilter {
+				t.Errorf("expected invalidFilter error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops      string
+		expected string
+		scimType string
+	}{
+		{
+			name:     "Replace simple attribute",
+			ops:      `[{"op": "Replace", "path": "active", "value": false}]`,
+			expected: `{"displayName": "Admins", "active": false, "members": [{"value": "1"}, {"value": "2"}]}`,
+		},
+		{
+			name:     "Add members without duplicates",
+			ops:      `[{"op": "add", "path": "members", "value": [{"value": "2"}, {"value": "3"}]}]`,
+			expected: `{"displayName": "Admins", "members": [{"value": "1"}, {"value": "2"}, {"value": "3"}]}`,
+		},
+		{
+			name:     "Remove member by filter",
+			ops:      `[{"op": "remove", "path": "members[value eq \"1\"]"}]`,
+			expected: `{"displayName": "Admins", "members": [{"value": "2"}]}`,
+		},
+		{
+			name:     "Remove member by value",
+			ops:      `[{"op": "remove", "path": "members", "value": [{"value": "2"}]}]`,
+			expected: `{"displayName": "Admins", "members": [{"value": "1"}]}`,
+		},
+		{
+			name:     "Replace without path",
+			ops:      `[{"op": "replace", "value": {"displayName": "Operators", "name.formatted": "Ops"}}]`,
+			expected: `{"displayName": "Operators", "name": {"formatted": "Ops"}, "members": [{"value": "1"}, {"value": "2"}]}`,
+		},
+		{
+			name:     "Replace sub-attribute of filtered element",
+			ops:      `[{"op": "replace", "path": "members[value eq \"2\"].display", "value": "Obi-Wan"}]`,
+			expected: `{"displayName": "Admins", "members": [{"value": "1"}, {"value": "2", "display": "Obi-Wan"}]}`,
+		},
+		{
+			name:     "Remove whole attribute",
+			ops:      `[{"op": "remove", "path": "members"}]`,
+			expected: `{"displayName": "Admins"}`,
+		},
+		{name: "No target", ops: `[{"op": "remove", "path": "members[value eq \"9\"]"}]`, scimType: ErrorNoTarget},
+		{name: "Remove without path", ops: `[{"op": "remove"}]`, scimType: ErrorNoTarget},
+		{name: "Invalid path", ops: `[{"op": "add", "path": "members[value eq", "value": 1}]`, scimType: ErrorInvalidPath},
+		{name: "Unknown operation", ops: `[{"op": "move", "path": "members"}]`, scimType: ErrorInvalidSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := resource(t, `{"displayName": "Admins", "members": [{"value": "1"}, {"value": "2"}]}`)
+			var ops []PatchOperation
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatal(err)
+			}
+			err := ApplyPatch(group, ops)
+			if tt.scimType != "" {
+				var scimErr *Error
+				if !errors.As(err, &scimErr) || scimErr.ScimType != tt.scimType {
+					t.Errorf("expected %s error, got %v", tt.scimType, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := resource(t, tt.expected); !reflect.DeepEqual(group, expected) {
+				t.Errorf("got %v want %v", group, expected)
+			}
+		})
+	}
+}
+
+func TestPage(t *testing.T) {
+	tests := []struct {
+		startIndex, count string
+		start, n          int
+		err               bool
+	}{
+		{"", "", 1, 100, false},
+		{"0", "-5", 1, 0, false},
+		{"11", "5000", 11, 200, false},
+		{"x", "", 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, n, err := Page(tt.startIndex, tt.count, 100, 200)
+		if (err != nil) != tt.err || start != tt.start || n != tt.n {
+			t.Errorf("Page(%q, %q) = %d, %d, %v", tt.startIndex, tt.count, start, n, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	signer := NewSigner([]byte("0123456789abcdef0123456789abcdef"))
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	signer.now = func() time.Time { return now }
+
+	claims := Claims{ID: NewID(), Purpose: "invite", Subject: "42", OrgID: "default", ExpiresAt: now.Add(time.Hour).Unix()}
+	tok, err := signer.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := signer.Verify(tok, "invite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != claims {
+		t.Errorf("unexpected claims: got %+v want %+v", got, claims)
+	}
+
+	other := NewSigner([]byte("fedcba9876543210fedcba9876543210"))
+	tests := []struct {
+		name    string
+		signer  *Signer
+		token   string
+		purpose string
+	}{
+		{name: "Wrong purpose", signer: signer, token: tok, purpose: "reset"},
+		{name: "Wrong key", signer: other, token: tok, purpose: "invite"},
+		{name: "Tampered payload", signer: signer, token: "x" + tok, purpose: "invite"},
+		{name: "Malformed", signer: signer, token: "garbage", purpose: "invite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.signer.Verify(tt.token, tt.purpose); !errors.Is(err, internalMsgs.ErrInvalidToken) {
+				t.Errorf("expected ErrInvalidToken, got %v", err)
+			}
+		})
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := signer.Verify(tok, "invite"); !errors.Is(err, internalMsgs.ErrInvalidToken) {
+		t.Errorf("expected expired token to be rejected, got %v", err)
+	}
+}
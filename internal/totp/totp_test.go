@@ -0,0 +1,53 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// SHA1 test vectors from RFC 6238, appendix B.
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "94287082"},
+		{unix: 1111111109, code: "07081804"},
+		{unix: 1111111111, code: "14050471"},
+		{unix: 1234567890, code: "89005924"},
+		{unix: 2000000000, code: "69279037"},
+		{unix: 20000000000, code: "65353130"},
+	}
+	for _, tt := range tests {
+		if got := hotp(key, Counter(time.Unix(tt.unix, 0)), 8); got != tt.code {
+			t.Errorf("unexpected code at %d: got %s want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := GenerateSecret()
+	now := time.Unix(1700000000, 0)
+
+	code, err := Code(secret, Counter(now.Add(-Period)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter, ok := Validate(secret, code, now)
+	if !ok || counter != Counter(now)-1 {
+		t.Errorf("expected the previous step to be accepted, got %d %v", counter, ok)
+	}
+	if _, ok := Validate(secret, code, now.Add(2*Period)); ok {
+		t.Error("expected a code outside the skew to be rejected")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("expected a short code to be rejected")
+	}
+
+	uri := URI("User Management", "leia@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/User%20Management:leia@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected URI: %s", uri)
+	}
+}
@@ -0,0 +1,161 @@
+package user
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+	"zpe-cloud-user-management-service/internal/policy"
+)
+
+// AuditPolicyDenied is recorded when the access policy denies a request.
+const AuditPolicyDenied = "policy.denied"
+
+var (
+	policyMu     sync.RWMutex
+	policyEngine *policy.Engine
+)
+
+// SetPolicyEngine installs the attribute-based access policy that is applied after the
+// role checks. Nil disables it.
+func SetPolicyEngine(e *policy.Engine) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	policyEngine = e
+}
+
+func currentPolicyEngine() *policy.Engine {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return policyEngine
+}
+
+// policyRequest describes an action to the access policy.
+type policyRequest struct {
+	orgID  string
+	action string
+	actor  AuthzActor
+	// targetID is the user the action applies to, or pending when it is not stored yet.
+	targetID string
+	pending  *User
+	context  map[string]any
+	at       time.Time
+}
+
+// evaluatePolicy evaluates the access policy for req. Everything is allowed when no
+// policy is installed.
+func evaluatePolicy(req policyRequest) policy.Decision {
+	e := currentPolicyEngine()
+	if e == nil {
+		return policy.Decision{Allowed: true}
+	}
+
+	ctx := map[string]any{"org_id": req.orgID}
+	for k, v := range req.context {
+		ctx[k] = v
+	}
+	in := policy.Input{Action: req.action, Context: ctx, Time: req.at}
+
+	mu.Lock()
+	in.Actor = map[string]any{}
+	if user, exists := lookupUser(req.orgID, req.actor.UserID); exists {
+		in.Actor = policyAttributes(user)
+	}
+	if req.actor.Role != "" {
+		in.Actor["role"] = req.actor.Role
+	}
+	if req.pending != nil {
+		in.Target = policyAttributes(req.pending)
+	} else if user, exists := lookupUser(req.orgID, req.targetID); exists {
+		in.Target = policyAttributes(user)
+	}
+	mu.Unlock()
+
+	return e.Evaluate(in)
+}
+
+// policyAttributes describes user to the access policy. The caller must hold mu.
+func policyAttributes(user *User) map[string]any {
+	attributes := make(map[string]any, len(user.Attributes))
+	for k, v := range user.Attributes {
+		attributes[k] = v
+	}
+	_, domain, _ := strings.Cut(user.Email, "@")
+	return map[string]any{
+		"id":           user.ID,
+		"role":         topRole(user),
+		"roles":        append([]string{}, user.Roles...),
+		"status":       user.Status,
+		"email":        user.Email,
+		"email_domain": domain,
+		"deleted":      user.IsDeleted(),
+		"attributes":   attributes,
+	}
+}
+
+// requestPolicyContext describes the request r to the access policy.
+func requestPolicyContext(r *http.Request) map[string]any {
+	ctx := map[string]any{
+		"method":       r.Method,
+		"path":         r.URL.Path,
+		"api_key":      r.Header.Get("X-API-Key-ID") != "",
+		"impersonated": r.Header.Get("X-Impersonator-ID") != "",
+	}
+	if addr := remoteIP(r.RemoteAddr); addr.IsValid() {
+		ctx["ip"] = addr.String()
+	}
+	return ctx
+}
+
+// checkUserPermission checks that the caller may perform action on the user id, whose
+// highest role is targetUserRole: the role hierarchy must allow it, and then the access
+// policy.
+func checkUserPermission(w http.ResponseWriter, r *http.Request, orgID, action, id, currentUserRole, targetUserRole string) bool {
+	return checkPermission(w, currentUserRole, targetUserRole) && checkPolicy(w, r, orgID, action, id, nil, nil)
+}
+
+// checkPolicy applies the access policy to a request that passed the role checks, for
+// the user targetID or the user pending that is not stored yet. extra is added to the
+// request context. It writes a 403 response and returns false when a rule denies it.
+func checkPolicy(w http.ResponseWriter, r *http.Request, orgID, action, targetID string, pending *User, extra map[string]any) bool {
+	if err := policyDenial(r, orgID, action, targetID, pending, extra); err != nil {
+		errResponse(w, http.StatusForbidden, err)
+		return false
+	}
+	return true
+}
+
+// policyDenial is checkPolicy for handlers that write their own error responses: it
+// records and logs a denial and returns it as an ErrPolicyDenied error.
+func policyDenial(r *http.Request, orgID, action, targetID string, pending *User, extra map[string]any) error {
+	ctx := requestPolicyContext(r)
+	for k, v := range extra {
+		ctx[k] = v
+	}
+	d := evaluatePolicy(policyRequest{
+		orgID:    orgID,
+		action:   action,
+		actor:    AuthzActor{UserID: r.Header.Get("X-User-ID"), Role: r.Header.Get("X-User-Type")},
+		targetID: targetID,
+		pending:  pending,
+		context:  ctx,
+		at:       time.Now(),
+	})
+	if d.Allowed {
+		return nil
+	}
+
+	audit.Record(audit.Event{
+		Type:    AuditPolicyDenied,
+		OrgID:   orgID,
+		Actor:   currentActor(r),
+		Target:  targetID,
+		Details: map[string]string{"action": action, "rule": d.Rule},
+	})
+	log.Printf("Forbidden: UserType=%s %s on %q denied by policy rule %s", r.Header.Get("X-User-Type"), action, targetID, d.Rule)
+	return fmt.Errorf("%w: %s", internalMsgs.ErrPolicyDenied, d.Rule)
+}
@@ -0,0 +1,151 @@
+package user
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"zpe-cloud-user-management-service/internal/audit"
+	"zpe-cloud-user-management-service/internal/policy"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	setupTestStorageWithUsers()
+	InitializeAttributeSchema()
+	defer InitializeAttributeSchema()
+	audit.Reset()
+	if err := CreateAttributeDefinition(DefaultOrgID, &AttributeDefinition{Name: "department", Type: AttributeTypeString}); err != nil {
+		t.Fatalf("failed to define attribute: %v", err)
+	}
+	for id, department := range map[string]string{"2": "sales", "3": "sales", "5": "support"} {
+		if _, err := UpdateUserAttributes(DefaultOrgID, id, map[string]interface{}{"department": department}, "system", nil); err != nil {
+			t.Fatalf("failed to set department: %v", err)
+		}
+	}
+	p, err := policy.Parse([]byte(`{"rules": [
+		{
+			"name": "modifiers-own-department",
+			"description": "Modifiers may only manage users in their own department",
+			"actions": ["users.*", "groups.*"],
+			"when": [
+				{"attr": "actor.role", "op": "eq", "value": "Modifier"},
+				{"attr": "target.attributes.department", "op": "ne", "ref": "actor.attributes.department"}
+			]
+		},
+		{"name": "no-weekend-deletes", "actions": ["users.delete"], "when": [{"attr": "context.weekday", "op": "in", "value": ["Saturday", "Sunday"]}]}
+	]}`))
+	if err != nil {
+		t.Fatalf("invalid policy: %v", err)
+	}
+	SetPolicyEngine(policy.NewEngine(p))
+	defer SetPolicyEngine(nil)
+
+	modifier := callerHeaders("Modifier", "2")
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Same department", "PUT", "/users/3/attributes", `{"attributes":{"department":"sales"}}`, http.StatusOK},
+		{"Other department", "PUT", "/users/5/attributes", `{"attributes":{"department":"sales"}}`, http.StatusForbidden},
+		{"Role hierarchy still applies first", "DELETE", "/users/1", "", http.StatusForbidden},
+		{"New user in another department", "POST", "/users", `{"name":"Krillin","email":"krillin@example.com","roles":["Watcher"],"attributes":{"department":"support"}}`, http.StatusForbidden},
+		{"New user in the same department", "POST", "/users", `{"name":"Krillin","email":"krillin@example.com","roles":["Watcher"],"attributes":{"department":"sales"}}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doRequest(t, tt.method, tt.path, modifier, tt.body); rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+
+	rr := doRequest(t, "PUT", "/users/5/attributes", modifier, `{"attributes":{"department":"sales"}}`)
+	if !strings.Contains(rr.Body.String(), "denied by access policy: modifiers-own-department") {
+		t.Errorf("expected the denying rule in the response, got %s", rr.Body.String())
+	}
+	if events := audit.Events(AuditPolicyDenied); len(events) != 3 || events[0].Target != "5" || events[0].Details["rule"] != "modifiers-own-department" {
+		t.Errorf("unexpected policy events %+v", events)
+	}
+
+	admin := callerHeaders("Admin", "")
+	_, result := checkAuthz(t, admin, `{"actor":{"user_id":"2"},"action":"users.update_attributes","target":{"user_id":"5"}}`)
+	if result.Allowed || result.DecidedBy != "policy" || !strings.Contains(result.Rules[len(result.Rules)-1].Detail, "own department") {
+		t.Errorf("expected the policy to explain the denial, got %+v", result)
+	}
+	saturday := `{"actor":{"role":"Admin"},"action":"users.delete","target":{"user_id":"5"},"at":"2026-03-07T12:00:00Z"}`
+	if _, result := checkAuthz(t, admin, saturday); result.Allowed || result.Rules[len(result.Rules)-1].Detail != "rule no-weekend-deletes denies users.delete" {
+		t.Errorf("expected deletes to be denied on Saturdays, got %+v", result)
+	}
+	if _, result := checkAuthz(t, admin, strings.Replace(saturday, "07T", "09T", 1)); !result.Allowed || result.DecidedBy != "policy" {
+		t.Errorf("expected deletes to be allowed on Mondays, got %+v", result)
+	}
+	create := `{"actor":{"user_id":"2"},"action":"users.create","target":{"roles":["Watcher"],"attributes":{"department":"support"}}}`
+	if _, result := checkAuthz(t, admin, create); result.Allowed || result.DecidedBy != "policy" {
+		t.Errorf("expected the policy to see the attributes of the new user, got %+v", result)
+	}
+	if _, result := checkAuthz(t, admin, strings.Replace(create, "support", "sales", 1)); !result.Allowed {
+		t.Errorf("expected a new user in the same department to be allowed, got %+v", result)
+	}
+}
+
+func TestAccessPolicyMutatingPaths(t *testing.T) {
+	setupTestStorageWithUsers()
+	audit.Reset()
+	admin, idp := callerHeaders("Admin", "1"), scimHeaders(t, "Admin")
+	rr := doRequest(t, "POST", "/access-reviews", admin, `{"name":"Q3 recertification","reviewers":["1","6"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("failed to create access review: %s", rr.Body.String())
+	}
+	reviewID, _ := decodeResponse(rr)["id"].(string)
+
+	p, err := policy.Parse([]byte(`{"rules": [{
+		"name": "change-freeze",
+		"actions": ["users.create", "users.update_attributes", "users.delete", "groups.*", "service_accounts.*", "access_reviews.*", "role_change_requests.*"],
+		"when": [{"attr": "actor.role", "op": "eq", "value": "Admin"}]
+	}]}`))
+	if err != nil {
+		t.Fatalf("invalid policy: %v", err)
+	}
+	SetPolicyEngine(policy.NewEngine(p))
+	defer SetPolicyEngine(nil)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"Create group", "POST", "/groups", `{"name":"Ops","roles":["Watcher"]}`},
+		{"Create service account", "POST", "/service-accounts", `{"name":"ci","roles":["Watcher"]}`},
+		{"Create access review", "POST", "/access-reviews", `{"name":"Q4 recertification","reviewers":["1","6"]}`},
+		{"Close access review", "POST", "/access-reviews/" + reviewID + "/close", ""},
+		{"SCIM create", "POST", "/scim/v2/Users", `{"userName":"ahsoka@example.com","name":{"formatted":"Ahsoka Tano"},"emails":[{"value":"ahsoka@example.com","primary":true}]}`},
+		{"SCIM replace", "PUT", "/scim/v2/Users/5", `{"userName":"gohan@example.com","displayName":"Gohan","emails":[{"value":"gohan@example.com","primary":true}],"active":true}`},
+		{"SCIM delete", "DELETE", "/scim/v2/Users/5", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := admin
+			if strings.HasPrefix(tt.path, "/scim/") {
+				headers = idp
+			}
+			rr := doRequest(t, tt.method, tt.path, headers, tt.body)
+			if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "denied by access policy: change-freeze") {
+				t.Errorf("expected the policy to deny it, got %v: %s", rr.Code, rr.Body.String())
+			}
+		})
+	}
+	if _, err := GetUser(DefaultOrgID, "5"); err != nil {
+		t.Errorf("expected the user to survive a denied SCIM delete: %v", err)
+	}
+	if events := audit.Events(AuditPolicyDenied); len(events) != len(tests) {
+		t.Errorf("expected %d policy events, got %+v", len(tests), events)
+	}
+
+	_, result := checkAuthz(t, admin, `{"action":"role_change_requests.approve","target":{"user_id":"5","roles":["Modifier"]}}`)
+	if result.Allowed || result.DecidedBy != "policy" {
+		t.Errorf("expected the policy to deny approvals, got %+v", result)
+	}
+}
@@ -0,0 +1,469 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	"zpe-cloud-user-management-service/internal/ids"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// AccessReview is a campaign to recertify the role assignments of an organization:
+// direct roles, time-bound grants and group memberships. It snapshots who holds the
+// roles under review when it is created, and each assignment is approved or revoked by
+// its reviewer. Closing the campaign removes the revoked assignments at their source.
+type AccessReview struct {
+	ID        string   `json:"id"`
+	OrgID     string   `json:"org_id"`
+	Name      string   `json:"name"`
+	Roles     []string `json:"roles"`
+	Reviewers []string `json:"reviewers"`
+	// RevokeUndecided also revokes the assignments still pending when the campaign closes.
+	RevokeUndecided bool                `json:"revoke_undecided"`
+	Status          string              `json:"status"`
+	CreatedBy       string              `json:"created_by"`
+	CreatedAt       time.Time           `json:"created_at"`
+	DueAt           *time.Time          `json:"due_at,omitempty"`
+	ClosedBy        string              `json:"closed_by,omitempty"`
+	ClosedAt        *time.Time          `json:"closed_at,omitempty"`
+	Summary         AccessReviewSummary `json:"summary"`
+	Items           []*AccessReviewItem `json:"items,omitempty"`
+}
+
+// AccessReviewSummary counts the assignments of a campaign by decision.
+type AccessReviewSummary struct {
+	Pending  int `json:"pending"`
+	Approved int `json:"approved"`
+	Revoked  int `json:"revoked"`
+}
+
+// AccessReviewItem is one role assignment under review.
+type AccessReviewItem struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	// Source is where the user gets the role from. Revoking a group role removes the
+	// user from the group.
+	Source    RoleSource `json:"source"`
+	Reviewer  string     `json:"reviewer"`
+	Decision  string     `json:"decision"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	// Outcome records what closing the campaign did to the assignment.
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// Access review statuses.
+const (
+	AccessReviewOpen   = "open"
+	AccessReviewClosed = "closed"
+)
+
+// Access review decisions.
+const (
+	AccessReviewPending  = "pending"
+	AccessReviewApproved = "approved"
+	AccessReviewRevoked  = "revoked"
+)
+
+// Outcomes of the assignments of a closed access review.
+const (
+	AccessReviewKept           = "kept"
+	AccessReviewRemoved        = "removed"
+	AccessReviewAlreadyRemoved = "already_removed"
+	AccessReviewUserNotFound   = "user_not_found"
+	AccessReviewFailed         = "failed"
+)
+
+// Audit event types emitted for access reviews.
+const (
+	AuditAccessReviewCreated     = "access_review.created"
+	AuditAccessReviewDecided     = "access_review.decided"
+	AuditAccessReviewRoleRevoked = "access_review.role_revoked"
+	AuditAccessReviewClosed      = "access_review.closed"
+)
+
+// accessReviews and accessReviewIDGenerator are guarded by mu.
+var (
+	accessReviews                         = make(map[string]*AccessReview)
+	accessReviewIDGenerator ids.Generator = ids.NewSequential()
+)
+
+func (a *AccessReview) clone() *AccessReview {
+	c := *a
+	c.Roles = append([]string{}, a.Roles...)
+	c.Reviewers = append([]string{}, a.Reviewers...)
+	c.Items = make([]*AccessReviewItem, len(a.Items))
+	for i, item := range a.Items {
+		itemCopy := *item
+		c.Items[i] = &itemCopy
+	}
+	return &c
+}
+
+// summarize recounts the decisions of the campaign.
+func (a *AccessReview) summarize() {
+	a.Summary = AccessReviewSummary{}
+	for _, item := range a.Items {
+		switch item.Decision {
+		case AccessReviewApproved:
+			a.Summary.Approved++
+		case AccessReviewRevoked:
+			a.Summary.Revoked++
+		default:
+			a.Summary.Pending++
+		}
+	}
+}
+
+// revokes reports whether closing the campaign removes the assignment.
+func (a *AccessReview) revokes(item *AccessReviewItem) bool {
+	return item.Decision == AccessReviewRevoked || (item.Decision == AccessReviewPending && a.RevokeUndecided)
+}
+
+// CreateAccessReview starts a campaign over the assignments of roles in the
+// organization, one for each source of an effective role. Every assignment is given to
+// one of reviewers, in turn, skipping reviewers who would review themselves or may not
+// manage the role. Reviewers must be active admins of the organization.
+func CreateAccessReview(review *AccessReview, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(review.Roles) == 0 || len(review.Reviewers) == 0 {
+		return internalErrors.ErrInvalidRequestPayload
+	}
+	for _, role := range review.Roles {
+		if !isRoleExists(role) {
+			return fmt.Errorf("%w: %s", internalErrors.ErrInvalidRole, role)
+		}
+	}
+	reviewerRoles := make([]string, len(review.Reviewers))
+	for i, id := range review.Reviewers {
+		reviewer, exists := lookupUser(review.OrgID, id)
+		if !exists || reviewer.IsDeleted() || reviewer.Status != UserStatusActive || !isAdmin(topRole(reviewer)) {
+			return fmt.Errorf("%w: %s", internalErrors.ErrInvalidReviewer, id)
+		}
+		reviewerRoles[i] = topRole(reviewer)
+	}
+
+	var holders []*User
+	for _, user := range users {
+		if user.OrgID == review.OrgID && !user.IsDeleted() {
+			holders = append(holders, user)
+		}
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].Email != holders[j].Email {
+			return holders[i].Email < holders[j].Email
+		}
+		return holders[i].ID < holders[j].ID
+	})
+
+	var items []*AccessReviewItem
+	next := 0
+	for _, user := range holders {
+		held := effectiveRoles(user)
+		for _, role := range review.Roles {
+			for _, effective := range held {
+				if effective.Role != role {
+					continue
+				}
+				for _, source := range effective.Sources {
+					reviewer := -1
+					for k := 0; k < len(review.Reviewers) && reviewer < 0; k++ {
+						candidate := (next + k) % len(review.Reviewers)
+						if review.Reviewers[candidate] != user.ID && isValidCrudOperation(reviewerRoles[candidate], role) {
+							reviewer = candidate
+						}
+					}
+					if reviewer < 0 {
+						return fmt.Errorf("%w: %s of user %s", internalErrors.ErrNoEligibleReviewer, role, user.ID)
+					}
+					next = reviewer + 1
+					items = append(items, &AccessReviewItem{
+						ID:       strconv.Itoa(len(items) + 1),
+						UserID:   user.ID,
+						UserName: user.Name,
+						Email:    user.Email,
+						Role:     role,
+						Source:   source,
+						Reviewer: review.Reviewers[reviewer],
+						Decision: AccessReviewPending,
+					})
+				}
+			}
+		}
+	}
+
+	review.ID = accessReviewIDGenerator.NewID()
+	review.Status = AccessReviewOpen
+	review.CreatedBy = actor
+	review.CreatedAt = time.Now().UTC()
+	review.Items = items
+	review.summarize()
+	accessReviews[review.ID] = review.clone()
+
+	audit.Record(audit.Event{
+		Type:   AuditAccessReviewCreated,
+		OrgID:  review.OrgID,
+		Actor:  actor,
+		Target: review.ID,
+		Details: map[string]string{
+			"roles":     strings.Join(review.Roles, ","),
+			"reviewers": strings.Join(review.Reviewers, ","),
+			"items":     strconv.Itoa(len(items)),
+		},
+	})
+	return nil
+}
+
+// GetAccessReview returns a campaign with its assignments.
+func GetAccessReview(orgID, id string) (*AccessReview, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	review, exists := accessReviews[id]
+	if !exists || review.OrgID != orgID {
+		return nil, internalErrors.ErrAccessReviewNotFound
+	}
+	return review.clone(), nil
+}
+
+// ListAccessReviews returns the campaigns of an organization, newest first, without
+// their assignments. An empty status returns campaigns in every status.
+func ListAccessReviews(orgID, status string) []*AccessReview {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]*AccessReview, 0)
+	for _, review := range accessReviews {
+		if review.OrgID == orgID && (status == "" || review.Status == status) {
+			c := review.clone()
+			c.Items = nil
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list
+}
+
+// DecideAccessReviewItem records the decision of the assigned reviewer on an assignment.
+// Decisions can be changed until the campaign closes.
+func DecideAccessReviewItem(orgID, id, itemID, decision, comment, actor string) (*AccessReviewItem, error) {
+	if decision != AccessReviewApproved && decision != AccessReviewRevoked {
+		return nil, internalErrors.ErrInvalidRequestPayload
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	review, exists := accessReviews[id]
+	if !exists || review.OrgID != orgID {
+		return nil, internalErrors.ErrAccessReviewNotFound
+	}
+	var item *AccessReviewItem
+	for _, candidate := range review.Items {
+		if candidate.ID == itemID {
+			item = candidate
+		}
+	}
+	if item == nil {
+		return nil, internalErrors.ErrAccessReviewItemNotFound
+	}
+	if review.Status != AccessReviewOpen {
+		return nil, internalErrors.ErrAccessReviewClosed
+	}
+	if actor != item.Reviewer {
+		return nil, internalErrors.ErrNotAssignedReviewer
+	}
+
+	now := time.Now().UTC()
+	item.Decision = decision
+	item.DecidedBy = actor
+	item.DecidedAt = &now
+	item.Comment = comment
+	review.summarize()
+
+	audit.Record(audit.Event{
+		Type:   AuditAccessReviewDecided,
+		OrgID:  orgID,
+		Actor:  actor,
+		Target: item.UserID,
+		Details: map[string]string{
+			"review_id": review.ID,
+			"item_id":   item.ID,
+			"role":      item.Role,
+			"decision":  decision,
+		},
+	})
+	itemCopy := *item
+	return &itemCopy, nil
+}
+
+// CloseAccessReview closes a campaign and removes every revoked assignment, together
+// with the undecided ones when the campaign revokes them. Direct roles are removed
+// through UpdateUserRoles, grants are revoked and group members are removed.
+func CloseAccessReview(orgID, id, actor string) (*AccessReview, error) {
+	mu.Lock()
+	review, exists := accessReviews[id]
+	if !exists || review.OrgID != orgID {
+		mu.Unlock()
+		return nil, internalErrors.ErrAccessReviewNotFound
+	}
+	if review.Status != AccessReviewOpen {
+		mu.Unlock()
+		return nil, internalErrors.ErrAccessReviewClosed
+	}
+	now := time.Now().UTC()
+	review.Status = AccessReviewClosed
+	review.ClosedBy = actor
+	review.ClosedAt = &now
+	revocations := make(map[string][]string)
+	var userIDs []string
+	for _, item := range review.Items {
+		if !review.revokes(item) || item.Source.Type != RoleSourceDirect {
+			continue
+		}
+		if _, exists := revocations[item.UserID]; !exists {
+			userIDs = append(userIDs, item.UserID)
+		}
+		revocations[item.UserID] = append(revocations[item.UserID], item.Role)
+	}
+	mu.Unlock()
+
+	// The revocations take the store lock themselves, so they are applied without it.
+	// The campaign is already closed, so no decision can change meanwhile.
+	outcomes := make(map[string]map[string]string)
+	for _, userID := range userIDs {
+		outcomes[userID] = revokeReviewedRoles(orgID, userID, revocations[userID], actor)
+	}
+	sourceOutcomes := make(map[*AccessReviewItem]string)
+	removedMemberships := make(map[string]string)
+	for _, item := range review.Items {
+		if !review.revokes(item) {
+			continue
+		}
+		switch item.Source.Type {
+		case RoleSourceGrant:
+			sourceOutcomes[item] = revokeOutcome(RevokeRoleGrant(orgID, item.UserID, item.Source.GrantID, actor), internalErrors.ErrRoleGrantNotFound)
+		case RoleSourceGroup:
+			// A membership gives every role of the group, so it is only removed once.
+			key := item.Source.GroupID + "\x00" + item.UserID
+			if _, done := removedMemberships[key]; !done {
+				removedMemberships[key] = revokeOutcome(RemoveGroupMember(orgID, item.Source.GroupID, item.UserID, actor), internalErrors.ErrGroupMemberNotFound, internalErrors.ErrGroupNotFound)
+			}
+			sourceOutcomes[item] = removedMemberships[key]
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	removed := 0
+	for _, item := range review.Items {
+		switch {
+		case !review.revokes(item):
+			item.Outcome = AccessReviewKept
+		case item.Source.Type == RoleSourceDirect:
+			item.Outcome = outcomes[item.UserID][item.Role]
+		default:
+			item.Outcome = sourceOutcomes[item]
+		}
+		if item.Outcome == AccessReviewRemoved {
+			removed++
+			audit.Record(audit.Event{
+				Type:    AuditAccessReviewRoleRevoked,
+				OrgID:   orgID,
+				Actor:   actor,
+				Target:  item.UserID,
+				Details: map[string]string{"review_id": review.ID, "item_id": item.ID, "role": item.Role, "source": item.Source.Type},
+			})
+		}
+	}
+	audit.Record(audit.Event{
+		Type:   AuditAccessReviewClosed,
+		OrgID:  orgID,
+		Actor:  actor,
+		Target: review.ID,
+		Details: map[string]string{
+			"approved": strconv.Itoa(review.Summary.Approved),
+			"revoked":  strconv.Itoa(review.Summary.Revoked),
+			"pending":  strconv.Itoa(review.Summary.Pending),
+			"removed":  strconv.Itoa(removed),
+		},
+	})
+	return review.clone(), nil
+}
+
+// revokeOutcome reports the outcome of revoking an assignment that failed with err,
+// where gone are the errors of an assignment that no longer exists.
+func revokeOutcome(err error, gone ...error) string {
+	switch {
+	case err == nil:
+		return AccessReviewRemoved
+	case errors.Is(err, internalErrors.ErrUserNotFound):
+		return AccessReviewUserNotFound
+	}
+	for _, target := range gone {
+		if errors.Is(err, target) {
+			return AccessReviewAlreadyRemoved
+		}
+	}
+	return AccessReviewFailed
+}
+
+// revokeReviewedRoles removes roles from the direct roles of a user and reports the
+// outcome for each role. The update is conditional on the version it was computed
+// from, and recomputed when the user changed in between.
+func revokeReviewedRoles(orgID, userID string, roles []string, actor string) map[string]string {
+	outcomes := make(map[string]string, len(roles))
+	for attempt := 0; attempt < 3; attempt++ {
+		user, err := GetUser(orgID, userID)
+		if err != nil {
+			for _, role := range roles {
+				outcomes[role] = AccessReviewUserNotFound
+			}
+			return outcomes
+		}
+		kept := make([]string, 0, len(user.Roles))
+		for _, role := range user.Roles {
+			if !containsString(roles, role) {
+				kept = append(kept, role)
+			}
+		}
+		for _, role := range roles {
+			outcomes[role] = AccessReviewAlreadyRemoved
+			if containsString(user.Roles, role) {
+				outcomes[role] = AccessReviewRemoved
+			}
+		}
+		if len(kept) == len(user.Roles) {
+			return outcomes
+		}
+		_, err = UpdateUserRoles(orgID, userID, kept, actor, []int64{user.Version})
+		if err == nil {
+			return outcomes
+		}
+		if !errors.Is(err, internalErrors.ErrPreconditionFailed) {
+			break
+		}
+	}
+	for _, role := range roles {
+		if outcomes[role] == AccessReviewRemoved {
+			outcomes[role] = AccessReviewFailed
+		}
+	}
+	return outcomes
+}
@@ -0,0 +1,353 @@
+package user
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// defaultAccessReviewRoles are reviewed when a campaign does not name its roles.
+var defaultAccessReviewRoles = []string{"Admin", "Modifier"}
+
+type AccessReviewRequest struct {
+	Name            string     `json:"name"`
+	Roles           []string   `json:"roles"`
+	Reviewers       []string   `json:"reviewers"`
+	RevokeUndecided bool       `json:"revoke_undecided"`
+	DueAt           *time.Time `json:"due_at"`
+}
+
+type AccessReviewDecisionRequest struct {
+	// Decision is "approve" or "revoke".
+	Decision string `json:"decision"`
+	Comment  string `json:"comment"`
+}
+
+// HandleAccessReviews handles HTTP requests for the /access-reviews endpoint.
+func HandleAccessReviews(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListAccessReviews(w, r)
+	case http.MethodPost:
+		HandleCreateAccessReview(w, r)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+// HandleAccessReview handles HTTP requests for /access-reviews/{id},
+// /access-reviews/{id}/items/{itemID}/decision, /access-reviews/{id}/close and
+// /access-reviews/{id}/report.
+func HandleAccessReview(w http.ResponseWriter, r *http.Request) {
+	_, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/access-reviews/"), "/")
+	method := http.MethodPost
+	switch {
+	case action == "" || action == "report":
+		method = http.MethodGet
+	case action == "close":
+	case strings.HasPrefix(action, "items/") && strings.HasSuffix(action, "/decision"):
+	default:
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+		return
+	}
+	if r.Method != method {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+
+	switch action {
+	case "":
+		HandleGetAccessReview(w, r)
+	case "report":
+		HandleAccessReviewReport(w, r)
+	case "close":
+		HandleCloseAccessReview(w, r)
+	default:
+		HandleDecideAccessReviewItem(w, r)
+	}
+}
+
+func HandleCreateAccessReview(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to create an access review", currentUserRole)
+		return
+	}
+
+	var req AccessReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" || len(req.Reviewers) == 0 {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: access review needs a name and reviewers: %v", err)
+		return
+	}
+	if len(req.Roles) == 0 {
+		req.Roles = defaultAccessReviewRoles
+	}
+	for _, role := range req.Roles {
+		if !checkPermission(w, currentUserRole, role) {
+			return
+		}
+	}
+	if !checkPolicy(w, r, orgID, "access_reviews.create", "", nil, map[string]any{"roles": req.Roles}) {
+		return
+	}
+
+	review := &AccessReview{
+		OrgID:           orgID,
+		Name:            strings.TrimSpace(req.Name),
+		Roles:           req.Roles,
+		Reviewers:       req.Reviewers,
+		RevokeUndecided: req.RevokeUndecided,
+		DueAt:           req.DueAt,
+	}
+	if err := CreateAccessReview(review, currentActor(r)); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("Access review creation failed: %v", err)
+		return
+	}
+
+	w.Header().Set("Location", "/access-reviews/"+review.ID)
+	jsonResponse(w, http.StatusCreated, review)
+	log.Printf("Access review %s created with %d assignments", review.ID, len(review.Items))
+}
+
+func HandleListAccessReviews(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list access reviews", currentUserRole)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", AccessReviewOpen, AccessReviewClosed:
+	default:
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidQueryParameter)
+		log.Printf("BadRequest: invalid status: %s", status)
+		return
+	}
+
+	list := ListAccessReviews(orgID, status)
+	jsonResponse(w, http.StatusOK, list)
+	log.Printf("Access reviews listed: %d reviews", len(list))
+}
+
+// accessReview loads the access review named in the path for an admin caller.
+func accessReview(w http.ResponseWriter, r *http.Request, orgID, currentUserRole string) (*AccessReview, bool) {
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to access an access review", currentUserRole)
+		return nil, false
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/access-reviews/"), "/")
+	review, err := GetAccessReview(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Access review %s", id)
+		return nil, false
+	}
+	return review, true
+}
+
+// HandleGetAccessReview returns a campaign with its assignments. ?reviewer={id} only
+// returns the assignments given to that reviewer.
+func HandleGetAccessReview(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	review, ok := accessReview(w, r, orgID, currentUserRole)
+	if !ok {
+		return
+	}
+
+	if reviewer := r.URL.Query().Get("reviewer"); reviewer != "" {
+		items := make([]*AccessReviewItem, 0)
+		for _, item := range review.Items {
+			if item.Reviewer == reviewer {
+				items = append(items, item)
+			}
+		}
+		review.Items = items
+	}
+	jsonResponse(w, http.StatusOK, review)
+}
+
+// HandleAccessReviewReport exports the assignments of a campaign as CSV.
+func HandleAccessReviewReport(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	review, ok := accessReview(w, r, orgID, currentUserRole)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="access-review-`+review.ID+`.csv"`)
+	w.WriteHeader(http.StatusOK)
+	if err := writeAccessReviewReport(w, review); err != nil {
+		log.Printf("Failed to write access review report: %v", err)
+		return
+	}
+	log.Printf("Access review %s exported", review.ID)
+}
+
+func writeAccessReviewReport(w io.Writer, review *AccessReview) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"review_id", "review_name", "review_status", "item_id", "user_id", "user_name", "email", "role", "source", "group_id", "grant_id", "reviewer", "decision", "decided_by", "decided_at", "comment", "outcome"})
+	for _, item := range review.Items {
+		var decidedAt string
+		if item.DecidedAt != nil {
+			decidedAt = item.DecidedAt.Format(time.RFC3339)
+		}
+		record := []string{review.ID, review.Name, review.Status, item.ID, item.UserID, item.UserName, item.Email, item.Role, item.Source.Type, item.Source.GroupID, item.Source.GrantID, item.Reviewer, item.Decision, item.DecidedBy, decidedAt, item.Comment, item.Outcome}
+		for i, cell := range record {
+			record[i] = csvCell(cell)
+		}
+		out.Write(record)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// csvCell keeps spreadsheets from evaluating a user-supplied cell as a formula by
+// prefixing a quote to cells that start like one.
+func csvCell(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// HandleDecideAccessReviewItem records the decision of the assigned reviewer, who must
+// be logged in or use an API key.
+func HandleDecideAccessReviewItem(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to decide an access review item", currentUserRole)
+		return
+	}
+	// Decisions are checked against the assigned reviewer, so the caller must prove who they are.
+	actor := requireAuthenticatedActor(w, r, "decide an access review item")
+	if actor == "" {
+		return
+	}
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/access-reviews/"), "/")
+	itemID := strings.TrimSuffix(strings.TrimPrefix(action, "items/"), "/decision")
+	var req AccessReviewDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	var decision string
+	switch req.Decision {
+	case "approve":
+		decision = AccessReviewApproved
+	case "revoke":
+		decision = AccessReviewRevoked
+	default:
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: invalid access review decision %q", req.Decision)
+		return
+	}
+
+	review, err := GetAccessReview(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Access review %s", id)
+		return
+	}
+	for _, item := range review.Items {
+		// The reviewer must still be allowed to manage the role under review.
+		if item.ID != itemID {
+			continue
+		}
+		if !checkPermission(w, currentUserRole, item.Role) ||
+			!checkPolicy(w, r, orgID, "access_reviews.decide", item.UserID, nil, map[string]any{"review_id": id, "roles": []string{item.Role}}) {
+			return
+		}
+	}
+
+	item, err := DecideAccessReviewItem(orgID, id, itemID, decision, req.Comment, actor)
+	if err != nil {
+		switch {
+		case errors.Is(err, internalMsgs.ErrNotAssignedReviewer):
+			errResponse(w, http.StatusForbidden, err)
+		case errors.Is(err, internalMsgs.ErrAccessReviewClosed):
+			errResponse(w, http.StatusConflict, err)
+		default:
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("Access review %s item %s not decided: %v", id, itemID, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, item)
+	log.Printf("Access review %s item %s %s by %s", id, itemID, item.Decision, item.DecidedBy)
+}
+
+// HandleCloseAccessReview closes a campaign and applies its revocations. The caller
+// must be allowed to manage every role under review.
+func HandleCloseAccessReview(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	review, ok := accessReview(w, r, orgID, currentUserRole)
+	if !ok {
+		return
+	}
+	for _, role := range review.Roles {
+		if !checkPermission(w, currentUserRole, role) {
+			return
+		}
+	}
+	if !checkPolicy(w, r, orgID, "access_reviews.close", "", nil, map[string]any{"review_id": review.ID, "roles": review.Roles}) {
+		return
+	}
+
+	closed, err := CloseAccessReview(orgID, review.ID, currentActor(r))
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrAccessReviewClosed) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("Access review %s not closed: %v", review.ID, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, closed)
+	log.Printf("Access review %s closed by %s", closed.ID, closed.ClosedBy)
+}
@@ -0,0 +1,195 @@
+package user
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"zpe-cloud-user-management-service/internal/audit"
+)
+
+func TestAccessReviews(t *testing.T) {
+	setupTestStorageWithUsers()
+	audit.Reset()
+
+	leia := map[string]string{"X-User-Type": "Admin", "X-User-ID": "1"}
+	goku := map[string]string{"X-User-Type": "Admin", "X-User-ID": "6"}
+
+	tests := []struct {
+		name           string
+		header         map[string]string
+		body           string
+		expectedStatus int
+	}{
+		{"Watcher cannot create a review", map[string]string{"X-User-Type": "Watcher", "X-User-ID": "3"}, `{"name":"Q3","reviewers":["1"]}`, http.StatusForbidden},
+		{"Reviewers are required", leia, `{"name":"Q3"}`, http.StatusBadRequest},
+		{"Reviewers must be admins", leia, `{"name":"Q3","reviewers":["3"]}`, http.StatusBadRequest},
+		{"Nobody may review their own role", leia, `{"name":"Q3","roles":["Admin"],"reviewers":["1"]}`, http.StatusBadRequest},
+		{"Admin cannot review SuperAdmins", leia, `{"name":"Q3","roles":["SuperAdmin"],"reviewers":["1","6"]}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doRequest(t, "POST", "/access-reviews", tt.header, tt.body); rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+
+	rr := doRequest(t, "POST", "/access-reviews", leia, `{"name":"Q3 recertification","reviewers":["1","6"]}`)
+	var review AccessReview
+	json.NewDecoder(rr.Body).Decode(&review)
+	if rr.Code != http.StatusCreated || len(review.Items) != 4 || review.Summary.Pending != 4 {
+		t.Fatalf("expected a review of the four Admin and Modifier assignments, got %v: %+v", rr.Code, review)
+	}
+	want := map[string]string{"6": "1", "1": "6", "2": "1", "4": "6"}
+	for _, item := range review.Items {
+		if want[item.UserID] != item.Reviewer || item.Reviewer == item.UserID {
+			t.Errorf("unexpected reviewer for %+v", item)
+		}
+	}
+	base := "/access-reviews/" + review.ID
+	itemOf := func(userID string) string {
+		for _, item := range review.Items {
+			if item.UserID == userID {
+				return item.ID
+			}
+		}
+		t.Fatalf("no item for user %s", userID)
+		return ""
+	}
+
+	// Decisions name the reviewer, so they need a session rather than a claimed X-User-ID.
+	leiaSession, gokuSession := sessionHeaders(t, "1"), sessionHeaders(t, "6")
+	if rr := doRequest(t, "POST", base+"/items/"+itemOf("6")+"/decision", leia, `{"decision":"approve"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unauthenticated decision to be refused, got %v", rr.Code)
+	}
+	spoofed := map[string]string{"Authorization": gokuSession["Authorization"], "X-User-ID": "1"}
+	if rr := doRequest(t, "POST", base+"/items/"+itemOf("6")+"/decision", spoofed, `{"decision":"approve"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected only the assigned reviewer to decide, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", base+"/items/"+itemOf("6")+"/decision", leiaSession, `{"decision":"maybe"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid decision to be rejected, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", base+"/items/"+itemOf("6")+"/decision", leiaSession, `{"decision":"approve"}`); rr.Code != http.StatusOK {
+		t.Errorf("expected the assignment to be approved, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", base+"/items/"+itemOf("4")+"/decision", gokuSession, `{"decision":"revoke","comment":"moved teams"}`); rr.Code != http.StatusOK {
+		t.Errorf("expected the assignment to be revoked, got %v", rr.Code)
+	}
+	rr = doRequest(t, "GET", base+"?reviewer=6", leia, "")
+	json.NewDecoder(rr.Body).Decode(&review)
+	if rr.Code != http.StatusOK || len(review.Items) != 2 || review.Summary != (AccessReviewSummary{Pending: 2, Approved: 1, Revoked: 1}) {
+		t.Errorf("unexpected review %v: %+v", rr.Code, review)
+	}
+
+	// Roles assigned since the snapshot are left alone when the campaign closes.
+	if _, err := UpdateUserRoles(DefaultOrgID, "4", []string{"Modifier", "Watcher"}, "system", nil); err != nil {
+		t.Fatalf("failed to update roles: %v", err)
+	}
+	rr = doRequest(t, "POST", base+"/close", leia, "")
+	json.NewDecoder(rr.Body).Decode(&review)
+	if rr.Code != http.StatusOK || review.Status != AccessReviewClosed {
+		t.Fatalf("expected the review to close, got %v: %+v", rr.Code, review)
+	}
+	for _, item := range review.Items {
+		wantOutcome := AccessReviewKept
+		if item.UserID == "4" {
+			wantOutcome = AccessReviewRemoved
+		}
+		if item.Outcome != wantOutcome {
+			t.Errorf("expected outcome %s, got %+v", wantOutcome, item)
+		}
+	}
+	if user, _ := GetUser(DefaultOrgID, "4"); !reflect.DeepEqual(user.Roles, []string{"Watcher"}) {
+		t.Errorf("expected the revoked role to be removed, got %v", user.Roles)
+	}
+	if user, _ := GetUser(DefaultOrgID, "2"); !reflect.DeepEqual(user.Roles, []string{"Modifier"}) {
+		t.Errorf("expected undecided assignments to be kept, got %v", user.Roles)
+	}
+	if events := audit.Events(AuditAccessReviewRoleRevoked); len(events) != 1 || events[0].Target != "4" || events[0].Actor != "1" {
+		t.Errorf("unexpected revocation events %+v", events)
+	}
+	if rr := doRequest(t, "POST", base+"/items/"+itemOf("2")+"/decision", leiaSession, `{"decision":"approve"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected decisions on a closed review to be refused, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", base+"/close", leia, ""); rr.Code != http.StatusConflict {
+		t.Errorf("expected a closed review to stay closed, got %v", rr.Code)
+	}
+
+	rr = doRequest(t, "GET", base+"/report", leia, "")
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || rr.Code != http.StatusOK || len(records) != 5 || records[0][3] != "item_id" {
+		t.Fatalf("unexpected report %v: %v %v", rr.Code, records, err)
+	}
+	for _, record := range records[1:] {
+		if record[4] == "4" && (record[8] != RoleSourceDirect || record[12] != AccessReviewRevoked || record[15] != "moved teams" || record[16] != AccessReviewRemoved) {
+			t.Errorf("unexpected report row %v", record)
+		}
+	}
+
+	// Undecided assignments can be revoked too.
+	rr = doRequest(t, "POST", "/access-reviews", leia, `{"name":"Modifiers","roles":["Modifier"],"reviewers":["6"],"revoke_undecided":true}`)
+	json.NewDecoder(rr.Body).Decode(&review)
+	if rr := doRequest(t, "POST", "/access-reviews/"+review.ID+"/close", goku, ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected the review to close, got %v", rr.Code)
+	}
+	if user, _ := GetUser(DefaultOrgID, "2"); len(user.Roles) != 0 {
+		t.Errorf("expected the undecided assignment to be revoked, got %v", user.Roles)
+	}
+	rr = doRequest(t, "GET", "/access-reviews?status=closed", leia, "")
+	var list []AccessReview
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list) != 2 || list[0].ID != review.ID || list[0].Items != nil {
+		t.Errorf("unexpected review list %v: %+v", rr.Code, list)
+	}
+
+	// Roles from groups and grants are reviewed too, and revoked at their source.
+	ops := &Group{OrgID: DefaultOrgID, Name: "=ops", Roles: []string{"Modifier"}}
+	if err := CreateGroup(ops, "system"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddGroupMember(DefaultOrgID, ops.ID, "3", "system"); err != nil {
+		t.Fatal(err)
+	}
+	grant, err := AddRoleGrant(DefaultOrgID, "5", RoleGrant{Role: "Modifier"}, "system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = doRequest(t, "POST", "/access-reviews", leia, `{"name":"=HYPERLINK(\"http://evil\")","roles":["Modifier"],"reviewers":["6"],"revoke_undecided":true}`)
+	json.NewDecoder(rr.Body).Decode(&review)
+	sources := map[string]RoleSource{}
+	for _, item := range review.Items {
+		sources[item.UserID] = item.Source
+	}
+	if rr.Code != http.StatusCreated || len(review.Items) != 2 || sources["3"].GroupID != ops.ID || sources["5"].GrantID != grant.ID {
+		t.Fatalf("expected the group and grant assignments to be reviewed, got %v: %+v", rr.Code, review)
+	}
+	if rr := doRequest(t, "POST", "/access-reviews/"+review.ID+"/close", goku, ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected the review to close, got %v", rr.Code)
+	}
+	if group, _ := GetGroup(DefaultOrgID, ops.ID); group.hasMember("3") {
+		t.Errorf("expected the revoked member to leave the group, got %v", group.Members)
+	}
+	if user, _ := GetUser(DefaultOrgID, "5"); len(user.RoleGrants) != 0 {
+		t.Errorf("expected the revoked grant to be removed, got %+v", user.RoleGrants)
+	}
+	rr = doRequest(t, "GET", "/access-reviews/"+review.ID+"/report", leia, "")
+	if records, _ := csv.NewReader(rr.Body).ReadAll(); len(records) != 3 || records[1][1] != `'=HYPERLINK("http://evil")` {
+		t.Errorf("expected formula cells to be escaped, got %v", records)
+	}
+
+	// Closing needs permission over every reviewed role.
+	superAdmin := map[string]string{"X-User-Type": "SuperAdmin", "X-User-ID": "6"}
+	rr = doRequest(t, "POST", "/access-reviews", superAdmin, `{"name":"SuperAdmins","roles":["SuperAdmin"],"reviewers":["6"]}`)
+	json.NewDecoder(rr.Body).Decode(&review)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected the review to be created, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, "POST", "/access-reviews/"+review.ID+"/close", leia, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected an Admin to be unable to close a review of SuperAdmins, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", "/access-reviews/"+review.ID+"/close", superAdmin, ""); rr.Code != http.StatusOK {
+		t.Errorf("expected a SuperAdmin to close the review, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,291 @@
+package user
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// Attribute types supported by the attribute schema.
+const (
+	AttributeTypeString  = "string"
+	AttributeTypeNumber  = "number"
+	AttributeTypeBoolean = "boolean"
+)
+
+// AttributeDefinition describes a custom user attribute managed by Admins.
+// Pattern and Enum only apply to string attributes.
+type AttributeDefinition struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Required    bool     `json:"required"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Unique      bool     `json:"unique"`
+
+	pattern *regexp.Regexp
+}
+
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// attributeSchemas holds the attribute definitions of each organization, keyed by org ID and name.
+var (
+	schemaMu         sync.RWMutex
+	attributeSchemas = make(map[string]map[string]*AttributeDefinition)
+)
+
+// InitializeAttributeSchema clears all attribute definitions of every organization.
+func InitializeAttributeSchema() {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	attributeSchemas = make(map[string]map[string]*AttributeDefinition)
+}
+
+// deleteAttributeSchema drops the attribute definitions of an organization.
+func deleteAttributeSchema(orgID string) {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	delete(attributeSchemas, orgID)
+}
+
+// validate checks the definition itself and compiles its pattern.
+func (d *AttributeDefinition) validate() error {
+	if !attributeNamePattern.MatchString(d.Name) {
+		return fmt.Errorf("%w: name must be lower-case letters, digits or underscores", internalMsgs.ErrInvalidAttributeDefinition)
+	}
+	switch d.Type {
+	case AttributeTypeString:
+	case AttributeTypeNumber, AttributeTypeBoolean:
+		if d.Pattern != "" || len(d.Enum) > 0 {
+			return fmt.Errorf("%w: pattern and enum are only supported for string attributes", internalMsgs.ErrInvalidAttributeDefinition)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", internalMsgs.ErrInvalidAttributeDefinition, d.Type)
+	}
+	if d.Pattern != "" {
+		re, err := regexp.Compile(d.Pattern)
+		if err != nil {
+			return fmt.Errorf("%w: %v", internalMsgs.ErrInvalidAttributeDefinition, err)
+		}
+		d.pattern = re
+	}
+	return nil
+}
+
+// checkValue verifies that value satisfies the definition.
+func (d *AttributeDefinition) checkValue(value interface{}) error {
+	switch d.Type {
+	case AttributeTypeString:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%w: %s must be a string", internalMsgs.ErrInvalidAttribute, d.Name)
+		}
+		if d.pattern != nil && !d.pattern.MatchString(s) {
+			return fmt.Errorf("%w: %s does not match %s", internalMsgs.ErrInvalidAttribute, d.Name, d.Pattern)
+		}
+		if len(d.Enum) > 0 && !containsString(d.Enum, s) {
+			return fmt.Errorf("%w: %s must be one of %v", internalMsgs.ErrInvalidAttribute, d.Name, d.Enum)
+		}
+	case AttributeTypeNumber:
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%w: %s must be a number", internalMsgs.ErrInvalidAttribute, d.Name)
+		}
+	case AttributeTypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%w: %s must be a boolean", internalMsgs.ErrInvalidAttribute, d.Name)
+		}
+	}
+	return nil
+}
+
+// CreateAttributeDefinition adds a new attribute to the schema of an organization.
+func CreateAttributeDefinition(orgID string, def *AttributeDefinition) error {
+	if err := def.validate(); err != nil {
+		return err
+	}
+
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	schema, exists := attributeSchemas[orgID]
+	if !exists {
+		schema = make(map[string]*AttributeDefinition)
+		attributeSchemas[orgID] = schema
+	}
+	if _, exists := schema[def.Name]; exists {
+		return internalMsgs.ErrAttributeAlreadyExists
+	}
+	schema[def.Name] = def
+	return nil
+}
+
+// UpdateAttributeDefinition replaces an existing attribute definition. The users of the
+// organization, deleted ones included, must satisfy it already; otherwise the update is
+// refused with the IDs of those that do not.
+func UpdateAttributeDefinition(orgID string, def *AttributeDefinition) error {
+	if err := def.validate(); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	if _, exists := attributeSchemas[orgID][def.Name]; !exists {
+		return internalMsgs.ErrAttributeNotFound
+	}
+	if offenders := attributeOffenders(orgID, def); len(offenders) > 0 {
+		return fmt.Errorf("%w: %s: %v", internalMsgs.ErrAttributeValuesInvalid, def.Name, offenders)
+	}
+	attributeSchemas[orgID][def.Name] = def
+	return nil
+}
+
+// attributeOffenders returns the sorted IDs of the users of an organization that do not
+// satisfy def: a required value is missing, a value is invalid, or a unique value is
+// shared. The caller must hold mu.
+func attributeOffenders(orgID string, def *AttributeDefinition) []string {
+	offending := make(map[string]bool)
+	var holders []*User
+	for _, user := range users {
+		if user.OrgID != orgID {
+			continue
+		}
+		value, present := user.Attributes[def.Name]
+		switch {
+		case !present:
+			if def.Required {
+				offending[user.ID] = true
+			}
+		case def.checkValue(value) != nil:
+			offending[user.ID] = true
+		case def.Unique:
+			for _, other := range holders {
+				if reflect.DeepEqual(other.Attributes[def.Name], value) {
+					offending[user.ID], offending[other.ID] = true, true
+				}
+			}
+			holders = append(holders, user)
+		}
+	}
+
+	offenders := make([]string, 0, len(offending))
+	for id := range offending {
+		offenders = append(offenders, id)
+	}
+	sort.Strings(offenders)
+	return offenders
+}
+
+// DeleteAttributeDefinition removes an attribute from the schema of an organization.
+func DeleteAttributeDefinition(orgID, name string) error {
+	schemaMu.Lock()
+	defer schemaMu.Unlock()
+	if _, exists := attributeSchemas[orgID][name]; !exists {
+		return internalMsgs.ErrAttributeNotFound
+	}
+	delete(attributeSchemas[orgID], name)
+	return nil
+}
+
+// GetAttributeDefinition returns the definition of the named attribute in an organization.
+func GetAttributeDefinition(orgID, name string) (*AttributeDefinition, error) {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+	def, exists := attributeSchemas[orgID][name]
+	if !exists {
+		return nil, internalMsgs.ErrAttributeNotFound
+	}
+	return def, nil
+}
+
+// ListAttributeDefinitions returns the attribute definitions of an organization sorted by name.
+func ListAttributeDefinitions(orgID string) []*AttributeDefinition {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+
+	schema := attributeSchemas[orgID]
+	defs := make([]*AttributeDefinition, 0, len(schema))
+	for _, def := range schema {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+	return defs
+}
+
+// ValidateAttributes checks the user's attributes against the schema of the user's
+// organization: every attribute
+// must be defined, have a valid value, and every required attribute must be present.
+// Uniqueness is enforced by the store.
+func (u *User) ValidateAttributes() error {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+
+	schema := attributeSchemas[u.OrgID]
+	for name, value := range u.Attributes {
+		def, exists := schema[name]
+		if !exists {
+			return fmt.Errorf("%w: %s", internalMsgs.ErrUnknownAttribute, name)
+		}
+		if err := def.checkValue(value); err != nil {
+			return err
+		}
+	}
+
+	var missing []string
+	for name, def := range schema {
+		if _, present := u.Attributes[name]; def.Required && !present {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%w: %v", internalMsgs.ErrMissingAttribute, missing)
+	}
+	return nil
+}
+
+// uniqueAttributeConflict reports whether another user of the same organization already
+// holds one of the user's values for an attribute marked unique. The caller must hold mu.
+func uniqueAttributeConflict(user *User) error {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+
+	schema := attributeSchemas[user.OrgID]
+	for name, value := range user.Attributes {
+		def, exists := schema[name]
+		if !exists || !def.Unique {
+			continue
+		}
+		for _, other := range users {
+			if other.OrgID == user.OrgID && other.ID != user.ID && reflect.DeepEqual(other.Attributes[name], value) {
+				return fmt.Errorf("%w: %s", internalMsgs.ErrAttributeNotUnique, name)
+			}
+		}
+	}
+	return nil
+}
+
+// formatAttribute formats an attribute value the way it is written in a query string.
+// Numbers are never written in exponent form, so 1000000 matches "1000000".
+func formatAttribute(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// HandleAttributes handles HTTP requests for the attribute schema at /attributes.
+func HandleAttributes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListAttributeDefinitions(w, r)
+	case http.MethodPost:
+		HandleCreateAttributeDefinition(w, r)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+// HandleAttribute handles HTTP requests for a single attribute definition at /attributes/{name}.
+func HandleAttribute(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleGetAttributeDefinition(w, r)
+	case http.MethodPut:
+		HandleUpdateAttributeDefinition(w, r)
+	case http.MethodDelete:
+		HandleDeleteAttributeDefinition(w, r)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+func HandleListAttributeDefinitions(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isRoleExists(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list attributes", currentUserRole)
+		return
+	}
+
+	defs := ListAttributeDefinitions(orgID)
+	jsonResponse(w, http.StatusOK, defs)
+	log.Printf("Attributes listed: %d attributes", len(defs))
+}
+
+func HandleGetAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isRoleExists(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to get an attribute", currentUserRole)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/attributes/")
+	def, err := GetAttributeDefinition(orgID, name)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Attribute %s", name)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, def)
+}
+
+func HandleCreateAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to create an attribute", currentUserRole)
+		return
+	}
+
+	var def AttributeDefinition
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	if err := CreateAttributeDefinition(orgID, &def); err != nil {
+		if errors.Is(err, internalMsgs.ErrAttributeAlreadyExists) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusBadRequest, err)
+		}
+		log.Printf("Attribute creation failed: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, &def)
+	log.Printf("Attribute created: %s", def.Name)
+}
+
+func HandleUpdateAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to update an attribute", currentUserRole)
+		return
+	}
+
+	var def AttributeDefinition
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	def.Name = strings.TrimPrefix(r.URL.Path, "/attributes/")
+
+	if err := UpdateAttributeDefinition(orgID, &def); err != nil {
+		if errors.Is(err, internalMsgs.ErrAttributeNotFound) {
+			errResponse(w, http.StatusNotFound, err)
+		} else if errors.Is(err, internalMsgs.ErrAttributeValuesInvalid) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusBadRequest, err)
+		}
+		log.Printf("Attribute update failed: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, &def)
+	log.Printf("Attribute updated: %s", def.Name)
+}
+
+func HandleDeleteAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to delete an attribute", currentUserRole)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/attributes/")
+	if err := DeleteAttributeDefinition(orgID, name); err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Attribute %s", name)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("Attribute deleted: %s", name)
+}
@@ -0,0 +1,183 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+func TestUserAttributes(t *testing.T) {
+	setupTestStorageWithUsers()
+	InitializeAttributeSchema()
+	defer InitializeAttributeSchema()
+
+	definitions := []string{
+		`{"name":"department","type":"string","required":true,"enum":["Engineering","Support"]}`,
+		`{"name":"employee_id","type":"string","pattern":"^E[0-9]{4}$","unique":true}`,
+		`{"name":"remote","type":"boolean"}`,
+	}
+	for _, def := range definitions {
+		req, _ := http.NewRequest("POST", "/attributes", bytes.NewBufferString(def))
+		req.Header.Set("X-User-Type", "Admin")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(HandleAttributes).ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Failed to create attribute %s: %v %s", def, rr.Code, rr.Body.String())
+		}
+	}
+
+	if rr := doRequest(t, "POST", "/scim/v2/Users", scimHeaders(t, "Admin"), `{"userName":"ahsoka@example.com","displayName":"Ahsoka Tano"}`); rr.Code != http.StatusBadRequest || decodeResponse(rr)["scimType"] != "invalidValue" {
+		t.Errorf("expected SCIM to enforce required attributes, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	tests := []struct {
+		name           string
+		attributes     map[string]interface{}
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "User with valid attributes is created",
+			attributes:     map[string]interface{}{"department": "Engineering", "employee_id": "E0001", "remote": true},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Missing required attribute is rejected",
+			attributes:     map[string]interface{}{"employee_id": "E0002"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"message":"attributes required: [department]"}`,
+		},
+		{
+			name:           "Value outside enum is rejected",
+			attributes:     map[string]interface{}{"department": "Sales"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"message":"invalid attribute: department must be one of [Engineering Support]"}`,
+		},
+		{
+			name:           "Value of wrong type is rejected",
+			attributes:     map[string]interface{}{"department": "Support", "remote": "yes"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"message":"invalid attribute: remote must be a boolean"}`,
+		},
+		{
+			name:           "Undefined attribute is rejected",
+			attributes:     map[string]interface{}{"department": "Support", "shoe_size": 42},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"message":"unknown attribute: shoe_size"}`,
+		},
+		{
+			name:           "Duplicate unique attribute is rejected",
+			attributes:     map[string]interface{}{"department": "Support", "employee_id": "E0001"},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"message":"attribute value already in use: employee_id"}`,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(User{
+				Name:       "Attribute User",
+				Email:      fmt.Sprintf("attr%d@example.com", i),
+				Roles:      []string{"Watcher"},
+				Attributes: tt.attributes,
+			})
+			req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(payload))
+			req.Header.Set("X-User-Type", "Admin")
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(HandleCreateUser).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+			if tt.expectedBody != "" && strings.TrimSpace(rr.Body.String()) != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+
+	payload := `{"attributes":{"department":"Support"}}`
+	req, _ := http.NewRequest("PUT", "/users/3/attributes", bytes.NewBufferString(payload))
+	req.Header.Set("X-User-Type", "Modifier")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(HandleUser).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	listed, _ := ListUsers(DefaultOrgID, ListOptions{Attributes: map[string]string{"department": "Support"}})
+	if len(listed) != 1 || listed[0].ID != "3" {
+		t.Errorf("unexpected filtered list: %+v", listed)
+	}
+	listed, _ = ListUsers(DefaultOrgID, ListOptions{Attributes: map[string]string{"remote": "true"}})
+	if len(listed) != 1 || listed[0].Email != "attr0@example.com" {
+		t.Errorf("unexpected filtered list: %+v", listed)
+	}
+
+	if err := CreateAttributeDefinition(DefaultOrgID, &AttributeDefinition{Name: "badge", Type: AttributeTypeNumber, Unique: true}); err != nil {
+		t.Fatalf("failed to define attribute: %v", err)
+	}
+	if _, err := UpdateUserAttributes(DefaultOrgID, "2", map[string]interface{}{"badge": float64(1000000)}, "Admin", nil); err != nil {
+		t.Fatalf("failed to set badge: %v", err)
+	}
+	listed, _ = ListUsers(DefaultOrgID, ListOptions{Attributes: map[string]string{"badge": "1000000"}})
+	if len(listed) != 1 || listed[0].ID != "2" {
+		t.Errorf("expected large numbers to match their decimal form, got %+v", listed)
+	}
+
+	// Values the schema cannot produce, such as lists, must not break the uniqueness check.
+	mu.Lock()
+	users["4"].Attributes = map[string]interface{}{"badge": []interface{}{"a"}}
+	err := uniqueAttributeConflict(&User{ID: "5", OrgID: DefaultOrgID, Attributes: map[string]interface{}{"badge": []interface{}{"a"}}})
+	mu.Unlock()
+	if !errors.Is(err, internalMsgs.ErrAttributeNotUnique) {
+		t.Errorf("expected equal lists to conflict, got %v", err)
+	}
+}
+
+func TestUpdateAttributeDefinitionChecksExistingValues(t *testing.T) {
+	setupTestStorageWithUsers()
+	InitializeAttributeSchema()
+	defer InitializeAttributeSchema()
+	if err := CreateAttributeDefinition(DefaultOrgID, &AttributeDefinition{Name: "team", Type: AttributeTypeString}); err != nil {
+		t.Fatalf("failed to define attribute: %v", err)
+	}
+	for id, team := range map[string]string{"2": "red", "3": "red", "4": "blue"} {
+		if _, err := UpdateUserAttributes(DefaultOrgID, id, map[string]interface{}{"team": team}, "Admin", nil); err != nil {
+			t.Fatalf("failed to set team: %v", err)
+		}
+	}
+
+	admin := callerHeaders("Admin", "")
+	tests := []struct {
+		name           string
+		definition     string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"Duplicates block unique", `{"type":"string","unique":true}`, http.StatusConflict, `{"message":"users do not satisfy the attribute definition: team: [2 3]"}`},
+		{"Missing values block required", `{"type":"string","required":true}`, http.StatusConflict, `{"message":"users do not satisfy the attribute definition: team: [1 5 6]"}`},
+		{"Values outside the enum block it", `{"type":"string","enum":["red"]}`, http.StatusConflict, `{"message":"users do not satisfy the attribute definition: team: [4]"}`},
+		{"Values of another type block a type change", `{"type":"number"}`, http.StatusConflict, `{"message":"users do not satisfy the attribute definition: team: [2 3 4]"}`},
+		{"Definition that every user satisfies", `{"type":"string","enum":["red","blue"]}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, "PUT", "/attributes/team", admin, tt.definition)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+			if tt.expectedBody != "" && strings.TrimSpace(rr.Body.String()) != tt.expectedBody {
+				t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+	if def, _ := GetAttributeDefinition(DefaultOrgID, "team"); len(def.Enum) != 2 || def.Unique || def.Required {
+		t.Errorf("expected only the satisfiable definition to be stored, got %+v", def)
+	}
+}
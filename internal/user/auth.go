@@ -0,0 +1,111 @@
+package user
+
+import (
+	"errors"
+	"time"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+	"zpe-cloud-user-management-service/internal/password"
+)
+
+// Credentials are the factors presented at login. MFACode and RecoveryCode are only
+// needed by users with multi-factor authentication.
+type Credentials struct {
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	MFACode      string `json:"mfa_code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// Authentication is the outcome of a successful login.
+type Authentication struct {
+	UserID string `json:"user_id"`
+	OrgID  string `json:"org_id"`
+	Role   string `json:"role"`
+	// Methods lists the authentication methods used, as in the OpenID Connect "amr" claim.
+	Methods []string `json:"amr"`
+	// SessionID identifies the session recorded for the login, once there is one.
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// dummyPasswordHash is verified against when no user matches, so that unknown addresses
+// take as long to reject as wrong passwords.
+var dummyPasswordHash, _ = password.Hash("dummy password")
+
+// Authenticate checks the credentials of an active user of the organization, presented
+// from remoteAddr. Failed logins are throttled as set by SetLockoutPolicy; a refused
+// attempt fails with a *LoginThrottledError and does not count as a failure. Attempts
+// count as failures until the credentials are known to be right, so that concurrent
+// attempts cannot get past the limits.
+func Authenticate(orgID string, creds Credentials, remoteAddr string) (*Authentication, error) {
+	accountKey := emailIndexKey(orgID, NormalizeEmail(creds.Email))
+	var ip string
+	if addr := remoteIP(remoteAddr); addr.IsValid() {
+		ip = addr.String()
+	}
+	t := currentLoginThrottle()
+	if err := t.check(accountKey, ip, time.Now()); err != nil {
+		return nil, err
+	}
+
+	auth, err := authenticate(orgID, accountKey, creds)
+	switch {
+	case err == nil:
+		t.succeed(accountKey, ip)
+	case errors.Is(err, internalErrors.ErrInvalidCredentials), errors.Is(err, internalErrors.ErrInvalidMFACode):
+		mu.Lock()
+		recordLoginFailure(t, accountKey, ip, time.Now())
+		mu.Unlock()
+	default:
+		t.abandon(accountKey, ip)
+	}
+	return auth, err
+}
+
+// authenticate checks creds against the user indexed under accountKey.
+func authenticate(orgID, accountKey string, creds Credentials) (*Authentication, error) {
+	mu.Lock()
+	var hash string
+	id, exists := emailIndex[accountKey]
+	if exists {
+		if user := users[id]; !user.IsDeleted() && user.Status == UserStatusActive {
+			hash = user.passwordHash
+		}
+	}
+	mu.Unlock()
+
+	// Password hashing is slow on purpose, so it runs without holding the store lock.
+	if hash == "" {
+		password.Verify(dummyPasswordHash, creds.Password)
+		return nil, internalErrors.ErrInvalidCredentials
+	}
+	if !password.Verify(hash, creds.Password) {
+		return nil, internalErrors.ErrInvalidCredentials
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() || user.passwordHash != hash {
+		return nil, internalErrors.ErrInvalidCredentials
+	}
+
+	methods := []string{"pwd"}
+	if user.mfa.enabled() {
+		method, err := verifySecondFactor(user, creds.MFACode, creds.RecoveryCode, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, method)
+	}
+
+	auth := &Authentication{UserID: user.ID, OrgID: user.OrgID, Methods: methods}
+	if roles := effectiveRoles(user); len(roles) > 0 {
+		auth.Role = roles[0].Role
+	}
+	if !user.mfa.enabled() && mfaRequired(user) {
+		// The password is right, so the user may start a session to enroll with.
+		return auth, internalErrors.ErrMFAEnrollmentRequired
+	}
+	return auth, nil
+}
@@ -0,0 +1,67 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// HandleLogin handles HTTP requests for the /login endpoint. It checks the credentials
+// of a user of the X-Org-ID organization, starts a session and reports who they are.
+func HandleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+
+	var creds Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil || creds.Email == "" {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	auth, err := Authenticate(orgID, creds, r.RemoteAddr)
+	if errors.Is(err, internalMsgs.ErrMFAEnrollmentRequired) && auth != nil {
+		resp, err := startSession(r, auth)
+		if err != nil {
+			errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+			log.Printf("Failed to start a session for user %s: %v", auth.UserID, err)
+			return
+		}
+		jsonResponse(w, http.StatusForbidden, MFAEnrollmentLoginResponse{Message: internalMsgs.ErrMFAEnrollmentRequired.Error(), LoginResponse: resp})
+		log.Printf("User %s logged in to enroll in MFA", auth.UserID)
+		return
+	}
+	if err != nil {
+		switch status := loginThrottledStatus(w, err); {
+		case status != 0:
+			errResponse(w, status, err)
+		case errors.Is(err, internalMsgs.ErrMFAEnrollmentRequired):
+			errResponse(w, http.StatusForbidden, err)
+		case errors.Is(err, internalMsgs.ErrMFARequired), errors.Is(err, internalMsgs.ErrInvalidMFACode), errors.Is(err, internalMsgs.ErrInvalidCredentials):
+			errResponse(w, http.StatusUnauthorized, err)
+		default:
+			errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+		}
+		log.Printf("Login failed: %v", err)
+		return
+	}
+
+	resp, err := startSession(r, auth)
+	if err != nil {
+		errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+		log.Printf("Failed to start a session for user %s: %v", auth.UserID, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, resp)
+	log.Printf("User %s logged in", auth.UserID)
+}
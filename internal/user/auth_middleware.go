@@ -0,0 +1,150 @@
+package user
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"zpe-cloud-user-management-service/internal/audit"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// AuthMiddleware authenticates requests that present an API key, either as
+// "Authorization: Bearer <key>" or in the X-API-Key header, or a session token.
+// Access tokens issued by the OpenID Connect provider only grant the userinfo
+// endpoint, which verifies them itself; they are refused everywhere else.
+// The caller headers (X-User-Type, X-User-ID, X-Org-ID) are replaced with the identity
+// of the credential so that handlers authorize service accounts and logged-in users
+// alike. X-API-Key-ID names the key used and X-Session-ID the session. Under an
+// impersonation session X-Impersonator-ID names the admin acting as the user. Requests
+// without a credential pass through unchanged.
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// X-API-Key-ID, X-Session-ID and X-Impersonator-ID are only ever set here; never
+		// trust client-supplied values.
+		r.Header.Del("X-API-Key-ID")
+		r.Header.Del("X-Session-ID")
+		r.Header.Del("X-Impersonator-ID")
+
+		key := r.Header.Get("X-API-Key")
+		bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		switch {
+		case strings.HasPrefix(bearer, APIKeyPrefix):
+			key = bearer
+		case strings.HasPrefix(bearer, SessionTokenPrefix):
+			authenticateSessionRequest(w, r, next, bearer)
+			return
+		case key == "" && strings.Count(bearer, ".") == 2 && oidcProviderEnabled():
+			if r.URL.Path != "/oauth2/userinfo" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+				errResponse(w, http.StatusUnauthorized, internalMsgs.ErrInvalidToken)
+				log.Printf("Unauthorized: access token used for %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := AuthenticateAPIKey(key, r.RemoteAddr)
+		if err != nil {
+			if errors.Is(err, internalMsgs.ErrAPIKeyIPNotAllowed) {
+				errResponse(w, http.StatusForbidden, err)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				errResponse(w, http.StatusUnauthorized, internalMsgs.ErrInvalidAPIKey)
+			}
+			log.Printf("Unauthorized: API key rejected from %s: %v", r.RemoteAddr, err)
+			return
+		}
+
+		r.Header.Del("X-API-Key")
+		r.Header.Del("Authorization")
+		r.Header.Set("X-User-Type", principal.Role)
+		r.Header.Set("X-User-ID", principal.ServiceAccountID)
+		r.Header.Set("X-Org-ID", principal.OrgID)
+		r.Header.Set("X-API-Key-ID", principal.KeyID)
+
+		audit.Record(audit.Event{
+			Type:   AuditAPIKeyUsed,
+			OrgID:  principal.OrgID,
+			Actor:  principal.ServiceAccountID,
+			Target: principal.KeyID,
+			Details: map[string]string{
+				"key_id": principal.KeyID,
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"role":   principal.Role,
+			},
+		})
+		log.Printf("API key %s authenticated as service account %s (%s): %s %s", principal.KeyID, principal.ServiceAccountID, principal.Role, r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateSessionRequest serves r as the user of the session token tok.
+func authenticateSessionRequest(w http.ResponseWriter, r *http.Request, next http.Handler, tok string) {
+	principal, err := AuthenticateSession(tok)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+		errResponse(w, http.StatusUnauthorized, internalMsgs.ErrInvalidToken)
+		log.Printf("Unauthorized: session token rejected from %s: %v", r.RemoteAddr, err)
+		return
+	}
+
+	r.Header.Del("Authorization")
+	r.Header.Set("X-User-Type", principal.Role)
+	r.Header.Set("X-User-ID", principal.UserID)
+	r.Header.Set("X-Org-ID", principal.OrgID)
+	r.Header.Set("X-Session-ID", principal.SessionID)
+	if principal.EnrollmentOnly && !isMFAEnrollmentPath(r.URL.Path, principal.UserID) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrMFAEnrollmentRequired)
+		log.Printf("Forbidden: user %s has to enroll in MFA before %s %s", principal.UserID, r.Method, r.URL.Path)
+		return
+	}
+	if principal.ImpersonatorID != "" {
+		serveImpersonatedRequest(w, r, next, principal)
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+// isMFAEnrollmentPath reports whether path is one of the MFA endpoints of user id, which
+// are all a session of a user who has to enroll in MFA can be used for.
+func isMFAEnrollmentPath(path, id string) bool {
+	switch strings.TrimPrefix(path, "/users/"+id+"/") {
+	case "mfa", "mfa/totp", "mfa/totp/confirm":
+		return true
+	}
+	return false
+}
+
+// serveImpersonatedRequest serves r for an impersonation session, attributing it to
+// both the impersonator and the impersonated user. Impersonation sessions are read-only,
+// so that nothing done under them can raise privileges or outlive the session.
+func serveImpersonatedRequest(w http.ResponseWriter, r *http.Request, next http.Handler, principal *SessionPrincipal) {
+	r.Header.Set("X-Impersonator-ID", principal.ImpersonatorID)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrImpersonationReadOnly)
+		log.Printf("Forbidden: user %s impersonating user %s attempted %s %s", principal.ImpersonatorID, principal.UserID, r.Method, r.URL.Path)
+		return
+	}
+
+	audit.Record(audit.Event{
+		Type:   AuditImpersonatedRequest,
+		OrgID:  principal.OrgID,
+		Actor:  principal.ImpersonatorID,
+		Target: principal.UserID,
+		Details: map[string]string{
+			"session_id": principal.SessionID,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"role":       principal.Role,
+		},
+	})
+	log.Printf("User %s impersonating user %s (%s): %s %s", principal.ImpersonatorID, principal.UserID, principal.Role, r.Method, r.URL.Path)
+	next.ServeHTTP(w, r)
+}
@@ -0,0 +1,332 @@
+package user
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// AuthzRule is one check of an authorization decision.
+type AuthzRule struct {
+	Rule   string `json:"rule"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// AuthzDecision records the checks behind an authorization decision. Checks stop at
+// the first failure, so the last rule is the one that decided.
+type AuthzDecision struct {
+	Allowed   bool        `json:"allowed"`
+	DecidedBy string      `json:"decided_by"`
+	Rules     []AuthzRule `json:"rules"`
+}
+
+// AuthzActor identifies who would perform an action: a user, whose highest effective
+// role is used as the handlers do, or a bare role.
+type AuthzActor struct {
+	UserID string `json:"user_id,omitempty"`
+	Role   string `json:"role,omitempty"`
+}
+
+// AuthzTarget identifies what an action applies to: a user, a role, or the roles the
+// action assigns or reviews. For users.create, Email and Attributes describe the user
+// to create to the access policy.
+type AuthzTarget struct {
+	UserID     string                 `json:"user_id,omitempty"`
+	Role       string                 `json:"role,omitempty"`
+	Roles      []string               `json:"roles,omitempty"`
+	Email      string                 `json:"email,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// roles returns the roles of the target, or its role alone.
+func (t AuthzTarget) roles() []string {
+	if len(t.Roles) == 0 && t.Role != "" {
+		return []string{t.Role}
+	}
+	return t.Roles
+}
+
+// AuthzCheck is an authorization question: may actor perform action on target? Context
+// and At describe the request to the access policy; At defaults to now.
+type AuthzCheck struct {
+	Actor   *AuthzActor    `json:"actor,omitempty"`
+	Action  string         `json:"action"`
+	Target  AuthzTarget    `json:"target"`
+	Context map[string]any `json:"context,omitempty"`
+	At      *time.Time     `json:"at,omitempty"`
+}
+
+// AuthzResult answers an AuthzCheck.
+type AuthzResult struct {
+	Action     string `json:"action"`
+	ActorRole  string `json:"actor_role"`
+	TargetRole string `json:"target_role,omitempty"`
+	AuthzDecision
+}
+
+// AuthzActions lists the actions CheckAuthorization understands, with the handlers
+// whose checks they replay. The access policy sees the same action names.
+var AuthzActions = map[string]string{
+	"users.list":                       "GET /users",
+	"users.list_deleted":               "GET /users?include_deleted=true",
+	"users.get":                        "GET /users/{id}",
+	"users.effective_roles":            "GET /users/{id}/effective-roles",
+	"users.create":                     "POST /users",
+	"users.update_roles":               "PUT /users/roles/{id}",
+	"users.update_attributes":          "PUT /users/{id}/attributes",
+	"users.update_email":               "PUT /users/{id}/email",
+	"users.request_email_verification": "POST /users/{id}/verification",
+	"users.delete":                     "DELETE /users/{id}",
+	"users.restore":                    "POST /users/{id}/restore",
+	"users.grant_role":                 "POST /users/{id}/role-grants",
+	"users.revoke_role":                "DELETE /users/{id}/role-grants/{grant_id}",
+	"users.sessions":                   "GET and DELETE /users/{id}/sessions",
+	"users.lockout":                    "GET and DELETE /users/{id}/lockout",
+	"users.mfa_status":                 "GET /users/{id}/mfa",
+	"users.reset_mfa":                  "DELETE /users/{id}/mfa",
+	"users.impersonate":                "POST /users/{id}/impersonation",
+	"users.federated_identities":       "GET, POST and DELETE /users/{id}/federated-identities",
+	"groups.create":                    "POST /groups",
+	"groups.update":                    "PUT /groups/{id}",
+	"groups.delete":                    "DELETE /groups/{id}",
+	"groups.add_member":                "POST /groups/{id}/members",
+	"groups.remove_member":             "DELETE /groups/{id}/members/{user_id}",
+	"role_change_requests.approve":     "POST /role-change-requests/{id}/approve",
+	"role_change_requests.reject":      "POST /role-change-requests/{id}/reject",
+	"service_accounts.create":          "POST /service-accounts",
+	"service_accounts.delete":          "DELETE /service-accounts/{id}",
+	"service_accounts.create_api_key":  "POST /service-accounts/{id}/keys",
+	"service_accounts.revoke_api_key":  "DELETE /service-accounts/{id}/keys/{key_id}",
+	"invitations.resend":               "POST /invitations/{id}/resend",
+	"invitations.revoke":               "DELETE /invitations/{id}",
+	"access_reviews.create":            "POST /access-reviews",
+	"access_reviews.decide":            "POST /access-reviews/{id}/items/{item_id}/decision",
+	"access_reviews.close":             "POST /access-reviews/{id}/close",
+}
+
+// check records the outcome of rule and returns it.
+func (d *AuthzDecision) check(rule string, passed bool, format string, args ...any) bool {
+	d.Rules = append(d.Rules, AuthzRule{Rule: rule, Passed: passed, Detail: fmt.Sprintf(format, args...)})
+	d.Allowed = passed
+	d.DecidedBy = rule
+	return passed
+}
+
+// reason describes the deciding rule, for log lines.
+func (d *AuthzDecision) reason() string {
+	if len(d.Rules) == 0 {
+		return ""
+	}
+	last := d.Rules[len(d.Rules)-1]
+	return last.Rule + ": " + last.Detail
+}
+
+func (d *AuthzDecision) knownRole(role string) bool {
+	if isRoleExists(role) {
+		return d.check("known_role", true, "%s is a known role", role)
+	}
+	return d.check("known_role", false, "%q is not a known role", role)
+}
+
+func (d *AuthzDecision) admin(role string) bool {
+	if isAdmin(role) {
+		return d.check("admin", true, "%s has administrative rights", role)
+	}
+	return d.check("admin", false, "only Admin and SuperAdmin have administrative rights, not %s", role)
+}
+
+// permission is the check behind checkPermission: currentUserRole must be known and
+// allowed to manage users with requiredRole.
+func (d *AuthzDecision) permission(currentUserRole, requiredRole string) bool {
+	if !d.knownRole(currentUserRole) {
+		return false
+	}
+	ok, detail := manageRule(currentUserRole, requiredRole)
+	return d.check("role_hierarchy", ok, "%s", detail)
+}
+
+// roleAssignment is the check behind isValidRoleUpdate: every role must exist, rank no
+// higher than sessionUserRole and be one it may manage. It returns the error the
+// handlers report.
+func (d *AuthzDecision) roleAssignment(newRoles []string, sessionUserRole string) error {
+	if len(newRoles) == 0 {
+		d.check("role_assignment", true, "no roles are assigned")
+		return nil
+	}
+	for _, role := range newRoles {
+		if !isRoleExists(role) {
+			d.check("role_exists", false, "%q is not a known role", role)
+			return fmt.Errorf("%w: %s", internalErrors.ErrInvalidRole, role)
+		}
+		d.check("role_exists", true, "%s is a known role", role)
+		if roleRank(role) > roleRank(sessionUserRole) {
+			d.check("role_rank", false, "%s outranks %s", role, sessionUserRole)
+			return fmt.Errorf("%w: %s", internalErrors.ErrInsufficientPermissions, role)
+		}
+		d.check("role_rank", true, "%s does not outrank %s", role, sessionUserRole)
+		ok, detail := manageRule(sessionUserRole, role)
+		if !d.check("role_hierarchy", ok, "%s", detail) {
+			return fmt.Errorf("%w: %s", internalErrors.ErrInsufficientPermissions, role)
+		}
+	}
+	return nil
+}
+
+// manageRule decides whether currentUserRole may act on users whose role is
+// targetUserRole, and explains why.
+func manageRule(currentUserRole, targetUserRole string) (bool, string) {
+	switch currentUserRole {
+	case "SuperAdmin":
+		return true, "SuperAdmin can manage every role"
+	case "Admin":
+		return targetUserRole != "SuperAdmin", "Admin can manage every role except SuperAdmin"
+	}
+	allowedRoles := roleHierarchy[currentUserRole]
+	switch {
+	case slices.Contains(allowedRoles, targetUserRole):
+		return true, fmt.Sprintf("%s can manage %s", currentUserRole, targetUserRole)
+	case len(allowedRoles) == 0:
+		return false, fmt.Sprintf("%s cannot manage any role", currentUserRole)
+	}
+	return false, fmt.Sprintf("%s can only manage %s, not %s", currentUserRole, strings.Join(allowedRoles, ", "), targetUserRole)
+}
+
+// CheckAuthorization replays the checks a handler would run for check, without
+// performing the action: the role checks and then the access policy. Actors given by
+// user ID act with their highest effective role, as X-User-Type carries for logged-in
+// users.
+func CheckAuthorization(orgID string, check AuthzCheck) (*AuthzResult, error) {
+	if _, exists := AuthzActions[check.Action]; !exists {
+		return nil, fmt.Errorf("%w: %s", internalErrors.ErrUnknownAuthzAction, check.Action)
+	}
+	result := &AuthzResult{Action: check.Action}
+	d := &result.AuthzDecision
+
+	actor := AuthzActor{}
+	if check.Actor != nil {
+		actor = *check.Actor
+	}
+	result.ActorRole = actor.Role
+	if actor.UserID != "" {
+		role, err := getUserTypeByID(orgID, actor.UserID)
+		if !d.check("actor", err == nil, "%s", describeUserRole(actor.UserID, role, err)) {
+			return result, nil
+		}
+		result.ActorRole = role
+	}
+
+	var pending *User
+	switch check.Action {
+	case "users.list", "users.get", "users.effective_roles":
+		d.knownRole(result.ActorRole)
+		return result, nil
+	case "users.list_deleted":
+		_ = d.knownRole(result.ActorRole) && d.admin(result.ActorRole)
+		return result, nil
+	case "groups.delete", "invitations.resend", "invitations.revoke",
+		"service_accounts.revoke_api_key":
+		if !d.admin(result.ActorRole) {
+			return result, nil
+		}
+	case "groups.create", "groups.update", "role_change_requests.reject":
+		if !d.admin(result.ActorRole) {
+			return result, nil
+		}
+		check.Context = withRoles(check.Context, check.Target.roles())
+	case "users.create":
+		if d.roleAssignment(check.Target.roles(), result.ActorRole) != nil {
+			return result, nil
+		}
+		pending = &User{OrgID: orgID, Email: check.Target.Email, Roles: check.Target.roles(), Attributes: check.Target.Attributes}
+	case "users.update_roles":
+		if d.roleAssignment(check.Target.roles(), result.ActorRole) != nil {
+			return result, nil
+		}
+		check.Context = withRoles(check.Context, check.Target.roles())
+	case "role_change_requests.approve", "service_accounts.create":
+		if !d.admin(result.ActorRole) || d.roleAssignment(check.Target.roles(), result.ActorRole) != nil {
+			return result, nil
+		}
+		check.Context = withRoles(check.Context, check.Target.roles())
+	case "service_accounts.delete", "service_accounts.create_api_key":
+		// The target roles are those of the service account.
+		if !d.admin(result.ActorRole) || d.roleAssignment(check.Target.roles(), result.ActorRole) != nil {
+			return result, nil
+		}
+	case "access_reviews.create", "access_reviews.decide", "access_reviews.close":
+		if !d.admin(result.ActorRole) {
+			return result, nil
+		}
+		for _, role := range check.Target.roles() {
+			if !d.permission(result.ActorRole, role) {
+				return result, nil
+			}
+		}
+		check.Context = withRoles(check.Context, check.Target.roles())
+	case "users.restore":
+		if !d.admin(result.ActorRole) {
+			return result, nil
+		}
+		fallthrough
+	default:
+		lookup := getUserTypeByID
+		if check.Action == "users.restore" {
+			lookup = getDeletedUserTypeByID
+		}
+		result.TargetRole = check.Target.Role
+		if check.Target.UserID != "" {
+			role, err := lookup(orgID, check.Target.UserID)
+			if !d.check("target", err == nil, "%s", describeUserRole(check.Target.UserID, role, err)) {
+				return result, nil
+			}
+			result.TargetRole = role
+		}
+		if !d.permission(result.ActorRole, result.TargetRole) {
+			return result, nil
+		}
+	}
+
+	at := time.Now()
+	if check.At != nil {
+		at = *check.At
+	}
+	policyDecision := evaluatePolicy(policyRequest{
+		orgID:    orgID,
+		action:   check.Action,
+		actor:    AuthzActor{UserID: actor.UserID, Role: result.ActorRole},
+		targetID: check.Target.UserID,
+		pending:  pending,
+		context:  check.Context,
+		at:       at,
+	})
+	if policyDecision.Allowed {
+		d.check("policy", true, "no access policy rule denies %s", check.Action)
+	} else if policyDecision.Description != "" {
+		d.check("policy", false, "rule %s denies %s: %s", policyDecision.Rule, check.Action, policyDecision.Description)
+	} else {
+		d.check("policy", false, "rule %s denies %s", policyDecision.Rule, check.Action)
+	}
+	return result, nil
+}
+
+// withRoles returns a copy of ctx with the roles an action assigns or reviews, as the
+// handlers describe them to the access policy.
+func withRoles(ctx map[string]any, roles []string) map[string]any {
+	ctx = maps.Clone(ctx)
+	if ctx == nil {
+		ctx = map[string]any{}
+	}
+	ctx["roles"] = roles
+	return ctx
+}
+
+func describeUserRole(id, role string, err error) string {
+	if err != nil {
+		return fmt.Sprintf("user %s: %v", id, err)
+	}
+	return fmt.Sprintf("user %s has the effective role %s", id, role)
+}
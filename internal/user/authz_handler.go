@@ -0,0 +1,52 @@
+package user
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// HandleAuthzCheck handles POST /authz/check. It explains whether an actor may perform
+// an action, listing the rules that decided, without performing it. The actor defaults
+// to the caller; only admins may ask about other actors.
+func HandleAuthzCheck(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	if !isRoleExists(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to check an authorization", currentUserRole)
+		return
+	}
+
+	var check AuthzCheck
+	if err := json.NewDecoder(r.Body).Decode(&check); err != nil || check.Action == "" {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: authorization check needs an action: %v", err)
+		return
+	}
+	if check.Actor == nil {
+		check.Actor = &AuthzActor{Role: currentUserRole}
+	} else if !isAdmin(currentUserRole) && !isSelf(r, check.Actor.UserID) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to check the authorization of another actor", currentUserRole)
+		return
+	}
+
+	result, err := CheckAuthorization(orgID, check)
+	if err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	jsonResponse(w, http.StatusOK, result)
+	log.Printf("Authorization check %s for %s: allowed=%t (%s)", check.Action, result.ActorRole, result.Allowed, result.reason())
+}
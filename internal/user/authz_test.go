@@ -0,0 +1,92 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// checkAuthz asks POST /authz/check for an explanation.
+func checkAuthz(t *testing.T, headers map[string]string, body string) (*httptest.ResponseRecorder, AuthzResult) {
+	t.Helper()
+	rr := doRequest(t, "POST", "/authz/check", headers, body)
+	var result AuthzResult
+	json.Unmarshal(rr.Body.Bytes(), &result)
+	return rr, result
+}
+
+func TestAuthzCheck(t *testing.T) {
+	setupTestStorageWithUsers()
+
+	admin := map[string]string{"X-User-Type": "Admin", "X-User-ID": "1"}
+
+	t.Run("Explains a denial", func(t *testing.T) {
+		rr, result := checkAuthz(t, admin, `{"actor":{"user_id":"2"},"action":"users.delete","target":{"user_id":"4"}}`)
+		if rr.Code != http.StatusOK || result.Allowed || result.DecidedBy != "role_hierarchy" || result.ActorRole != "Modifier" || result.TargetRole != "Modifier" {
+			t.Fatalf("unexpected result %v: %+v", rr.Code, result)
+		}
+		if last := result.Rules[len(result.Rules)-1]; last.Passed || last.Detail != "Modifier can only manage Watcher, not Modifier" {
+			t.Errorf("unexpected deciding rule %+v", last)
+		}
+	})
+
+	t.Run("Explains a role assignment", func(t *testing.T) {
+		_, result := checkAuthz(t, admin, `{"actor":{"role":"Admin"},"action":"users.update_roles","target":{"roles":["Watcher","SuperAdmin"]}}`)
+		if result.Allowed || result.DecidedBy != "role_rank" || len(result.Rules) != 5 {
+			t.Errorf("unexpected result %+v", result)
+		}
+	})
+
+	tests := []struct {
+		name           string
+		header         map[string]string
+		body           string
+		expectedStatus int
+	}{
+		{"Actor defaults to the caller", map[string]string{"X-User-Type": "Watcher", "X-User-ID": "3"}, `{"action":"users.list"}`, http.StatusOK},
+		{"Callers may check themselves", map[string]string{"X-User-Type": "Watcher", "X-User-ID": "3"}, `{"actor":{"user_id":"3"},"action":"users.list"}`, http.StatusOK},
+		{"Only admins check other actors", map[string]string{"X-User-Type": "Modifier", "X-User-ID": "2"}, `{"actor":{"user_id":"3"},"action":"users.list"}`, http.StatusForbidden},
+		{"Unknown caller", map[string]string{"X-User-Type": "Guest"}, `{"action":"users.list"}`, http.StatusForbidden},
+		{"Unknown action", admin, `{"action":"users.fly"}`, http.StatusBadRequest},
+		{"Missing action", admin, `{}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr, _ := checkAuthz(t, tt.header, tt.body); rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+
+	// The explanation must agree with what the handlers actually do. The preconditions
+	// and the unknown user keep the handlers from changing anything.
+	for _, role := range []string{"SuperAdmin", "Admin", "Modifier", "Watcher", "Guest"} {
+		for _, id := range []string{"1", "2", "3"} {
+			req := httptest.NewRequest("DELETE", "/users/"+id, nil)
+			req.Header.Set("X-User-Type", role)
+			req.Header.Set("If-Match", `"999"`)
+			rr := httptest.NewRecorder()
+			HandleDeleteUser(rr, req)
+			_, result := checkAuthz(t, admin, fmt.Sprintf(`{"actor":{"role":%q},"action":"users.delete","target":{"user_id":%q}}`, role, id))
+			if result.Allowed != (rr.Code != http.StatusForbidden) {
+				t.Errorf("%s deleting user %s: handler returned %v but check allowed=%t", role, id, rr.Code, result.Allowed)
+			}
+		}
+		for _, assigned := range []string{"SuperAdmin", "Admin", "Modifier", "Watcher", "Guest"} {
+			req := httptest.NewRequest("PUT", "/users/roles/999", bytes.NewBufferString(fmt.Sprintf(`{"roles":[%q]}`, assigned)))
+			req.Header.Set("X-User-Type", role)
+			rr := httptest.NewRecorder()
+			HandleUpdateUserRoles(rr, req)
+			_, result := checkAuthz(t, admin, fmt.Sprintf(`{"actor":{"role":%q},"action":"users.update_roles","target":{"roles":[%q]}}`, role, assigned))
+			if result.Allowed != (rr.Code != http.StatusForbidden) {
+				t.Errorf("%s assigning %s: handler returned %v but check allowed=%t", role, assigned, rr.Code, result.Allowed)
+			}
+		}
+	}
+	if user, _ := GetUser(DefaultOrgID, "1"); user == nil {
+		t.Error("expected the consistency checks to leave users untouched")
+	}
+}
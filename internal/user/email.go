@@ -0,0 +1,136 @@
+package user
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// EmailPolicy holds the per-deployment rules applied to user email addresses.
+// Domains match exactly or as a parent domain, so "example.com" also covers "eu.example.com".
+type EmailPolicy struct {
+	AllowedDomains []string
+	DeniedDomains  []string
+	// ProviderRules enables provider-specific canonicalization, such as ignoring dots
+	// and "+tag" suffixes in Gmail addresses, when checking uniqueness.
+	ProviderRules bool
+}
+
+var (
+	emailPolicyMu sync.RWMutex
+	emailPolicy   EmailPolicy
+)
+
+// SetEmailPolicy installs the email policy used by validation and uniqueness checks.
+func SetEmailPolicy(p EmailPolicy) {
+	emailPolicyMu.Lock()
+	defer emailPolicyMu.Unlock()
+	emailPolicy = p
+}
+
+func currentEmailPolicy() EmailPolicy {
+	emailPolicyMu.RLock()
+	defer emailPolicyMu.RUnlock()
+	return emailPolicy
+}
+
+// NormalizeEmail trims surrounding whitespace and lower-cases the domain of an address.
+// The local part keeps its case so the address is stored as the user typed it.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	return email[:at+1] + strings.ToLower(email[at+1:])
+}
+
+// canonicalEmail returns the key used to enforce email uniqueness. It is case-insensitive
+// and, when provider rules are enabled, folds provider-specific aliases together.
+func canonicalEmail(email string) string {
+	email = strings.ToLower(NormalizeEmail(email))
+	if !currentEmailPolicy().ProviderRules {
+		return email
+	}
+
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+	switch domain {
+	case "gmail.com", "googlemail.com":
+		local, _, _ = strings.Cut(local, "+")
+		local = strings.ReplaceAll(local, ".", "")
+		domain = "gmail.com"
+	case "outlook.com", "hotmail.com", "live.com", "icloud.com", "fastmail.com":
+		local, _, _ = strings.Cut(local, "+")
+	}
+	return local + "@" + domain
+}
+
+// validateEmail checks the syntax of an address against RFC 5322 and the deployment's
+// domain allowlist and denylist. Display names, quoted local parts and domains without
+// a top-level domain are rejected.
+func validateEmail(email string) error {
+	email = NormalizeEmail(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return fmt.Errorf("%w: %s", internalMsgs.ErrInvalidEmail, email)
+	}
+
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], email[at+1:]
+	if len(email) > 254 || len(local) > 64 || strings.HasPrefix(local, `"`) || !isValidDomain(domain) {
+		return fmt.Errorf("%w: %s", internalMsgs.ErrInvalidEmail, email)
+	}
+
+	policy := currentEmailPolicy()
+	if len(policy.AllowedDomains) > 0 && !domainInList(domain, policy.AllowedDomains) {
+		return fmt.Errorf("%w: %s", internalMsgs.ErrEmailDomainNotAllowed, domain)
+	}
+	if domainInList(domain, policy.DeniedDomains) {
+		return fmt.Errorf("%w: %s", internalMsgs.ErrEmailDomainNotAllowed, domain)
+	}
+	return nil
+}
+
+// isValidDomain checks that domain is a DNS hostname with at least two labels and an alphabetic TLD.
+func isValidDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 || len(domain) > 253 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+
+	tld := labels[len(labels)-1]
+	if len(tld) < 2 {
+		return false
+	}
+	for _, c := range tld {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func domainInList(domain string, list []string) bool {
+	for _, entry := range list {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry != "" && (domain == entry || strings.HasSuffix(domain, "."+entry)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package user
+
+import (
+	"testing"
+)
+
+func TestEmailPolicy(t *testing.T) {
+	defer SetEmailPolicy(EmailPolicy{})
+
+	tests := []struct {
+		name        string
+		policy      EmailPolicy
+		email       string
+		expectValid bool
+	}{
+		{name: "Plain address is valid", email: "solo@example.com", expectValid: true},
+		{name: "Subdomain is valid", email: "solo@eu.example.co.uk", expectValid: true},
+		{name: "Missing domain is invalid", email: "solo@", expectValid: false},
+		{name: "IP literal is invalid", email: "solo@[127.0.0.1]", expectValid: false},
+		{name: "Allowlisted domain is valid", policy: EmailPolicy{AllowedDomains: []string{"zpe.com"}}, email: "a@eu.zpe.com", expectValid: true},
+		{name: "Domain outside allowlist is invalid", policy: EmailPolicy{AllowedDomains: []string{"zpe.com"}}, email: "a@example.com", expectValid: false},
+		{name: "Denylisted domain is invalid", policy: EmailPolicy{DeniedDomains: []string{"mailinator.com"}}, email: "a@Mailinator.com", expectValid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetEmailPolicy(tt.policy)
+			user := User{Name: "Test", Email: NormalizeEmail(tt.email), Roles: []string{"Watcher"}}
+			if err := user.ValidateRequiredFields(); (err == nil) != tt.expectValid {
+				t.Errorf("unexpected validation result for %s: %v", tt.email, err)
+			}
+		})
+	}
+
+	SetEmailPolicy(EmailPolicy{ProviderRules: true})
+	if canonicalEmail("Han.Solo+rebels@googlemail.com") != canonicalEmail("hansolo@gmail.com") {
+		t.Errorf("expected Gmail aliases to share a canonical form")
+	}
+	if canonicalEmail("han.solo+rebels@example.com") == canonicalEmail("hansolo@example.com") {
+		t.Errorf("expected provider rules to apply only to known providers")
+	}
+}
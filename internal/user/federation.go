@@ -0,0 +1,485 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+	"zpe-cloud-user-management-service/internal/oidcclient"
+)
+
+// FederationProvider configures login through an upstream OpenID Connect provider,
+// such as a customer's corporate identity provider. Its users belong to OrgID.
+type FederationProvider struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	OrgID        string   `json:"org_id"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+	// RoleMappings translate ID token claims into roles. Every matching rule adds its role.
+	RoleMappings []RoleMapping `json:"role_mappings"`
+	// DefaultRole is given when no rule matches. Without it such users cannot log in.
+	DefaultRole string `json:"default_role"`
+	// JIT creates users on their first login; otherwise only existing users can log in.
+	JIT bool `json:"jit"`
+	// SyncRoles replaces the roles of existing users with the mapped roles on every login.
+	SyncRoles bool `json:"sync_roles"`
+}
+
+// RoleMapping grants Role when the ID token claim Claim equals Value or, for list
+// claims such as groups, contains it.
+type RoleMapping struct {
+	Claim string `json:"claim"`
+	Value string `json:"value"`
+	Role  string `json:"role"`
+}
+
+// FederationProviderInfo is the public description of a provider shown on login pages.
+type FederationProviderInfo struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	OrgID string `json:"org_id"`
+}
+
+// federationLogin is a login in progress at an upstream provider, keyed by its state.
+type federationLogin struct {
+	providerID   string
+	nonce        string
+	codeVerifier string
+	// authorization is the request of an OpenID Connect client to resume after the
+	// login, if the login started at this service's own authorization endpoint.
+	authorization *AuthorizationRequest
+	expiresAt     time.Time
+}
+
+// federationLoginTTL is how long a user has to complete a login at the upstream provider.
+const federationLoginTTL = 10 * time.Minute
+
+// federationActor is recorded as the creator of just-in-time provisioned users.
+const federationActor = "system"
+
+// Audit event types emitted by federated login.
+const (
+	AuditFederatedLogin            = "federation.login"
+	AuditFederatedUserProvisioned  = "federation.user_provisioned"
+	AuditFederatedIdentityLinked   = "federation.identity_linked"
+	AuditFederatedIdentityUnlinked = "federation.identity_unlinked"
+)
+
+// upstreamMFAMethods are the amr values (RFC 8176) that show the provider checked a
+// second factor. Users the MFA policy covers need one of them to log in.
+var upstreamMFAMethods = []string{"mfa", "otp", "hwk", "swk", "sms", "sc"}
+
+// FederatedIdentity is an upstream identity linked to a user.
+type FederatedIdentity struct {
+	ProviderID string `json:"provider_id"`
+	Subject    string `json:"subject"`
+}
+
+var federationProviderIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+type federationProvider struct {
+	FederationProvider
+	client *oidcclient.Client
+}
+
+var (
+	federationMu        sync.RWMutex
+	federationProviders = make(map[string]*federationProvider)
+)
+
+var (
+	// federationLogins is guarded by mu.
+	federationLogins = make(map[string]*federationLogin)
+	// federatedIdentities maps a provider ID and subject to the linked user's ID. It is
+	// guarded by mu.
+	federatedIdentities = make(map[string]string)
+)
+
+// SetFederationProviders replaces the configured upstream providers. A nil httpClient
+// uses a default client.
+func SetFederationProviders(providers []FederationProvider, httpClient *http.Client) error {
+	configured := make(map[string]*federationProvider, len(providers))
+	for _, p := range providers {
+		if !federationProviderIDPattern.MatchString(p.ID) {
+			return fmt.Errorf("provider id %q must be lower-case letters, digits or hyphens", p.ID)
+		}
+		if _, exists := configured[p.ID]; exists {
+			return fmt.Errorf("provider %q is configured twice", p.ID)
+		}
+		if p.Issuer == "" || p.ClientID == "" || p.RedirectURL == "" {
+			return fmt.Errorf("provider %q needs an issuer, a client_id and a redirect_url", p.ID)
+		}
+		if p.OrgID == "" {
+			p.OrgID = DefaultOrgID
+		}
+		if p.Name == "" {
+			p.Name = p.ID
+		}
+		if p.DefaultRole != "" && !isRoleExists(p.DefaultRole) {
+			return fmt.Errorf("provider %q: unknown default role %q", p.ID, p.DefaultRole)
+		}
+		for _, m := range p.RoleMappings {
+			if m.Claim == "" || !isRoleExists(m.Role) {
+				return fmt.Errorf("provider %q: invalid role mapping %+v", p.ID, m)
+			}
+		}
+		configured[p.ID] = &federationProvider{
+			FederationProvider: p,
+			client: oidcclient.New(oidcclient.Config{
+				Issuer:       p.Issuer,
+				ClientID:     p.ClientID,
+				ClientSecret: p.ClientSecret,
+				RedirectURL:  p.RedirectURL,
+				Scopes:       p.Scopes,
+			}, httpClient),
+		}
+	}
+
+	federationMu.Lock()
+	defer federationMu.Unlock()
+	federationProviders = configured
+	return nil
+}
+
+func lookupFederationProvider(id string) (*federationProvider, error) {
+	federationMu.RLock()
+	defer federationMu.RUnlock()
+	p, exists := federationProviders[id]
+	if !exists {
+		return nil, internalErrors.ErrFederationProviderNotFound
+	}
+	return p, nil
+}
+
+// ListFederationProviders returns the providers users of orgID can log in with, or
+// those of all organizations if orgID is empty.
+func ListFederationProviders(orgID string) []FederationProviderInfo {
+	federationMu.RLock()
+	defer federationMu.RUnlock()
+
+	list := []FederationProviderInfo{}
+	for _, p := range federationProviders {
+		if orgID == "" || p.OrgID == orgID {
+			list = append(list, FederationProviderInfo{ID: p.ID, Name: p.Name, OrgID: p.OrgID})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// StartFederatedLogin begins a login at the provider and returns the state that ties
+// the callback to it and the provider URL to send the user agent to. A non-nil
+// authorization is resumed once the login completes.
+func StartFederatedLogin(ctx context.Context, providerID string, authorization *AuthorizationRequest) (state, redirectURL string, err error) {
+	p, err := lookupFederationProvider(providerID)
+	if err != nil {
+		return "", "", err
+	}
+	login := &federationLogin{
+		providerID:    providerID,
+		nonce:         oidcclient.NewVerifier(),
+		codeVerifier:  oidcclient.NewVerifier(),
+		authorization: authorization,
+		expiresAt:     time.Now().Add(federationLoginTTL),
+	}
+	state = oidcclient.NewVerifier()
+	redirectURL, err = p.client.AuthCodeURL(ctx, state, login.nonce, login.codeVerifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for s, pending := range federationLogins {
+		if !now.Before(pending.expiresAt) {
+			delete(federationLogins, s)
+		}
+	}
+	federationLogins[state] = login
+	return state, redirectURL, nil
+}
+
+// CompleteFederatedLogin finishes the login identified by state with the authorization
+// code returned by the provider. The user linked to the upstream identity is logged in;
+// without one, a user is provisioned if the provider allows it. Existing users are only
+// linked by an admin, never by a matching email address. Once the state is known to be
+// valid, the authorization to resume is returned even if the login fails.
+func CompleteFederatedLogin(ctx context.Context, providerID, state, code string) (*Authentication, *AuthorizationRequest, error) {
+	p, err := lookupFederationProvider(providerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	login, err := consumeFederatedLogin(providerID, state)
+	if err != nil {
+		return nil, nil, err
+	}
+	auth, err := p.authenticate(ctx, login, code)
+	return auth, login.authorization, err
+}
+
+// CancelFederatedLogin discards the login identified by state after the provider
+// reported an error, and returns the authorization that can no longer be resumed.
+func CancelFederatedLogin(providerID, state string) (*AuthorizationRequest, error) {
+	login, err := consumeFederatedLogin(providerID, state)
+	if err != nil {
+		return nil, err
+	}
+	return login.authorization, nil
+}
+
+// consumeFederatedLogin removes and returns the pending login identified by state.
+func consumeFederatedLogin(providerID, state string) (*federationLogin, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	login, exists := federationLogins[state]
+	delete(federationLogins, state)
+	if !exists || login.providerID != providerID || !time.Now().Before(login.expiresAt) {
+		return nil, internalErrors.ErrFederatedLoginFailed
+	}
+	return login, nil
+}
+
+// authenticate redeems the authorization code for login and logs in the upstream user.
+func (p *federationProvider) authenticate(ctx context.Context, login *federationLogin, code string) (*Authentication, error) {
+	claims, err := p.client.Exchange(ctx, code, login.codeVerifier, login.nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", internalErrors.ErrFederatedLoginFailed, err)
+	}
+	roles := p.mapRoles(claims)
+	if len(roles) == 0 {
+		return nil, internalErrors.ErrFederatedRoleNotMapped
+	}
+
+	userID, linked := p.linkedUser(claims)
+	switch {
+	case linked && p.SyncRoles:
+		p.syncRoles(userID, roles)
+	case !linked && !p.JIT:
+		return nil, internalErrors.ErrFederatedUserNotFound
+	case !linked:
+		if userID, err = p.provisionUser(claims, roles); err != nil {
+			return nil, err
+		}
+	}
+
+	methods := claims.Strings("amr")
+	if len(methods) == 0 {
+		methods = []string{"fed"}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(p.OrgID, userID)
+	if !exists || user.IsDeleted() || user.Status != UserStatusActive {
+		return nil, internalErrors.ErrInvalidCredentials
+	}
+	if mfaRequired(user) && !slices.ContainsFunc(methods, func(m string) bool { return containsString(upstreamMFAMethods, m) }) {
+		return nil, internalErrors.ErrFederatedMFARequired
+	}
+	auth := &Authentication{UserID: user.ID, OrgID: user.OrgID, Methods: methods}
+	if roles := effectiveRoles(user); len(roles) > 0 {
+		auth.Role = roles[0].Role
+	}
+	audit.Record(audit.Event{Type: AuditFederatedLogin, OrgID: user.OrgID, Actor: user.ID, Target: user.ID, Details: map[string]string{"provider": p.ID, "subject": claims.String("sub")}})
+	return auth, nil
+}
+
+// mapRoles returns the roles the provider's rules grant for claims, highest first, or
+// the default role if no rule matches.
+func (p *federationProvider) mapRoles(claims oidcclient.Claims) []string {
+	var roles []string
+	for _, m := range p.RoleMappings {
+		if containsString(claims.Strings(m.Claim), m.Value) && !containsString(roles, m.Role) {
+			roles = append(roles, m.Role)
+		}
+	}
+	if len(roles) == 0 && p.DefaultRole != "" {
+		roles = append(roles, p.DefaultRole)
+	}
+	sort.SliceStable(roles, func(i, j int) bool { return roleRank(roles[i]) > roleRank(roles[j]) })
+	return roles
+}
+
+func (p *federationProvider) identityKey(subject string) string {
+	return p.ID + "\x00" + subject
+}
+
+// linkedUser returns the ID of the user linked to the upstream identity in claims.
+// Identities are linked when the provider provisions the user or by an admin.
+func (p *federationProvider) linkedUser(claims oidcclient.Claims) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := p.identityKey(claims.String("sub"))
+	if id, exists := federatedIdentities[key]; exists {
+		if _, exists := lookupUser(p.OrgID, id); exists {
+			return id, true
+		}
+		delete(federatedIdentities, key)
+	}
+	return "", false
+}
+
+// ListFederatedIdentities returns the upstream identities linked to a user.
+func ListFederatedIdentities(orgID, userID string) ([]FederatedIdentity, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if user, exists := lookupUser(orgID, userID); !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	identities := []FederatedIdentity{}
+	for key, id := range federatedIdentities {
+		if id == userID {
+			providerID, subject, _ := strings.Cut(key, "\x00")
+			identities = append(identities, FederatedIdentity{ProviderID: providerID, Subject: subject})
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		if identities[i].ProviderID != identities[j].ProviderID {
+			return identities[i].ProviderID < identities[j].ProviderID
+		}
+		return identities[i].Subject < identities[j].Subject
+	})
+	return identities, nil
+}
+
+// LinkFederatedIdentity links the identity subject at a provider of the user's
+// organization to the user, so that it logs in as that user.
+func LinkFederatedIdentity(orgID, userID string, identity FederatedIdentity, actor string) error {
+	p, err := lookupFederationProvider(identity.ProviderID)
+	if err != nil || p.OrgID != orgID {
+		return internalErrors.ErrFederationProviderNotFound
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if user, exists := lookupUser(orgID, userID); !exists || user.IsDeleted() {
+		return internalErrors.ErrUserNotFound
+	}
+	key := p.identityKey(identity.Subject)
+	if id, exists := federatedIdentities[key]; exists && id != userID {
+		if _, exists := lookupUser(orgID, id); exists {
+			return internalErrors.ErrFederatedIdentityLinked
+		}
+	}
+	federatedIdentities[key] = userID
+	audit.Record(audit.Event{Type: AuditFederatedIdentityLinked, OrgID: orgID, Actor: actor, Target: userID, Details: map[string]string{"provider": p.ID, "subject": identity.Subject}})
+	return nil
+}
+
+// UnlinkFederatedIdentity removes the identities at providerID linked to the user.
+func UnlinkFederatedIdentity(orgID, userID, providerID, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if user, exists := lookupUser(orgID, userID); !exists || user.IsDeleted() {
+		return internalErrors.ErrUserNotFound
+	}
+	unlinked := false
+	for key, id := range federatedIdentities {
+		if id == userID && strings.HasPrefix(key, providerID+"\x00") {
+			delete(federatedIdentities, key)
+			unlinked = true
+		}
+	}
+	if !unlinked {
+		return internalErrors.ErrFederatedIdentityNotFound
+	}
+	audit.Record(audit.Event{Type: AuditFederatedIdentityUnlinked, OrgID: orgID, Actor: actor, Target: userID, Details: map[string]string{"provider": providerID}})
+	return nil
+}
+
+// provisionUser creates and links a user for the upstream identity in claims. Roles
+// that need approval are requested; until then the user only has the lowest role.
+func (p *federationProvider) provisionUser(claims oidcclient.Claims, roles []string) (string, error) {
+	verified, _ := claims["email_verified"].(bool)
+	user := &User{
+		OrgID:         p.OrgID,
+		Name:          claims.String("name"),
+		Email:         NormalizeEmail(claims.String("email")),
+		EmailVerified: verified,
+		Roles:         roles,
+	}
+	if user.Name == "" {
+		user.Name = user.Email
+	}
+	var pendingRoles []string
+	if requiresApproval(nil, roles) {
+		pendingRoles, user.Roles = roles, []string{lowestRole()}
+	}
+	if err := user.ValidateRequiredFields(); err != nil {
+		return "", fmt.Errorf("%w: %v", internalErrors.ErrFederatedLoginFailed, err)
+	}
+	if err := user.ValidateAttributes(); err != nil {
+		return "", fmt.Errorf("%w: %v", internalErrors.ErrFederatedLoginFailed, err)
+	}
+	if err := CreateUser(user, federationActor); err != nil {
+		return "", err
+	}
+
+	mu.Lock()
+	federatedIdentities[p.identityKey(claims.String("sub"))] = user.ID
+	mu.Unlock()
+
+	audit.Record(audit.Event{Type: AuditFederatedUserProvisioned, OrgID: user.OrgID, Actor: federationActor, Target: user.ID, Details: map[string]string{"provider": p.ID, "subject": claims.String("sub"), "roles": strings.Join(user.Roles, ",")}})
+	log.Printf("User %s provisioned by provider %s", user.ID, p.ID)
+	if pendingRoles != nil {
+		p.requestRoles(user.ID, pendingRoles)
+	}
+	return user.ID, nil
+}
+
+// syncRoles replaces the roles of a linked user with the mapped roles, going through
+// the approval workflow where the policy requires it.
+func (p *federationProvider) syncRoles(userID string, roles []string) {
+	current, err := GetUser(p.OrgID, userID)
+	if err != nil || sameRoles(current.Roles, roles) {
+		return
+	}
+	if requiresApproval(current.Roles, roles) {
+		p.requestRoles(userID, roles)
+		return
+	}
+	if _, err := UpdateUserRoles(p.OrgID, userID, roles, federationActor, nil); err != nil {
+		log.Printf("Failed to sync roles of user %s from provider %s: %v", userID, p.ID, err)
+		return
+	}
+	log.Printf("Roles of user %s synced from provider %s: %v", userID, p.ID, roles)
+}
+
+// requestRoles files a role change request unless an identical one is already pending
+// for the current version of the user.
+func (p *federationProvider) requestRoles(userID string, roles []string) {
+	current, err := GetUser(p.OrgID, userID)
+	if err != nil {
+		return
+	}
+	for _, req := range ListRoleChangeRequests(p.OrgID, RoleChangePending) {
+		if req.UserID == userID && req.GroupID == "" && req.Grant == nil && req.Version == current.Version && sameRoles(req.Roles, roles) {
+			return
+		}
+	}
+	if req, err := CreateRoleChangeRequest(p.OrgID, userID, roles, federationActor, nil); err != nil {
+		log.Printf("Failed to request role change for user %s: %v", userID, err)
+	} else {
+		log.Printf("Role change for user %s is pending approval: %s", userID, req.ID)
+	}
+}
@@ -0,0 +1,245 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// federationStateCookie binds a federated login to the user agent that started it, so
+// that a callback cannot be replayed in another browser.
+const federationStateCookie = "zpe_federation_state"
+
+// federationLink is a provider offered on the login page.
+type federationLink struct {
+	Name string
+	URL  string
+}
+
+// HandleFederation handles HTTP requests for /federation, which lists the configured
+// identity providers, and for the login and callback endpoints of each provider.
+func HandleFederation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/federation"), "/")
+	if rest == "" {
+		jsonResponse(w, http.StatusOK, ListFederationProviders(r.URL.Query().Get("org_id")))
+		return
+	}
+	switch providerID, action, _ := strings.Cut(rest, "/"); action {
+	case "login":
+		HandleFederatedLogin(w, r, providerID)
+	case "callback":
+		HandleFederatedCallback(w, r, providerID)
+	default:
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+	}
+}
+
+// HandleFederatedLogin redirects the user agent to the identity provider. When called
+// with the parameters of an authorization request, as linked from the login page of
+// the OpenID Connect provider, that request is resumed after the login.
+func HandleFederatedLogin(w http.ResponseWriter, r *http.Request, providerID string) {
+	if err := r.ParseForm(); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	var authorization *AuthorizationRequest
+	if r.Form.Get("client_id") != "" {
+		cfg, keys := currentOIDCProvider()
+		if keys == nil {
+			errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidClient)
+			log.Printf("BadRequest: authorization request while the OIDC provider is disabled")
+			return
+		}
+		req, ok := parseAuthorizationRequest(w, r, cfg)
+		if !ok {
+			return
+		}
+		authorization = &req
+	}
+
+	state, redirectURL, err := StartFederatedLogin(r.Context(), providerID, authorization)
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrFederationProviderNotFound) {
+			errResponse(w, http.StatusNotFound, err)
+		} else {
+			errResponse(w, http.StatusBadGateway, internalMsgs.ErrFederatedLoginFailed)
+		}
+		log.Printf("Federated login with provider %s not started: %v", providerID, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     federationStateCookie,
+		Value:    state,
+		Path:     "/federation/" + providerID + "/",
+		MaxAge:   int(federationLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// HandleFederatedCallback completes a federated login. The user is either sent back to
+// the OpenID Connect client whose authorization request started the login, or the
+// outcome is reported like that of POST /login.
+func HandleFederatedCallback(w http.ResponseWriter, r *http.Request, providerID string) {
+	query := r.URL.Query()
+	state := query.Get("state")
+	http.SetCookie(w, &http.Cookie{Name: federationStateCookie, Path: "/federation/" + providerID + "/", MaxAge: -1})
+	if cookie, err := r.Cookie(federationStateCookie); err != nil || state == "" || cookie.Value != state {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrFederatedLoginFailed)
+		log.Printf("BadRequest: federated login callback from provider %s without a matching state", providerID)
+		return
+	}
+
+	if upstreamErr := query.Get("error"); upstreamErr != "" {
+		authorization, err := CancelFederatedLogin(providerID, state)
+		if err == nil && authorization != nil {
+			redirectWithError(w, r, *authorization, "access_denied", "the identity provider refused the login")
+		} else {
+			errResponse(w, http.StatusUnauthorized, internalMsgs.ErrFederatedLoginFailed)
+		}
+		log.Printf("Federated login with provider %s failed upstream: %s %s", providerID, upstreamErr, query.Get("error_description"))
+		return
+	}
+
+	auth, authorization, err := CompleteFederatedLogin(r.Context(), providerID, state, query.Get("code"))
+	if err != nil {
+		if authorization != nil {
+			redirectWithError(w, r, *authorization, "access_denied", err.Error())
+			return
+		}
+		switch {
+		case errors.Is(err, internalMsgs.ErrFederationProviderNotFound):
+			errResponse(w, http.StatusNotFound, err)
+		case errors.Is(err, internalMsgs.ErrFederatedRoleNotMapped), errors.Is(err, internalMsgs.ErrFederatedUserNotFound), errors.Is(err, internalMsgs.ErrFederatedMFARequired):
+			errResponse(w, http.StatusForbidden, err)
+		case errors.Is(err, internalMsgs.ErrFederatedLoginFailed), errors.Is(err, internalMsgs.ErrInvalidCredentials):
+			errResponse(w, http.StatusUnauthorized, internalMsgs.ErrFederatedLoginFailed)
+		case errors.Is(err, internalMsgs.ErrUserAlreadyExists), errors.Is(err, internalMsgs.ErrAttributeNotUnique):
+			errResponse(w, http.StatusConflict, err)
+		default:
+			errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+		}
+		log.Printf("Federated login with provider %s failed: %v", providerID, err)
+		return
+	}
+
+	resp, err := startSession(r, auth)
+	if err != nil {
+		errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+		log.Printf("Failed to start a session for user %s: %v", auth.UserID, err)
+		return
+	}
+	if authorization != nil {
+		code := IssueAuthorizationCode(*authorization, auth)
+		redirect(w, r, *authorization, url.Values{"code": {code}})
+		log.Printf("User %s authorized client %s through provider %s", auth.UserID, authorization.ClientID, providerID)
+		return
+	}
+	jsonResponse(w, http.StatusOK, resp)
+	log.Printf("User %s logged in through provider %s", auth.UserID, providerID)
+}
+
+// federationLinks returns the providers of orgID for the login page, with links that
+// carry the authorization request in params.
+func federationLinks(orgID string, params map[string]string) []federationLink {
+	query := url.Values{}
+	for name, value := range params {
+		query.Set(name, value)
+	}
+	var links []federationLink
+	for _, p := range ListFederationProviders(orgID) {
+		links = append(links, federationLink{Name: p.Name, URL: "/federation/" + p.ID + "/login?" + query.Encode()})
+	}
+	return links
+}
+
+// HandleUserFederatedIdentities handles HTTP requests for /users/{id}/federated-identities.
+// GET lists the upstream identities linked to a user, POST links one and DELETE
+// /users/{id}/federated-identities/{provider} unlinks those of a provider. Only admins
+// with permission over the user can manage them.
+func HandleUserFederatedIdentities(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, action := splitUserPath(r.URL.Path)
+	providerID := strings.TrimPrefix(strings.TrimPrefix(action, "federated-identities"), "/")
+
+	switch {
+	case providerID == "" && (r.Method == http.MethodGet || r.Method == http.MethodPost):
+	case providerID != "" && r.Method == http.MethodDelete:
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to manage the federated identities of user %s", currentUserRole, id)
+		return
+	}
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+	if !checkUserPermission(w, r, orgID, "users.federated_identities", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		identities, err := ListFederatedIdentities(orgID, id)
+		if err != nil {
+			errResponse(w, http.StatusNotFound, err)
+			log.Printf("NotFound: User %s", id)
+			return
+		}
+		jsonResponse(w, http.StatusOK, identities)
+	case http.MethodPost:
+		var identity FederatedIdentity
+		if err := json.NewDecoder(r.Body).Decode(&identity); err != nil || identity.ProviderID == "" || identity.Subject == "" {
+			errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+			log.Printf("BadRequest: federated identity needs a provider_id and a subject")
+			return
+		}
+		if err := LinkFederatedIdentity(orgID, id, identity, currentActor(r)); err != nil {
+			switch {
+			case errors.Is(err, internalMsgs.ErrFederatedIdentityLinked):
+				errResponse(w, http.StatusConflict, err)
+			default:
+				errResponse(w, http.StatusNotFound, err)
+			}
+			log.Printf("Identity %s at provider %s not linked to user %s: %v", identity.Subject, identity.ProviderID, id, err)
+			return
+		}
+		jsonResponse(w, http.StatusCreated, identity)
+		log.Printf("Linked user %s to identity %s at provider %s", id, identity.Subject, identity.ProviderID)
+	case http.MethodDelete:
+		if err := UnlinkFederatedIdentity(orgID, id, providerID, currentActor(r)); err != nil {
+			errResponse(w, http.StatusNotFound, err)
+			log.Printf("NotFound: %v", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		log.Printf("Unlinked user %s from provider %s", id, providerID)
+	}
+}
@@ -0,0 +1,226 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	"zpe-cloud-user-management-service/internal/jwt"
+)
+
+func TestFederatedLogin(t *testing.T) {
+	setupTestStorageWithUsers()
+	audit.Reset()
+
+	idpKeys, err := jwt.NewKeySet(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var identity map[string]any
+	idp := http.NewServeMux()
+	idpServer := httptest.NewServer(idp)
+	defer idpServer.Close()
+	idp.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idpServer.URL,
+			"authorization_endpoint": idpServer.URL + "/authorize",
+			"token_endpoint":         idpServer.URL + "/token",
+			"jwks_uri":               idpServer.URL + "/jwks",
+		})
+	})
+	idp.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(idpKeys.JWKS())
+	})
+	idp.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "zpe" || secret != "idp-secret" || r.PostFormValue("code") != "upstream-code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		tok, _ := idpKeys.Sign(identity)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": tok, "token_type": "Bearer"})
+	})
+
+	if err := SetFederationProviders([]FederationProvider{{ID: "corp", Issuer: idpServer.URL, ClientID: "zpe", RedirectURL: "http://localhost/cb", DefaultRole: "Emperor"}}, nil); err == nil {
+		t.Error("expected an unknown default role to be rejected")
+	}
+	err = SetFederationProviders([]FederationProvider{{
+		ID:           "corp",
+		Name:         "Corp SSO",
+		Issuer:       idpServer.URL,
+		ClientID:     "zpe",
+		ClientSecret: "idp-secret",
+		RedirectURL:  "http://localhost:8080/federation/corp/callback",
+		Scopes:       []string{"email", "profile", "groups"},
+		RoleMappings: []RoleMapping{
+			{Claim: "groups", Value: "engineering", Role: "Modifier"},
+			{Claim: "groups", Value: "zpe-admins", Role: "Admin"},
+		},
+		JIT:       true,
+		SyncRoles: true,
+	}}, idpServer.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetFederationProviders(nil, nil)
+
+	rr := doRequest(t, "GET", "/federation", nil, "")
+	var providers []FederationProviderInfo
+	json.NewDecoder(rr.Body).Decode(&providers)
+	if rr.Code != http.StatusOK || len(providers) != 1 || providers[0].Name != "Corp SSO" || providers[0].OrgID != DefaultOrgID {
+		t.Fatalf("unexpected providers: %v %+v", rr.Code, providers)
+	}
+
+	// startLogin begins a login and returns the state and the cookie binding it to the browser.
+	startLogin := func(query string) (string, *http.Cookie) {
+		t.Helper()
+		rr := doRequest(t, "GET", "/federation/corp/login"+query, nil, "")
+		if rr.Code != http.StatusFound {
+			t.Fatalf("expected a redirect to the identity provider, got %v: %s", rr.Code, rr.Body.String())
+		}
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		params := location.Query()
+		if location.Path != "/authorize" || params.Get("client_id") != "zpe" || params.Get("code_challenge_method") != "S256" {
+			t.Fatalf("unexpected redirect %s", location)
+		}
+		identity["nonce"] = params.Get("nonce")
+		return params.Get("state"), rr.Result().Cookies()[0]
+	}
+	callback := func(state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		var headers map[string]string
+		if cookie != nil {
+			headers = map[string]string{"Cookie": cookie.Name + "=" + cookie.Value}
+		}
+		return doRequest(t, "GET", "/federation/corp/callback?code=upstream-code&state="+url.QueryEscape(state), headers, "")
+	}
+	login := func(claims map[string]any) *httptest.ResponseRecorder {
+		identity = map[string]any{"iss": idpServer.URL, "aud": "zpe", "exp": time.Now().Add(time.Minute).Unix()}
+		for k, v := range claims {
+			identity[k] = v
+		}
+		return callback(startLogin(""))
+	}
+
+	// The first login provisions the user with the mapped roles.
+	rr = login(map[string]any{"sub": "rey", "email": "Rey@Example.com", "email_verified": true, "name": "Rey", "groups": []string{"engineering"}})
+	var auth Authentication
+	json.NewDecoder(rr.Body).Decode(&auth)
+	if rr.Code != http.StatusOK || auth.Role != "Modifier" || !reflect.DeepEqual(auth.Methods, []string{"fed"}) {
+		t.Fatalf("expected the new user to log in as Modifier, got %v: %s", rr.Code, rr.Body.String())
+	}
+	rey, err := GetUser(DefaultOrgID, auth.UserID)
+	if err != nil || rey.Name != "Rey" || rey.Email != "Rey@example.com" || !rey.EmailVerified || rey.CreatedBy != "system" {
+		t.Fatalf("unexpected provisioned user %+v: %v", rey, err)
+	}
+	if events := audit.Events(AuditFederatedUserProvisioned); len(events) != 1 || events[0].Target != rey.ID || events[0].Details["provider"] != "corp" {
+		t.Errorf("expected one provisioning audit event, got %+v", events)
+	}
+
+	// Later logins find the linked user, even with another address, and sync the roles.
+	rr = login(map[string]any{"sub": "rey", "email": "rey.skywalker@example.com", "groups": []string{"engineering", "zpe-admins"}})
+	json.NewDecoder(rr.Body).Decode(&auth)
+	if rr.Code != http.StatusOK || auth.UserID != rey.ID || auth.Role != "Admin" {
+		t.Fatalf("expected the linked user to log in as Admin, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rey, _ = GetUser(DefaultOrgID, rey.ID); !reflect.DeepEqual(rey.Roles, []string{"Admin", "Modifier"}) {
+		t.Errorf("expected the roles to be synced, got %v", rey.Roles)
+	}
+
+	// An existing user is never linked by email address, even a verified one, only by an admin.
+	rr = login(map[string]any{"sub": "leia", "email": "leia@example.com", "email_verified": true, "groups": []string{"zpe-admins"}})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected a verified address of an existing user to conflict, got %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = login(map[string]any{"sub": "mallory", "email": "obi-wan@example.com", "email_verified": false, "groups": []string{"zpe-admins"}})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected an unverified address of an existing user to conflict, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, "POST", "/users/1/federated-identities", callerHeaders("Modifier", "2"), `{"provider_id":"corp","subject":"leia"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a Modifier to be unable to link identities, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", "/users/1/federated-identities", callerHeaders("Admin", "6"), `{"provider_id":"corp","subject":"rey"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected an identity linked to another user to conflict, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, "POST", "/users/1/federated-identities", callerHeaders("Admin", "6"), `{"provider_id":"corp","subject":"leia"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("expected the identity to be linked, got %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = login(map[string]any{"sub": "leia", "email": "leia@example.com", "groups": []string{"zpe-admins"}})
+	if json.NewDecoder(rr.Body).Decode(&auth); rr.Code != http.StatusOK || auth.UserID != "1" {
+		t.Errorf("expected Leia to log in through the linked identity, got %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = doRequest(t, "GET", "/users/1/federated-identities", callerHeaders("Admin", "6"), "")
+	var identities []FederatedIdentity
+	if json.NewDecoder(rr.Body).Decode(&identities); rr.Code != http.StatusOK || !reflect.DeepEqual(identities, []FederatedIdentity{{ProviderID: "corp", Subject: "leia"}}) {
+		t.Errorf("unexpected linked identities %v: %+v", rr.Code, identities)
+	}
+
+	// Users the MFA policy covers need MFA at the provider.
+	if err := SetMFAPolicy(MFAPolicy{RequiredRoles: []string{"Admin"}, Issuer: "Test"}); err != nil {
+		t.Fatal(err)
+	}
+	rr = login(map[string]any{"sub": "leia", "groups": []string{"zpe-admins"}})
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected a login without upstream MFA to be refused, got %v: %s", rr.Code, rr.Body.String())
+	}
+	rr = login(map[string]any{"sub": "leia", "groups": []string{"zpe-admins"}, "amr": []string{"pwd", "otp"}})
+	if json.NewDecoder(rr.Body).Decode(&auth); rr.Code != http.StatusOK || !reflect.DeepEqual(auth.Methods, []string{"pwd", "otp"}) {
+		t.Errorf("expected a login with upstream MFA to succeed, got %v: %s", rr.Code, rr.Body.String())
+	}
+	SetMFAPolicy(MFAPolicy{Issuer: "ZPE Cloud"})
+
+	if rr := doRequest(t, "DELETE", "/users/1/federated-identities/corp", callerHeaders("Admin", "6"), ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected the identity to be unlinked, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, "DELETE", "/users/1/federated-identities/corp", callerHeaders("Admin", "6"), ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected nothing left to unlink, got %v", rr.Code)
+	}
+
+	rr = login(map[string]any{"sub": "lando", "email": "lando@example.com", "groups": []string{"contractors"}})
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected an identity without mapped roles to be refused, got %v", rr.Code)
+	}
+
+	identity = map[string]any{"iss": idpServer.URL, "aud": "zpe", "exp": time.Now().Add(time.Minute).Unix(), "sub": "rey", "groups": []string{"engineering", "zpe-admins"}}
+	state, cookie := startLogin("")
+	if rr = callback(state, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a callback without the state cookie to be rejected, got %v", rr.Code)
+	}
+	if rr = callback(state, cookie); rr.Code != http.StatusOK {
+		t.Errorf("expected the callback to succeed, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr = callback(state, cookie); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a replayed state to be rejected, got %v", rr.Code)
+	}
+
+	// A login started from the authorization endpoint returns to the OpenID Connect client.
+	keys, err := jwt.NewKeySet(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const redirectURI = "http://localhost:9999/callback"
+	SetOIDCProvider(OIDCConfig{Issuer: "http://localhost:8080", Clients: []OIDCClient{{ID: "portal", RedirectURIs: []string{redirectURI}}}, TokenTTL: time.Hour}, keys)
+	defer SetOIDCProvider(OIDCConfig{}, nil)
+
+	authorize := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"portal"},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid"},
+		"state":                 {"portal-state"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+	}
+	rr = doRequest(t, "GET", "/oauth2/authorize?"+authorize.Encode(), nil, "")
+	if !strings.Contains(rr.Body.String(), "Sign in with Corp SSO") {
+		t.Errorf("expected the login page to offer the provider, got %s", rr.Body.String())
+	}
+	rr = callback(startLogin("?" + authorize.Encode()))
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	if rr.Code != http.StatusFound || !strings.HasPrefix(location.String(), redirectURI) || location.Query().Get("code") == "" || location.Query().Get("state") != "portal-state" {
+		t.Errorf("expected a redirect to the client with a code, got %v %s", rr.Code, location)
+	}
+}
@@ -0,0 +1,342 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"zpe-cloud-user-management-service/internal/ids"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// Group is a named set of users. Members inherit every role attached to the group.
+type Group struct {
+	ID          string    `json:"id"`
+	OrgID       string    `json:"org_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Roles       []string  `json:"roles"`
+	Members     []string  `json:"members"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	CreatedBy   string    `json:"created_by"`
+	UpdatedBy   string    `json:"updated_by"`
+
+	// externalID is the identifier an identity provider assigned to the group over SCIM.
+	externalID string
+}
+
+// RoleSource explains where an effective role comes from.
+type RoleSource struct {
+	Type       string     `json:"type"`
+	GroupID    string     `json:"group_id,omitempty"`
+	GroupName  string     `json:"group_name,omitempty"`
+	GrantID    string     `json:"grant_id,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+// Role source types.
+const (
+	RoleSourceDirect = "direct"
+	RoleSourceGroup  = "group"
+	RoleSourceGrant  = "grant"
+)
+
+// EffectiveRole is a role held by a user together with every source that grants it.
+type EffectiveRole struct {
+	Role    string       `json:"role"`
+	Sources []RoleSource `json:"sources"`
+}
+
+// groups and groupIDGenerator are guarded by mu, like the user storage, so that
+// effective roles are always computed from a consistent view.
+var (
+	groups                         = make(map[string]*Group)
+	groupIDGenerator ids.Generator = ids.NewSequential()
+)
+
+// ValidateRequiredFields checks that the group has a name and only known roles.
+func (g *Group) ValidateRequiredFields() error {
+	if strings.TrimSpace(g.Name) == "" {
+		return errors.New("fields required: name")
+	}
+	for _, role := range g.Roles {
+		if !isRoleExists(role) {
+			return fmt.Errorf("%w: %s", internalErrors.ErrInvalidRole, role)
+		}
+	}
+	return nil
+}
+
+func (g *Group) clone() *Group {
+	c := *g
+	c.Roles = append([]string{}, g.Roles...)
+	c.Members = append([]string{}, g.Members...)
+	return &c
+}
+
+func (g *Group) hasMember(userID string) bool {
+	return containsString(g.Members, userID)
+}
+
+// lookupGroup returns the stored group with the given ID if it belongs to orgID.
+// The caller must hold mu.
+func lookupGroup(orgID, id string) (*Group, bool) {
+	group, exists := groups[id]
+	if !exists || group.OrgID != orgID {
+		return nil, false
+	}
+	return group, true
+}
+
+// groupNameTaken reports whether another group of the organization already uses name.
+// The caller must hold mu.
+func groupNameTaken(orgID, name, exceptID string) bool {
+	for _, g := range groups {
+		if g.OrgID == orgID && g.ID != exceptID && strings.EqualFold(g.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateGroup stores a new group in the organization named by group.OrgID.
+func CreateGroup(group *Group, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := orgs[group.OrgID]; !exists {
+		return internalErrors.ErrOrganizationNotFound
+	}
+	if groupNameTaken(group.OrgID, group.Name, "") {
+		return internalErrors.ErrGroupAlreadyExists
+	}
+
+	now := time.Now().UTC()
+	group.ID = groupIDGenerator.NewID()
+	group.Members = []string{}
+	if group.Roles == nil {
+		group.Roles = []string{}
+	}
+	group.CreatedAt, group.UpdatedAt = now, now
+	group.CreatedBy, group.UpdatedBy = actor, actor
+	groups[group.ID] = group
+	return nil
+}
+
+func GetGroup(orgID, id string) (*Group, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	group, exists := lookupGroup(orgID, id)
+	if !exists {
+		return nil, internalErrors.ErrGroupNotFound
+	}
+	return group.clone(), nil
+}
+
+func ListGroups(orgID string) []*Group {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]*Group, 0, len(groups))
+	for _, group := range groups {
+		if group.OrgID == orgID {
+			list = append(list, group.clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// UpdateGroup replaces the name, description and roles of a group and returns the updated snapshot.
+// Roles that need approval can only be added while the group has no members, since
+// members would get them without one.
+func UpdateGroup(orgID, id, name, description string, roles []string, actor string) (*Group, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	group, exists := lookupGroup(orgID, id)
+	if !exists {
+		return nil, internalErrors.ErrGroupNotFound
+	}
+	if groupNameTaken(orgID, name, id) {
+		return nil, internalErrors.ErrGroupAlreadyExists
+	}
+	if len(group.Members) > 0 && requiresApproval(group.Roles, roles) {
+		return nil, internalErrors.ErrGroupRolesNeedApproval
+	}
+
+	group.Name = name
+	group.Description = description
+	group.Roles = append([]string{}, roles...)
+	group.UpdatedAt = time.Now().UTC()
+	group.UpdatedBy = actor
+	return group.clone(), nil
+}
+
+func DeleteGroup(orgID, id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := lookupGroup(orgID, id); !exists {
+		return internalErrors.ErrGroupNotFound
+	}
+	delete(groups, id)
+	return nil
+}
+
+// AddGroupMember adds a user to a group. Adding an existing member is a no-op. It fails
+// with ErrApprovalRequired if the group would give the user a role that needs approval.
+func AddGroupMember(orgID, groupID, userID, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	group, exists := lookupGroup(orgID, groupID)
+	if !exists {
+		return internalErrors.ErrGroupNotFound
+	}
+	user, exists := lookupUser(orgID, userID)
+	if !exists || user.IsDeleted() {
+		return internalErrors.ErrUserNotFound
+	}
+	if group.hasMember(userID) {
+		return nil
+	}
+	if requiresApproval(user.Roles, group.Roles) {
+		return internalErrors.ErrApprovalRequired
+	}
+	addGroupMember(group, userID, actor)
+	return nil
+}
+
+// joinGroup adds a user to a group for an approved request. It fails with
+// ErrPreconditionFailed if the user changed since ifMatch or the group no longer has
+// exactly roles. The caller must hold mu.
+func joinGroup(orgID, groupID, userID string, roles []string, actor string, ifMatch []int64) error {
+	group, exists := lookupGroup(orgID, groupID)
+	if !exists {
+		return internalErrors.ErrGroupNotFound
+	}
+	user, exists := lookupUser(orgID, userID)
+	if !exists || user.IsDeleted() {
+		return internalErrors.ErrUserNotFound
+	}
+	if err := checkVersion(user, ifMatch); err != nil {
+		return err
+	}
+	if !sameRoles(group.Roles, roles) {
+		return internalErrors.ErrPreconditionFailed
+	}
+	if !group.hasMember(userID) {
+		addGroupMember(group, userID, actor)
+	}
+	return nil
+}
+
+// addGroupMember appends userID to the members of group. The caller must hold mu.
+func addGroupMember(group *Group, userID, actor string) {
+	group.Members = append(group.Members, userID)
+	sort.Strings(group.Members)
+	group.UpdatedAt = time.Now().UTC()
+	group.UpdatedBy = actor
+}
+
+func RemoveGroupMember(orgID, groupID, userID, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	group, exists := lookupGroup(orgID, groupID)
+	if !exists {
+		return internalErrors.ErrGroupNotFound
+	}
+	if !group.hasMember(userID) {
+		return internalErrors.ErrGroupMemberNotFound
+	}
+
+	members := group.Members[:0]
+	for _, member := range group.Members {
+		if member != userID {
+			members = append(members, member)
+		}
+	}
+	group.Members = members
+	group.UpdatedAt = time.Now().UTC()
+	group.UpdatedBy = actor
+	return nil
+}
+
+// removeFromAllGroups drops a purged user from every group. The caller must hold mu.
+func removeFromAllGroups(userID string) {
+	for _, group := range groups {
+		if !group.hasMember(userID) {
+			continue
+		}
+		members := group.Members[:0]
+		for _, member := range group.Members {
+			if member != userID {
+				members = append(members, member)
+			}
+		}
+		group.Members = members
+	}
+}
+
+// GetEffectiveRoles returns the roles a user holds directly, through active time-bound
+// grants or through group membership, each with the sources that grant it, ordered from
+// the highest-ranked role down.
+func GetEffectiveRoles(orgID, userID string) ([]EffectiveRole, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, userID)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	return effectiveRoles(user), nil
+}
+
+// effectiveRoles computes the effective roles of user. The caller must hold mu.
+func effectiveRoles(user *User) []EffectiveRole {
+	sources := make(map[string][]RoleSource)
+	for _, role := range user.Roles {
+		sources[role] = append(sources[role], RoleSource{Type: RoleSourceDirect})
+	}
+
+	now := time.Now()
+	for _, grant := range user.RoleGrants {
+		if grant.ActiveAt(now) {
+			sources[grant.Role] = append(sources[grant.Role], RoleSource{Type: RoleSourceGrant, GrantID: grant.ID, ValidUntil: grant.ValidUntil})
+		}
+	}
+
+	memberOf := make([]*Group, 0)
+	for _, group := range groups {
+		if group.OrgID == user.OrgID && group.hasMember(user.ID) {
+			memberOf = append(memberOf, group)
+		}
+	}
+	sort.Slice(memberOf, func(i, j int) bool {
+		return memberOf[i].Name < memberOf[j].Name
+	})
+	for _, group := range memberOf {
+		for _, role := range group.Roles {
+			sources[role] = append(sources[role], RoleSource{Type: RoleSourceGroup, GroupID: group.ID, GroupName: group.Name})
+		}
+	}
+
+	result := make([]EffectiveRole, 0, len(sources))
+	for role, src := range sources {
+		result = append(result, EffectiveRole{Role: role, Sources: src})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if ri, rj := roleRank(result[i].Role), roleRank(result[j].Role); ri != rj {
+			return ri > rj
+		}
+		return result[i].Role < result[j].Role
+	})
+	return result
+}
@@ -0,0 +1,322 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+type GroupRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Roles       []string `json:"roles"`
+}
+
+type GroupMemberRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// HandleGroups handles HTTP requests for the /groups endpoint.
+func HandleGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListGroups(w, r)
+	case http.MethodPost:
+		HandleCreateGroup(w, r)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+// HandleGroup handles HTTP requests for /groups/{id}, /groups/{id}/members
+// and /groups/{id}/members/{userID}.
+func HandleGroup(w http.ResponseWriter, r *http.Request) {
+	_, sub, memberID := splitGroupPath(r.URL.Path)
+	switch {
+	case sub == "":
+		switch r.Method {
+		case http.MethodGet:
+			HandleGetGroup(w, r)
+		case http.MethodPut:
+			HandleUpdateGroup(w, r)
+		case http.MethodDelete:
+			HandleDeleteGroup(w, r)
+		default:
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+		}
+	case sub == "members" && memberID == "":
+		switch r.Method {
+		case http.MethodGet:
+			HandleGetGroup(w, r)
+		case http.MethodPost:
+			HandleAddGroupMember(w, r)
+		default:
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+		}
+	case sub == "members":
+		if r.Method != http.MethodDelete {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleRemoveGroupMember(w, r)
+	default:
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+	}
+}
+
+func HandleListGroups(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isRoleExists(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list groups", currentUserRole)
+		return
+	}
+
+	list := ListGroups(orgID)
+	jsonResponse(w, http.StatusOK, list)
+	log.Printf("Groups listed: %d groups", len(list))
+}
+
+func HandleGetGroup(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isRoleExists(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to get a group", currentUserRole)
+		return
+	}
+
+	id, sub, _ := splitGroupPath(r.URL.Path)
+	group, err := GetGroup(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Group %s", id)
+		return
+	}
+
+	if sub == "members" {
+		jsonResponse(w, http.StatusOK, group.Members)
+		return
+	}
+	jsonResponse(w, http.StatusOK, group)
+}
+
+func HandleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to create a group", currentUserRole)
+		return
+	}
+
+	var req GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	group := &Group{OrgID: orgID, Name: strings.TrimSpace(req.Name), Description: req.Description, Roles: req.Roles}
+	if err := group.ValidateRequiredFields(); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	if !checkPolicy(w, r, orgID, "groups.create", "", nil, map[string]any{"roles": group.Roles}) {
+		return
+	}
+
+	if err := CreateGroup(group, currentActor(r)); err != nil {
+		if errors.Is(err, internalMsgs.ErrOrganizationNotFound) {
+			errResponse(w, http.StatusNotFound, err)
+		} else {
+			errResponse(w, http.StatusConflict, err)
+		}
+		log.Printf("Group creation failed: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, map[string]string{
+		"id":      group.ID,
+		"message": "Group created successfully",
+	})
+	log.Printf("Group created: %s", group.Name)
+}
+
+func HandleUpdateGroup(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to update a group", currentUserRole)
+		return
+	}
+
+	id, _, _ := splitGroupPath(r.URL.Path)
+	var req GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	candidate := &Group{Name: strings.TrimSpace(req.Name), Roles: req.Roles}
+	if err := candidate.ValidateRequiredFields(); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	if !checkPolicy(w, r, orgID, "groups.update", "", nil, map[string]any{"group_id": id, "roles": candidate.Roles}) {
+		return
+	}
+
+	group, err := UpdateGroup(orgID, id, candidate.Name, req.Description, req.Roles, currentActor(r))
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrGroupAlreadyExists) || errors.Is(err, internalMsgs.ErrGroupRolesNeedApproval) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("Group update failed: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, group)
+	log.Printf("Group updated: %s", id)
+}
+
+func HandleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to delete a group", currentUserRole)
+		return
+	}
+
+	id, _, _ := splitGroupPath(r.URL.Path)
+	if !checkPolicy(w, r, orgID, "groups.delete", "", nil, map[string]any{"group_id": id}) {
+		return
+	}
+	if err := DeleteGroup(orgID, id); err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Group %s", id)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("UserType=%s deleted group %s", currentUserRole, id)
+}
+
+func HandleAddGroupMember(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	groupID, _, _ := splitGroupPath(r.URL.Path)
+
+	var req GroupMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	group, err := GetGroup(orgID, groupID)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Group %s", groupID)
+		return
+	}
+
+	// Joining a group grants its roles, so the caller must be allowed to assign all of them.
+	if err := isValidRoleUpdate(group.Roles, currentUserRole); err != nil {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrInsufficientPermissions)
+		log.Printf("Forbidden: %v", err)
+		return
+	}
+
+	targetUserRole, err := getUserTypeByID(orgID, req.UserID)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", req.UserID)
+		return
+	}
+	if !checkUserPermission(w, r, orgID, "groups.add_member", req.UserID, currentUserRole, targetUserRole) {
+		return
+	}
+
+	err = AddGroupMember(orgID, groupID, req.UserID, currentActor(r))
+	if errors.Is(err, internalMsgs.ErrApprovalRequired) {
+		pending, err := CreateGroupMembershipRequest(orgID, groupID, req.UserID, currentActor(r))
+		if err != nil {
+			errResponse(w, http.StatusNotFound, err)
+			log.Printf("NotFound: %v", err)
+			return
+		}
+		roleChangeAccepted(w, pending)
+		return
+	}
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]string{"message": "Group member added successfully"})
+	log.Printf("User %s added to group %s", req.UserID, groupID)
+}
+
+func HandleRemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	groupID, _, userID := splitGroupPath(r.URL.Path)
+
+	targetUserRole, err := getUserTypeByID(orgID, userID)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", userID)
+		return
+	}
+	if !checkUserPermission(w, r, orgID, "groups.remove_member", userID, currentUserRole, targetUserRole) {
+		return
+	}
+
+	if err := RemoveGroupMember(orgID, groupID, userID, currentActor(r)); err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("User %s removed from group %s", userID, groupID)
+}
@@ -0,0 +1,52 @@
+package user
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGroupsAndEffectiveRoles(t *testing.T) {
+	setupTestStorageWithUsers()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		userType       string
+		body           string
+		expectedStatus int
+	}{
+		{name: "Modifier cannot create group", method: "POST", path: "/groups", userType: "Modifier", body: `{"name":"Ops","roles":["Modifier"]}`, expectedStatus: http.StatusForbidden},
+		{name: "Admin can create group", method: "POST", path: "/groups", userType: "Admin", body: `{"name":"Ops","roles":["Modifier"]}`, expectedStatus: http.StatusCreated},
+		{name: "Group names are unique", method: "POST", path: "/groups", userType: "Admin", body: `{"name":"ops"}`, expectedStatus: http.StatusConflict},
+		{name: "Group roles must exist", method: "POST", path: "/groups", userType: "Admin", body: `{"name":"Jedi","roles":["Master"]}`, expectedStatus: http.StatusBadRequest},
+		{name: "Modifier cannot add member to Modifier group", method: "POST", path: "/groups/1/members", userType: "Modifier", body: `{"user_id":"3"}`, expectedStatus: http.StatusForbidden},
+		{name: "Admin can add member", method: "POST", path: "/groups/1/members", userType: "Admin", body: `{"user_id":"3"}`, expectedStatus: http.StatusOK},
+		{name: "Add unknown user", method: "POST", path: "/groups/1/members", userType: "Admin", body: `{"user_id":"999"}`, expectedStatus: http.StatusNotFound},
+		{name: "Modifier cannot delete user with inherited Modifier role", method: "DELETE", path: "/users/3", userType: "Modifier", expectedStatus: http.StatusForbidden},
+		{name: "Any role can read group", method: "GET", path: "/groups/1", userType: "Watcher", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, tt.method, tt.path, callerHeaders(tt.userType, ""), tt.body)
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", status, tt.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+
+	rr := doRequest(t, "GET", "/users/3/effective-roles", callerHeaders("Watcher", ""), "")
+	expected := `[{"role":"Modifier","sources":[{"type":"group","group_id":"1","group_name":"Ops"}]},{"role":"Watcher","sources":[{"type":"direct"}]}]`
+	if body := strings.TrimSpace(rr.Body.String()); body != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", body, expected)
+	}
+
+	if rr := doRequest(t, "DELETE", "/groups/1/members/3", callerHeaders("Admin", ""), ""); rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if role, _ := getUserTypeByID(DefaultOrgID, "3"); role != "Watcher" {
+		t.Errorf("expected effective role to drop back to Watcher, got %s", role)
+	}
+}
@@ -2,6 +2,7 @@ package user
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -12,6 +13,10 @@ type RoleUpdateRequest struct {
 	Roles []string `json:"roles"`
 }
 
+type AttributesUpdateRequest struct {
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
 // HandleUsers handles HTTP requests for the /users endpoint.
 func HandleUsers(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -25,8 +30,85 @@ func HandleUsers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// HandleUser handles HTTP requests for individual user operations at /users/{id}.
+// HandleUser handles HTTP requests for individual user operations at /users/{id}
+// and its sub-resources such as /users/{id}/restore.
 func HandleUser(w http.ResponseWriter, r *http.Request) {
+	_, action := splitUserPath(r.URL.Path)
+	switch action {
+	case "":
+	case "restore":
+		if r.Method != http.MethodPost {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleRestoreUser(w, r)
+		return
+	case "effective-roles":
+		if r.Method != http.MethodGet {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleGetEffectiveRoles(w, r)
+		return
+	case "attributes":
+		if r.Method != http.MethodPut {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleUpdateUserAttributes(w, r)
+		return
+	case "role-grants":
+		HandleUserRoleGrants(w, r)
+		return
+	case "email":
+		if r.Method != http.MethodPut {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleUpdateUserEmail(w, r)
+		return
+	case "verification":
+		if r.Method != http.MethodPost {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleRequestEmailVerification(w, r)
+		return
+	default:
+		if strings.HasPrefix(action, "role-grants/") {
+			HandleUserRoleGrant(w, r)
+			return
+		}
+		if action == "mfa" || strings.HasPrefix(action, "mfa/") {
+			HandleUserMFA(w, r)
+			return
+		}
+		if action == "sessions" || strings.HasPrefix(action, "sessions/") {
+			HandleUserSessions(w, r)
+			return
+		}
+		if action == "lockout" {
+			HandleUserLockout(w, r)
+			return
+		}
+		if action == "impersonation" {
+			HandleImpersonateUser(w, r)
+			return
+		}
+		if action == "federated-identities" || strings.HasPrefix(action, "federated-identities/") {
+			HandleUserFederatedIdentities(w, r)
+			return
+		}
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		HandleGetUser(w, r)
@@ -51,6 +133,10 @@ func HandleUserRoles(w http.ResponseWriter, r *http.Request) {
 
 func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
@@ -58,38 +144,105 @@ func HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user.OrgID = orgID
+	user.Email = NormalizeEmail(user.Email)
+	user.Status = UserStatusActive
+	user.EmailVerified = false
+	if InvitationsEnabled() {
+		user.Status = UserStatusInvited
+	}
 	if err := user.ValidateRequiredFields(); err != nil {
 		errResponse(w, http.StatusBadRequest, err)
 		log.Printf("BadRequest: %v", err)
 		return
 	}
 
-	if !isValidCrudOperation(currentUserRole, user.Roles[0]) {
+	if err := user.ValidateAttributes(); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	if err := isValidRoleUpdate(user.Roles, currentUserRole); err != nil {
 		errResponse(w, http.StatusForbidden, internalMsgs.ErrInsufficientPermissions)
-		log.Printf("Forbidden: UserType=%s attempted to create a user", currentUserRole)
+		log.Printf("Forbidden: UserType=%s attempted to create a user: %v", currentUserRole, err)
+		return
+	}
+	if !checkPolicy(w, r, orgID, "users.create", "", &user, nil) {
 		return
 	}
-	if err := CreateUser(&user); err != nil {
-		errResponse(w, http.StatusConflict, internalMsgs.ErrUserAlreadyExists)
+	// Roles that need approval are requested; until then the user only has the lowest role.
+	var pendingRoles []string
+	if requiresApproval(nil, user.Roles) {
+		pendingRoles, user.Roles = user.Roles, []string{lowestRole()}
+	}
+	if err := CreateUser(&user, currentActor(r)); err != nil {
+		if errors.Is(err, internalMsgs.ErrOrganizationNotFound) {
+			errResponse(w, http.StatusNotFound, err)
+		} else if errors.Is(err, internalMsgs.ErrAttributeNotUnique) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusConflict, internalMsgs.ErrUserAlreadyExists)
+		}
 		log.Printf("Conflict: %v", err)
 		return
 	}
 
-	jsonResponse(w, http.StatusCreated, map[string]string{
-		"id":      user.ID,
-		"message": "User created successfully",
-	})
+	resp := map[string]string{"id": user.ID}
+	if pendingRoles != nil {
+		if reqID := requestPendingRoles(orgID, user.ID, pendingRoles, currentActor(r)); reqID != "" {
+			resp["role_change_request_id"] = reqID
+		}
+	}
+
+	if user.Status == UserStatusInvited {
+		inv, err := inviteUser(orgID, user.ID, currentActor(r))
+		if err != nil {
+			errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+			log.Printf("Failed to invite user %s: %v", user.ID, err)
+			return
+		}
+		resp["invitation_id"] = inv.ID
+		resp["message"] = "User invited successfully"
+		w.Header().Set("ETag", etag(user.Version))
+		jsonResponse(w, http.StatusCreated, resp)
+		log.Printf("User invited: %v", user)
+		return
+	}
+
+	verifyEmail(orgID, user.ID)
+
+	resp["message"] = "User created successfully"
+	w.Header().Set("ETag", etag(user.Version))
+	jsonResponse(w, http.StatusCreated, resp)
 	log.Printf("User created: %v", user)
 }
 
 func HandleListUsers(w http.ResponseWriter, r *http.Request) {
 	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
 	if !isRoleExists(currentUserRole) {
 		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
 		log.Printf("Forbidden: UserType=%s attempted to list users", currentUserRole)
 		return
 	}
-	users, err := ListUsers()
+
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidQueryParameter)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	if opts.IncludeDeleted && !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list deleted users", currentUserRole)
+		return
+	}
+
+	users, err := ListUsers(orgID, opts)
 	if err != nil {
 		errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
 		log.Printf("InternalServerError: %v", err)
@@ -102,6 +255,10 @@ func HandleListUsers(w http.ResponseWriter, r *http.Request) {
 
 func HandleGetUser(w http.ResponseWriter, r *http.Request) {
 	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
 	if !isRoleExists(currentUserRole) {
 		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
 		log.Printf("Forbidden: UserType=%s attempted to get a user", currentUserRole)
@@ -113,9 +270,9 @@ func HandleGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := GetUser(id)
+	user, err := GetUser(orgID, id)
 	if err != nil {
-		users, err := ListUsers()
+		users, err := ListUsers(orgID, ListOptions{})
 		if err != nil {
 			jsonResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
 			log.Printf("InternalServerError: %v", err)
@@ -132,25 +289,45 @@ func HandleGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("ETag", etag(user.Version))
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatchesNone(inm, user.Version) {
+		w.WriteHeader(http.StatusNotModified)
+		log.Printf("User not modified: %s", id)
+		return
+	}
+
 	jsonResponse(w, http.StatusOK, []*User{user})
 	log.Printf("User retrieved: %v", *user)
 }
 
 func HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
 	id := strings.TrimPrefix(r.URL.Path, "/users/")
-	targetUserRole, err := getUserTypeByID(id)
+	targetUserRole, err := getUserTypeByID(orgID, id)
 	if err != nil {
 		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
 		log.Printf("User not found: %s", id)
 		return
 	}
 
-	if !checkPermission(w, currentUserRole, targetUserRole) {
+	if !checkUserPermission(w, r, orgID, "users.delete", id, currentUserRole, targetUserRole) {
 		return
 	}
 
-	if err := DeleteUser(id); err != nil {
+	ifMatch, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err == nil {
+		err = DeleteUser(orgID, id, currentActor(r), ifMatch)
+	}
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrPreconditionFailed) {
+			errResponse(w, http.StatusPreconditionFailed, err)
+			log.Printf("PreconditionFailed: User %s", id)
+			return
+		}
 		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
 		log.Printf("NotFound: User %s", id)
 		return
@@ -160,8 +337,139 @@ func HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	log.Printf("UserType=%s deleted user %s", currentUserRole, id)
 }
 
+func HandleRestoreUser(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to restore user %s", currentUserRole, id)
+		return
+	}
+	targetUserRole, err := getDeletedUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+	if !checkUserPermission(w, r, orgID, "users.restore", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	ifMatch, err := parseIfMatch(r.Header.Get("If-Match"))
+	var user *User
+	if err == nil {
+		user, err = RestoreUser(orgID, id, currentActor(r), ifMatch)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, internalMsgs.ErrPreconditionFailed):
+			errResponse(w, http.StatusPreconditionFailed, err)
+			log.Printf("PreconditionFailed: User %s", id)
+		case errors.Is(err, internalMsgs.ErrUserNotDeleted):
+			errResponse(w, http.StatusConflict, err)
+			log.Printf("Conflict: User %s is not deleted", id)
+		default:
+			errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+			log.Printf("NotFound: User %s", id)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	jsonResponse(w, http.StatusOK, map[string]string{"message": "User restored successfully"})
+	log.Printf("UserType=%s restored user %s", currentUserRole, id)
+}
+
+func HandleGetEffectiveRoles(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isRoleExists(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to get effective roles", currentUserRole)
+		return
+	}
+
+	id, _ := splitUserPath(r.URL.Path)
+	roles, err := GetEffectiveRoles(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("NotFound: User %s", id)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, roles)
+	log.Printf("Effective roles retrieved: %s", id)
+}
+
+func HandleUpdateUserAttributes(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+
+	if !checkUserPermission(w, r, orgID, "users.update_attributes", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	var req AttributesUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	if err := (&User{OrgID: orgID, Attributes: req.Attributes}).ValidateAttributes(); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	ifMatch, err := parseIfMatch(r.Header.Get("If-Match"))
+	var user *User
+	if err == nil {
+		user, err = UpdateUserAttributes(orgID, id, req.Attributes, currentActor(r), ifMatch)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, internalMsgs.ErrPreconditionFailed):
+			errResponse(w, http.StatusPreconditionFailed, err)
+			log.Printf("PreconditionFailed: User %s", id)
+		case errors.Is(err, internalMsgs.ErrAttributeNotUnique):
+			errResponse(w, http.StatusConflict, err)
+			log.Printf("Conflict: %v", err)
+		default:
+			errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+			log.Printf("NotFound: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	jsonResponse(w, http.StatusOK, map[string]string{"message": "User attributes updated successfully"})
+	log.Printf("User attributes updated: %s", id)
+}
+
 func HandleUpdateUserRoles(w http.ResponseWriter, r *http.Request) {
 	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
 	id := strings.TrimPrefix(r.URL.Path, "/users/roles/")
 
 	var req RoleUpdateRequest
@@ -176,13 +484,35 @@ func HandleUpdateUserRoles(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Forbidden: %v", err)
 		return
 	}
+	if !checkPolicy(w, r, orgID, "users.update_roles", id, nil, map[string]any{"roles": req.Roles}) {
+		return
+	}
 
-	if err := UpdateUserRoles(id, req.Roles); err != nil {
+	ifMatch, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		errResponse(w, http.StatusPreconditionFailed, internalMsgs.ErrPreconditionFailed)
+		log.Printf("PreconditionFailed: User %s", id)
+		return
+	}
+
+	if current, err := GetUser(orgID, id); err == nil && requiresApproval(current.Roles, req.Roles) {
+		requestRoleChange(w, r, orgID, id, req.Roles, ifMatch)
+		return
+	}
+
+	user, err := UpdateUserRoles(orgID, id, req.Roles, currentActor(r), ifMatch)
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrPreconditionFailed) {
+			errResponse(w, http.StatusPreconditionFailed, err)
+			log.Printf("PreconditionFailed: User %s", id)
+			return
+		}
 		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
 		log.Printf("NotFound: %v", err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(user.Version))
 	jsonResponse(w, http.StatusOK, map[string]string{"message": "User roles updated successfully"})
 	log.Printf("User roles updated: %s", id)
 }
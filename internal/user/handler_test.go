@@ -6,7 +6,10 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -18,17 +21,153 @@ func setupTestStorageWithUsers() {
 		{Name: "R2-D2", Email: "r2-d2@example.com", Roles: []string{"Watcher"}},
 		{Name: "Vegeta", Email: "vegeta@example.com", Roles: []string{"Modifier"}},
 		{Name: "Gohan", Email: "gohan@example.com", Roles: []string{"Watcher"}},
-		{Name: "Goku", Email: "goku@example", Roles: []string{"Admin"}},
+		{Name: "Goku", Email: "goku@example.com", Roles: []string{"Admin"}},
 	}
 
 	for i, user := range users {
-		if err := CreateUser(user); err != nil {
+		user.OrgID = DefaultOrgID
+		if err := CreateUser(user, "Admin"); err != nil {
 			log.Fatalf("Failed to create user%d: %v", i+1, err)
 		}
 	}
 }
 
+var mailedTokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+// lastMailedToken returns the token of the most recent link written to outbox.
+func lastMailedToken(t *testing.T, outbox *bytes.Buffer) string {
+	t.Helper()
+	matches := mailedTokenPattern.FindAllStringSubmatch(outbox.String(), -1)
+	if len(matches) == 0 {
+		t.Fatalf("no link was mailed: %q", outbox.String())
+	}
+	tok, err := url.QueryUnescape(matches[len(matches)-1][1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+// stripMetadata removes store-maintained metadata from decoded user responses
+// so that bodies can be compared against fixed expectations.
+func stripMetadata(body interface{}) {
+	list, ok := body.([]interface{})
+	if !ok {
+		return
+	}
+	for _, item := range list {
+		if user, ok := item.(map[string]interface{}); ok {
+			for _, key := range []string{"org_id", "status", "email_verified", "created_at", "updated_at", "created_by", "updated_by", "version"} {
+				delete(user, key)
+			}
+		}
+	}
+}
+
+// testRemoteAddr is the client address of the requests made by doRequest.
+const testRemoteAddr = "192.0.2.10:51234"
+
+// remoteAddrHeader is not sent by doRequest; it sets the client address instead.
+const remoteAddrHeader = "Remote-Addr"
+
+// testHandler serves every route of the service behind AuthMiddleware, as the server
+// does.
+var testHandler = newTestHandler()
+
+func newTestHandler() http.Handler {
+	mux := http.NewServeMux()
+	for pattern, handler := range map[string]http.HandlerFunc{
+		"/users":                            HandleUsers,
+		"/users/":                           HandleUser,
+		"/users/roles/":                     HandleUserRoles,
+		"/orgs":                             HandleOrganizations,
+		"/orgs/":                            HandleOrganization,
+		"/role-grants/":                     HandleRoleGrants,
+		"/role-change-requests":             HandleRoleChangeRequests,
+		"/role-change-requests/":            HandleRoleChangeRequest,
+		"/invitations":                      HandleInvitations,
+		"/invitations/":                     HandleInvitation,
+		"/email-verification/":              HandleEmailVerification,
+		"/password-reset":                   HandlePasswordReset,
+		"/password-reset/":                  HandlePasswordReset,
+		"/login":                            HandleLogin,
+		"/.well-known/openid-configuration": HandleOIDCDiscovery,
+		"/oauth2/jwks":                      HandleJWKS,
+		"/oauth2/authorize":                 HandleAuthorize,
+		"/oauth2/token":                     HandleToken,
+		"/oauth2/userinfo":                  HandleUserInfo,
+		"/federation":                       HandleFederation,
+		"/federation/":                      HandleFederation,
+		"/service-accounts":                 HandleServiceAccounts,
+		"/service-accounts/":                HandleServiceAccount,
+		"/scim/v2/":                         HandleSCIM,
+		"/groups":                           HandleGroups,
+		"/groups/":                          HandleGroup,
+		"/attributes":                       HandleAttributes,
+		"/attributes/":                      HandleAttribute,
+		"/access-reviews":                   HandleAccessReviews,
+		"/access-reviews/":                  HandleAccessReview,
+		"/authz/check":                      HandleAuthzCheck,
+		"/ip-blocks":                        HandleIPBlocks,
+		"/ip-blocks/":                       HandleIPBlocks,
+	} {
+		mux.HandleFunc(pattern, handler)
+	}
+	return AuthMiddleware(mux)
+}
+
+// newTestRequest builds a request from testRemoteAddr with the given headers.
+func newTestRequest(method, path string, headers map[string]string, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = testRemoteAddr
+	for k, v := range headers {
+		if k == remoteAddrHeader {
+			req.RemoteAddr = v
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+	return req
+}
+
+// doRequest serves a request through testHandler and returns the response.
+func doRequest(t *testing.T, method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	testHandler.ServeHTTP(rr, newTestRequest(method, path, headers, body))
+	return rr
+}
+
+// callerHeaders identifies the caller of a request by role and, unless id is empty,
+// by user ID.
+func callerHeaders(role, id string) map[string]string {
+	headers := map[string]string{"X-User-Type": role}
+	if id != "" {
+		headers["X-User-ID"] = id
+	}
+	return headers
+}
+
+// sessionHeaders logs the user id in and returns the headers of a client that
+// authenticates with the session token.
+func sessionHeaders(t *testing.T, id string) map[string]string {
+	t.Helper()
+	_, tok, err := CreateSession(&Authentication{UserID: id, OrgID: DefaultOrgID, Methods: []string{"pwd"}}, testRemoteAddr, "test")
+	if err != nil {
+		t.Fatalf("failed to create session for user %s: %v", id, err)
+	}
+	return map[string]string{"Authorization": "Bearer " + tok}
+}
+
+// decodeResponse decodes a JSON object response, or returns nil.
+func decodeResponse(rr *httptest.ResponseRecorder) map[string]any {
+	var body map[string]any
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	return body
+}
+
 func TestHandleCreateUser(t *testing.T) {
+	InitializeStorage()
 
 	tests := []struct {
 		name           string
@@ -92,6 +231,39 @@ func TestHandleCreateUser(t *testing.T) {
 			expectedStatus: http.StatusConflict,
 			expectedBody:   `{"message":"user already exists"}`,
 		},
+		{
+			name:     "User creation fails if email differs only in case",
+			userType: "Admin",
+			payload: User{
+				Name:  "Yoda",
+				Email: " YODA@Example.COM ",
+				Roles: []string{"Watcher"},
+			},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"message":"user already exists"}`,
+		},
+		{
+			name:     "User creation fails if email has no top-level domain",
+			userType: "Admin",
+			payload: User{
+				Name:  "Goku",
+				Email: "goku@example",
+				Roles: []string{"Watcher"},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"message":"invalid email address: goku@example"}`,
+		},
+		{
+			name:     "User creation fails if email has a display name",
+			userType: "Admin",
+			payload: User{
+				Name:  "Han Solo",
+				Email: "Han Solo <solo@example.com>",
+				Roles: []string{"Watcher"},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"message":"invalid email address: Han Solo <solo@example.com>"}`,
+		},
 		{
 			name:     "Modifier cannot create user at same level",
 			userType: "Modifier",
@@ -215,7 +387,7 @@ func TestHandleGetUser(t *testing.T) {
                             {"id":"3","name":"R2-D2","email":"r2-d2@example.com","roles":["Watcher"]},
 							{"id":"4","name":"Vegeta","email":"vegeta@example.com","roles":["Modifier"]},
 							{"id":"5","name":"Gohan","email":"gohan@example.com","roles":["Watcher"]},
-							{"id":"6","name":"Goku","email":"goku@example","roles":["Admin"]}]`,
+							{"id":"6","name":"Goku","email":"goku@example.com","roles":["Admin"]}]`,
 		},
 		{
 			name:           "Get non-existent user with empty list",
@@ -253,6 +425,7 @@ func TestHandleGetUser(t *testing.T) {
 			if err := json.Unmarshal([]byte(tt.expectedBody), &expectedBody); err != nil {
 				t.Fatalf("Failed to unmarshal expected body: %v", err)
 			}
+			stripMetadata(actualBody)
 
 			if !reflect.DeepEqual(actualBody, expectedBody) {
 				t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), tt.expectedBody)
@@ -421,3 +594,200 @@ func TestHandleUpdateUserRoles(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleRestoreUser(t *testing.T) {
+	setupTestStorageWithUsers()
+	if err := DeleteUser(DefaultOrgID, "3", "Admin", nil); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	superAdmin := &User{OrgID: DefaultOrgID, Name: "Mace Windu", Email: "mace@example.com", Roles: []string{"SuperAdmin"}}
+	if err := CreateUser(superAdmin, "system"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := DeleteUser(DefaultOrgID, superAdmin.ID, "SuperAdmin", nil); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		userType       string
+		userID         string
+		expectedStatus int
+		expectedBody   map[string]string
+	}{
+		{
+			name:           "Modifier cannot restore user",
+			userType:       "Modifier",
+			userID:         "3",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]string{"message": "forbidden"},
+		},
+		{
+			name:           "Admin cannot restore a SuperAdmin",
+			userType:       "Admin",
+			userID:         superAdmin.ID,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]string{"message": "forbidden"},
+		},
+		{
+			name:           "SuperAdmin can restore a SuperAdmin",
+			userType:       "SuperAdmin",
+			userID:         superAdmin.ID,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "User restored successfully"},
+		},
+		{
+			name:           "Admin can restore deleted user",
+			userType:       "Admin",
+			userID:         "3",
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]string{"message": "User restored successfully"},
+		},
+		{
+			name:           "Restore user that is not deleted",
+			userType:       "Admin",
+			userID:         "3",
+			expectedStatus: http.StatusConflict,
+			expectedBody:   map[string]string{"message": "user is not deleted"},
+		},
+		{
+			name:           "Restore non-existent user",
+			userType:       "Admin",
+			userID:         "999",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]string{"message": "user not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/users/"+tt.userID+"/restore", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-User-Type", tt.userType)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(HandleUser)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+
+			var response map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response body: %v", err)
+			}
+
+			if !reflect.DeepEqual(response, tt.expectedBody) {
+				t.Errorf("handler returned unexpected message: got %v want %v", response, tt.expectedBody)
+			}
+		})
+	}
+}
+
+func TestUserMetadataIsMaintainedOnMutation(t *testing.T) {
+	setupTestStorageWithUsers()
+
+	user, err := GetUser(DefaultOrgID, "3")
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.Version != 1 || user.CreatedBy != "Admin" || user.CreatedAt.IsZero() || !user.UpdatedAt.Equal(user.CreatedAt) {
+		t.Fatalf("unexpected metadata after create: %+v", user)
+	}
+
+	user, err = UpdateUserRoles(DefaultOrgID, "3", []string{"Modifier"}, "Leia", nil)
+	if err != nil {
+		t.Fatalf("Failed to update roles: %v", err)
+	}
+	if user.Version != 2 || user.UpdatedBy != "Leia" || user.CreatedBy != "Admin" {
+		t.Errorf("unexpected metadata after update: %+v", user)
+	}
+
+	req, err := http.NewRequest("GET", "/users?updated_by=Leia&sort=version&order=desc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-User-Type", "Admin")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(HandleListUsers).ServeHTTP(rr, req)
+
+	var listed []User
+	if err := json.NewDecoder(rr.Body).Decode(&listed); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != "3" || listed[0].Version != 2 {
+		t.Errorf("unexpected filtered list: %+v", listed)
+	}
+
+	req, _ = http.NewRequest("GET", "/users?sort=unknown", nil)
+	req.Header.Set("X-User-Type", "Admin")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(HandleListUsers).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	setupTestStorageWithUsers()
+
+	req, _ := http.NewRequest("GET", "/users/2", nil)
+	req.Header.Set("X-User-Type", "Admin")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(HandleGetUser).ServeHTTP(rr, req)
+	tag := rr.Header().Get("ETag")
+	if tag != `"1"` {
+		t.Fatalf("unexpected ETag: %q", tag)
+	}
+
+	req, _ = http.NewRequest("GET", "/users/2", nil)
+	req.Header.Set("X-User-Type", "Admin")
+	req.Header.Set("If-None-Match", tag)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(HandleGetUser).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotModified)
+	}
+
+	tests := []struct {
+		name           string
+		ifMatch        string
+		expectedStatus int
+		expectedETag   string
+	}{
+		{name: "Update with current ETag", ifMatch: `"1"`, expectedStatus: http.StatusOK, expectedETag: `"2"`},
+		{name: "Update with stale ETag", ifMatch: `"1"`, expectedStatus: http.StatusPreconditionFailed},
+		{name: "Update with invalid ETag", ifMatch: `W/"2"`, expectedStatus: http.StatusPreconditionFailed},
+		{name: "Update with wildcard", ifMatch: "*", expectedStatus: http.StatusOK, expectedETag: `"3"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(RoleUpdateRequest{Roles: []string{"Watcher"}})
+			req, _ := http.NewRequest("PUT", "/users/roles/2", bytes.NewBuffer(payload))
+			req.Header.Set("X-User-Type", "Admin")
+			req.Header.Set("If-Match", tt.ifMatch)
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(HandleUpdateUserRoles).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+			if got := rr.Header().Get("ETag"); got != tt.expectedETag {
+				t.Errorf("handler returned wrong ETag: got %q want %q", got, tt.expectedETag)
+			}
+		})
+	}
+
+	req, _ = http.NewRequest("DELETE", "/users/2", nil)
+	req.Header.Set("X-User-Type", "Admin")
+	req.Header.Set("If-Match", `"1"`)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(HandleDeleteUser).ServeHTTP(rr, req)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+}
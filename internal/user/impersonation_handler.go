@@ -0,0 +1,94 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// ImpersonationRequest is the body of POST /users/{id}/impersonation. TTL is a duration
+// such as "10m"; it defaults to the maximum lifetime.
+type ImpersonationRequest struct {
+	TTL    string `json:"ttl,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ImpersonationResponse is an impersonation session with its token, to be sent as
+// "Authorization: Bearer <token>".
+type ImpersonationResponse struct {
+	*Session
+	Token string `json:"token"`
+}
+
+// HandleImpersonateUser handles POST /users/{id}/impersonation. Admins logged in with a
+// session can impersonate users they outrank and are allowed to manage; the impersonator
+// is the user of the session, never a client-supplied X-User-ID.
+func HandleImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+
+	if r.Method != http.MethodPost {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	if !isAdmin(currentUserRole) || r.Header.Get("X-API-Key-ID") != "" {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s UserID=%s attempted to impersonate user %s", currentUserRole, r.Header.Get("X-User-ID"), id)
+		return
+	}
+	// API keys are refused above, so the impersonator is the user of the session.
+	impersonatorID := requireAuthenticatedActor(w, r, "impersonate user "+id)
+	if impersonatorID == "" {
+		return
+	}
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+	if !checkUserPermission(w, r, orgID, "users.impersonate", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	var req ImpersonationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason == "" {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: impersonation needs a reason: %v", err)
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+			log.Printf("BadRequest: invalid impersonation ttl %q", req.TTL)
+			return
+		}
+	}
+
+	session, tok, err := Impersonate(orgID, impersonatorID, id, ttl, req.Reason, r.RemoteAddr, r.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, internalMsgs.ErrUserNotFound):
+			errResponse(w, http.StatusNotFound, err)
+		case errors.Is(err, internalMsgs.ErrImpersonationNotAllowed):
+			errResponse(w, http.StatusForbidden, err)
+		case errors.Is(err, internalMsgs.ErrInvalidImpersonationTTL):
+			errResponse(w, http.StatusBadRequest, err)
+		default:
+			errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+		}
+		log.Printf("Impersonation of user %s by user %s refused: %v", id, impersonatorID, err)
+		return
+	}
+	jsonResponse(w, http.StatusCreated, ImpersonationResponse{Session: session, Token: tok})
+	log.Printf("User %s started impersonating user %s until %s", impersonatorID, id, session.ExpiresAt.Format(time.RFC3339))
+}
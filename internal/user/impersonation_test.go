@@ -0,0 +1,83 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+)
+
+func TestImpersonation(t *testing.T) {
+	setupTestStorageWithUsers()
+	audit.Reset()
+	SetImpersonationConfig(ImpersonationConfig{MaxTTL: 15 * time.Minute})
+
+	admin := sessionHeaders(t, "1")
+
+	tests := []struct {
+		name           string
+		header         map[string]string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Impersonator must be logged in", map[string]string{"X-User-Type": "Admin", "X-User-ID": "1"}, "/users/3/impersonation", `{"reason":"support"}`, http.StatusUnauthorized},
+		{"Watcher cannot impersonate", map[string]string{"X-User-Type": "Watcher", "X-User-ID": "5"}, "/users/3/impersonation", `{"reason":"support"}`, http.StatusForbidden},
+		{"Admin cannot impersonate an equal", admin, "/users/6/impersonation", `{"reason":"support"}`, http.StatusForbidden},
+		{"Admin cannot impersonate themselves", admin, "/users/1/impersonation", `{"reason":"support"}`, http.StatusForbidden},
+		{"A reason is required", admin, "/users/3/impersonation", `{}`, http.StatusBadRequest},
+		{"Lifetime is capped", admin, "/users/3/impersonation", `{"reason":"support","ttl":"1h"}`, http.StatusBadRequest},
+		{"Unknown user", admin, "/users/99/impersonation", `{"reason":"support"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := doRequest(t, "POST", tt.path, tt.header, tt.body); rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+
+	// A client-supplied X-User-ID does not change who the impersonator is.
+	spoofed := map[string]string{"Authorization": admin["Authorization"], "X-User-ID": "6"}
+	rr := doRequest(t, "POST", "/users/3/impersonation", spoofed, `{"reason":"ticket 42","ttl":"10m"}`)
+	var imp ImpersonationResponse
+	json.NewDecoder(rr.Body).Decode(&imp)
+	if rr.Code != http.StatusCreated || imp.Session == nil || imp.UserID != "3" || imp.ImpersonatorID != "1" || imp.ExpiresAt.Sub(imp.CreatedAt) != 10*time.Minute {
+		t.Fatalf("expected an impersonation session, got %v: %+v", rr.Code, imp.Session)
+	}
+	if events := audit.Events(AuditImpersonationStarted); len(events) != 1 || events[0].Actor != "1" || events[0].Target != "3" || events[0].Details["reason"] != "ticket 42" {
+		t.Errorf("unexpected impersonation events %+v", events)
+	}
+	bearer := map[string]string{"Authorization": "Bearer " + imp.Token, "X-Impersonator-ID": "6"}
+
+	// The impersonator sees what the user sees, and every request names both.
+	if rr := doRequest(t, "GET", "/users/2/sessions", bearer, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected the Watcher's permissions to apply, got %v", rr.Code)
+	}
+	rr = doRequest(t, "GET", "/users/3/sessions", bearer, "")
+	var list []Session
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list) != 1 || list[0].ImpersonatorID != "1" || !list[0].Current {
+		t.Errorf("expected the user to see the impersonation session, got %v: %+v", rr.Code, list)
+	}
+	if events := audit.Events(AuditImpersonatedRequest); len(events) != 2 || events[1].Actor != "1" || events[1].Target != "3" || events[1].Details["path"] != "/users/3/sessions" {
+		t.Errorf("unexpected impersonated request events %+v", events)
+	}
+
+	// Nothing can be changed while impersonating, including starting another impersonation.
+	if rr := doRequest(t, "PUT", "/users/roles/3", bearer, `{"roles":["Admin"]}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected role changes to be refused, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", "/users/3/impersonation", bearer, `{"reason":"again"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected nested impersonation to be refused, got %v", rr.Code)
+	}
+
+	// The session ends once the impersonator no longer outranks the user.
+	if _, err := UpdateUserRoles(DefaultOrgID, "1", []string{"Watcher"}, "system", nil); err != nil {
+		t.Fatalf("failed to demote the impersonator: %v", err)
+	}
+	if rr := doRequest(t, "GET", "/users/3", bearer, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the impersonation session to end, got %v", rr.Code)
+	}
+}
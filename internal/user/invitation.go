@@ -0,0 +1,291 @@
+package user
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	"zpe-cloud-user-management-service/internal/ids"
+	"zpe-cloud-user-management-service/internal/mail"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+	"zpe-cloud-user-management-service/internal/password"
+	"zpe-cloud-user-management-service/internal/token"
+)
+
+// InvitationConfig controls how invitations are issued. Tokens are signed with the
+// signer installed by SetTokenSigner and delivered with the mailer from SetMailer.
+type InvitationConfig struct {
+	// Enabled makes POST /users invite new users instead of activating them directly.
+	Enabled bool
+	TTL     time.Duration
+	// AcceptURL is the page that receives the token as its "token" query parameter.
+	AcceptURL string
+}
+
+// Invitation is a pending or decided invite for an invited user.
+type Invitation struct {
+	ID         string     `json:"id"`
+	OrgID      string     `json:"org_id"`
+	UserID     string     `json:"user_id"`
+	Email      string     `json:"email"`
+	Status     string     `json:"status"`
+	InvitedBy  string     `json:"invited_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	SentCount  int        `json:"sent_count"`
+	LastSentAt time.Time  `json:"last_sent_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+
+	// tokenID is the ID of the only token that can still accept the invitation.
+	tokenID string
+}
+
+// Invitation statuses.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// tokenPurposeInvitation marks tokens that accept invitations.
+const tokenPurposeInvitation = "invitation"
+
+// Audit event types emitted for invitations.
+const (
+	AuditInvitationCreated  = "invitation.created"
+	AuditInvitationResent   = "invitation.resent"
+	AuditInvitationRevoked  = "invitation.revoked"
+	AuditInvitationAccepted = "invitation.accepted"
+)
+
+var (
+	invitationConfigMu sync.RWMutex
+	invitationConfig   = InvitationConfig{TTL: 7 * 24 * time.Hour}
+)
+
+// invitations and invitationIDGenerator are guarded by mu, like the users they refer to.
+var (
+	invitations                         = make(map[string]*Invitation)
+	invitationIDGenerator ids.Generator = ids.NewSequential()
+)
+
+// SetInvitationConfig installs the invitation settings.
+func SetInvitationConfig(c InvitationConfig) {
+	invitationConfigMu.Lock()
+	defer invitationConfigMu.Unlock()
+	invitationConfig = c
+}
+
+func currentInvitationConfig() InvitationConfig {
+	invitationConfigMu.RLock()
+	defer invitationConfigMu.RUnlock()
+	return invitationConfig
+}
+
+// InvitationsEnabled reports whether new users are invited instead of activated directly.
+func InvitationsEnabled() bool {
+	return currentInvitationConfig().Enabled
+}
+
+// lookupInvitation returns the stored invitation with the given ID if it belongs to orgID,
+// expiring it first when its TTL has passed. The caller must hold mu.
+func lookupInvitation(orgID, id string, now time.Time) (*Invitation, bool) {
+	inv, exists := invitations[id]
+	if !exists || inv.OrgID != orgID {
+		return nil, false
+	}
+	if inv.Status == InvitationPending && !now.Before(inv.ExpiresAt) {
+		inv.Status = InvitationExpired
+	}
+	return inv, true
+}
+
+// issueInvitationToken rotates the token of an invitation, invalidating any earlier one,
+// and restarts its expiry. The caller must hold mu.
+func issueInvitationToken(inv *Invitation, now time.Time) (string, error) {
+	cfg := currentInvitationConfig()
+	inv.tokenID = token.NewID()
+	inv.ExpiresAt = now.Add(cfg.TTL)
+	inv.Status = InvitationPending
+	inv.SentCount++
+	inv.LastSentAt = now
+	return currentTokenSigner().Sign(token.Claims{
+		ID:        inv.tokenID,
+		Purpose:   tokenPurposeInvitation,
+		Subject:   inv.ID,
+		OrgID:     inv.OrgID,
+		ExpiresAt: inv.ExpiresAt.Unix(),
+	})
+}
+
+// CreateInvitation creates a pending invitation for an invited user and returns it
+// together with the token that accepts it.
+func CreateInvitation(orgID, userID, actor string) (*Invitation, string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, userID)
+	if !exists || user.IsDeleted() {
+		return nil, "", internalErrors.ErrUserNotFound
+	}
+	if user.Status != UserStatusInvited {
+		return nil, "", internalErrors.ErrInvitationNotPending
+	}
+
+	now := time.Now().UTC()
+	inv := &Invitation{
+		ID:        invitationIDGenerator.NewID(),
+		OrgID:     orgID,
+		UserID:    userID,
+		Email:     user.Email,
+		InvitedBy: actor,
+		CreatedAt: now,
+	}
+	tok, err := issueInvitationToken(inv, now)
+	if err != nil {
+		return nil, "", err
+	}
+	invitations[inv.ID] = inv
+
+	audit.Record(audit.Event{Type: AuditInvitationCreated, OrgID: orgID, Actor: actor, Target: userID, Details: map[string]string{"invitation_id": inv.ID}})
+	c := *inv
+	return &c, tok, nil
+}
+
+func GetInvitation(orgID, id string) (*Invitation, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	inv, exists := lookupInvitation(orgID, id, time.Now())
+	if !exists {
+		return nil, internalErrors.ErrInvitationNotFound
+	}
+	c := *inv
+	return &c, nil
+}
+
+// ListInvitations returns the invitations of an organization, newest first.
+// An empty status returns invitations in every status.
+func ListInvitations(orgID, status string) []*Invitation {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	list := make([]*Invitation, 0)
+	for id := range invitations {
+		inv, exists := lookupInvitation(orgID, id, now)
+		if !exists || (status != "" && inv.Status != status) {
+			continue
+		}
+		c := *inv
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// ResendInvitation issues a fresh token for a pending or expired invitation. Earlier
+// tokens stop working.
+func ResendInvitation(orgID, id, actor string) (*Invitation, string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now().UTC()
+	inv, exists := lookupInvitation(orgID, id, now)
+	if !exists {
+		return nil, "", internalErrors.ErrInvitationNotFound
+	}
+	if inv.Status != InvitationPending && inv.Status != InvitationExpired {
+		return nil, "", internalErrors.ErrInvitationNotPending
+	}
+	if user, exists := lookupUser(orgID, inv.UserID); !exists || user.IsDeleted() {
+		return nil, "", internalErrors.ErrUserNotFound
+	}
+
+	tok, err := issueInvitationToken(inv, now)
+	if err != nil {
+		return nil, "", err
+	}
+	audit.Record(audit.Event{Type: AuditInvitationResent, OrgID: orgID, Actor: actor, Target: inv.UserID, Details: map[string]string{"invitation_id": inv.ID}})
+	c := *inv
+	return &c, tok, nil
+}
+
+// RevokeInvitation cancels an invitation that has not been accepted yet. The invited user
+// never became active, so it is removed and its email address can be invited again.
+func RevokeInvitation(orgID, id, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	inv, exists := lookupInvitation(orgID, id, time.Now())
+	if !exists {
+		return internalErrors.ErrInvitationNotFound
+	}
+	if inv.Status != InvitationPending && inv.Status != InvitationExpired {
+		return internalErrors.ErrInvitationNotPending
+	}
+
+	inv.Status = InvitationRevoked
+	inv.tokenID = ""
+	if user, exists := lookupUser(orgID, inv.UserID); exists && user.Status == UserStatusInvited {
+		removeUser(user)
+	}
+	audit.Record(audit.Event{Type: AuditInvitationRevoked, OrgID: orgID, Actor: actor, Target: inv.UserID, Details: map[string]string{"invitation_id": inv.ID}})
+	return nil
+}
+
+// AcceptInvitation redeems an invitation token, sets the user's password and activates
+// the user. Each token can be used once.
+func AcceptInvitation(tok, newPassword string) (*User, error) {
+	claims, err := currentTokenSigner().Verify(tok, tokenPurposeInvitation)
+	if err != nil {
+		return nil, err
+	}
+	if err := password.Validate(newPassword); err != nil {
+		return nil, err
+	}
+	hash, err := password.Hash(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now().UTC()
+	inv, exists := lookupInvitation(claims.OrgID, claims.Subject, now)
+	if !exists || inv.Status != InvitationPending || inv.tokenID != claims.ID {
+		return nil, internalErrors.ErrInvalidToken
+	}
+	user, exists := lookupUser(inv.OrgID, inv.UserID)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrInvalidToken
+	}
+
+	inv.Status = InvitationAccepted
+	inv.AcceptedAt = &now
+	inv.tokenID = ""
+	user.Status = UserStatusActive
+	user.EmailVerified = true
+	user.passwordHash = hash
+	user.touch(user.ID, now)
+
+	audit.Record(audit.Event{Type: AuditInvitationAccepted, OrgID: inv.OrgID, Actor: user.ID, Target: user.ID, Details: map[string]string{"invitation_id": inv.ID}})
+	return user.clone(), nil
+}
+
+// sendInvitation emails the accept link of an invitation.
+func sendInvitation(inv *Invitation, tok string) error {
+	link := currentInvitationConfig().AcceptURL + "?token=" + url.QueryEscape(tok)
+	return sendMail(mail.Message{
+		To:      inv.Email,
+		Subject: "You have been invited",
+		Body: fmt.Sprintf("You have been invited to join %s.\n\nAccept the invitation and choose a password here:\n%s\n\nThe link expires at %s.\n",
+			inv.OrgID, link, inv.ExpiresAt.Format(time.RFC1123)),
+	})
+}
@@ -0,0 +1,228 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// HandleInvitations handles HTTP requests for the /invitations endpoint.
+func HandleInvitations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	HandleListInvitations(w, r)
+}
+
+// HandleInvitation handles HTTP requests for /invitations/accept, /invitations/{id}
+// and /invitations/{id}/resend.
+func HandleInvitation(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/invitations/"), "/")
+	switch {
+	case id == "accept" && action == "":
+		if r.Method != http.MethodPost {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleAcceptInvitation(w, r)
+	case action == "":
+		switch r.Method {
+		case http.MethodGet:
+			HandleGetInvitation(w, r)
+		case http.MethodDelete:
+			HandleRevokeInvitation(w, r)
+		default:
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+		}
+	case action == "resend":
+		if r.Method != http.MethodPost {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleResendInvitation(w, r)
+	default:
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+	}
+}
+
+// inviteUser creates and sends the invitation of a user created in invitation mode.
+// Delivery failures are logged; the invitation can be resent later.
+func inviteUser(orgID, userID, actor string) (*Invitation, error) {
+	inv, tok, err := CreateInvitation(orgID, userID, actor)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendInvitation(inv, tok); err != nil {
+		log.Printf("Failed to send invitation %s: %v", inv.ID, err)
+	}
+	return inv, nil
+}
+
+func HandleListInvitations(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list invitations", currentUserRole)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", InvitationPending, InvitationAccepted, InvitationRevoked, InvitationExpired:
+	default:
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidQueryParameter)
+		log.Printf("BadRequest: invalid status: %s", status)
+		return
+	}
+
+	list := ListInvitations(orgID, status)
+	jsonResponse(w, http.StatusOK, list)
+	log.Printf("Invitations listed: %d invitations", len(list))
+}
+
+func HandleGetInvitation(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to get an invitation", currentUserRole)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/invitations/")
+	inv, err := GetInvitation(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Invitation %s", id)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, inv)
+}
+
+func HandleResendInvitation(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to resend an invitation", currentUserRole)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/invitations/"), "/resend")
+	if !checkInvitationPolicy(w, r, orgID, "invitations.resend", id) {
+		return
+	}
+	inv, tok, err := ResendInvitation(orgID, id, currentActor(r))
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrInvitationNotPending) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("Invitation %s not resent: %v", id, err)
+		return
+	}
+	if err := sendInvitation(inv, tok); err != nil {
+		errResponse(w, http.StatusBadGateway, internalMsgs.ErrInternalServerError)
+		log.Printf("Failed to send invitation %s: %v", id, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, inv)
+	log.Printf("Invitation %s resent to %s", id, inv.Email)
+}
+
+func HandleRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to revoke an invitation", currentUserRole)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/invitations/")
+	if !checkInvitationPolicy(w, r, orgID, "invitations.revoke", id) {
+		return
+	}
+	if err := RevokeInvitation(orgID, id, currentActor(r)); err != nil {
+		if errors.Is(err, internalMsgs.ErrInvitationNotPending) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("Invitation %s not revoked: %v", id, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("UserType=%s revoked invitation %s", currentUserRole, id)
+}
+
+// checkInvitationPolicy applies the access policy to action on the invitation id, whose
+// target is the invited user.
+func checkInvitationPolicy(w http.ResponseWriter, r *http.Request, orgID, action, id string) bool {
+	inv, err := GetInvitation(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Invitation %s", id)
+		return false
+	}
+	return checkPolicy(w, r, orgID, action, inv.UserID, nil, nil)
+}
+
+// HandleAcceptInvitation redeems an invitation token. It is called by the invited person,
+// who has no role yet, so it is authorized by the token alone.
+func HandleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var req AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	user, err := AcceptInvitation(req.Token, req.Password)
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrInvalidToken) || errors.Is(err, internalMsgs.ErrWeakPassword) {
+			errResponse(w, http.StatusBadRequest, err)
+		} else {
+			errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+		}
+		log.Printf("Invitation not accepted: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"id":      user.ID,
+		"message": "Invitation accepted successfully",
+	})
+	log.Printf("Invitation accepted by user %s", user.ID)
+}
@@ -0,0 +1,96 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/mail"
+)
+
+func TestUserInvitations(t *testing.T) {
+	setupTestStorageWithUsers()
+	var outbox bytes.Buffer
+	SetMailer(mail.NewWriterSender(&outbox))
+	defer SetMailer(nil)
+	SetInvitationConfig(InvitationConfig{Enabled: true, TTL: time.Hour, AcceptURL: "https://app.example.com/accept"})
+	defer SetInvitationConfig(InvitationConfig{TTL: time.Hour})
+
+	lastToken := func() string { return lastMailedToken(t, &outbox) }
+
+	rr := doRequest(t, "POST", "/users", callerHeaders("Admin", ""), `{"name":"Padme","email":"padme@example.com","roles":["Watcher"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var created map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if user, _ := GetUser(DefaultOrgID, created["id"]); user.Status != UserStatusInvited {
+		t.Errorf("expected user to be invited, got %s", user.Status)
+	}
+	firstToken := lastToken()
+
+	if rr := doRequest(t, "POST", "/invitations/"+created["invitation_id"]+"/resend", callerHeaders("Admin", ""), ""); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	secondToken := lastToken()
+
+	accept := func(tok, password string) int {
+		body, _ := json.Marshal(AcceptInvitationRequest{Token: tok, Password: password})
+		return doRequest(t, "POST", "/invitations/accept", nil, string(body)).Code
+	}
+	tests := []struct {
+		name           string
+		token          string
+		password       string
+		expectedStatus int
+	}{
+		{name: "Resent invitation invalidates the earlier token", token: firstToken, password: "a strong password", expectedStatus: http.StatusBadRequest},
+		{name: "Forged token", token: secondToken + "x", password: "a strong password", expectedStatus: http.StatusBadRequest},
+		{name: "Weak password", token: secondToken, password: "short", expectedStatus: http.StatusBadRequest},
+		{name: "Accept invitation", token: secondToken, password: "a strong password", expectedStatus: http.StatusOK},
+		{name: "Token is single-use", token: secondToken, password: "a strong password", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := accept(tt.token, tt.password); status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.expectedStatus)
+			}
+		})
+	}
+
+	if user, _ := GetUser(DefaultOrgID, created["id"]); user.Status != UserStatusActive {
+		t.Errorf("expected user to be active, got %s", user.Status)
+	}
+	if rr := doRequest(t, "DELETE", "/invitations/"+created["invitation_id"], callerHeaders("Admin", ""), ""); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	rr = doRequest(t, "POST", "/users", callerHeaders("Admin", ""), `{"name":"Anakin","email":"anakin@example.com","roles":["Watcher"]}`)
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if rr := doRequest(t, "DELETE", "/invitations/"+created["invitation_id"], callerHeaders("Modifier", ""), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := doRequest(t, "DELETE", "/invitations/"+created["invitation_id"], callerHeaders("Admin", ""), ""); rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if _, err := GetUser(DefaultOrgID, created["id"]); err == nil {
+		t.Error("expected the invited user to be removed")
+	}
+	if rr := doRequest(t, "POST", "/users", callerHeaders("Admin", ""), `{"name":"Anakin","email":"anakin@example.com","roles":["Watcher"]}`); rr.Code != http.StatusCreated {
+		t.Errorf("expected the email address to be free again, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doRequest(t, "GET", "/invitations?status=revoked", callerHeaders("Admin", ""), "")
+	var revoked []Invitation
+	if err := json.NewDecoder(rr.Body).Decode(&revoked); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(revoked) != 1 || revoked[0].Email != "anakin@example.com" {
+		t.Errorf("expected one revoked invitation, got %+v", revoked)
+	}
+}
@@ -0,0 +1,413 @@
+package user
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	"zpe-cloud-user-management-service/internal/metrics"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// LockoutPolicy controls the brute-force protection of logins. Failures are counted
+// per account, whether or not the account exists, and per client IP address.
+type LockoutPolicy struct {
+	// AccountThreshold consecutive failures lock an account for AccountLockout. Zero
+	// disables account lockout.
+	AccountThreshold int
+	AccountLockout   time.Duration
+	// IPThreshold failures from one address within IPWindow block it for IPLockout.
+	// Zero disables address blocking.
+	IPThreshold int
+	IPWindow    time.Duration
+	IPLockout   time.Duration
+	// Delay is the wait enforced after a failed login of an account. It doubles with
+	// every further consecutive failure, up to MaxDelay. Zero disables delays.
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// LockoutStatus reports the failed logins of an account.
+type LockoutStatus struct {
+	Locked         bool       `json:"locked"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	FailedAttempts int        `json:"failed_attempts"`
+}
+
+// BlockedIP is a client address that is blocked from logging in.
+type BlockedIP struct {
+	IP           string    `json:"ip"`
+	BlockedUntil time.Time `json:"blocked_until"`
+}
+
+// LoginThrottledError is returned when a login is refused before the credentials are
+// checked. It wraps ErrAccountLocked or ErrTooManyRequests.
+type LoginThrottledError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string { return e.Err.Error() }
+
+func (e *LoginThrottledError) Unwrap() error { return e.Err }
+
+// Audit event types emitted by the brute-force protection.
+const (
+	AuditAccountLocked   = "account.locked"
+	AuditAccountUnlocked = "account.unlocked"
+	AuditIPBlocked       = "login.ip_blocked"
+	AuditIPUnblocked     = "login.ip_unblocked"
+)
+
+var (
+	loginFailuresTotal  = metrics.NewCounter("login_failures_total", "Failed logins.")
+	loginThrottledTotal = metrics.NewCounter("login_throttled_total",
+		"Logins refused before checking the credentials, by reason.", "reason")
+	loginLockoutsTotal = metrics.NewCounter("login_lockouts_total",
+		"Accounts locked and addresses blocked after repeated failed logins.", "scope")
+)
+
+// accountFailures and ipFailures count attempts in progress as pending failures, so
+// that concurrent attempts cannot get past the thresholds before any of them fails.
+type accountFailures struct {
+	count       int
+	pending     int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type ipFailures struct {
+	failures     []time.Time
+	pending      int
+	blockedUntil time.Time
+}
+
+// loginThrottleSweepInterval is how often expired failures are dropped.
+const loginThrottleSweepInterval = time.Minute
+
+// loginThrottle tracks failed logins. Accounts are keyed by their email index key.
+type loginThrottle struct {
+	mu        sync.Mutex
+	policy    LockoutPolicy
+	accounts  map[string]*accountFailures
+	ips       map[string]*ipFailures
+	lastSweep time.Time
+}
+
+func newLoginThrottle(p LockoutPolicy) *loginThrottle {
+	return &loginThrottle{policy: p, accounts: make(map[string]*accountFailures), ips: make(map[string]*ipFailures)}
+}
+
+// defaultLockoutPolicy applies until SetLockoutPolicy is called.
+var defaultLockoutPolicy = LockoutPolicy{
+	AccountThreshold: 5,
+	AccountLockout:   15 * time.Minute,
+	IPThreshold:      20,
+	IPWindow:         15 * time.Minute,
+	IPLockout:        15 * time.Minute,
+	Delay:            time.Second,
+	MaxDelay:         30 * time.Second,
+}
+
+var (
+	lockoutMu sync.RWMutex
+	throttle  = newLoginThrottle(defaultLockoutPolicy)
+)
+
+// SetLockoutPolicy installs the brute-force protection settings and forgets all failures.
+func SetLockoutPolicy(p LockoutPolicy) {
+	lockoutMu.Lock()
+	defer lockoutMu.Unlock()
+	throttle = newLoginThrottle(p)
+}
+
+func currentLoginThrottle() *loginThrottle {
+	lockoutMu.RLock()
+	defer lockoutMu.RUnlock()
+	return throttle
+}
+
+// resetLoginThrottle forgets all failures under the current policy.
+func resetLoginThrottle() {
+	lockoutMu.Lock()
+	defer lockoutMu.Unlock()
+	throttle = newLoginThrottle(throttle.policy)
+}
+
+// account returns the failures of an account, forgetting them once they are older
+// than the lockout period. The caller must hold t.mu.
+func (t *loginThrottle) account(key string, now time.Time) *accountFailures {
+	a, exists := t.accounts[key]
+	if !exists {
+		return nil
+	}
+	if a.pending > 0 || now.Before(a.lockedUntil) || now.Sub(a.lastFailure) < max(t.policy.AccountLockout, t.policy.MaxDelay) {
+		return a
+	}
+	delete(t.accounts, key)
+	return nil
+}
+
+// ip returns the failures of an address within the window, forgetting the older ones.
+// The caller must hold t.mu.
+func (t *loginThrottle) ip(ip string, now time.Time) *ipFailures {
+	f, exists := t.ips[ip]
+	if !exists {
+		return nil
+	}
+	recent := f.failures[:0]
+	for _, at := range f.failures {
+		if now.Sub(at) < t.policy.IPWindow {
+			recent = append(recent, at)
+		}
+	}
+	f.failures = recent
+	if f.pending > 0 || len(f.failures) > 0 || now.Before(f.blockedUntil) {
+		return f
+	}
+	delete(t.ips, ip)
+	return nil
+}
+
+// sweep drops the failures that have expired. The caller must hold t.mu.
+func (t *loginThrottle) sweep(now time.Time) {
+	for key := range t.accounts {
+		t.account(key, now)
+	}
+	for ip := range t.ips {
+		t.ip(ip, now)
+	}
+	t.lastSweep = now
+}
+
+// release ends an attempt reserved by check. The caller must hold t.mu.
+func (t *loginThrottle) release(accountKey, ip string) {
+	if a, exists := t.accounts[accountKey]; exists && a.pending > 0 {
+		a.pending--
+	}
+	if f, exists := t.ips[ip]; exists && f.pending > 0 {
+		f.pending--
+	}
+}
+
+// delay returns the wait enforced after count consecutive failures.
+func (t *loginThrottle) delay(count int) time.Duration {
+	if t.policy.Delay <= 0 || count == 0 {
+		return 0
+	}
+	d := t.policy.Delay
+	for i := 1; i < count && d < t.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, max(t.policy.MaxDelay, t.policy.Delay))
+}
+
+// check reports whether a login of the account from ip may be attempted at now and,
+// if so, reserves the attempt as a pending failure. Every successful check must be
+// followed by fail, succeed or release.
+func (t *loginThrottle) check(accountKey, ip string, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastSweep) >= loginThrottleSweepInterval {
+		t.sweep(now)
+	}
+	f := t.ip(ip, now)
+	if f != nil && now.Before(f.blockedUntil) {
+		loginThrottledTotal.Inc("ip_blocked")
+		return &LoginThrottledError{Err: internalErrors.ErrTooManyRequests, RetryAfter: f.blockedUntil.Sub(now)}
+	}
+	if f != nil && t.policy.IPThreshold > 0 && len(f.failures)+f.pending >= t.policy.IPThreshold {
+		loginThrottledTotal.Inc("pending")
+		return &LoginThrottledError{Err: internalErrors.ErrTooManyRequests, RetryAfter: time.Second}
+	}
+	a := t.account(accountKey, now)
+	if a != nil {
+		if now.Before(a.lockedUntil) {
+			loginThrottledTotal.Inc("account_locked")
+			return &LoginThrottledError{Err: internalErrors.ErrAccountLocked, RetryAfter: a.lockedUntil.Sub(now)}
+		}
+		if next := a.lastFailure.Add(t.delay(a.count)); now.Before(next) {
+			loginThrottledTotal.Inc("delay")
+			return &LoginThrottledError{Err: internalErrors.ErrTooManyRequests, RetryAfter: next.Sub(now)}
+		}
+		if t.policy.AccountThreshold > 0 && a.count+a.pending >= t.policy.AccountThreshold {
+			loginThrottledTotal.Inc("pending")
+			return &LoginThrottledError{Err: internalErrors.ErrTooManyRequests, RetryAfter: time.Second}
+		}
+	}
+
+	if a == nil {
+		a = &accountFailures{}
+		t.accounts[accountKey] = a
+	}
+	a.pending++
+	if t.policy.IPThreshold > 0 && ip != "" {
+		if f == nil {
+			f = &ipFailures{}
+			t.ips[ip] = f
+		}
+		f.pending++
+	}
+	return nil
+}
+
+// fail turns the attempt reserved by check into a failed login and reports whether it
+// locked the account or blocked ip.
+func (t *loginThrottle) fail(accountKey, ip string, now time.Time) (accountLocked, ipBlocked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	loginFailuresTotal.Inc()
+	t.release(accountKey, ip)
+	a := t.account(accountKey, now)
+	if a == nil {
+		a = &accountFailures{}
+		t.accounts[accountKey] = a
+	}
+	a.count++
+	a.lastFailure = now
+	if t.policy.AccountThreshold > 0 && a.count >= t.policy.AccountThreshold {
+		a.lockedUntil = now.Add(t.policy.AccountLockout)
+		a.count = 0
+		accountLocked = true
+		loginLockoutsTotal.Inc("account")
+	}
+
+	if t.policy.IPThreshold <= 0 || ip == "" {
+		return accountLocked, false
+	}
+	f := t.ip(ip, now)
+	if f == nil {
+		f = &ipFailures{}
+		t.ips[ip] = f
+	}
+	f.failures = append(f.failures, now)
+	if len(f.failures) >= t.policy.IPThreshold {
+		f.blockedUntil = now.Add(t.policy.IPLockout)
+		f.failures = nil
+		ipBlocked = true
+		loginLockoutsTotal.Inc("ip")
+	}
+	return accountLocked, ipBlocked
+}
+
+// succeed ends the attempt reserved by check and forgets the failures of the account.
+func (t *loginThrottle) succeed(accountKey, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.release(accountKey, ip)
+	if a, exists := t.accounts[accountKey]; exists && a.pending == 0 {
+		delete(t.accounts, accountKey)
+	} else if exists {
+		a.count, a.lastFailure = 0, time.Time{}
+	}
+}
+
+// abandon ends the attempt reserved by check without counting it either way.
+func (t *loginThrottle) abandon(accountKey, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.release(accountKey, ip)
+}
+
+func (t *loginThrottle) status(accountKey string, now time.Time) LockoutStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var status LockoutStatus
+	if a := t.account(accountKey, now); a != nil {
+		status.FailedAttempts = a.count
+		if now.Before(a.lockedUntil) {
+			lockedUntil := a.lockedUntil
+			status.Locked, status.LockedUntil = true, &lockedUntil
+		}
+	}
+	return status
+}
+
+// unlock forgets the failures of an account and reports whether it was locked.
+func (t *loginThrottle) unlock(accountKey string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a := t.account(accountKey, now)
+	delete(t.accounts, accountKey)
+	return a != nil && now.Before(a.lockedUntil)
+}
+
+// recordLoginFailure counts the attempt t reserved for the account with the given email
+// index key from ip as failed, and records the lockouts it causes. The caller must hold mu.
+func recordLoginFailure(t *loginThrottle, accountKey, ip string, now time.Time) {
+	accountLocked, ipBlocked := t.fail(accountKey, ip, now)
+	if accountLocked {
+		details := map[string]string{"ip": ip}
+		var orgID, target string
+		if id, exists := emailIndex[accountKey]; exists {
+			orgID, target = users[id].OrgID, id
+		}
+		audit.Record(audit.Event{Type: AuditAccountLocked, OrgID: orgID, Actor: "system", Target: target, Details: details})
+	}
+	if ipBlocked {
+		audit.Record(audit.Event{Type: AuditIPBlocked, Actor: "system", Target: ip})
+	}
+}
+
+// GetLockoutStatus reports the failed logins of a user.
+func GetLockoutStatus(orgID, id string) (LockoutStatus, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return LockoutStatus{}, internalErrors.ErrUserNotFound
+	}
+	return currentLoginThrottle().status(user.emailKey, time.Now()), nil
+}
+
+// UnlockUser lifts the lockout of a user and forgets its failed logins.
+func UnlockUser(orgID, id, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return internalErrors.ErrUserNotFound
+	}
+	if currentLoginThrottle().unlock(user.emailKey, time.Now()) {
+		audit.Record(audit.Event{Type: AuditAccountUnlocked, OrgID: orgID, Actor: actor, Target: id})
+	}
+	return nil
+}
+
+// ListBlockedIPs returns the addresses that are currently blocked from logging in.
+func ListBlockedIPs() []BlockedIP {
+	t := currentLoginThrottle()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	list := []BlockedIP{}
+	for ip, f := range t.ips {
+		if now.Before(f.blockedUntil) {
+			list = append(list, BlockedIP{IP: ip, BlockedUntil: f.blockedUntil.UTC()})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].IP < list[j].IP })
+	return list
+}
+
+// UnblockIP lifts the block of a client address.
+func UnblockIP(ip, actor string) error {
+	t := currentLoginThrottle()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f, exists := t.ips[ip]; !exists || !time.Now().Before(f.blockedUntil) {
+		return internalErrors.ErrNotFound
+	}
+	delete(t.ips, ip)
+	audit.Record(audit.Event{Type: AuditIPUnblocked, Actor: actor, Target: ip})
+	return nil
+}
@@ -0,0 +1,104 @@
+package user
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// loginThrottledStatus returns the status for a login refused by the brute-force
+// protection and sets its Retry-After header. It returns 0 for other errors.
+func loginThrottledStatus(w http.ResponseWriter, err error) int {
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return 0
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	if errors.Is(err, internalMsgs.ErrAccountLocked) {
+		return http.StatusLocked
+	}
+	return http.StatusTooManyRequests
+}
+
+// HandleUserLockout handles HTTP requests for /users/{id}/lockout. GET reports the
+// failed logins of a user and DELETE unlocks the account. Only admins with permission
+// over the user can do either.
+func HandleUserLockout(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to manage the lockout of user %s", currentUserRole, id)
+		return
+	}
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+	if !checkUserPermission(w, r, orgID, "users.lockout", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		status, err := GetLockoutStatus(orgID, id)
+		if err != nil {
+			errResponse(w, http.StatusNotFound, err)
+			log.Printf("NotFound: User %s", id)
+			return
+		}
+		jsonResponse(w, http.StatusOK, status)
+		return
+	}
+	if err := UnlockUser(orgID, id, currentActor(r)); err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: User %s", id)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("User %s unlocked", id)
+}
+
+// HandleIPBlocks handles HTTP requests for /ip-blocks, which lists the client addresses
+// blocked after repeated failed logins, and /ip-blocks/{ip}, which unblocks one.
+// Addresses are blocked across organizations, so only a SuperAdmin can manage them.
+func HandleIPBlocks(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	if currentUserRole != "SuperAdmin" {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to manage blocked addresses", currentUserRole)
+		return
+	}
+
+	ip := strings.Trim(strings.TrimPrefix(r.URL.Path, "/ip-blocks"), "/")
+	switch {
+	case r.Method == http.MethodGet && ip == "":
+		jsonResponse(w, http.StatusOK, ListBlockedIPs())
+	case r.Method == http.MethodDelete && ip != "":
+		if err := UnblockIP(ip, currentActor(r)); err != nil {
+			errResponse(w, http.StatusNotFound, err)
+			log.Printf("NotFound: blocked address %s", ip)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		log.Printf("Address %s unblocked", ip)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
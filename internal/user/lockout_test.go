@@ -0,0 +1,137 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+func TestLoginLockout(t *testing.T) {
+	setupTestStorageWithUsers()
+	audit.Reset()
+	setPassword(t, "3", "r2-d2 password")
+	SetLockoutPolicy(LockoutPolicy{AccountThreshold: 3, AccountLockout: time.Hour, IPThreshold: 5, IPWindow: time.Hour, IPLockout: time.Hour})
+	defer SetLockoutPolicy(defaultLockoutPolicy)
+	lockouts := loginLockoutsTotal.Value("account")
+
+	login := func(email, pw, remoteAddr string) *httptest.ResponseRecorder {
+		return doRequest(t, "POST", "/login", map[string]string{remoteAddrHeader: remoteAddr}, fmt.Sprintf(`{"email":%q,"password":%q}`, email, pw))
+	}
+	admin := map[string]string{"X-User-Type": "Admin", "X-User-ID": "1"}
+
+	// Consecutive failures lock the account, even for the right password.
+	for i := 0; i < 3; i++ {
+		if rr := login("r2-d2@example.com", "wrong password", "192.0.2.10:1234"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %v", i+1, rr.Code)
+		}
+	}
+	rr := login("r2-d2@example.com", "r2-d2 password", "192.0.2.10:1234")
+	if rr.Code != http.StatusLocked || rr.Header().Get("Retry-After") != "3600" {
+		t.Fatalf("expected the account to be locked for an hour, got %v Retry-After=%q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if events := audit.Events(AuditAccountLocked); len(events) != 1 || events[0].Target != "3" || events[0].Details["ip"] != "192.0.2.10" {
+		t.Errorf("unexpected lockout events %+v", events)
+	}
+	if got := loginLockoutsTotal.Value("account"); got != lockouts+1 {
+		t.Errorf("expected the lockout to be counted, got %d after %d", got, lockouts)
+	}
+
+	// Only admins with permission over the user see and lift the lockout.
+	if rr := doRequest(t, "DELETE", "/users/3/lockout", map[string]string{"X-User-Type": "Watcher", "X-User-ID": "3"}, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a Watcher to be unable to unlock their account, got %v", rr.Code)
+	}
+	rr = doRequest(t, "GET", "/users/3/lockout", admin, "")
+	var status LockoutStatus
+	json.NewDecoder(rr.Body).Decode(&status)
+	if rr.Code != http.StatusOK || !status.Locked || status.LockedUntil == nil {
+		t.Errorf("expected the lockout to be reported, got %v: %+v", rr.Code, status)
+	}
+	if rr := doRequest(t, "DELETE", "/users/3/lockout", admin, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected the account to be unlocked, got %v", rr.Code)
+	}
+	if events := audit.Events(AuditAccountUnlocked); len(events) != 1 || events[0].Actor != "1" {
+		t.Errorf("unexpected unlock events %+v", events)
+	}
+	if rr := login("r2-d2@example.com", "r2-d2 password", "192.0.2.10:1234"); rr.Code != http.StatusOK {
+		t.Fatalf("expected login after the unlock, got %v", rr.Code)
+	}
+
+	// Failures against unknown accounts count towards blocking the address.
+	for i := 0; i < 5; i++ {
+		login(fmt.Sprintf("nobody%d@example.com", i), "guess", "198.51.100.7:4321")
+	}
+	if rr := login("r2-d2@example.com", "r2-d2 password", "198.51.100.7:4321"); rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the address to be blocked, got %v", rr.Code)
+	}
+	if rr := login("r2-d2@example.com", "r2-d2 password", "192.0.2.10:1234"); rr.Code != http.StatusOK {
+		t.Errorf("expected other addresses to be unaffected, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "GET", "/ip-blocks/", admin, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected an Admin to be unable to list blocked addresses, got %v", rr.Code)
+	}
+	superAdmin := map[string]string{"X-User-Type": "SuperAdmin"}
+	rr = doRequest(t, "GET", "/ip-blocks/", superAdmin, "")
+	var blocked []BlockedIP
+	json.NewDecoder(rr.Body).Decode(&blocked)
+	if rr.Code != http.StatusOK || len(blocked) != 1 || blocked[0].IP != "198.51.100.7" {
+		t.Errorf("unexpected blocked addresses %v: %+v", rr.Code, blocked)
+	}
+	if rr := doRequest(t, "DELETE", "/ip-blocks/198.51.100.7", superAdmin, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected the address to be unblocked, got %v", rr.Code)
+	}
+	if rr := login("r2-d2@example.com", "r2-d2 password", "198.51.100.7:4321"); rr.Code != http.StatusOK {
+		t.Errorf("expected login after the address was unblocked, got %v", rr.Code)
+	}
+
+	// Each further failure doubles the wait before the next attempt, up to the maximum.
+	throttle := newLoginThrottle(LockoutPolicy{AccountLockout: time.Hour, Delay: time.Second, MaxDelay: 4 * time.Second})
+	now := time.Now()
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		throttle.fail("account", "192.0.2.10", now)
+		var throttled *LoginThrottledError
+		if err := throttle.check("account", "192.0.2.10", now); !errors.As(err, &throttled) || throttled.RetryAfter != want {
+			t.Errorf("failure %d: expected to wait %v, got %v", i+1, want, err)
+		}
+		now = now.Add(want)
+		if err := throttle.check("account", "192.0.2.10", now); err != nil {
+			t.Errorf("failure %d: expected an attempt after %v, got %v", i+1, want, err)
+		}
+	}
+
+	// Attempts in progress count as failures until they succeed.
+	throttle = newLoginThrottle(LockoutPolicy{AccountThreshold: 2, AccountLockout: time.Hour, IPThreshold: 3, IPWindow: time.Hour, IPLockout: time.Hour})
+	for i := 0; i < 2; i++ {
+		if err := throttle.check("account", "192.0.2.10", now); err != nil {
+			t.Fatalf("attempt %d: expected to be allowed, got %v", i+1, err)
+		}
+	}
+	if err := throttle.check("account", "192.0.2.10", now); !errors.Is(err, internalMsgs.ErrTooManyRequests) {
+		t.Errorf("expected a concurrent attempt beyond the threshold to be refused, got %v", err)
+	}
+	if err := throttle.check("other", "192.0.2.10", now); err != nil {
+		t.Errorf("expected another account to be allowed, got %v", err)
+	}
+	if err := throttle.check("third", "192.0.2.10", now); !errors.Is(err, internalMsgs.ErrTooManyRequests) {
+		t.Errorf("expected concurrent attempts beyond the address threshold to be refused, got %v", err)
+	}
+	throttle.succeed("account", "192.0.2.10")
+	throttle.succeed("account", "192.0.2.10")
+	throttle.succeed("other", "192.0.2.10")
+	if err := throttle.check("account", "192.0.2.10", now); err != nil {
+		t.Errorf("expected successful attempts to be released, got %v", err)
+	}
+	throttle.abandon("account", "192.0.2.10")
+
+	// Expired failures are swept.
+	throttle.fail("gone", "198.51.100.7", now)
+	throttle.check("account", "192.0.2.10", now.Add(2*time.Hour))
+	if _, exists := throttle.accounts["gone"]; exists || throttle.ips["198.51.100.7"] != nil {
+		t.Errorf("expected expired failures to be swept, got %+v %+v", throttle.accounts, throttle.ips)
+	}
+}
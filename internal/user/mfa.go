@@ -0,0 +1,241 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+	"zpe-cloud-user-management-service/internal/totp"
+)
+
+// MFAPolicy decides which users must use multi-factor authentication.
+type MFAPolicy struct {
+	// RequiredRoles lists the roles whose holders cannot log in without MFA.
+	RequiredRoles []string
+	// Issuer is shown by authenticator apps next to the account name.
+	Issuer string
+}
+
+// MFAStatus describes the multi-factor authentication of a user.
+type MFAStatus struct {
+	Enabled                bool       `json:"enabled"`
+	Required               bool       `json:"required"`
+	EnrolledAt             *time.Time `json:"enrolled_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// MFAEnrollment is returned when a user starts enrolling an authenticator app.
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// mfaState is the second factor of a user. Recovery codes are stored as SHA-256 hashes.
+type mfaState struct {
+	secret        string
+	pendingSecret string
+	lastCounter   int64
+	recoveryCodes []string
+	enrolledAt    *time.Time
+}
+
+func (m *mfaState) enabled() bool {
+	return m.secret != ""
+}
+
+// recoveryCodeCount is the number of recovery codes issued on enrollment.
+const recoveryCodeCount = 10
+
+// Audit event types emitted for multi-factor authentication.
+const (
+	AuditMFAEnrolled         = "mfa.enrolled"
+	AuditMFAReset            = "mfa.reset"
+	AuditMFARecoveryCodeUsed = "mfa.recovery_code_used"
+)
+
+var (
+	mfaPolicyMu sync.RWMutex
+	mfaPolicy   = MFAPolicy{Issuer: "ZPE Cloud"}
+)
+
+// SetMFAPolicy installs the MFA policy. It rejects unknown roles.
+func SetMFAPolicy(p MFAPolicy) error {
+	for _, role := range p.RequiredRoles {
+		if !isRoleExists(role) {
+			return fmt.Errorf("%w: %s", internalErrors.ErrInvalidRole, role)
+		}
+	}
+	mfaPolicyMu.Lock()
+	defer mfaPolicyMu.Unlock()
+	mfaPolicy = p
+	return nil
+}
+
+func currentMFAPolicy() MFAPolicy {
+	mfaPolicyMu.RLock()
+	defer mfaPolicyMu.RUnlock()
+	return mfaPolicy
+}
+
+// mfaRequired reports whether any effective role of user is forced to use MFA.
+// The caller must hold mu.
+func mfaRequired(user *User) bool {
+	required := currentMFAPolicy().RequiredRoles
+	for _, role := range effectiveRoles(user) {
+		if containsString(required, role.Role) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMFAStatus returns the MFA status of a user.
+func GetMFAStatus(orgID, id string) (*MFAStatus, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	return &MFAStatus{
+		Enabled:                user.mfa.enabled(),
+		Required:               mfaRequired(user),
+		EnrolledAt:             user.mfa.enrolledAt,
+		RecoveryCodesRemaining: len(user.mfa.recoveryCodes),
+	}, nil
+}
+
+// StartMFAEnrollment creates a new pending TOTP secret for a user. It replaces any
+// earlier pending secret; the enrollment only takes effect once confirmed with a code.
+func StartMFAEnrollment(orgID, id string) (*MFAEnrollment, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	if user.mfa.enabled() {
+		return nil, internalErrors.ErrMFAAlreadyEnabled
+	}
+
+	user.mfa.pendingSecret = totp.GenerateSecret()
+	return &MFAEnrollment{
+		Secret:     user.mfa.pendingSecret,
+		OTPAuthURI: totp.URI(currentMFAPolicy().Issuer, user.Email, user.mfa.pendingSecret),
+	}, nil
+}
+
+// ConfirmMFAEnrollment activates the pending secret of a user when code matches it and
+// returns the recovery codes, which are only shown this once.
+func ConfirmMFAEnrollment(orgID, id, code string) ([]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	if user.mfa.enabled() {
+		return nil, internalErrors.ErrMFAAlreadyEnabled
+	}
+	if user.mfa.pendingSecret == "" {
+		return nil, internalErrors.ErrMFAEnrollmentNotStarted
+	}
+	now := time.Now().UTC()
+	counter, ok := totp.Validate(user.mfa.pendingSecret, code, now)
+	if !ok {
+		return nil, internalErrors.ErrInvalidMFACode
+	}
+
+	codes, hashes := newRecoveryCodes()
+	user.mfa = mfaState{
+		secret:        user.mfa.pendingSecret,
+		lastCounter:   counter,
+		recoveryCodes: hashes,
+		enrolledAt:    &now,
+	}
+	user.touch(id, now)
+	audit.Record(audit.Event{Type: AuditMFAEnrolled, OrgID: orgID, Actor: id, Target: id, Details: map[string]string{"method": "totp"}})
+	return codes, nil
+}
+
+// ResetMFA removes the second factor of a user, who has to enroll again.
+func ResetMFA(orgID, id, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return internalErrors.ErrUserNotFound
+	}
+	if !user.mfa.enabled() {
+		return internalErrors.ErrMFANotEnabled
+	}
+
+	user.mfa = mfaState{}
+	user.touch(actor, time.Now().UTC())
+	audit.Record(audit.Event{Type: AuditMFAReset, OrgID: orgID, Actor: actor, Target: id})
+	return nil
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code. Used TOTP
+// steps and recovery codes cannot be used again. The caller must hold mu.
+func verifySecondFactor(user *User, code, recoveryCode string, now time.Time) (string, error) {
+	if code != "" {
+		counter, ok := totp.Validate(user.mfa.secret, code, now)
+		if !ok || counter <= user.mfa.lastCounter {
+			return "", internalErrors.ErrInvalidMFACode
+		}
+		user.mfa.lastCounter = counter
+		return "otp", nil
+	}
+	if recoveryCode != "" {
+		hash := hashRecoveryCode(recoveryCode)
+		for i, stored := range user.mfa.recoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+				user.mfa.recoveryCodes = append(user.mfa.recoveryCodes[:i:i], user.mfa.recoveryCodes[i+1:]...)
+				audit.Record(audit.Event{
+					Type:    AuditMFARecoveryCodeUsed,
+					OrgID:   user.OrgID,
+					Actor:   user.ID,
+					Target:  user.ID,
+					Details: map[string]string{"remaining": fmt.Sprint(len(user.mfa.recoveryCodes))},
+				})
+				return "recovery_code", nil
+			}
+		}
+		return "", internalErrors.ErrInvalidMFACode
+	}
+	return "", internalErrors.ErrMFARequired
+}
+
+// newRecoveryCodes returns fresh recovery codes and their hashes.
+func newRecoveryCodes() ([]string, []string) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			panic(fmt.Sprintf("user: reading random bytes: %v", err))
+		}
+		raw := hex.EncodeToString(b)
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes
+}
+
+// hashRecoveryCode hashes a recovery code. The codes are random, so a fast hash suffices.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
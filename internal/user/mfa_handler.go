@@ -0,0 +1,196 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// HandleUserMFA handles HTTP requests for /users/{id}/mfa, /users/{id}/mfa/totp and
+// /users/{id}/mfa/totp/confirm.
+func HandleUserMFA(w http.ResponseWriter, r *http.Request) {
+	_, action := splitUserPath(r.URL.Path)
+	switch action {
+	case "mfa":
+		switch r.Method {
+		case http.MethodGet:
+			HandleGetMFAStatus(w, r)
+		case http.MethodDelete:
+			HandleResetMFA(w, r)
+		default:
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+		}
+	case "mfa/totp", "mfa/totp/confirm":
+		if r.Method != http.MethodPost {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		if action == "mfa/totp" {
+			HandleStartMFAEnrollment(w, r)
+		} else {
+			HandleConfirmMFAEnrollment(w, r)
+		}
+	default:
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+	}
+}
+
+// isSelf reports whether the caller identified by X-User-ID is the user with the given ID.
+func isSelf(r *http.Request, id string) bool {
+	return id != "" && r.Header.Get("X-User-ID") == id
+}
+
+// isSessionSelf reports whether the caller is the user with the given ID and proved it
+// with a session token. X-Session-ID is only set by AuthMiddleware, unlike X-User-ID,
+// which a client can send.
+func isSessionSelf(r *http.Request, id string) bool {
+	return isSelf(r, id) && r.Header.Get("X-Session-ID") != ""
+}
+
+func HandleGetMFAStatus(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+	if !isSessionSelf(r, id) {
+		targetUserRole, err := getUserTypeByID(orgID, id)
+		if err != nil {
+			errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+			log.Printf("User not found: %s", id)
+			return
+		}
+		if !checkUserPermission(w, r, orgID, "users.mfa_status", id, currentUserRole, targetUserRole) {
+			return
+		}
+	}
+
+	status, err := GetMFAStatus(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: User %s", id)
+		return
+	}
+	jsonResponse(w, http.StatusOK, status)
+}
+
+// HandleStartMFAEnrollment starts TOTP enrollment. Only users themselves, logged in with
+// a session, can enroll.
+func HandleStartMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+	if !isSessionSelf(r, id) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserID=%s attempted to enroll MFA for user %s", r.Header.Get("X-User-ID"), id)
+		return
+	}
+
+	enrollment, err := StartMFAEnrollment(orgID, id)
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrMFAAlreadyEnabled) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("MFA enrollment not started for user %s: %v", id, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, enrollment)
+	log.Printf("MFA enrollment started for user %s", id)
+}
+
+func HandleConfirmMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+	if !isSessionSelf(r, id) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserID=%s attempted to confirm MFA for user %s", r.Header.Get("X-User-ID"), id)
+		return
+	}
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	codes, err := ConfirmMFAEnrollment(orgID, id, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, internalMsgs.ErrInvalidMFACode):
+			errResponse(w, http.StatusBadRequest, err)
+		case errors.Is(err, internalMsgs.ErrMFAAlreadyEnabled), errors.Is(err, internalMsgs.ErrMFAEnrollmentNotStarted):
+			errResponse(w, http.StatusConflict, err)
+		default:
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("MFA enrollment not confirmed for user %s: %v", id, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+	log.Printf("MFA enabled for user %s", id)
+}
+
+// HandleResetMFA removes the second factor of another user, for example after a lost
+// phone. The caller must outrank or equal the user in the role hierarchy and cannot
+// reset their own MFA.
+func HandleResetMFA(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to reset MFA", currentUserRole)
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+	if isSelf(r, id) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: user %s attempted to reset their own MFA", id)
+		return
+	}
+
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+	if !checkUserPermission(w, r, orgID, "users.reset_mfa", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	if err := ResetMFA(orgID, id, currentActor(r)); err != nil {
+		if errors.Is(err, internalMsgs.ErrMFANotEnabled) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("MFA not reset for user %s: %v", id, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("UserType=%s reset MFA of user %s", currentUserRole, id)
+}
@@ -0,0 +1,138 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/password"
+	"zpe-cloud-user-management-service/internal/totp"
+)
+
+// setPassword gives a stored user a password without going through a token flow.
+func setPassword(t *testing.T, id, pw string) {
+	t.Helper()
+	hash, err := password.Hash(pw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	users[id].passwordHash = hash
+}
+
+func TestMFAAndLogin(t *testing.T) {
+	setupTestStorageWithUsers()
+	setPassword(t, "1", "leia password")
+	setPassword(t, "3", "r2-d2 password")
+	if err := SetMFAPolicy(MFAPolicy{RequiredRoles: []string{"Admin"}, Issuer: "Test"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetMFAPolicy(MFAPolicy{Issuer: "ZPE Cloud"})
+	SetLockoutPolicy(LockoutPolicy{})
+	defer SetLockoutPolicy(defaultLockoutPolicy)
+
+	login := func(creds Credentials) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(creds)
+		return doRequest(t, "POST", "/login", nil, string(body))
+	}
+
+	if rr := login(Credentials{Email: "r2-d2@example.com", Password: "r2-d2 password"}); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"amr":["pwd"]`) {
+		t.Errorf("expected password login to succeed, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := login(Credentials{Email: "r2-d2@example.com", Password: "wrong password"}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected wrong password to be rejected, got %v", rr.Code)
+	}
+	if rr := login(Credentials{Email: "yoda@example.com", Password: "r2-d2 password"}); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected unknown user to be rejected, got %v", rr.Code)
+	}
+	rr := login(Credentials{Email: "leia@example.com", Password: "leia password"})
+	var enrolling MFAEnrollmentLoginResponse
+	if err := json.NewDecoder(rr.Body).Decode(&enrolling); err != nil || rr.Code != http.StatusForbidden || enrolling.LoginResponse == nil {
+		t.Fatalf("expected Admin without MFA to be asked to enroll, got %v: %v", rr.Code, err)
+	}
+	session := map[string]string{"Authorization": "Bearer " + enrolling.Token}
+
+	if rr := doRequest(t, "GET", "/users", session, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected the enrollment session to be limited to enrollment, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", "/users/1/mfa/totp", callerHeaders("Admin", "6"), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected enrollment for someone else to be forbidden, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", "/users/1/mfa/totp", callerHeaders("Admin", "1"), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected enrollment without a session to be forbidden, got %v", rr.Code)
+	}
+	rr = doRequest(t, "POST", "/users/1/mfa/totp", session, "")
+	var enrollment MFAEnrollment
+	if err := json.NewDecoder(rr.Body).Decode(&enrollment); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("expected enrollment to start, got %v: %v", rr.Code, err)
+	}
+	if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/Test:leia@example.com?") {
+		t.Errorf("unexpected otpauth URI: %s", enrollment.OTPAuthURI)
+	}
+
+	if rr := doRequest(t, "POST", "/users/1/mfa/totp/confirm", session, `{"code":"abc"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid code to be rejected, got %v", rr.Code)
+	}
+	now := time.Now()
+	code, _ := totp.Code(enrollment.Secret, totp.Counter(now))
+	rr = doRequest(t, "POST", "/users/1/mfa/totp/confirm", session, `{"code":"`+code+`"}`)
+	var confirmed map[string][]string
+	if err := json.NewDecoder(rr.Body).Decode(&confirmed); err != nil || len(confirmed["recovery_codes"]) != 10 {
+		t.Fatalf("expected 10 recovery codes, got %v: %v", rr.Code, err)
+	}
+	recoveryCode := confirmed["recovery_codes"][0]
+
+	nextCode, _ := totp.Code(enrollment.Secret, totp.Counter(now)+1)
+	tests := []struct {
+		name           string
+		creds          Credentials
+		expectedStatus int
+		expectedAMR    string
+	}{
+		{name: "Second factor is required", creds: Credentials{Email: "leia@example.com", Password: "leia password"}, expectedStatus: http.StatusUnauthorized},
+		{name: "Used code is rejected", creds: Credentials{Email: "leia@example.com", Password: "leia password", MFACode: code}, expectedStatus: http.StatusUnauthorized},
+		{name: "Wrong password with valid code", creds: Credentials{Email: "leia@example.com", Password: "wrong password", MFACode: nextCode}, expectedStatus: http.StatusUnauthorized},
+		{name: "Login with TOTP", creds: Credentials{Email: "leia@example.com", Password: "leia password", MFACode: nextCode}, expectedStatus: http.StatusOK, expectedAMR: `"amr":["pwd","otp"]`},
+		{name: "Login with recovery code", creds: Credentials{Email: "leia@example.com", Password: "leia password", RecoveryCode: strings.ToUpper(recoveryCode)}, expectedStatus: http.StatusOK, expectedAMR: `"amr":["pwd","recovery_code"]`},
+		{name: "Recovery code is single-use", creds: Credentials{Email: "leia@example.com", Password: "leia password", RecoveryCode: recoveryCode}, expectedStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := login(tt.creds)
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", status, tt.expectedStatus, rr.Body.String())
+			}
+			if tt.expectedAMR != "" && !strings.Contains(rr.Body.String(), tt.expectedAMR) {
+				t.Errorf("expected %s in %s", tt.expectedAMR, rr.Body.String())
+			}
+		})
+	}
+
+	if rr := doRequest(t, "GET", "/users", session, ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the session to be usable once enrolled, got %v", rr.Code)
+	}
+	rr = doRequest(t, "GET", "/users/1/mfa", session, "")
+	var status MFAStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if !status.Enabled || !status.Required || status.RecoveryCodesRemaining != 9 {
+		t.Errorf("unexpected MFA status: %+v", status)
+	}
+
+	if rr := doRequest(t, "DELETE", "/users/1/mfa", callerHeaders("Modifier", "2"), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected Modifier not to reset MFA, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "DELETE", "/users/1/mfa", callerHeaders("Admin", "1"), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected Admin not to reset their own MFA, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "DELETE", "/users/1/mfa", callerHeaders("Admin", "6"), ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected another Admin to reset MFA, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, "DELETE", "/users/1/mfa", callerHeaders("Admin", "6"), ""); rr.Code != http.StatusConflict {
+		t.Errorf("expected a second reset to conflict, got %v", rr.Code)
+	}
+}
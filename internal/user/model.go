@@ -3,17 +3,85 @@ package user
 import (
 	"errors"
 	"strings"
+	"time"
 )
 
 // User represents a user in the system with ID, Name, Email, and Roles.
+// The metadata fields are maintained by the store and bumped on every mutation.
+// Soft-deleted users keep their record with DeletedAt and DeletedBy set until they are purged.
 type User struct {
-	ID    string   `json:"id"`
-	Name  string   `json:"name"`
-	Email string   `json:"email"`
-	Roles []string `json:"roles"`
+	ID    string `json:"id"`
+	OrgID string `json:"org_id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// EmailVerified is set once the user has proven control of Email.
+	EmailVerified bool     `json:"email_verified"`
+	Roles         []string `json:"roles"`
+	// Status is UserStatusActive, UserStatusInvited until an invitation is accepted, or
+	// UserStatusDisabled while an identity provider has deactivated the user.
+	Status string `json:"status"`
+	// RoleGrants holds additional roles that are only effective within a time window.
+	RoleGrants []RoleGrant `json:"role_grants,omitempty"`
+	// Attributes holds custom profile fields governed by the attribute schema.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+	CreatedBy  string                 `json:"created_by"`
+	UpdatedBy  string                 `json:"updated_by"`
+	Version    int64                  `json:"version"`
+	DeletedAt  *time.Time             `json:"deleted_at,omitempty"`
+	DeletedBy  string                 `json:"deleted_by,omitempty"`
+
+	// externalID is the identifier an identity provider assigned to the user over SCIM.
+	externalID string
+	// emailKey is the canonical form of Email under which the store indexes the user.
+	emailKey string
+	// passwordHash is the encoded hash of the user's password, empty until one is set.
+	passwordHash string
+	// verificationTokenID and resetTokenID identify the only email verification and
+	// password reset tokens that are still redeemable.
+	verificationTokenID string
+	resetTokenID        string
+	// mfa holds the user's second factor; it is only exposed through the MFA endpoints.
+	mfa mfaState
+}
+
+// User statuses.
+const (
+	UserStatusActive   = "active"
+	UserStatusInvited  = "invited"
+	UserStatusDisabled = "disabled"
+)
+
+// touch records a mutation by actor at the given time and increments the version.
+func (u *User) touch(actor string, at time.Time) {
+	u.UpdatedAt = at
+	u.UpdatedBy = actor
+	u.Version++
+}
+
+// clone returns a copy of the user that does not share mutable state with the stored record.
+func (u *User) clone() *User {
+	c := *u
+	c.Roles = append([]string(nil), u.Roles...)
+	c.RoleGrants = append([]RoleGrant(nil), u.RoleGrants...)
+	c.mfa.recoveryCodes = append([]string(nil), u.mfa.recoveryCodes...)
+	if u.Attributes != nil {
+		c.Attributes = make(map[string]interface{}, len(u.Attributes))
+		for k, v := range u.Attributes {
+			c.Attributes[k] = v
+		}
+	}
+	return &c
+}
+
+// IsDeleted reports whether the user has been soft-deleted.
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
 }
 
-// ValidateRequiredFields checks that the user has all necessary fields filled out.
+// ValidateRequiredFields checks that the user has all necessary fields filled out
+// and that the email address is valid for this deployment.
 func (u *User) ValidateRequiredFields() error {
 	var missingFields []string
 
@@ -30,5 +98,5 @@ func (u *User) ValidateRequiredFields() error {
 	if len(missingFields) > 0 {
 		return errors.New("fields required: " + strings.Join(missingFields, ", "))
 	}
-	return nil
+	return validateEmail(u.Email)
 }
@@ -0,0 +1,45 @@
+package user
+
+import (
+	"fmt"
+	"sync"
+	"zpe-cloud-user-management-service/internal/mail"
+	"zpe-cloud-user-management-service/internal/token"
+)
+
+// tokenSigner signs the tokens embedded in emailed links, and mailer delivers the emails.
+var (
+	notifyMu    sync.RWMutex
+	tokenSigner = token.NewRandomSigner()
+	mailer      mail.Sender
+)
+
+// SetTokenSigner installs the signer used for invitation, verification and reset tokens.
+func SetTokenSigner(s *token.Signer) {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	tokenSigner = s
+}
+
+// SetMailer installs the sender used for user notifications.
+func SetMailer(m mail.Sender) {
+	notifyMu.Lock()
+	defer notifyMu.Unlock()
+	mailer = m
+}
+
+func currentTokenSigner() *token.Signer {
+	notifyMu.RLock()
+	defer notifyMu.RUnlock()
+	return tokenSigner
+}
+
+func sendMail(msg mail.Message) error {
+	notifyMu.RLock()
+	m := mailer
+	notifyMu.RUnlock()
+	if m == nil {
+		return fmt.Errorf("no mail sender configured")
+	}
+	return m.Send(msg)
+}
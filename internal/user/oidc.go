@@ -0,0 +1,364 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	"zpe-cloud-user-management-service/internal/jwt"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+	"zpe-cloud-user-management-service/internal/token"
+)
+
+// OIDCClient is a relying party allowed to use the OpenID Connect provider. Clients
+// without a secret are public clients; all clients must use PKCE.
+type OIDCClient struct {
+	ID           string   `json:"client_id"`
+	Secret       string   `json:"client_secret,omitempty"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// OIDCConfig configures the OpenID Connect provider.
+type OIDCConfig struct {
+	// Issuer is the public base URL of this service, used as the "iss" claim.
+	Issuer  string
+	Clients []OIDCClient
+	// TokenTTL is the lifetime of issued access and ID tokens.
+	TokenTTL time.Duration
+}
+
+// IDTokenClaims are the claims of an ID token.
+type IDTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      string   `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	AuthTime      int64    `json:"auth_time"`
+	Nonce         string   `json:"nonce,omitempty"`
+	Methods       []string `json:"amr"`
+	SessionID     string   `json:"sid"`
+	OrgID         string   `json:"org_id"`
+	Roles         []string `json:"roles"`
+	Name          string   `json:"name,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified *bool    `json:"email_verified,omitempty"`
+}
+
+// AccessTokenClaims are the claims of an access token. Its audience is the issuer
+// itself, which keeps ID tokens from being accepted as access tokens. The token is only
+// valid while the session it was issued for is.
+type AccessTokenClaims struct {
+	ID        string `json:"jti"`
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	ExpiresAt int64  `json:"exp"`
+	IssuedAt  int64  `json:"iat"`
+	ClientID  string `json:"client_id"`
+	Scope     string `json:"scope"`
+	OrgID     string `json:"org_id"`
+	SessionID string `json:"sid"`
+}
+
+// TokenResponse is returned by the token endpoint.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// AuthorizationRequest holds the parameters of an authorization request.
+type AuthorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// authorizationCode is a pending authorization, redeemed once at the token endpoint.
+type authorizationCode struct {
+	request   AuthorizationRequest
+	userID    string
+	orgID     string
+	methods   []string
+	sessionID string
+	authTime  time.Time
+	expiresAt time.Time
+}
+
+// authorizationCodeTTL is how long an authorization code can be redeemed.
+const authorizationCodeTTL = time.Minute
+
+// Audit event types emitted by the OpenID Connect provider.
+const (
+	AuditOIDCTokenIssued = "oidc.token_issued"
+)
+
+var (
+	oidcMu     sync.RWMutex
+	oidcConfig OIDCConfig
+	oidcKeys   *jwt.KeySet
+)
+
+// authorizationCodes is guarded by mu.
+var authorizationCodes = make(map[string]*authorizationCode)
+
+// SetOIDCProvider enables the OpenID Connect provider with the given configuration and
+// signing keys. A nil key set disables it.
+func SetOIDCProvider(cfg OIDCConfig, keys *jwt.KeySet) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	oidcConfig = cfg
+	oidcKeys = keys
+}
+
+func currentOIDCProvider() (OIDCConfig, *jwt.KeySet) {
+	oidcMu.RLock()
+	defer oidcMu.RUnlock()
+	return oidcConfig, oidcKeys
+}
+
+// oidcProviderEnabled reports whether the OpenID Connect provider is configured.
+func oidcProviderEnabled() bool {
+	_, keys := currentOIDCProvider()
+	return keys != nil
+}
+
+// StartOIDCKeyRotation runs a background loop that rotates the signing key every
+// interval until ctx is cancelled.
+func StartOIDCKeyRotation(ctx context.Context, keys *jwt.KeySet, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := keys.Rotate(); err != nil {
+					log.Printf("Failed to rotate OIDC signing key: %v", err)
+					continue
+				}
+				log.Printf("Rotated OIDC signing key, now %s", keys.KeyIDs()[0])
+			}
+		}
+	}()
+}
+
+func (cfg OIDCConfig) client(id string) (OIDCClient, bool) {
+	for _, c := range cfg.Clients {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return OIDCClient{}, false
+}
+
+// ValidateClient checks the client and redirect URI of an authorization request. Errors
+// from here must not be reported to the redirect URI, since it is not trusted yet.
+func (cfg OIDCConfig) ValidateClient(req AuthorizationRequest) error {
+	client, ok := cfg.client(req.ClientID)
+	if !ok {
+		return internalErrors.ErrInvalidClient
+	}
+	if !containsString(client.RedirectURIs, req.RedirectURI) {
+		return internalErrors.ErrInvalidRedirectURI
+	}
+	return nil
+}
+
+// Validate checks the remaining parameters of an authorization request.
+func (req AuthorizationRequest) Validate() error {
+	if !containsString(strings.Fields(req.Scope), "openid") {
+		return fmt.Errorf("%w: scope must include openid", internalErrors.ErrInvalidAuthorizationRequest)
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return fmt.Errorf("%w: PKCE with code_challenge_method S256 is required", internalErrors.ErrInvalidAuthorizationRequest)
+	}
+	return nil
+}
+
+// IssueAuthorizationCode stores an authorization for an authenticated user and returns
+// the code the client redeems at the token endpoint.
+func IssueAuthorizationCode(req AuthorizationRequest, auth *Authentication) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("user: reading random bytes: %v", err))
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for c, pending := range authorizationCodes {
+		if !now.Before(pending.expiresAt) {
+			delete(authorizationCodes, c)
+		}
+	}
+	authorizationCodes[code] = &authorizationCode{
+		request:   req,
+		userID:    auth.UserID,
+		orgID:     auth.OrgID,
+		methods:   append([]string{}, auth.Methods...),
+		sessionID: auth.SessionID,
+		authTime:  now,
+		expiresAt: now.Add(authorizationCodeTTL),
+	}
+	return code
+}
+
+// ExchangeAuthorizationCode redeems an authorization code and issues an access token
+// and an ID token. The code is consumed even when the exchange fails, so that a leaked
+// code cannot be retried.
+func ExchangeAuthorizationCode(clientID, clientSecret, code, redirectURI, codeVerifier string) (*TokenResponse, error) {
+	cfg, keys := currentOIDCProvider()
+	client, ok := cfg.client(clientID)
+	if !ok || (client.Secret != "" && subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1) {
+		return nil, internalErrors.ErrInvalidClient
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	pending, exists := authorizationCodes[code]
+	delete(authorizationCodes, code)
+	now := time.Now()
+	if !exists || !now.Before(pending.expiresAt) || pending.request.ClientID != clientID || pending.request.RedirectURI != redirectURI {
+		return nil, internalErrors.ErrInvalidGrant
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != pending.request.CodeChallenge {
+		return nil, internalErrors.ErrInvalidGrant
+	}
+	user, err := useSession(pending.orgID, pending.sessionID, now.UTC())
+	if err != nil || user.ID != pending.userID {
+		return nil, internalErrors.ErrInvalidGrant
+	}
+
+	expiresAt := now.Add(cfg.TokenTTL)
+	scopes := strings.Fields(pending.request.Scope)
+	idClaims := IDTokenClaims{
+		Issuer:    cfg.Issuer,
+		Subject:   user.ID,
+		Audience:  clientID,
+		ExpiresAt: expiresAt.Unix(),
+		IssuedAt:  now.Unix(),
+		AuthTime:  pending.authTime.Unix(),
+		Nonce:     pending.request.Nonce,
+		Methods:   pending.methods,
+		SessionID: pending.sessionID,
+		OrgID:     user.OrgID,
+		Roles:     effectiveRoleNames(user),
+	}
+	if containsString(scopes, "profile") {
+		idClaims.Name = user.Name
+	}
+	if containsString(scopes, "email") {
+		verified := user.EmailVerified
+		idClaims.Email, idClaims.EmailVerified = user.Email, &verified
+	}
+	idToken, err := keys.Sign(idClaims)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := keys.Sign(AccessTokenClaims{
+		ID:        token.NewID(),
+		Issuer:    cfg.Issuer,
+		Subject:   user.ID,
+		Audience:  cfg.Issuer,
+		ExpiresAt: expiresAt.Unix(),
+		IssuedAt:  now.Unix(),
+		ClientID:  clientID,
+		Scope:     pending.request.Scope,
+		OrgID:     user.OrgID,
+		SessionID: pending.sessionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	audit.Record(audit.Event{Type: AuditOIDCTokenIssued, OrgID: user.OrgID, Actor: user.ID, Target: clientID, Details: map[string]string{"scope": pending.request.Scope, "amr": strings.Join(pending.methods, ",")}})
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(cfg.TokenTTL / time.Second),
+		IDToken:     idToken,
+		Scope:       pending.request.Scope,
+	}, nil
+}
+
+// VerifyAccessToken checks an access token issued by this provider and that its session
+// is still active, and returns its claims.
+func VerifyAccessToken(tok string) (*AccessTokenClaims, error) {
+	cfg, keys := currentOIDCProvider()
+	if keys == nil {
+		return nil, internalErrors.ErrInvalidToken
+	}
+	var claims AccessTokenClaims
+	if err := keys.Verify(tok, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Issuer != cfg.Issuer || claims.Audience != cfg.Issuer {
+		return nil, internalErrors.ErrInvalidToken
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if user, err := useSession(claims.OrgID, claims.SessionID, time.Now().UTC()); err != nil || user.ID != claims.Subject {
+		return nil, internalErrors.ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// UserInfo returns the current claims about the user an access token was issued for,
+// limited to the scopes granted to it.
+func UserInfo(claims *AccessTokenClaims) (map[string]any, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(claims.OrgID, claims.Subject)
+	if !exists || user.IsDeleted() || user.Status != UserStatusActive {
+		return nil, internalErrors.ErrInvalidToken
+	}
+	info := map[string]any{
+		"sub":    user.ID,
+		"org_id": user.OrgID,
+		"roles":  effectiveRoleNames(user),
+	}
+	scopes := strings.Fields(claims.Scope)
+	if containsString(scopes, "profile") {
+		info["name"] = user.Name
+	}
+	if containsString(scopes, "email") {
+		info["email"] = user.Email
+		info["email_verified"] = user.EmailVerified
+	}
+	return info, nil
+}
+
+// effectiveRoleNames returns the names of the effective roles of user, highest first.
+// The caller must hold mu.
+func effectiveRoleNames(user *User) []string {
+	roles := effectiveRoles(user)
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Role)
+	}
+	return names
+}
@@ -0,0 +1,280 @@
+package user
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"zpe-cloud-user-management-service/internal/jwt"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// loginPage is shown by the authorization endpoint. The authorization request is carried
+// along in hidden fields and submitted together with the credentials.
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth2/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>Email <input type="email" name="email" value="{{.Email}}" required></label>
+<label>Password <input type="password" name="password" required></label>
+<label>Authentication code <input type="text" name="mfa_code" autocomplete="one-time-code"></label>
+<button type="submit">Sign in</button>
+</form>
+{{range .Providers}}<p><a href="{{.URL}}">Sign in with {{.Name}}</a></p>
+{{end}}</body>
+</html>
+`))
+
+type loginPageData struct {
+	Params    map[string]string
+	Providers []federationLink
+	Email     string
+	Error     string
+}
+
+// authorizationParams are the request parameters carried through the login form.
+var authorizationParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method", "org_id"}
+
+// HandleOIDCDiscovery serves /.well-known/openid-configuration.
+func HandleOIDCDiscovery(w http.ResponseWriter, r *http.Request) {
+	cfg, _, ok := oidcEnabled(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]any{
+		"issuer":                                cfg.Issuer,
+		"authorization_endpoint":                cfg.Issuer + "/oauth2/authorize",
+		"token_endpoint":                        cfg.Issuer + "/oauth2/token",
+		"userinfo_endpoint":                     cfg.Issuer + "/oauth2/userinfo",
+		"jwks_uri":                              cfg.Issuer + "/oauth2/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr", "org_id", "roles", "name", "email", "email_verified"},
+	})
+}
+
+// HandleJWKS serves the public signing keys at /oauth2/jwks.
+func HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	_, keys, ok := oidcEnabled(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	jsonResponse(w, http.StatusOK, keys.JWKS())
+}
+
+// HandleAuthorize serves /oauth2/authorize. GET shows the login form; POST checks the
+// credentials and redirects back to the client with an authorization code.
+func HandleAuthorize(w http.ResponseWriter, r *http.Request) {
+	cfg, _, ok := oidcEnabled(w, r, http.MethodGet, http.MethodPost)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	req, ok := parseAuthorizationRequest(w, r, cfg)
+	if !ok {
+		return
+	}
+
+	params := make(map[string]string)
+	for _, name := range authorizationParams {
+		if value := r.Form.Get(name); value != "" {
+			params[name] = value
+		}
+	}
+	orgID := r.Form.Get("org_id")
+	if orgID == "" {
+		orgID = DefaultOrgID
+	}
+	if r.Method == http.MethodGet {
+		renderLoginPage(w, http.StatusOK, loginPageData{Params: params, Providers: federationLinks(orgID, params)})
+		return
+	}
+
+	creds := Credentials{Email: r.Form.Get("email"), Password: r.Form.Get("password"), MFACode: r.Form.Get("mfa_code")}
+	auth, err := Authenticate(orgID, creds, r.RemoteAddr)
+	if err != nil {
+		status := http.StatusUnauthorized
+		if throttledStatus := loginThrottledStatus(w, err); throttledStatus != 0 {
+			status = throttledStatus
+		} else if errors.Is(err, internalMsgs.ErrMFAEnrollmentRequired) {
+			status = http.StatusForbidden
+		} else if !errors.Is(err, internalMsgs.ErrMFARequired) && !errors.Is(err, internalMsgs.ErrInvalidMFACode) && !errors.Is(err, internalMsgs.ErrInvalidCredentials) {
+			status = http.StatusInternalServerError
+		}
+		renderLoginPage(w, status, loginPageData{Params: params, Providers: federationLinks(orgID, params), Email: creds.Email, Error: err.Error()})
+		log.Printf("OIDC login for client %s failed: %v", req.ClientID, err)
+		return
+	}
+
+	if _, err := startSession(r, auth); err != nil {
+		renderLoginPage(w, http.StatusInternalServerError, loginPageData{Params: params, Email: creds.Email, Error: internalMsgs.ErrInternalServerError.Error()})
+		log.Printf("Failed to start a session for user %s: %v", auth.UserID, err)
+		return
+	}
+	code := IssueAuthorizationCode(req, auth)
+	redirect(w, r, req, url.Values{"code": {code}})
+	log.Printf("User %s authorized client %s", auth.UserID, req.ClientID)
+}
+
+// parseAuthorizationRequest reads the authorization request from the parsed form of r
+// and validates it. Requests from unknown clients or with unregistered redirect URIs are
+// answered directly, other invalid requests are redirected back to the client.
+func parseAuthorizationRequest(w http.ResponseWriter, r *http.Request, cfg OIDCConfig) (AuthorizationRequest, bool) {
+	req := AuthorizationRequest{
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		Nonce:               r.Form.Get("nonce"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+	}
+	if err := cfg.ValidateClient(req); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: authorization request from client %q: %v", req.ClientID, err)
+		return req, false
+	}
+	if r.Form.Get("response_type") != "code" {
+		redirectWithError(w, r, req, "unsupported_response_type", "only the code response type is supported")
+		return req, false
+	}
+	if err := req.Validate(); err != nil {
+		redirectWithError(w, r, req, "invalid_request", err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+// HandleToken serves /oauth2/token, exchanging an authorization code for tokens.
+func HandleToken(w http.ResponseWriter, r *http.Request) {
+	_, _, ok := oidcEnabled(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if grantType := r.PostForm.Get("grant_type"); grantType != "authorization_code" {
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant is supported")
+		log.Printf("BadRequest: unsupported grant type %q", grantType)
+		return
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if !basic {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	resp, err := ExchangeAuthorizationCode(clientID, clientSecret, r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	if err != nil {
+		switch {
+		case errors.Is(err, internalMsgs.ErrInvalidClient):
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+			oauthError(w, http.StatusUnauthorized, "invalid_client", err.Error())
+		case errors.Is(err, internalMsgs.ErrInvalidGrant):
+			oauthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		default:
+			oauthError(w, http.StatusInternalServerError, "server_error", internalMsgs.ErrInternalServerError.Error())
+		}
+		log.Printf("Token request from client %q failed: %v", clientID, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	jsonResponse(w, http.StatusOK, resp)
+	log.Printf("Tokens issued to client %s", clientID)
+}
+
+// HandleUserInfo serves /oauth2/userinfo for a bearer access token.
+func HandleUserInfo(w http.ResponseWriter, r *http.Request) {
+	_, _, ok := oidcEnabled(w, r, http.MethodGet, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	tok, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	claims, err := VerifyAccessToken(tok)
+	var info map[string]any
+	if err == nil {
+		info, err = UserInfo(claims)
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		errResponse(w, http.StatusUnauthorized, internalMsgs.ErrInvalidToken)
+		log.Printf("Unauthorized: userinfo request: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, info)
+}
+
+// oidcEnabled checks the request method and that the OpenID Connect provider is
+// configured, and returns the provider configuration.
+func oidcEnabled(w http.ResponseWriter, r *http.Request, methods ...string) (OIDCConfig, *jwt.KeySet, bool) {
+	cfg, keys := currentOIDCProvider()
+	if keys == nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s (OIDC provider disabled)", r.URL.Path)
+		return cfg, nil, false
+	}
+	if !containsString(methods, r.Method) {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return cfg, nil, false
+	}
+	return cfg, keys, true
+}
+
+func renderLoginPage(w http.ResponseWriter, status int, data loginPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := loginPage.Execute(w, data); err != nil {
+		log.Printf("Failed to render login page: %v", err)
+	}
+}
+
+// redirect sends the user agent back to the client's redirect URI with params and the state.
+func redirect(w http.ResponseWriter, r *http.Request, req AuthorizationRequest, params url.Values) {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRedirectURI)
+		return
+	}
+	query := target.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, req AuthorizationRequest, code, description string) {
+	redirect(w, r, req, url.Values{"error": {code}, "error_description": {description}})
+	log.Printf("Authorization request from client %s rejected: %s", req.ClientID, description)
+}
+
+// oauthError writes an error response in the format of RFC 6749 section 5.2.
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	jsonResponse(w, status, map[string]string{"error": code, "error_description": description})
+}
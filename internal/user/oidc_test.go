@@ -0,0 +1,255 @@
+package user
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/jwt"
+)
+
+// verifyWithJWKS checks the RS256 signature of tok against the key set published at
+// jwksURL, as a relying party would, and decodes its claims into claims.
+func verifyWithJWKS(t *testing.T, client *http.Client, jwksURL, tok string, claims any) {
+	t.Helper()
+	resp, err := client.Get(jwksURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var set jwt.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed token: %s", tok)
+	}
+	var header struct {
+		Kid string `json:"kid"`
+	}
+	headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	json.Unmarshal(headerJSON, &header)
+	for _, key := range set.Keys {
+		if key.Kid != header.Kid {
+			continue
+		}
+		n, _ := base64.RawURLEncoding.DecodeString(key.N)
+		e, _ := base64.RawURLEncoding.DecodeString(key.E)
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], sig); err != nil {
+			t.Fatalf("token signature does not verify: %v", err)
+		}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		if err := json.Unmarshal(payload, claims); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	t.Fatalf("key %s is not published", header.Kid)
+}
+
+func TestOIDCProvider(t *testing.T) {
+	setupTestStorageWithUsers()
+	setPassword(t, "3", "r2-d2 password")
+
+	server := httptest.NewServer(testHandler)
+	defer server.Close()
+
+	if rr := doRequest(t, "GET", "/.well-known/openid-configuration", nil, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected disabled provider to return 404, got %v", rr.Code)
+	}
+
+	keys, err := jwt.NewKeySet(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const redirectURI = "http://localhost:9999/callback"
+	SetOIDCProvider(OIDCConfig{
+		Issuer:   server.URL,
+		Clients:  []OIDCClient{{ID: "portal", Secret: "portal secret", RedirectURIs: []string{redirectURI}}},
+		TokenTTL: time.Hour,
+	}, keys)
+	defer SetOIDCProvider(OIDCConfig{}, nil)
+	SetLockoutPolicy(LockoutPolicy{})
+	defer SetLockoutPolicy(defaultLockoutPolicy)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(server.URL + "/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var discovery map[string]any
+	json.NewDecoder(resp.Body).Decode(&discovery)
+	resp.Body.Close()
+	if discovery["issuer"] != server.URL || discovery["token_endpoint"] != server.URL+"/oauth2/token" {
+		t.Fatalf("unexpected discovery document: %v", discovery)
+	}
+	authorizeURL := discovery["authorization_endpoint"].(string)
+	tokenURL := discovery["token_endpoint"].(string)
+	jwksURL := discovery["jwks_uri"].(string)
+	userinfoURL := discovery["userinfo_endpoint"].(string)
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"portal"},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid profile email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	with := func(name, value string) url.Values {
+		v := url.Values{}
+		for k, vs := range params {
+			v[k] = vs
+		}
+		v.Set(name, value)
+		return v
+	}
+
+	resp, _ = client.Get(authorizeURL + "?" + params.Encode())
+	page := new(bytes.Buffer)
+	page.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(page.String(), `name="code_challenge"`) {
+		t.Fatalf("expected login form, got %v: %s", resp.StatusCode, page.String())
+	}
+	if resp, _ := client.Get(authorizeURL + "?" + with("redirect_uri", "https://evil.example/cb").Encode()); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected unregistered redirect URI to be refused without redirecting, got %v", resp.StatusCode)
+	}
+	resp, _ = client.Get(authorizeURL + "?" + with("code_challenge_method", "plain").Encode())
+	if location := resp.Header.Get("Location"); resp.StatusCode != http.StatusFound || !strings.Contains(location, "error=invalid_request") || !strings.Contains(location, "state=xyz") {
+		t.Errorf("expected missing S256 PKCE to be reported to the client, got %v %s", resp.StatusCode, location)
+	}
+
+	login := func(pw string) *http.Response {
+		form := with("email", "r2-d2@example.com")
+		form.Set("password", pw)
+		resp, err := client.PostForm(authorizeURL, form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := login("wrong password"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected wrong password to show the form again, got %v", resp.StatusCode)
+	}
+	authorize := func() string {
+		resp := login("r2-d2 password")
+		location, err := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusFound || err != nil || location.Query().Get("state") != "xyz" {
+			t.Fatalf("expected redirect with code, got %v %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		return location.Query().Get("code")
+	}
+	exchange := func(code, secret, verifier string) (*http.Response, TokenResponse) {
+		req, _ := http.NewRequest("POST", tokenURL, strings.NewReader(url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("portal", secret)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var tokens TokenResponse
+		json.NewDecoder(resp.Body).Decode(&tokens)
+		return resp, tokens
+	}
+
+	code := authorize()
+	if resp, _ := exchange(code, "portal secret", "wrong verifier"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected wrong PKCE verifier to be rejected, got %v", resp.StatusCode)
+	}
+	if resp, _ := exchange(code, "portal secret", verifier); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected code to be consumed by a failed exchange, got %v", resp.StatusCode)
+	}
+
+	code = authorize()
+	if resp, _ := exchange(code, "wrong secret", verifier); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected wrong client secret to be rejected, got %v", resp.StatusCode)
+	}
+	resp, tokens := exchange(code, "portal secret", verifier)
+	if resp.StatusCode != http.StatusOK || tokens.TokenType != "Bearer" || tokens.IDToken == "" {
+		t.Fatalf("expected tokens, got %v: %+v", resp.StatusCode, tokens)
+	}
+	if resp, _ := exchange(code, "portal secret", verifier); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected code to be single-use, got %v", resp.StatusCode)
+	}
+
+	var idClaims IDTokenClaims
+	verifyWithJWKS(t, client, jwksURL, tokens.IDToken, &idClaims)
+	if idClaims.Issuer != server.URL || idClaims.Subject != "3" || idClaims.Audience != "portal" || idClaims.Nonce != "n-0S6_WzA2Mj" ||
+		!reflect.DeepEqual(idClaims.Roles, []string{"Watcher"}) || idClaims.Email != "r2-d2@example.com" || idClaims.Name != "R2-D2" {
+		t.Errorf("unexpected ID token claims: %+v", idClaims)
+	}
+
+	userinfo := func(tok string) (*http.Response, map[string]any) {
+		req, _ := http.NewRequest("GET", userinfoURL, nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var info map[string]any
+		json.NewDecoder(resp.Body).Decode(&info)
+		return resp, info
+	}
+	if resp, info := userinfo(tokens.AccessToken); resp.StatusCode != http.StatusOK || info["sub"] != "3" || info["email"] != "r2-d2@example.com" {
+		t.Errorf("unexpected userinfo response %v: %v", resp.StatusCode, info)
+	}
+	for _, path := range []string{"/users/3", "/users/3/sessions"} {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		req.Header.Set("X-User-Type", "Admin")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected the access token to be refused at %s, got %v", path, resp.StatusCode)
+		}
+	}
+	if resp, _ := userinfo(tokens.IDToken); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected ID token to be refused as an access token, got %v", resp.StatusCode)
+	}
+
+	if err := keys.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := userinfo(tokens.AccessToken); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected token signed before rotation to remain valid, got %v", resp.StatusCode)
+	}
+	verifyWithJWKS(t, client, jwksURL, tokens.IDToken, &idClaims)
+
+	if err := RevokeSession(DefaultOrgID, "3", idClaims.SessionID, "1"); err != nil {
+		t.Fatalf("expected the login to have a session: %v", err)
+	}
+	if resp, _ := userinfo(tokens.AccessToken); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the access token to stop working with its session, got %v", resp.StatusCode)
+	}
+}
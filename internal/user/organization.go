@@ -0,0 +1,163 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// DefaultOrgID is the organization used when a request does not name one.
+const DefaultOrgID = "default"
+
+// Organization is a customer tenant. Users, groups and attribute schemas belong to
+// exactly one organization and are invisible to the others.
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatedBy string    `json:"created_by"`
+	UpdatedBy string    `json:"updated_by"`
+}
+
+var orgIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// orgs is guarded by mu together with the user storage. The default organization always exists.
+var orgs = map[string]*Organization{DefaultOrgID: newDefaultOrganization()}
+
+// ValidateRequiredFields checks that the organization has a valid ID and a name.
+func (o *Organization) ValidateRequiredFields() error {
+	var missingFields []string
+	if o.ID == "" {
+		missingFields = append(missingFields, "id")
+	}
+	if strings.TrimSpace(o.Name) == "" {
+		missingFields = append(missingFields, "name")
+	}
+	if len(missingFields) > 0 {
+		return errors.New("fields required: " + strings.Join(missingFields, ", "))
+	}
+	if !orgIDPattern.MatchString(o.ID) {
+		return internalErrors.ErrInvalidOrganizationID
+	}
+	return nil
+}
+
+// newDefaultOrganization returns the organization that always exists.
+func newDefaultOrganization() *Organization {
+	now := time.Now().UTC()
+	return &Organization{ID: DefaultOrgID, Name: "Default", CreatedAt: now, UpdatedAt: now}
+}
+
+func CreateOrganization(org *Organization, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := orgs[org.ID]; exists {
+		return internalErrors.ErrOrganizationAlreadyExists
+	}
+
+	now := time.Now().UTC()
+	org.CreatedAt, org.UpdatedAt = now, now
+	org.CreatedBy, org.UpdatedBy = actor, actor
+	orgs[org.ID] = org
+	return nil
+}
+
+func GetOrganization(id string) (*Organization, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	org, exists := orgs[id]
+	if !exists {
+		return nil, internalErrors.ErrOrganizationNotFound
+	}
+	c := *org
+	return &c, nil
+}
+
+// OrganizationExists reports whether an organization with the given ID exists.
+func OrganizationExists(id string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, exists := orgs[id]
+	return exists
+}
+
+func ListOrganizations() []*Organization {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]*Organization, 0, len(orgs))
+	for _, org := range orgs {
+		c := *org
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func UpdateOrganization(id, name, actor string) (*Organization, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	org, exists := orgs[id]
+	if !exists {
+		return nil, internalErrors.ErrOrganizationNotFound
+	}
+	org.Name = name
+	org.UpdatedAt = time.Now().UTC()
+	org.UpdatedBy = actor
+	c := *org
+	return &c, nil
+}
+
+// DeleteOrganization removes an empty organization. The default organization and
+// organizations that still hold users (including soft-deleted ones) or groups cannot be deleted.
+func DeleteOrganization(id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := orgs[id]; !exists {
+		return internalErrors.ErrOrganizationNotFound
+	}
+	if id == DefaultOrgID {
+		return internalErrors.ErrOrganizationNotEmpty
+	}
+	for _, user := range users {
+		if user.OrgID == id {
+			return internalErrors.ErrOrganizationNotEmpty
+		}
+	}
+	for _, group := range groups {
+		if group.OrgID == id {
+			return internalErrors.ErrOrganizationNotEmpty
+		}
+	}
+	for _, account := range serviceAccounts {
+		if account.OrgID == id {
+			return internalErrors.ErrOrganizationNotEmpty
+		}
+	}
+
+	// Role change requests and invitations only refer to users that are gone by now.
+	for reqID, req := range roleChangeRequests {
+		if req.OrgID == id {
+			delete(roleChangeRequests, reqID)
+		}
+	}
+	for invID, inv := range invitations {
+		if inv.OrgID == id {
+			delete(invitations, invID)
+		}
+	}
+
+	delete(orgs, id)
+	deleteAttributeSchema(id)
+	return nil
+}
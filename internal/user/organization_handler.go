@@ -0,0 +1,172 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+type OrganizationRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// HandleOrganizations handles HTTP requests for the /orgs endpoint.
+func HandleOrganizations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListOrganizations(w, r)
+	case http.MethodPost:
+		HandleCreateOrganization(w, r)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+// HandleOrganization handles HTTP requests for a single organization at /orgs/{id}.
+func HandleOrganization(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleGetOrganization(w, r)
+	case http.MethodPut:
+		HandleUpdateOrganization(w, r)
+	case http.MethodDelete:
+		HandleDeleteOrganization(w, r)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+func HandleListOrganizations(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	if currentUserRole != "SuperAdmin" {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list organizations", currentUserRole)
+		return
+	}
+
+	list := ListOrganizations()
+	jsonResponse(w, http.StatusOK, list)
+	log.Printf("Organizations listed: %d organizations", len(list))
+}
+
+// HandleGetOrganization returns an organization to a SuperAdmin or to a member of that organization.
+func HandleGetOrganization(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	id := strings.TrimPrefix(r.URL.Path, "/orgs/")
+	callerOrgID := r.Header.Get("X-Org-ID")
+	if callerOrgID == "" {
+		callerOrgID = DefaultOrgID
+	}
+	if currentUserRole != "SuperAdmin" && (!isRoleExists(currentUserRole) || callerOrgID != id) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to get organization %s", currentUserRole, id)
+		return
+	}
+
+	org, err := GetOrganization(id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Organization %s", id)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, org)
+}
+
+func HandleCreateOrganization(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	if currentUserRole != "SuperAdmin" {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to create an organization", currentUserRole)
+		return
+	}
+
+	var req OrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	org := &Organization{ID: strings.TrimSpace(req.ID), Name: strings.TrimSpace(req.Name)}
+	if err := org.ValidateRequiredFields(); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	if err := CreateOrganization(org, currentActor(r)); err != nil {
+		errResponse(w, http.StatusConflict, err)
+		log.Printf("Conflict: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, map[string]string{
+		"id":      org.ID,
+		"message": "Organization created successfully",
+	})
+	log.Printf("Organization created: %s", org.ID)
+}
+
+func HandleUpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	if currentUserRole != "SuperAdmin" {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to update an organization", currentUserRole)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/orgs/")
+	var req OrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	candidate := &Organization{ID: id, Name: strings.TrimSpace(req.Name)}
+	if err := candidate.ValidateRequiredFields(); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	org, err := UpdateOrganization(id, candidate.Name, currentActor(r))
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Organization %s", id)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, org)
+	log.Printf("Organization updated: %s", id)
+}
+
+func HandleDeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	if currentUserRole != "SuperAdmin" {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to delete an organization", currentUserRole)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/orgs/")
+	if err := DeleteOrganization(id); err != nil {
+		if errors.Is(err, internalMsgs.ErrOrganizationNotEmpty) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("Organization deletion failed: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("UserType=%s deleted organization %s", currentUserRole, id)
+}
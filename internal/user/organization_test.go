@@ -0,0 +1,58 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestOrganizationIsolation(t *testing.T) {
+	setupTestStorageWithUsers()
+
+	headers := func(userType, orgID string) map[string]string {
+		h := callerHeaders(userType, "")
+		if orgID != "" {
+			h["X-Org-ID"] = orgID
+		}
+		return h
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		userType       string
+		orgID          string
+		body           string
+		expectedStatus int
+	}{
+		{name: "Admin cannot create organization", method: "POST", path: "/orgs", userType: "Admin", body: `{"id":"acme","name":"Acme"}`, expectedStatus: http.StatusForbidden},
+		{name: "SuperAdmin can create organization", method: "POST", path: "/orgs", userType: "SuperAdmin", body: `{"id":"acme","name":"Acme"}`, expectedStatus: http.StatusCreated},
+		{name: "Organization IDs are validated", method: "POST", path: "/orgs", userType: "SuperAdmin", body: `{"id":"Acme Corp","name":"Acme"}`, expectedStatus: http.StatusBadRequest},
+		{name: "Email is only unique per organization", method: "POST", path: "/users", userType: "Admin", orgID: "acme", body: `{"name":"Leia","email":"leia@example.com","roles":["Watcher"]}`, expectedStatus: http.StatusCreated},
+		{name: "Admin cannot create SuperAdmin", method: "POST", path: "/users", userType: "Admin", orgID: "acme", body: `{"name":"Root","email":"root@example.com","roles":["Watcher","SuperAdmin"]}`, expectedStatus: http.StatusForbidden},
+		{name: "Users of other organizations are invisible", method: "DELETE", path: "/users/2", userType: "Admin", orgID: "acme", expectedStatus: http.StatusNotFound},
+		{name: "Unknown organization is rejected", method: "GET", path: "/users", userType: "Admin", orgID: "globex", expectedStatus: http.StatusNotFound},
+		{name: "Member can read own organization", method: "GET", path: "/orgs/acme", userType: "Watcher", orgID: "acme", expectedStatus: http.StatusOK},
+		{name: "Member cannot read other organization", method: "GET", path: "/orgs/acme", userType: "Admin", expectedStatus: http.StatusForbidden},
+		{name: "Non-empty organization cannot be deleted", method: "DELETE", path: "/orgs/acme", userType: "SuperAdmin", expectedStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, tt.method, tt.path, headers(tt.userType, tt.orgID), tt.body)
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", status, tt.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+
+	rr := doRequest(t, "GET", "/users", headers("Watcher", "acme"), "")
+	var listed []User
+	if err := json.NewDecoder(rr.Body).Decode(&listed); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(listed) != 1 || listed[0].OrgID != "acme" {
+		t.Errorf("expected only the acme user, got %+v", listed)
+	}
+}
@@ -0,0 +1,27 @@
+package user
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// StartPurger runs a background loop that hard-deletes users whose soft deletion is older than retention.
+// It checks every interval and stops when ctx is cancelled.
+func StartPurger(ctx context.Context, interval, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if purged := PurgeDeletedUsers(time.Now().Add(-retention)); purged > 0 {
+					log.Printf("Purged %d deleted users", purged)
+				}
+			}
+		}
+	}()
+}
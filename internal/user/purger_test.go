@@ -0,0 +1,33 @@
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSoftDeletedUsersAreHiddenAndPurged(t *testing.T) {
+	setupTestStorageWithUsers()
+	if err := DeleteUser(DefaultOrgID, "2", "Admin", nil); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	if _, err := GetUser(DefaultOrgID, "2"); err == nil {
+		t.Errorf("expected deleted user to be hidden from GetUser")
+	}
+
+	active, _ := ListUsers(DefaultOrgID, ListOptions{})
+	all, _ := ListUsers(DefaultOrgID, ListOptions{IncludeDeleted: true})
+	if len(active) != 5 || len(all) != 6 {
+		t.Errorf("unexpected list sizes: active=%d all=%d", len(active), len(all))
+	}
+
+	if purged := PurgeDeletedUsers(time.Now().Add(-time.Hour)); purged != 0 {
+		t.Errorf("purged users before retention elapsed: %d", purged)
+	}
+	if purged := PurgeDeletedUsers(time.Now().Add(time.Second)); purged != 1 {
+		t.Errorf("expected one purged user, got %d", purged)
+	}
+	if _, err := RestoreUser(DefaultOrgID, "2", "Admin", nil); err == nil {
+		t.Errorf("expected purged user to be gone")
+	}
+}
@@ -0,0 +1,245 @@
+package user
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	"zpe-cloud-user-management-service/internal/mail"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+	"zpe-cloud-user-management-service/internal/password"
+	"zpe-cloud-user-management-service/internal/token"
+)
+
+// RecoveryConfig controls email verification and self-service password resets.
+type RecoveryConfig struct {
+	VerificationTTL time.Duration
+	// VerificationURL and ResetURL receive the token as their "token" query parameter.
+	VerificationURL string
+	ResetTTL        time.Duration
+	ResetURL        string
+	// ResetLimit is the number of reset emails sent per address within ResetWindow.
+	ResetLimit  int
+	ResetWindow time.Duration
+}
+
+// Token purposes for account recovery.
+const (
+	tokenPurposeEmailVerification = "email_verification"
+	tokenPurposePasswordReset     = "password_reset"
+)
+
+// Audit event types emitted for account recovery.
+const (
+	AuditEmailVerified        = "user.email_verified"
+	AuditPasswordResetRequest = "password_reset.requested"
+	AuditPasswordReset        = "password_reset.completed"
+)
+
+var (
+	recoveryMu     sync.RWMutex
+	recoveryConfig = RecoveryConfig{VerificationTTL: 48 * time.Hour, ResetTTL: time.Hour, ResetLimit: 3, ResetWindow: time.Hour}
+	resetLimiter   = newEmailRateLimiter(3, time.Hour)
+)
+
+// SetRecoveryConfig installs the recovery settings and resets the password reset rate limit.
+func SetRecoveryConfig(c RecoveryConfig) {
+	recoveryMu.Lock()
+	defer recoveryMu.Unlock()
+	recoveryConfig = c
+	resetLimiter = newEmailRateLimiter(c.ResetLimit, c.ResetWindow)
+}
+
+func currentRecoveryConfig() (RecoveryConfig, *emailRateLimiter) {
+	recoveryMu.RLock()
+	defer recoveryMu.RUnlock()
+	return recoveryConfig, resetLimiter
+}
+
+// emailRateLimiter allows a fixed number of events per key within a sliding window.
+type emailRateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newEmailRateLimiter(limit int, window time.Duration) *emailRateLimiter {
+	return &emailRateLimiter{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// allow records an event for key and reports whether it is within the limit. When it is
+// not, it also returns how long until the next event would be allowed.
+func (l *emailRateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= l.window {
+		l.sweep(now)
+	}
+	recent := l.hits[key][:0]
+	for _, at := range l.hits[key] {
+		if now.Sub(at) < l.window {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= l.limit {
+		l.hits[key] = recent
+		return false, recent[0].Add(l.window).Sub(now)
+	}
+	l.hits[key] = append(recent, now)
+	return true, 0
+}
+
+// sweep drops the keys without an event in the last window, which is the same as having
+// none. The caller must hold l.mu.
+func (l *emailRateLimiter) sweep(now time.Time) {
+	for key, hits := range l.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= l.window {
+			delete(l.hits, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// RequestEmailVerification issues a verification token for the user's current email
+// address. Earlier verification tokens stop working.
+func RequestEmailVerification(orgID, id string) (*User, string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return nil, "", internalErrors.ErrUserNotFound
+	}
+	if user.EmailVerified {
+		return nil, "", internalErrors.ErrEmailAlreadyVerified
+	}
+
+	cfg, _ := currentRecoveryConfig()
+	user.verificationTokenID = token.NewID()
+	tok, err := currentTokenSigner().Sign(token.Claims{
+		ID:        user.verificationTokenID,
+		Purpose:   tokenPurposeEmailVerification,
+		Subject:   user.ID,
+		OrgID:     user.OrgID,
+		ExpiresAt: time.Now().Add(cfg.VerificationTTL).Unix(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return user.clone(), tok, nil
+}
+
+// ConfirmEmailVerification redeems a verification token and marks the email as verified.
+func ConfirmEmailVerification(tok string) (*User, error) {
+	claims, err := currentTokenSigner().Verify(tok, tokenPurposeEmailVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(claims.OrgID, claims.Subject)
+	if !exists || user.IsDeleted() || user.verificationTokenID == "" || user.verificationTokenID != claims.ID {
+		return nil, internalErrors.ErrInvalidToken
+	}
+
+	user.EmailVerified = true
+	user.verificationTokenID = ""
+	user.touch(user.ID, time.Now().UTC())
+	audit.Record(audit.Event{Type: AuditEmailVerified, OrgID: user.OrgID, Actor: user.ID, Target: user.ID, Details: map[string]string{"email": user.Email}})
+	return user.clone(), nil
+}
+
+// RequestPasswordReset issues a reset token for the active user with the given email
+// address in the organization. Earlier reset tokens stop working.
+func RequestPasswordReset(orgID, email string) (*User, string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	id, exists := emailIndex[emailIndexKey(orgID, email)]
+	if !exists {
+		return nil, "", internalErrors.ErrUserNotFound
+	}
+	user := users[id]
+	if user.IsDeleted() || user.Status != UserStatusActive {
+		return nil, "", internalErrors.ErrUserNotFound
+	}
+
+	cfg, _ := currentRecoveryConfig()
+	user.resetTokenID = token.NewID()
+	tok, err := currentTokenSigner().Sign(token.Claims{
+		ID:        user.resetTokenID,
+		Purpose:   tokenPurposePasswordReset,
+		Subject:   user.ID,
+		OrgID:     user.OrgID,
+		ExpiresAt: time.Now().Add(cfg.ResetTTL).Unix(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	audit.Record(audit.Event{Type: AuditPasswordResetRequest, OrgID: orgID, Actor: "anonymous", Target: user.ID})
+	return user.clone(), tok, nil
+}
+
+// ConfirmPasswordReset redeems a reset token and replaces the user's password. Receiving
+// the token also proves control of the email address, so the address becomes verified.
+func ConfirmPasswordReset(tok, newPassword string) (*User, error) {
+	claims, err := currentTokenSigner().Verify(tok, tokenPurposePasswordReset)
+	if err != nil {
+		return nil, err
+	}
+	if err := password.Validate(newPassword); err != nil {
+		return nil, err
+	}
+	hash, err := password.Hash(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(claims.OrgID, claims.Subject)
+	if !exists || user.IsDeleted() || user.resetTokenID == "" || user.resetTokenID != claims.ID {
+		return nil, internalErrors.ErrInvalidToken
+	}
+
+	user.passwordHash = hash
+	user.resetTokenID = ""
+	user.EmailVerified = true
+	user.touch(user.ID, time.Now().UTC())
+	audit.Record(audit.Event{Type: AuditPasswordReset, OrgID: user.OrgID, Actor: user.ID, Target: user.ID})
+	return user.clone(), nil
+}
+
+// allowPasswordReset applies the per-address rate limit to reset requests. The limit is
+// applied whether or not the address belongs to a user, so it reveals nothing.
+func allowPasswordReset(orgID, email string) (bool, time.Duration) {
+	_, limiter := currentRecoveryConfig()
+	return limiter.allow(emailIndexKey(orgID, email), time.Now())
+}
+
+func sendEmailVerification(user *User, tok string) error {
+	cfg, _ := currentRecoveryConfig()
+	return sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Confirm that %s is your email address by opening this link:\n%s\n\nThe link expires in %s.\n",
+			user.Email, cfg.VerificationURL+"?token="+url.QueryEscape(tok), cfg.VerificationTTL),
+	})
+}
+
+func sendPasswordReset(user *User, tok string) error {
+	cfg, _ := currentRecoveryConfig()
+	return sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of %s. Choose a new password here:\n%s\n\nThe link expires in %s. If you did not ask for this, ignore this email.\n",
+			user.Email, cfg.ResetURL+"?token="+url.QueryEscape(tok), cfg.ResetTTL),
+	})
+}
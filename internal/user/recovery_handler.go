@@ -0,0 +1,248 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+type EmailUpdateRequest struct {
+	Email string `json:"email"`
+}
+
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// verifyEmail sends a verification email to a user with an unverified address.
+// Failures are logged; the email can be requested again.
+func verifyEmail(orgID, id string) {
+	user, tok, err := RequestEmailVerification(orgID, id)
+	if err != nil {
+		log.Printf("Email verification not requested for user %s: %v", id, err)
+		return
+	}
+	if err := sendEmailVerification(user, tok); err != nil {
+		log.Printf("Failed to send email verification to user %s: %v", id, err)
+	}
+}
+
+// HandleEmailVerification handles HTTP requests for /email-verification/confirm.
+func HandleEmailVerification(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/email-verification/confirm" {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodPost {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	HandleConfirmEmailVerification(w, r)
+}
+
+// HandlePasswordReset handles HTTP requests for /password-reset and /password-reset/confirm.
+func HandlePasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	switch r.URL.Path {
+	case "/password-reset":
+		HandleRequestPasswordReset(w, r)
+	case "/password-reset/confirm":
+		HandleConfirmPasswordReset(w, r)
+	default:
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+	}
+}
+
+func HandleUpdateUserEmail(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+
+	if !checkUserPermission(w, r, orgID, "users.update_email", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	var req EmailUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	email := NormalizeEmail(req.Email)
+	if err := validateEmail(email); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	ifMatch, err := parseIfMatch(r.Header.Get("If-Match"))
+	var user *User
+	if err == nil {
+		user, err = UpdateUserEmail(orgID, id, email, currentActor(r), ifMatch)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, internalMsgs.ErrPreconditionFailed):
+			errResponse(w, http.StatusPreconditionFailed, err)
+			log.Printf("PreconditionFailed: User %s", id)
+		case errors.Is(err, internalMsgs.ErrUserAlreadyExists):
+			errResponse(w, http.StatusConflict, err)
+			log.Printf("Conflict: %v", err)
+		default:
+			errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+			log.Printf("NotFound: %v", err)
+		}
+		return
+	}
+
+	verifyEmail(orgID, id)
+
+	w.Header().Set("ETag", etag(user.Version))
+	jsonResponse(w, http.StatusOK, map[string]string{"message": "User email updated successfully"})
+	log.Printf("User email updated: %s", id)
+}
+
+func HandleRequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+
+	if !checkUserPermission(w, r, orgID, "users.request_email_verification", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	user, tok, err := RequestEmailVerification(orgID, id)
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrEmailAlreadyVerified) {
+			errResponse(w, http.StatusConflict, err)
+		} else {
+			errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		}
+		log.Printf("Email verification not requested for user %s: %v", id, err)
+		return
+	}
+	if err := sendEmailVerification(user, tok); err != nil {
+		errResponse(w, http.StatusBadGateway, internalMsgs.ErrInternalServerError)
+		log.Printf("Failed to send email verification to user %s: %v", id, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusAccepted, map[string]string{"message": "Verification email sent"})
+	log.Printf("Verification email sent to user %s", id)
+}
+
+// HandleConfirmEmailVerification redeems a verification token. It is authorized by the
+// token alone.
+func HandleConfirmEmailVerification(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	user, err := ConfirmEmailVerification(req.Token)
+	if err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidToken)
+		log.Printf("Email not verified: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]string{"message": "Email verified successfully"})
+	log.Printf("Email verified for user %s", user.ID)
+}
+
+// HandleRequestPasswordReset emails a reset link to the given address. It answers the
+// same way whether or not the address belongs to a user, so addresses cannot be probed.
+func HandleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+
+	var req PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	email := NormalizeEmail(req.Email)
+
+	if allowed, retryAfter := allowPasswordReset(orgID, email); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		errResponse(w, http.StatusTooManyRequests, internalMsgs.ErrTooManyRequests)
+		log.Printf("TooManyRequests: password reset for %s", email)
+		return
+	}
+
+	if user, tok, err := RequestPasswordReset(orgID, email); err == nil {
+		if err := sendPasswordReset(user, tok); err != nil {
+			log.Printf("Failed to send password reset to user %s: %v", user.ID, err)
+		}
+	}
+
+	jsonResponse(w, http.StatusAccepted, map[string]string{"message": "If the address belongs to a user, a reset link has been sent"})
+}
+
+// HandleConfirmPasswordReset redeems a reset token and sets a new password. It is
+// authorized by the token alone.
+func HandleConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	user, err := ConfirmPasswordReset(req.Token, req.Password)
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrInvalidToken) || errors.Is(err, internalMsgs.ErrWeakPassword) {
+			errResponse(w, http.StatusBadRequest, err)
+		} else {
+			errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+		}
+		log.Printf("Password not reset: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]string{"message": "Password reset successfully"})
+	log.Printf("Password reset for user %s", user.ID)
+}
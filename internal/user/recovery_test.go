@@ -0,0 +1,127 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/mail"
+	"zpe-cloud-user-management-service/internal/password"
+)
+
+func TestEmailVerificationAndPasswordReset(t *testing.T) {
+	setupTestStorageWithUsers()
+	var outbox bytes.Buffer
+	SetMailer(mail.NewWriterSender(&outbox))
+	defer SetMailer(nil)
+	SetRecoveryConfig(RecoveryConfig{
+		VerificationTTL: time.Hour,
+		VerificationURL: "https://app.example.com/verify",
+		ResetTTL:        time.Hour,
+		ResetURL:        "https://app.example.com/reset",
+		ResetLimit:      2,
+		ResetWindow:     time.Hour,
+	})
+	defer SetRecoveryConfig(RecoveryConfig{VerificationTTL: time.Hour, ResetTTL: time.Hour, ResetLimit: 3, ResetWindow: time.Hour})
+
+	tokenBody := func(tok string) string {
+		body, _ := json.Marshal(PasswordResetConfirmRequest{Token: tok, Password: "a strong password"})
+		return string(body)
+	}
+
+	if rr := doRequest(t, "POST", "/users", callerHeaders("Admin", ""), `{"name":"Padme","email":"padme@example.com","roles":["Watcher"],"email_verified":true}`); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	signupToken := lastMailedToken(t, &outbox)
+	if user, _ := GetUser(DefaultOrgID, "7"); user.EmailVerified {
+		t.Error("expected a new user to be unverified")
+	}
+
+	if rr := doRequest(t, "PUT", "/users/7/email", callerHeaders("Admin", ""), `{"email":"leia@example.com"}`); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := doRequest(t, "PUT", "/users/7/email", callerHeaders("Admin", ""), `{"email":"amidala@Example.com"}`); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	changeToken := lastMailedToken(t, &outbox)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		userType       string
+		body           string
+		expectedStatus int
+	}{
+		{name: "Email change invalidates the signup token", method: "POST", path: "/email-verification/confirm", body: tokenBody(signupToken), expectedStatus: http.StatusBadRequest},
+		{name: "Verify new email", method: "POST", path: "/email-verification/confirm", body: tokenBody(changeToken), expectedStatus: http.StatusOK},
+		{name: "Verification token is single-use", method: "POST", path: "/email-verification/confirm", body: tokenBody(changeToken), expectedStatus: http.StatusBadRequest},
+		{name: "Verified email needs no new verification", method: "POST", path: "/users/7/verification", userType: "Admin", expectedStatus: http.StatusConflict},
+		{name: "Tampered verification token", method: "POST", path: "/email-verification/confirm", body: tokenBody(changeToken + "x"), expectedStatus: http.StatusBadRequest},
+		{name: "Request reset", method: "POST", path: "/password-reset", body: `{"email":"r2-d2@example.com"}`, expectedStatus: http.StatusAccepted},
+		{name: "Unknown address looks the same", method: "POST", path: "/password-reset", body: `{"email":"yoda@example.com"}`, expectedStatus: http.StatusAccepted},
+		{name: "Request reset again", method: "POST", path: "/password-reset", body: `{"email":"R2-D2@example.com"}`, expectedStatus: http.StatusAccepted},
+		{name: "Reset requests are rate limited per address", method: "POST", path: "/password-reset", body: `{"email":"r2-d2@example.com"}`, expectedStatus: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, tt.method, tt.path, callerHeaders(tt.userType, ""), tt.body)
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", status, tt.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+
+	if user, _ := GetUser(DefaultOrgID, "7"); !user.EmailVerified || user.Email != "amidala@example.com" {
+		t.Errorf("expected the new address to be verified, got %+v", user)
+	}
+	if strings.Contains(outbox.String(), "yoda@example.com") {
+		t.Error("expected no email to an unknown address")
+	}
+
+	resetToken := lastMailedToken(t, &outbox)
+	if rr := doRequest(t, "POST", "/password-reset/confirm", nil, `{"token":"`+resetToken+`","password":"short"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := doRequest(t, "POST", "/password-reset/confirm", nil, tokenBody(resetToken)); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if rr := doRequest(t, "POST", "/password-reset/confirm", nil, tokenBody(resetToken)); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected the reset token to be single-use, got %v", rr.Code)
+	}
+
+	mu.Lock()
+	hash := users["3"].passwordHash
+	mu.Unlock()
+	if !password.Verify(hash, "a strong password") {
+		t.Error("expected the new password to be set")
+	}
+
+	if rr := doRequest(t, "POST", "/password-reset", nil, `{"email":"gohan@example.com"}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	staleToken := lastMailedToken(t, &outbox)
+	if rr := doRequest(t, "PUT", "/users/5/email", callerHeaders("Admin", ""), `{"email":"son-gohan@example.com"}`); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := doRequest(t, "POST", "/password-reset/confirm", nil, tokenBody(staleToken)); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an email change to invalidate the reset token, got %v", rr.Code)
+	}
+}
+
+func TestEmailRateLimiterSweep(t *testing.T) {
+	l := newEmailRateLimiter(1, time.Hour)
+	start := time.Now()
+	if ok, _ := l.allow("leia@example.com", start); !ok {
+		t.Fatal("expected the first event to be allowed")
+	}
+	if ok, retry := l.allow("leia@example.com", start.Add(time.Minute)); ok || retry != 59*time.Minute {
+		t.Errorf("expected the second event to wait 59m, got %t %v", ok, retry)
+	}
+	l.allow("obi-wan@example.com", start.Add(2*time.Hour))
+	if _, exists := l.hits["leia@example.com"]; exists || len(l.hits) != 1 {
+		t.Errorf("expected idle addresses to be evicted, got %v", l.hits)
+	}
+}
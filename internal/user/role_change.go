@@ -0,0 +1,358 @@
+package user
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	"zpe-cloud-user-management-service/internal/ids"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// RoleApprovalPolicy decides which role changes need a second Admin's approval.
+type RoleApprovalPolicy struct {
+	// Threshold is the lowest role whose assignment needs approval. Empty disables approvals.
+	Threshold string
+	// TTL is how long a request stays pending before it expires.
+	TTL time.Duration
+}
+
+// RoleChangeRequest is a pending or decided request to replace the roles of a user, to
+// give the user a time-bound grant of Roles[0] or to add the user to a group with Roles.
+// UserID may also name a service account, whose roles the request replaces.
+type RoleChangeRequest struct {
+	ID      string     `json:"id"`
+	OrgID   string     `json:"org_id"`
+	UserID  string     `json:"user_id"`
+	Roles   []string   `json:"roles"`
+	Grant   *RoleGrant `json:"grant,omitempty"`
+	GroupID string     `json:"group_id,omitempty"`
+	// Version is the version of the user when the change was requested. The change is
+	// only applied if the user has not changed since.
+	Version     int64      `json:"version"`
+	Status      string     `json:"status"`
+	RequestedBy string     `json:"requested_by"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+}
+
+// Role change request statuses.
+const (
+	RoleChangePending  = "pending"
+	RoleChangeApproved = "approved"
+	RoleChangeRejected = "rejected"
+	RoleChangeExpired  = "expired"
+)
+
+// Audit event types emitted for role change requests.
+const (
+	AuditRoleChangeRequested = "role_change.requested"
+	AuditRoleChangeApproved  = "role_change.approved"
+	AuditRoleChangeRejected  = "role_change.rejected"
+	AuditRoleChangeExpired   = "role_change.expired"
+)
+
+var (
+	roleApprovalMu sync.RWMutex
+	roleApproval   RoleApprovalPolicy
+)
+
+// roleChangeRequests and roleChangeIDGenerator are guarded by mu so that approving a
+// request and updating the user's roles happen atomically.
+var (
+	roleChangeRequests                  = make(map[string]*RoleChangeRequest)
+	roleChangeIDGenerator ids.Generator = ids.NewSequential()
+)
+
+// SetRoleApprovalPolicy installs the policy used by role updates. It rejects unknown
+// threshold roles.
+func SetRoleApprovalPolicy(p RoleApprovalPolicy) error {
+	if p.Threshold != "" && !isRoleExists(p.Threshold) {
+		return fmt.Errorf("%w: %s", internalErrors.ErrInvalidRole, p.Threshold)
+	}
+	roleApprovalMu.Lock()
+	defer roleApprovalMu.Unlock()
+	roleApproval = p
+	return nil
+}
+
+func currentRoleApprovalPolicy() RoleApprovalPolicy {
+	roleApprovalMu.RLock()
+	defer roleApprovalMu.RUnlock()
+	return roleApproval
+}
+
+// requiresApproval reports whether replacing current with requested adds a role at or
+// above the approval threshold. Removing roles never needs approval.
+func requiresApproval(current, requested []string) bool {
+	threshold := currentRoleApprovalPolicy().Threshold
+	if threshold == "" {
+		return false
+	}
+	for _, role := range requested {
+		if !containsString(current, role) && roleRank(role) >= roleRank(threshold) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RoleChangeRequest) clone() *RoleChangeRequest {
+	cp := *c
+	cp.Roles = append([]string{}, c.Roles...)
+	if c.Grant != nil {
+		grant := *c.Grant
+		cp.Grant = &grant
+	}
+	return &cp
+}
+
+// lookupRoleChangeRequest returns the stored request with the given ID if it belongs to
+// orgID, expiring it first when its TTL has passed. The caller must hold mu.
+func lookupRoleChangeRequest(orgID, id string, now time.Time) (*RoleChangeRequest, bool) {
+	req, exists := roleChangeRequests[id]
+	if !exists || req.OrgID != orgID {
+		return nil, false
+	}
+	expireRoleChangeRequest(req, now)
+	return req, true
+}
+
+// expireRoleChangeRequest marks a pending request as expired once its TTL has passed.
+// The caller must hold mu.
+func expireRoleChangeRequest(req *RoleChangeRequest, now time.Time) {
+	if req.Status != RoleChangePending || now.Before(req.ExpiresAt) {
+		return
+	}
+	req.Status = RoleChangeExpired
+	audit.Record(audit.Event{
+		Type:    AuditRoleChangeExpired,
+		OrgID:   req.OrgID,
+		Actor:   "system",
+		Target:  req.UserID,
+		Details: roleChangeDetails(req),
+	})
+}
+
+// CreateRoleChangeRequest records a pending request to replace the roles of a user.
+// A non-empty ifMatch makes the request conditional on the user's current version.
+func CreateRoleChangeRequest(orgID, userID string, roles []string, actor string, ifMatch []int64) (*RoleChangeRequest, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, userID)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	if err := checkVersion(user, ifMatch); err != nil {
+		return nil, err
+	}
+	return fileRoleChangeRequest(orgID, userID, user.Version, &RoleChangeRequest{Roles: append([]string{}, roles...)}, actor), nil
+}
+
+// CreateServiceAccountRoleRequest records a pending request to replace the roles of a
+// service account.
+func CreateServiceAccountRoleRequest(orgID, accountID string, roles []string, actor string) (*RoleChangeRequest, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := lookupServiceAccount(orgID, accountID); !exists {
+		return nil, internalErrors.ErrServiceAccountNotFound
+	}
+	return fileRoleChangeRequest(orgID, accountID, 0, &RoleChangeRequest{Roles: append([]string{}, roles...)}, actor), nil
+}
+
+// CreateRoleGrantRequest records a pending request to give a user a time-bound role grant.
+func CreateRoleGrantRequest(orgID, userID string, grant RoleGrant, actor string) (*RoleChangeRequest, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, userID)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	return fileRoleChangeRequest(orgID, userID, user.Version, &RoleChangeRequest{Roles: []string{grant.Role}, Grant: &grant}, actor), nil
+}
+
+// CreateGroupMembershipRequest records a pending request to add a user to a group, which
+// gives the user the current roles of the group.
+func CreateGroupMembershipRequest(orgID, groupID, userID, actor string) (*RoleChangeRequest, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	group, exists := lookupGroup(orgID, groupID)
+	if !exists {
+		return nil, internalErrors.ErrGroupNotFound
+	}
+	user, exists := lookupUser(orgID, userID)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	return fileRoleChangeRequest(orgID, userID, user.Version, &RoleChangeRequest{Roles: append([]string{}, group.Roles...), GroupID: groupID}, actor), nil
+}
+
+// fileRoleChangeRequest stores req as a pending request against version of the user
+// userID. The caller must hold mu.
+func fileRoleChangeRequest(orgID, userID string, version int64, req *RoleChangeRequest, actor string) *RoleChangeRequest {
+	now := time.Now().UTC()
+	req.ID = roleChangeIDGenerator.NewID()
+	req.OrgID = orgID
+	req.UserID = userID
+	req.Version = version
+	req.Status = RoleChangePending
+	req.RequestedBy = actor
+	req.RequestedAt = now
+	req.ExpiresAt = now.Add(currentRoleApprovalPolicy().TTL)
+	roleChangeRequests[req.ID] = req
+
+	audit.Record(audit.Event{
+		Type:    AuditRoleChangeRequested,
+		OrgID:   req.OrgID,
+		Actor:   actor,
+		Target:  req.UserID,
+		Details: roleChangeDetails(req),
+	})
+	return req.clone()
+}
+
+func GetRoleChangeRequest(orgID, id string) (*RoleChangeRequest, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	req, exists := lookupRoleChangeRequest(orgID, id, time.Now())
+	if !exists {
+		return nil, internalErrors.ErrRoleChangeRequestNotFound
+	}
+	return req.clone(), nil
+}
+
+// ListRoleChangeRequests returns the requests of an organization, newest first.
+// An empty status returns requests in every status.
+func ListRoleChangeRequests(orgID, status string) []*RoleChangeRequest {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	list := make([]*RoleChangeRequest, 0)
+	for _, req := range roleChangeRequests {
+		if req.OrgID != orgID {
+			continue
+		}
+		expireRoleChangeRequest(req, now)
+		if status == "" || req.Status == status {
+			list = append(list, req.clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].RequestedAt.After(list[j].RequestedAt)
+	})
+	return list
+}
+
+// pendingRoleChangeRequest returns the request with the given ID if it is still pending.
+// The caller must hold mu.
+func pendingRoleChangeRequest(orgID, id string, now time.Time) (*RoleChangeRequest, error) {
+	req, exists := lookupRoleChangeRequest(orgID, id, now)
+	if !exists {
+		return nil, internalErrors.ErrRoleChangeRequestNotFound
+	}
+	if req.Status != RoleChangePending {
+		return nil, internalErrors.ErrRoleChangeNotPending
+	}
+	return req, nil
+}
+
+// ApproveRoleChangeRequest approves a pending request and applies the requested change
+// to the user. Neither the requester nor the target user may approve it, nor the creator
+// of either when it is a service account, nor any service account. It fails with
+// ErrPreconditionFailed, leaving the request pending, if the user changed since the
+// request was made or the group it joins has other roles now.
+func ApproveRoleChangeRequest(orgID, id, actor string) (*RoleChangeRequest, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now().UTC()
+	req, err := pendingRoleChangeRequest(orgID, id, now)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(actor, serviceAccountIDPrefix) {
+		return nil, internalErrors.ErrServiceAccountApprover
+	}
+	if actor == req.RequestedBy || actor == req.UserID || ownsServiceAccount(orgID, actor, req.RequestedBy) || ownsServiceAccount(orgID, actor, req.UserID) {
+		return nil, internalErrors.ErrSelfApproval
+	}
+	ifMatch := []int64{req.Version}
+	switch {
+	case strings.HasPrefix(req.UserID, serviceAccountIDPrefix):
+		err = setServiceAccountRoles(orgID, req.UserID, req.Roles)
+	case req.GroupID != "":
+		err = joinGroup(orgID, req.GroupID, req.UserID, req.Roles, actor, ifMatch)
+	case req.Grant != nil && req.Grant.ExpiredAt(now):
+		err = internalErrors.ErrPreconditionFailed
+	case req.Grant != nil:
+		_, err = addRoleGrant(orgID, req.UserID, *req.Grant, actor, ifMatch)
+	default:
+		_, err = updateUserRoles(orgID, req.UserID, append([]string{}, req.Roles...), actor, ifMatch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req.Status = RoleChangeApproved
+	req.DecidedBy = actor
+	req.DecidedAt = &now
+	audit.Record(audit.Event{
+		Type:    AuditRoleChangeApproved,
+		OrgID:   orgID,
+		Actor:   actor,
+		Target:  req.UserID,
+		Details: roleChangeDetails(req),
+	})
+	return req.clone(), nil
+}
+
+// RejectRoleChangeRequest rejects a pending request without touching the user.
+// The requester may reject their own request to withdraw it.
+func RejectRoleChangeRequest(orgID, id, actor, reason string) (*RoleChangeRequest, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now().UTC()
+	req, err := pendingRoleChangeRequest(orgID, id, now)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Status = RoleChangeRejected
+	req.DecidedBy = actor
+	req.DecidedAt = &now
+	req.Reason = reason
+	audit.Record(audit.Event{
+		Type:    AuditRoleChangeRejected,
+		OrgID:   orgID,
+		Actor:   actor,
+		Target:  req.UserID,
+		Details: roleChangeDetails(req),
+	})
+	return req.clone(), nil
+}
+
+func roleChangeDetails(req *RoleChangeRequest) map[string]string {
+	details := map[string]string{"request_id": req.ID, "roles": strings.Join(req.Roles, ",")}
+	if req.GroupID != "" {
+		details["group_id"] = req.GroupID
+	}
+	if req.Grant != nil {
+		details["grant"] = "true"
+	}
+	if req.Reason != "" {
+		details["reason"] = req.Reason
+	}
+	return details
+}
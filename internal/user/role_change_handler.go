@@ -0,0 +1,206 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+type RoleChangeDecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// requestRoleChange records a role update that needs approval and answers 202 Accepted
+// with the pending request.
+func requestRoleChange(w http.ResponseWriter, r *http.Request, orgID, id string, roles []string, ifMatch []int64) {
+	req, err := CreateRoleChangeRequest(orgID, id, roles, currentActor(r), ifMatch)
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrPreconditionFailed) {
+			errResponse(w, http.StatusPreconditionFailed, err)
+			log.Printf("PreconditionFailed: User %s", id)
+			return
+		}
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("NotFound: %v", err)
+		return
+	}
+	roleChangeAccepted(w, req)
+}
+
+// requestPendingRoles files a request for the roles a user was created or updated
+// without because they need approval, and returns its ID.
+func requestPendingRoles(orgID, userID string, roles []string, actor string) string {
+	req, err := CreateRoleChangeRequest(orgID, userID, roles, actor, nil)
+	if err != nil {
+		log.Printf("Failed to request role change for user %s: %v", userID, err)
+		return ""
+	}
+	log.Printf("Role change for user %s is pending approval: %s", userID, req.ID)
+	return req.ID
+}
+
+// roleChangeAccepted answers 202 Accepted with a pending role change request.
+func roleChangeAccepted(w http.ResponseWriter, req *RoleChangeRequest) {
+	w.Header().Set("Location", "/role-change-requests/"+req.ID)
+	jsonResponse(w, http.StatusAccepted, req)
+	log.Printf("Role change for user %s is pending approval: %s", req.UserID, req.ID)
+}
+
+// HandleRoleChangeRequests handles HTTP requests for the /role-change-requests endpoint.
+func HandleRoleChangeRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	HandleListRoleChangeRequests(w, r)
+}
+
+// HandleRoleChangeRequest handles HTTP requests for /role-change-requests/{id},
+// /role-change-requests/{id}/approve and /role-change-requests/{id}/reject.
+func HandleRoleChangeRequest(w http.ResponseWriter, r *http.Request) {
+	_, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/role-change-requests/"), "/")
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleGetRoleChangeRequest(w, r)
+	case "approve", "reject":
+		if r.Method != http.MethodPost {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleDecideRoleChangeRequest(w, r)
+	default:
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+	}
+}
+
+func HandleListRoleChangeRequests(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list role change requests", currentUserRole)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", RoleChangePending, RoleChangeApproved, RoleChangeRejected, RoleChangeExpired:
+	default:
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidQueryParameter)
+		log.Printf("BadRequest: invalid status: %s", status)
+		return
+	}
+
+	list := ListRoleChangeRequests(orgID, status)
+	jsonResponse(w, http.StatusOK, list)
+	log.Printf("Role change requests listed: %d requests", len(list))
+}
+
+func HandleGetRoleChangeRequest(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to get a role change request", currentUserRole)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/role-change-requests/")
+	req, err := GetRoleChangeRequest(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Role change request %s", id)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, req)
+}
+
+func HandleDecideRoleChangeRequest(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to decide a role change request", currentUserRole)
+		return
+	}
+	// The four-eyes rule compares people, so the approver must be authenticated.
+	actor := requireAuthenticatedActor(w, r, "decide a role change request")
+	if actor == "" {
+		return
+	}
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/role-change-requests/"), "/")
+	var decision RoleChangeDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&decision); err != nil && err != io.EOF {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	pending, err := GetRoleChangeRequest(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Role change request %s", id)
+		return
+	}
+
+	var decided *RoleChangeRequest
+	if action == "approve" {
+		// The approver must be allowed to assign the roles themselves.
+		if err := isValidRoleUpdate(pending.Roles, currentUserRole); err != nil {
+			errResponse(w, http.StatusForbidden, internalMsgs.ErrInsufficientPermissions)
+			log.Printf("Forbidden: %v", err)
+			return
+		}
+		if !checkPolicy(w, r, orgID, "role_change_requests.approve", pending.UserID, nil, map[string]any{"roles": pending.Roles}) {
+			return
+		}
+		decided, err = ApproveRoleChangeRequest(orgID, id, actor)
+	} else {
+		if !checkPolicy(w, r, orgID, "role_change_requests.reject", pending.UserID, nil, map[string]any{"roles": pending.Roles}) {
+			return
+		}
+		decided, err = RejectRoleChangeRequest(orgID, id, actor, decision.Reason)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, internalMsgs.ErrSelfApproval), errors.Is(err, internalMsgs.ErrServiceAccountApprover):
+			errResponse(w, http.StatusForbidden, err)
+		case errors.Is(err, internalMsgs.ErrRoleChangeNotPending):
+			errResponse(w, http.StatusConflict, err)
+		case errors.Is(err, internalMsgs.ErrPreconditionFailed):
+			// The user or the group changed since the request was made; it stays
+			// pending so that it can be rejected or expire.
+			errResponse(w, http.StatusPreconditionFailed, err)
+		default:
+			errResponse(w, http.StatusNotFound, err)
+		}
+		log.Printf("Role change request %s not decided: %v", id, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, decided)
+	log.Printf("Role change request %s %s by %s", id, decided.Status, decided.DecidedBy)
+}
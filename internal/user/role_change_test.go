@@ -0,0 +1,217 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRoleChangeApproval(t *testing.T) {
+	setupTestStorageWithUsers()
+	if err := SetRoleApprovalPolicy(RoleApprovalPolicy{Threshold: "Admin", TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetRoleApprovalPolicy(RoleApprovalPolicy{})
+
+	rr := doRequest(t, "PUT", "/users/roles/3", callerHeaders("Admin", "1"), `{"roles":["Admin"]}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusAccepted, rr.Body.String())
+	}
+	var pending RoleChangeRequest
+	if err := json.NewDecoder(rr.Body).Decode(&pending); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if pending.Status != RoleChangePending || pending.RequestedBy != "1" {
+		t.Errorf("expected a pending request by user 1, got %+v", pending)
+	}
+	if role, _ := getUserTypeByID(DefaultOrgID, "3"); role != "Watcher" {
+		t.Errorf("expected roles to be unchanged until approval, got %s", role)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		userType       string
+		userID         string
+		session        bool
+		body           string
+		expectedStatus int
+	}{
+		{name: "Changes below the threshold apply immediately", method: "PUT", path: "/users/roles/2", userType: "Admin", userID: "1", body: `{"roles":["Watcher"]}`, expectedStatus: http.StatusOK},
+		{name: "Watcher cannot list requests", method: "GET", path: "/role-change-requests", userType: "Watcher", userID: "3", expectedStatus: http.StatusForbidden},
+		{name: "Invalid status filter", method: "GET", path: "/role-change-requests?status=done", userType: "Admin", userID: "1", expectedStatus: http.StatusBadRequest},
+		{name: "Requester cannot approve", method: "POST", path: "/role-change-requests/" + pending.ID + "/approve", userID: "1", session: true, expectedStatus: http.StatusForbidden},
+		{name: "Modifier cannot approve", method: "POST", path: "/role-change-requests/" + pending.ID + "/approve", userID: "4", session: true, expectedStatus: http.StatusForbidden},
+		{name: "Requester cannot approve as another user", method: "POST", path: "/role-change-requests/" + pending.ID + "/approve", userType: "Admin", userID: "6", expectedStatus: http.StatusUnauthorized},
+		{name: "Second Admin can approve", method: "POST", path: "/role-change-requests/" + pending.ID + "/approve", userID: "6", session: true, expectedStatus: http.StatusOK},
+		{name: "Decided request cannot be rejected", method: "POST", path: "/role-change-requests/" + pending.ID + "/reject", userID: "6", session: true, body: `{"reason":"too late"}`, expectedStatus: http.StatusConflict},
+		{name: "Unknown request", method: "GET", path: "/role-change-requests/999", userType: "Admin", userID: "1", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := callerHeaders(tt.userType, tt.userID)
+			if tt.session {
+				headers = sessionHeaders(t, tt.userID)
+			}
+			rr := doRequest(t, tt.method, tt.path, headers, tt.body)
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", status, tt.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+
+	if role, _ := getUserTypeByID(DefaultOrgID, "3"); role != "Admin" {
+		t.Errorf("expected approved role to be applied, got %s", role)
+	}
+
+	expiring, err := CreateRoleChangeRequest(DefaultOrgID, "5", []string{"Admin"}, "1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	roleChangeRequests[expiring.ID].ExpiresAt = time.Now().Add(-time.Minute)
+	mu.Unlock()
+	if rr := doRequest(t, "POST", "/role-change-requests/"+expiring.ID+"/approve", sessionHeaders(t, "6"), ""); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if req, _ := GetRoleChangeRequest(DefaultOrgID, expiring.ID); req.Status != RoleChangeExpired {
+		t.Errorf("expected request to be expired, got %s", req.Status)
+	}
+}
+
+func TestRoleChangeApprovalOnEveryPath(t *testing.T) {
+	setupTestStorageWithUsers()
+	if err := SetRoleApprovalPolicy(RoleApprovalPolicy{Threshold: "Admin", TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetRoleApprovalPolicy(RoleApprovalPolicy{})
+	admin, approver := callerHeaders("Admin", "1"), sessionHeaders(t, "6")
+	approve := func(t *testing.T, id string, want int) {
+		t.Helper()
+		if rr := doRequest(t, "POST", "/role-change-requests/"+id+"/approve", approver, ""); rr.Code != want {
+			t.Errorf("approval returned wrong status code: got %v want %v: %s", rr.Code, want, rr.Body.String())
+		}
+	}
+	accepted := func(t *testing.T, rr *httptest.ResponseRecorder) string {
+		t.Helper()
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusAccepted, rr.Body.String())
+		}
+		id, _ := decodeResponse(rr)["id"].(string)
+		return id
+	}
+
+	t.Run("User changed since the request", func(t *testing.T) {
+		id := accepted(t, doRequest(t, "PUT", "/users/roles/5", admin, `{"roles":["Admin"]}`))
+		if rr := doRequest(t, "PUT", "/users/roles/5", admin, `{"roles":["Modifier"]}`); rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		approve(t, id, http.StatusPreconditionFailed)
+		if role, _ := getUserTypeByID(DefaultOrgID, "5"); role != "Modifier" {
+			t.Errorf("expected the stale request not to be applied, got %s", role)
+		}
+		if rr := doRequest(t, "POST", "/role-change-requests/"+id+"/reject", approver, ""); rr.Code != http.StatusOK {
+			t.Errorf("expected the stale request to stay pending, got %v", rr.Code)
+		}
+	})
+
+	t.Run("Creating a user", func(t *testing.T) {
+		rr := doRequest(t, "POST", "/users", admin, `{"name":"Ahsoka Tano","email":"ahsoka@example.com","roles":["Admin"]}`)
+		resp := decodeResponse(rr)
+		if rr.Code != http.StatusCreated || resp["role_change_request_id"] == nil {
+			t.Fatalf("expected the user to be created with a role change request, got %v: %s", rr.Code, rr.Body.String())
+		}
+		id := resp["id"].(string)
+		if role, _ := getUserTypeByID(DefaultOrgID, id); role != lowestRole() {
+			t.Errorf("expected the user to have the lowest role until approval, got %s", role)
+		}
+		approve(t, resp["role_change_request_id"].(string), http.StatusOK)
+		if role, _ := getUserTypeByID(DefaultOrgID, id); role != "Admin" {
+			t.Errorf("expected the approved role to be applied, got %s", role)
+		}
+	})
+
+	t.Run("Provisioning a user over SCIM", func(t *testing.T) {
+		rr := doRequest(t, "POST", "/scim/v2/Users", scimHeaders(t, "Admin"), `{"userName":"padme@example.com","displayName":"Padme Amidala","roles":[{"value":"Admin"}]}`)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body.String())
+		}
+		id := decodeResponse(rr)["id"].(string)
+		if role, _ := getUserTypeByID(DefaultOrgID, id); role != lowestRole() {
+			t.Errorf("expected the user to have the lowest role until approval, got %s", role)
+		}
+		if pending := ListRoleChangeRequests(DefaultOrgID, RoleChangePending); len(pending) == 0 || pending[0].UserID != id {
+			t.Errorf("expected a pending request for user %s, got %+v", id, pending)
+		}
+	})
+
+	t.Run("Granting a role", func(t *testing.T) {
+		id := accepted(t, doRequest(t, "POST", "/users/3/role-grants", admin, `{"role":"Admin"}`))
+		if user, _ := GetUser(DefaultOrgID, "3"); len(user.RoleGrants) != 0 {
+			t.Errorf("expected no grant until approval, got %+v", user.RoleGrants)
+		}
+		approve(t, id, http.StatusOK)
+		if user, _ := GetUser(DefaultOrgID, "3"); len(user.RoleGrants) != 1 || user.RoleGrants[0].Role != "Admin" {
+			t.Errorf("expected the approved grant to be stored, got %+v", user.RoleGrants)
+		}
+	})
+
+	t.Run("Joining a group", func(t *testing.T) {
+		rr := doRequest(t, "POST", "/groups", admin, `{"name":"Council","roles":["Admin"]}`)
+		groupID, _ := decodeResponse(rr)["id"].(string)
+		id := accepted(t, doRequest(t, "POST", "/groups/"+groupID+"/members", admin, `{"user_id":"4"}`))
+		if group, _ := GetGroup(DefaultOrgID, groupID); len(group.Members) != 0 {
+			t.Errorf("expected no member until approval, got %v", group.Members)
+		}
+		approve(t, id, http.StatusOK)
+		if group, _ := GetGroup(DefaultOrgID, groupID); !group.hasMember("4") {
+			t.Errorf("expected the approved member to be added, got %v", group.Members)
+		}
+
+		rr = doRequest(t, "POST", "/groups", admin, `{"name":"Droids","roles":["Watcher"]}`)
+		groupID, _ = decodeResponse(rr)["id"].(string)
+		if rr := doRequest(t, "POST", "/groups/"+groupID+"/members", admin, `{"user_id":"2"}`); rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if rr := doRequest(t, "PUT", "/groups/"+groupID, admin, `{"name":"Droids","roles":["Admin"]}`); rr.Code != http.StatusConflict {
+			t.Errorf("expected Admin not to be added to a group with members, got %v", rr.Code)
+		}
+	})
+
+	t.Run("Creating a service account", func(t *testing.T) {
+		rr := doRequest(t, "POST", "/service-accounts", admin, `{"name":"deploy","roles":["Admin"]}`)
+		resp := decodeResponse(rr)
+		if rr.Code != http.StatusCreated || resp["role_change_request_id"] == nil {
+			t.Fatalf("expected the account to be created with a role change request, got %v: %s", rr.Code, rr.Body.String())
+		}
+		accountID, requestID := resp["id"].(string), resp["role_change_request_id"].(string)
+		if account, _ := GetServiceAccount(DefaultOrgID, accountID); !sameRoles(account.Roles, []string{lowestRole()}) {
+			t.Errorf("expected the account to have the lowest role until approval, got %v", account.Roles)
+		}
+		_, key, err := CreateAPIKey(DefaultOrgID, accountID, &APIKey{Name: "approver"}, "1")
+		if err != nil {
+			t.Fatalf("failed to create API key: %v", err)
+		}
+		if rr := doRequest(t, "POST", "/role-change-requests/"+requestID+"/approve", map[string]string{"X-API-Key": key}, ""); rr.Code != http.StatusForbidden {
+			t.Errorf("expected a service account not to approve, got %v", rr.Code)
+		}
+		approve(t, requestID, http.StatusOK)
+		if account, _ := GetServiceAccount(DefaultOrgID, accountID); !sameRoles(account.Roles, []string{"Admin"}) {
+			t.Errorf("expected the approved roles to be applied, got %v", account.Roles)
+		}
+	})
+
+	t.Run("Requests by an approver's service account", func(t *testing.T) {
+		rr := doRequest(t, "POST", "/service-accounts", approver, `{"name":"bot","roles":["Modifier"]}`)
+		botID, _ := decodeResponse(rr)["id"].(string)
+		id := accepted(t, doRequest(t, "PUT", "/users/roles/2", callerHeaders("Admin", botID), `{"roles":["Admin"]}`))
+		approve(t, id, http.StatusForbidden)
+		if rr := doRequest(t, "POST", "/role-change-requests/"+id+"/approve", sessionHeaders(t, "1"), ""); rr.Code != http.StatusOK {
+			t.Errorf("expected an Admin unrelated to the account to approve, got %v", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// defaultExpiringWindow is used by /role-grants/expiring when no window is given.
+const defaultExpiringWindow = 7 * 24 * time.Hour
+
+type RoleGrantRequest struct {
+	Role       string     `json:"role"`
+	ValidFrom  *time.Time `json:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until"`
+}
+
+// HandleUserRoleGrants handles HTTP requests for the time-bound role grants of a user
+// at /users/{id}/role-grants.
+func HandleUserRoleGrants(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListRoleGrants(w, r)
+	case http.MethodPost:
+		HandleCreateRoleGrant(w, r)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+// HandleUserRoleGrant handles HTTP requests for a single grant at /users/{id}/role-grants/{grantID}.
+func HandleUserRoleGrant(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleGetRoleGrant(w, r)
+	case http.MethodDelete:
+		HandleRevokeRoleGrant(w, r)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+// HandleRoleGrants handles HTTP requests for organization-wide grant queries such as
+// /role-grants/expiring.
+func HandleRoleGrants(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimPrefix(r.URL.Path, "/role-grants/") != "expiring" {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+		return
+	}
+	if r.Method != http.MethodGet {
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	HandleListExpiringRoleGrants(w, r)
+}
+
+func HandleListRoleGrants(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isRoleExists(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list role grants", currentUserRole)
+		return
+	}
+
+	id, _ := splitUserPath(r.URL.Path)
+	user, err := GetUser(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("NotFound: User %s", id)
+		return
+	}
+
+	grants := user.RoleGrants
+	if grants == nil {
+		grants = []RoleGrant{}
+	}
+	jsonResponse(w, http.StatusOK, grants)
+}
+
+func HandleGetRoleGrant(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isRoleExists(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to get a role grant", currentUserRole)
+		return
+	}
+
+	id, action := splitUserPath(r.URL.Path)
+	grantID := strings.TrimPrefix(action, "role-grants/")
+	grant, err := GetRoleGrant(orgID, id, grantID)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, grant)
+}
+
+func HandleCreateRoleGrant(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, _ := splitUserPath(r.URL.Path)
+
+	var req RoleGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	grant := RoleGrant{Role: req.Role, ValidFrom: req.ValidFrom, ValidUntil: req.ValidUntil}
+	if err := grant.Validate(time.Now()); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	if err := isValidRoleUpdate([]string{grant.Role}, currentUserRole); err != nil {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrInsufficientPermissions)
+		log.Printf("Forbidden: %v", err)
+		return
+	}
+
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+	if !checkUserPermission(w, r, orgID, "users.grant_role", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	if current, err := GetUser(orgID, id); err == nil && requiresApproval(current.Roles, []string{grant.Role}) {
+		pending, err := CreateRoleGrantRequest(orgID, id, grant, currentActor(r))
+		if err != nil {
+			errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+			log.Printf("NotFound: %v", err)
+			return
+		}
+		roleChangeAccepted(w, pending)
+		return
+	}
+
+	created, err := AddRoleGrant(orgID, id, grant, currentActor(r))
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("NotFound: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, created)
+	log.Printf("Role grant %s created for user %s: %s", created.ID, id, created.Role)
+}
+
+func HandleRevokeRoleGrant(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, action := splitUserPath(r.URL.Path)
+	grantID := strings.TrimPrefix(action, "role-grants/")
+
+	grant, err := GetRoleGrant(orgID, id, grantID)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: %v", err)
+		return
+	}
+	if err := isValidRoleUpdate([]string{grant.Role}, currentUserRole); err != nil {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrInsufficientPermissions)
+		log.Printf("Forbidden: %v", err)
+		return
+	}
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		log.Printf("User not found: %s", id)
+		return
+	}
+	if !checkUserPermission(w, r, orgID, "users.revoke_role", id, currentUserRole, targetUserRole) {
+		return
+	}
+
+	if err := RevokeRoleGrant(orgID, id, grantID, currentActor(r)); err != nil {
+		if errors.Is(err, internalMsgs.ErrRoleGrantNotFound) {
+			errResponse(w, http.StatusNotFound, err)
+		} else {
+			errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+		}
+		log.Printf("NotFound: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("Role grant %s revoked for user %s", grantID, id)
+}
+
+func HandleListExpiringRoleGrants(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list expiring role grants", currentUserRole)
+		return
+	}
+
+	within := defaultExpiringWindow
+	if value := r.URL.Query().Get("within"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidQueryParameter)
+			log.Printf("BadRequest: invalid within: %s", value)
+			return
+		}
+		within = d
+	}
+
+	grants := ListExpiringRoleGrants(orgID, within)
+	if grants == nil {
+		grants = []ExpiringRoleGrant{}
+	}
+	jsonResponse(w, http.StatusOK, grants)
+	log.Printf("Expiring role grants listed: %d grants", len(grants))
+}
@@ -0,0 +1,85 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+)
+
+func TestTimeBoundRoleGrants(t *testing.T) {
+	setupTestStorageWithUsers()
+	audit.Reset()
+
+	until := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		userType       string
+		body           string
+		expectedStatus int
+	}{
+		{name: "Modifier cannot grant Admin", method: "POST", path: "/users/3/role-grants", userType: "Modifier", body: `{"role":"Admin","valid_until":"` + until + `"}`, expectedStatus: http.StatusForbidden},
+		{name: "Grant role must exist", method: "POST", path: "/users/3/role-grants", userType: "Admin", body: `{"role":"Master","valid_until":"` + until + `"}`, expectedStatus: http.StatusBadRequest},
+		{name: "Grant cannot already be expired", method: "POST", path: "/users/3/role-grants", userType: "Admin", body: `{"role":"Modifier","valid_until":"` + past + `"}`, expectedStatus: http.StatusBadRequest},
+		{name: "Admin can grant Modifier", method: "POST", path: "/users/3/role-grants", userType: "Admin", body: `{"role":"Modifier","valid_until":"` + until + `"}`, expectedStatus: http.StatusCreated},
+		{name: "Grant for unknown user", method: "POST", path: "/users/999/role-grants", userType: "Admin", body: `{"role":"Modifier"}`, expectedStatus: http.StatusNotFound},
+		{name: "Watcher cannot list expiring grants", method: "GET", path: "/role-grants/expiring", userType: "Watcher", expectedStatus: http.StatusForbidden},
+		{name: "Invalid expiring window", method: "GET", path: "/role-grants/expiring?within=soon", userType: "Admin", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, tt.method, tt.path, callerHeaders(tt.userType, ""), tt.body)
+			if status := rr.Code; status != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v: %s", status, tt.expectedStatus, rr.Body.String())
+			}
+		})
+	}
+
+	if role, _ := getUserTypeByID(DefaultOrgID, "3"); role != "Modifier" {
+		t.Errorf("expected granted role to be effective, got %s", role)
+	}
+
+	rr := doRequest(t, "GET", "/role-grants/expiring?within=2h", callerHeaders("Admin", ""), "")
+	var expiring []ExpiringRoleGrant
+	if err := json.NewDecoder(rr.Body).Decode(&expiring); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(expiring) != 1 || expiring[0].UserID != "3" || expiring[0].Grant.Role != "Modifier" {
+		t.Errorf("expected the Modifier grant of user 3 to be expiring, got %+v", expiring)
+	}
+
+	if removed := SweepExpiredRoleGrants(time.Now().Add(2 * time.Hour)); removed != 1 {
+		t.Errorf("expected 1 expired grant to be removed, got %d", removed)
+	}
+	if role, _ := getUserTypeByID(DefaultOrgID, "3"); role != "Watcher" {
+		t.Errorf("expected effective role to drop back to Watcher, got %s", role)
+	}
+	if events := audit.Events(AuditRoleGrantExpired); len(events) != 1 || events[0].Target != "3" {
+		t.Errorf("expected one role_grant.expired audit event for user 3, got %+v", events)
+	}
+
+	watcherGrant, err := AddRoleGrant(DefaultOrgID, "4", RoleGrant{Role: "Watcher"}, "Admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := doRequest(t, "DELETE", "/users/4/role-grants/"+watcherGrant.ID, callerHeaders("Modifier", "2"), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a Modifier to be unable to revoke a grant of another Modifier, got %v", rr.Code)
+	}
+
+	grant, err := AddRoleGrant(DefaultOrgID, "5", RoleGrant{Role: "Modifier"}, "Admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := doRequest(t, "DELETE", "/users/5/role-grants/"+grant.ID, callerHeaders("Admin", ""), ""); rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := doRequest(t, "DELETE", "/users/5/role-grants/"+grant.ID, callerHeaders("Admin", ""), ""); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,348 @@
+package user
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+	"zpe-cloud-user-management-service/internal/scim"
+)
+
+// SCIMValue is an element of a multi-valued SCIM attribute such as emails, roles,
+// groups or members.
+type SCIMValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// SCIMName is the name of a SCIM user. The service stores a single display name, so
+// only Formatted is returned; the other parts are accepted on input.
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMUser is a user in the SCIM core User schema. userName is the user's email address;
+// roles are the direct roles of the user and map onto the role hierarchy.
+type SCIMUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *SCIMName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []SCIMValue `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Roles       []SCIMValue `json:"roles,omitempty"`
+	Groups      []SCIMValue `json:"groups,omitempty"`
+	Meta        *scim.Meta  `json:"meta,omitempty"`
+}
+
+// SCIMGroup is a group in the SCIM core Group schema. The roles of a group are managed
+// through /groups and are kept when a group is provisioned over SCIM.
+type SCIMGroup struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []SCIMValue `json:"members,omitempty"`
+	Meta        *scim.Meta  `json:"meta,omitempty"`
+}
+
+// scimUserFields are the user fields a SCIM client can set.
+type scimUserFields struct {
+	Name       string
+	Email      string
+	ExternalID string
+	Active     bool
+	// Roles is nil when the client did not send any, which leaves the roles unchanged.
+	Roles []string
+}
+
+// fields extracts the user fields from a SCIM user, mapping role values onto the role
+// hierarchy case-insensitively.
+func (u *SCIMUser) fields() (scimUserFields, error) {
+	f := scimUserFields{ExternalID: u.ExternalID, Active: u.Active == nil || *u.Active}
+
+	// The primary email is the user's address; userName is used when there is none.
+	for _, email := range u.Emails {
+		if email.Primary || f.Email == "" {
+			f.Email = email.Value
+		}
+		if email.Primary {
+			break
+		}
+	}
+	if f.Email == "" {
+		f.Email = u.UserName
+	}
+	f.Email = NormalizeEmail(f.Email)
+
+	switch {
+	case strings.TrimSpace(u.DisplayName) != "":
+		f.Name = strings.TrimSpace(u.DisplayName)
+	case u.Name != nil && strings.TrimSpace(u.Name.Formatted) != "":
+		f.Name = strings.TrimSpace(u.Name.Formatted)
+	case u.Name != nil:
+		f.Name = strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+	}
+
+	for _, role := range u.Roles {
+		canonical, ok := canonicalRole(role.Value)
+		if !ok {
+			return f, scim.NewError(400, scim.ErrorInvalidValue, "%v: %s", internalErrors.ErrInvalidRole, role.Value)
+		}
+		if !containsString(f.Roles, canonical) {
+			f.Roles = append(f.Roles, canonical)
+		}
+	}
+	return f, nil
+}
+
+// canonicalRole returns the role of the hierarchy whose name matches value case-insensitively.
+func canonicalRole(value string) (string, bool) {
+	for role := range roleHierarchy {
+		if strings.EqualFold(role, strings.TrimSpace(value)) {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+// lowestRole returns the lowest-ranked role of the hierarchy, given to users that are
+// provisioned without roles.
+func lowestRole() string {
+	lowest := ""
+	for role := range roleHierarchy {
+		if lowest == "" || roleRank(role) < roleRank(lowest) {
+			lowest = role
+		}
+	}
+	return lowest
+}
+
+// newSCIMUser returns the SCIM representation of user. The caller must hold mu.
+func newSCIMUser(user *User) *SCIMUser {
+	active := user.Status == UserStatusActive
+	created, modified := user.CreatedAt, user.UpdatedAt
+	u := &SCIMUser{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          user.ID,
+		ExternalID:  user.externalID,
+		UserName:    user.Email,
+		Name:        &SCIMName{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []SCIMValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Roles:       []SCIMValue{},
+		Groups:      []SCIMValue{},
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      &created,
+			LastModified: &modified,
+			Location:     "/scim/v2/Users/" + user.ID,
+			Version:      etag(user.Version),
+		},
+	}
+
+	roles := append([]string{}, user.Roles...)
+	sort.Slice(roles, func(i, j int) bool {
+		return roleRank(roles[i]) > roleRank(roles[j])
+	})
+	for i, role := range roles {
+		u.Roles = append(u.Roles, SCIMValue{Value: role, Primary: i == 0})
+	}
+
+	for _, group := range groups {
+		if group.OrgID == user.OrgID && group.hasMember(user.ID) {
+			u.Groups = append(u.Groups, SCIMValue{Value: group.ID, Display: group.Name, Ref: "/scim/v2/Groups/" + group.ID})
+		}
+	}
+	sort.Slice(u.Groups, func(i, j int) bool {
+		return u.Groups[i].Display < u.Groups[j].Display
+	})
+	return u
+}
+
+// newSCIMGroup returns the SCIM representation of group. The caller must hold mu.
+func newSCIMGroup(group *Group) *SCIMGroup {
+	created, modified := group.CreatedAt, group.UpdatedAt
+	g := &SCIMGroup{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          group.ID,
+		ExternalID:  group.externalID,
+		DisplayName: group.Name,
+		Members:     []SCIMValue{},
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      &created,
+			LastModified: &modified,
+			Location:     "/scim/v2/Groups/" + group.ID,
+		},
+	}
+	for _, member := range group.Members {
+		value := SCIMValue{Value: member, Type: "User", Ref: "/scim/v2/Users/" + member}
+		if user, exists := users[member]; exists {
+			value.Display = user.Name
+		}
+		g.Members = append(g.Members, value)
+	}
+	return g
+}
+
+// GetSCIMUser returns the SCIM representation of a user.
+func GetSCIMUser(orgID, id string) (*SCIMUser, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	return newSCIMUser(user), nil
+}
+
+// ListSCIMUsers returns the SCIM representation of the users of an organization, oldest first.
+func ListSCIMUsers(orgID string) []*SCIMUser {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]*User, 0)
+	for _, user := range users {
+		if user.OrgID == orgID && !user.IsDeleted() {
+			list = append(list, user)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	result := make([]*SCIMUser, 0, len(list))
+	for _, user := range list {
+		result = append(result, newSCIMUser(user))
+	}
+	return result
+}
+
+// ReplaceSCIMUser applies the fields of a SCIM user to a stored user and returns the
+// updated representation. Deactivating a user disables their login; a changed email
+// address has to be verified again.
+// A non-empty ifMatch makes the update conditional on the user's current version.
+func ReplaceSCIMUser(orgID, id string, f scimUserFields, actor string, ifMatch []int64) (*SCIMUser, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	if err := checkVersion(user, ifMatch); err != nil {
+		return nil, err
+	}
+	emailKey := emailIndexKey(orgID, f.Email)
+	if owner, exists := emailIndex[emailKey]; exists && owner != id {
+		return nil, internalErrors.ErrUserAlreadyExists
+	}
+
+	if emailKey != user.emailKey {
+		delete(emailIndex, user.emailKey)
+		emailIndex[emailKey] = id
+		user.emailKey = emailKey
+		user.EmailVerified = false
+		user.verificationTokenID = ""
+	}
+	user.Email = f.Email
+	user.Name = f.Name
+	user.externalID = f.ExternalID
+	previous := user.Roles
+	if f.Roles != nil {
+		user.Roles = f.Roles
+	}
+	now := time.Now().UTC()
+	switch {
+	case f.Active && user.Status == UserStatusDisabled:
+		user.Status = UserStatusActive
+	case !f.Active && user.Status != UserStatusDisabled:
+		user.Status = UserStatusDisabled
+		revokeUserSessions(user, actor, SessionRevokedDisabled, now)
+	}
+	user.touch(actor, now)
+	revokeSessionsOnDowngrade(user, previous, actor, now)
+	return newSCIMUser(user), nil
+}
+
+// GetSCIMGroup returns the SCIM representation of a group.
+func GetSCIMGroup(orgID, id string) (*SCIMGroup, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	group, exists := lookupGroup(orgID, id)
+	if !exists {
+		return nil, internalErrors.ErrGroupNotFound
+	}
+	return newSCIMGroup(group), nil
+}
+
+// ListSCIMGroups returns the SCIM representation of the groups of an organization, by name.
+func ListSCIMGroups(orgID string) []*SCIMGroup {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]*SCIMGroup, 0)
+	for _, group := range groups {
+		if group.OrgID == orgID {
+			list = append(list, newSCIMGroup(group))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].DisplayName < list[j].DisplayName
+	})
+	return list
+}
+
+// ReplaceSCIMGroup sets the name, external ID and members of a group and returns the
+// updated representation. Every member must be a user of the organization. New members
+// that the roles of the group need approval for are left out and returned.
+func ReplaceSCIMGroup(orgID, id, name, externalID string, members []string, actor string) (*SCIMGroup, []string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	group, exists := lookupGroup(orgID, id)
+	if !exists {
+		return nil, nil, internalErrors.ErrGroupNotFound
+	}
+	if groupNameTaken(orgID, name, id) {
+		return nil, nil, internalErrors.ErrGroupAlreadyExists
+	}
+	unique := make([]string, 0, len(members))
+	var held []string
+	for _, member := range members {
+		user, exists := lookupUser(orgID, member)
+		if !exists || user.IsDeleted() {
+			return nil, nil, fmt.Errorf("%w: %s", internalErrors.ErrUserNotFound, member)
+		}
+		if containsString(unique, member) || containsString(held, member) {
+			continue
+		}
+		if !group.hasMember(member) && requiresApproval(user.Roles, group.Roles) {
+			held = append(held, member)
+			continue
+		}
+		unique = append(unique, member)
+	}
+	sort.Strings(unique)
+
+	group.Name = name
+	group.externalID = externalID
+	group.Members = unique
+	group.UpdatedAt = time.Now().UTC()
+	group.UpdatedBy = actor
+	return newSCIMGroup(group), held, nil
+}
@@ -0,0 +1,148 @@
+package user
+
+import (
+	"net/http"
+	"zpe-cloud-user-management-service/internal/scim"
+)
+
+// scimAttribute describes an attribute in a SCIM schema resource (RFC 7643 section 7).
+type scimAttribute struct {
+	Name          string          `json:"name"`
+	Type          string          `json:"type"`
+	MultiValued   bool            `json:"multiValued"`
+	Required      bool            `json:"required"`
+	CaseExact     bool            `json:"caseExact"`
+	Mutability    string          `json:"mutability"`
+	Returned      string          `json:"returned"`
+	Uniqueness    string          `json:"uniqueness"`
+	SubAttributes []scimAttribute `json:"subAttributes,omitempty"`
+}
+
+func attribute(name, typ, mutability string, required bool, sub ...scimAttribute) scimAttribute {
+	return scimAttribute{Name: name, Type: typ, Required: required, Mutability: mutability, Returned: "default", Uniqueness: "none", SubAttributes: sub}
+}
+
+func multiValued(a scimAttribute) scimAttribute {
+	a.MultiValued = true
+	return a
+}
+
+func serverUnique(a scimAttribute) scimAttribute {
+	a.Uniqueness = "server"
+	return a
+}
+
+var multiValueSubAttributes = []scimAttribute{
+	attribute("value", "string", "readWrite", false),
+	attribute("display", "string", "readOnly", false),
+	attribute("type", "string", "readWrite", false),
+	attribute("primary", "boolean", "readWrite", false),
+	attribute("$ref", "reference", "readOnly", false),
+}
+
+var scimSchemas = []map[string]any{
+	{
+		"schemas":     []string{scim.SchemaSchema},
+		"id":          scim.SchemaUser,
+		"name":        "User",
+		"description": "User account. userName is the email address; roles are Admin, Modifier, Watcher or SuperAdmin.",
+		"attributes": []scimAttribute{
+			serverUnique(attribute("userName", "string", "readWrite", true)),
+			attribute("name", "complex", "readWrite", false,
+				attribute("formatted", "string", "readWrite", false),
+				attribute("givenName", "string", "writeOnly", false),
+				attribute("familyName", "string", "writeOnly", false),
+			),
+			attribute("displayName", "string", "readWrite", false),
+			attribute("active", "boolean", "readWrite", false),
+			multiValued(attribute("emails", "complex", "readWrite", false, multiValueSubAttributes...)),
+			multiValued(attribute("roles", "complex", "readWrite", false, multiValueSubAttributes...)),
+			multiValued(attribute("groups", "complex", "readOnly", false, multiValueSubAttributes...)),
+		},
+		"meta": scim.Meta{ResourceType: "Schema", Location: "/scim/v2/Schemas/" + scim.SchemaUser},
+	},
+	{
+		"schemas":     []string{scim.SchemaSchema},
+		"id":          scim.SchemaGroup,
+		"name":        "Group",
+		"description": "Group. Members inherit the roles of the group.",
+		"attributes": []scimAttribute{
+			serverUnique(attribute("displayName", "string", "readWrite", true)),
+			multiValued(attribute("members", "complex", "readWrite", false, multiValueSubAttributes...)),
+		},
+		"meta": scim.Meta{ResourceType: "Schema", Location: "/scim/v2/Schemas/" + scim.SchemaGroup},
+	},
+}
+
+var scimResourceTypes = []map[string]any{
+	{
+		"schemas":  []string{scim.SchemaResourceType},
+		"id":       "User",
+		"name":     "User",
+		"endpoint": "/Users",
+		"schema":   scim.SchemaUser,
+		"meta":     scim.Meta{ResourceType: "ResourceType", Location: "/scim/v2/ResourceTypes/User"},
+	},
+	{
+		"schemas":  []string{scim.SchemaResourceType},
+		"id":       "Group",
+		"name":     "Group",
+		"endpoint": "/Groups",
+		"schema":   scim.SchemaGroup,
+		"meta":     scim.Meta{ResourceType: "ResourceType", Location: "/scim/v2/ResourceTypes/Group"},
+	},
+}
+
+var scimServiceProviderConfig = map[string]any{
+	"schemas":        []string{scim.SchemaServiceProviderConfig},
+	"patch":          map[string]bool{"supported": true},
+	"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+	"filter":         map[string]any{"supported": true, "maxResults": scimMaxResults},
+	"changePassword": map[string]bool{"supported": false},
+	"sort":           map[string]bool{"supported": false},
+	"etag":           map[string]bool{"supported": true},
+	"authenticationSchemes": []map[string]any{{
+		"type":        "oauthbearertoken",
+		"name":        "API key",
+		"description": "API key of a service account, sent as a bearer token",
+		"primary":     true,
+	}},
+	"meta": scim.Meta{ResourceType: "ServiceProviderConfig", Location: "/scim/v2/ServiceProviderConfig"},
+}
+
+// HandleSCIMDiscovery serves the ServiceProviderConfig, Schemas and ResourceTypes
+// resources, which describe the SCIM API and need no authorization.
+func HandleSCIMDiscovery(w http.ResponseWriter, resource, id string) {
+	var list []map[string]any
+	switch resource {
+	case "ServiceProviderConfig":
+		scimResponse(w, http.StatusOK, scimServiceProviderConfig)
+		return
+	case "Schemas":
+		list = scimSchemas
+	case "ResourceTypes":
+		list = scimResourceTypes
+	}
+
+	if id == "" {
+		resources := make([]any, 0, len(list))
+		for _, item := range list {
+			resources = append(resources, item)
+		}
+		scimResponse(w, http.StatusOK, scim.ListResponse{
+			Schemas:      []string{scim.SchemaListResponse},
+			TotalResults: len(resources),
+			StartIndex:   1,
+			ItemsPerPage: len(resources),
+			Resources:    resources,
+		})
+		return
+	}
+	for _, item := range list {
+		if item["id"] == id {
+			scimResponse(w, http.StatusOK, item)
+			return
+		}
+	}
+	scimError(w, scim.NewError(http.StatusNotFound, "", "%s %s not found", resource, id))
+}
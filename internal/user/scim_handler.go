@@ -0,0 +1,605 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+	"zpe-cloud-user-management-service/internal/scim"
+)
+
+// scimDefaultPageSize and scimMaxResults bound the size of SCIM list responses.
+const (
+	scimDefaultPageSize = 100
+	scimMaxResults      = 200
+)
+
+// HandleSCIM handles the SCIM 2.0 endpoints under /scim/v2/. Identity providers
+// authenticate with the API key of a service account holding an Admin role; the
+// discovery endpoints need no authentication.
+func HandleSCIM(w http.ResponseWriter, r *http.Request) {
+	resource, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/scim/v2/"), "/")
+	switch resource {
+	case "ServiceProviderConfig", "Schemas", "ResourceTypes":
+		if r.Method != http.MethodGet {
+			scimError(w, scim.NewError(http.StatusMethodNotAllowed, "", "%v", internalMsgs.ErrMethodNotAllowed))
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleSCIMDiscovery(w, resource, id)
+		return
+	case "Users", "Groups":
+	default:
+		scimError(w, scim.NewError(http.StatusNotFound, "", "%v", internalMsgs.ErrNotFound))
+		log.Printf("NotFound: %s", r.URL.Path)
+		return
+	}
+
+	// X-API-Key-ID is only set by AuthMiddleware, so the caller's role is the key's.
+	if r.Header.Get("X-API-Key-ID") == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		scimError(w, scim.NewError(http.StatusUnauthorized, "", "%v", internalMsgs.ErrAPIKeyRequired))
+		log.Printf("Unauthorized: UserType=%s attempted to use SCIM without an API key", r.Header.Get("X-User-Type"))
+		return
+	}
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		scimError(w, scim.NewError(http.StatusForbidden, "", "%v", internalMsgs.ErrForbidden))
+		log.Printf("Forbidden: UserType=%s attempted to use SCIM", currentUserRole)
+		return
+	}
+
+	switch {
+	case resource == "Users" && id == "" && r.Method == http.MethodGet:
+		HandleSCIMListUsers(w, r, orgID)
+	case resource == "Users" && id == "" && r.Method == http.MethodPost:
+		HandleSCIMCreateUser(w, r, orgID)
+	case resource == "Users" && id != "" && r.Method == http.MethodGet:
+		HandleSCIMGetUser(w, r, orgID, id)
+	case resource == "Users" && id != "" && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		HandleSCIMReplaceUser(w, r, orgID, id)
+	case resource == "Users" && id != "" && r.Method == http.MethodDelete:
+		HandleSCIMDeleteUser(w, r, orgID, id)
+	case resource == "Groups" && id == "" && r.Method == http.MethodGet:
+		HandleSCIMListGroups(w, r, orgID)
+	case resource == "Groups" && id == "" && r.Method == http.MethodPost:
+		HandleSCIMCreateGroup(w, r, orgID)
+	case resource == "Groups" && id != "" && r.Method == http.MethodGet:
+		HandleSCIMGetGroup(w, r, orgID, id)
+	case resource == "Groups" && id != "" && (r.Method == http.MethodPut || r.Method == http.MethodPatch):
+		HandleSCIMReplaceGroup(w, r, orgID, id)
+	case resource == "Groups" && id != "" && r.Method == http.MethodDelete:
+		HandleSCIMDeleteGroup(w, r, orgID, id)
+	default:
+		scimError(w, scim.NewError(http.StatusMethodNotAllowed, "", "%v", internalMsgs.ErrMethodNotAllowed))
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+func HandleSCIMListUsers(w http.ResponseWriter, r *http.Request, orgID string) {
+	list := ListSCIMUsers(orgID)
+	resources := make([]any, 0, len(list))
+	for _, u := range list {
+		resources = append(resources, u)
+	}
+	writeSCIMList(w, r, resources)
+}
+
+func HandleSCIMGetUser(w http.ResponseWriter, r *http.Request, orgID, id string) {
+	u, err := GetSCIMUser(orgID, id)
+	if err != nil {
+		scimError(w, err)
+		log.Printf("NotFound: User %s", id)
+		return
+	}
+	w.Header().Set("ETag", u.Meta.Version)
+	scimResponse(w, http.StatusOK, u)
+}
+
+func HandleSCIMCreateUser(w http.ResponseWriter, r *http.Request, orgID string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+
+	var req SCIMUser
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimError(w, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, "%v", internalMsgs.ErrInvalidRequestPayload))
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	f, err := req.fields()
+	if err != nil {
+		scimError(w, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	if f.Roles == nil {
+		f.Roles = []string{lowestRole()}
+	}
+	if err := isValidRoleUpdate(f.Roles, currentUserRole); err != nil {
+		scimError(w, scim.NewError(http.StatusForbidden, "", "%v", err))
+		log.Printf("Forbidden: UserType=%s attempted to provision a user: %v", currentUserRole, err)
+		return
+	}
+
+	user := &User{OrgID: orgID, Name: f.Name, Email: f.Email, Roles: f.Roles, externalID: f.ExternalID}
+	if !f.Active {
+		user.Status = UserStatusDisabled
+	}
+	if err := user.ValidateRequiredFields(); err != nil {
+		scimError(w, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "%v", err))
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	if err := user.ValidateAttributes(); err != nil {
+		scimError(w, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "%v", err))
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	if !scimCheckPolicy(w, r, orgID, "users.create", "", user, nil) {
+		return
+	}
+	// Roles that need approval are requested; until then the user only has the lowest role.
+	var pendingRoles []string
+	if requiresApproval(nil, user.Roles) {
+		pendingRoles, user.Roles = user.Roles, []string{lowestRole()}
+	}
+	if err := CreateUser(user, currentActor(r)); err != nil {
+		scimError(w, err)
+		log.Printf("Conflict: %v", err)
+		return
+	}
+	if pendingRoles != nil {
+		requestPendingRoles(orgID, user.ID, pendingRoles, currentActor(r))
+	}
+
+	created, err := GetSCIMUser(orgID, user.ID)
+	if err != nil {
+		scimError(w, err)
+		return
+	}
+	w.Header().Set("Location", created.Meta.Location)
+	w.Header().Set("ETag", created.Meta.Version)
+	scimResponse(w, http.StatusCreated, created)
+	log.Printf("User provisioned over SCIM: %s", user.ID)
+}
+
+// HandleSCIMReplaceUser handles PUT, which replaces the user, and PATCH, which is
+// applied to the current representation and then handled like a PUT. Role changes that
+// need approval are submitted as role change requests and not applied yet.
+func HandleSCIMReplaceUser(w http.ResponseWriter, r *http.Request, orgID, id string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+
+	current, err := GetUser(orgID, id)
+	if err != nil {
+		scimError(w, err)
+		log.Printf("NotFound: User %s", id)
+		return
+	}
+	if err := scimCanManageUser(orgID, id, currentUserRole); err != nil {
+		scimError(w, err)
+		log.Printf("Forbidden: UserType=%s attempted to update user %s over SCIM", currentUserRole, id)
+		return
+	}
+	ifMatch, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err != nil {
+		scimError(w, err)
+		log.Printf("PreconditionFailed: User %s", id)
+		return
+	}
+
+	var req SCIMUser
+	if r.Method == http.MethodPatch {
+		representation, err := GetSCIMUser(orgID, id)
+		if err == nil {
+			err = applySCIMPatch(r, representation, &req)
+		}
+		if err != nil {
+			scimError(w, err)
+			log.Printf("BadRequest: %v", err)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimError(w, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, "%v", internalMsgs.ErrInvalidRequestPayload))
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	f, err := req.fields()
+	if err == nil && f.Name == "" {
+		err = scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "fields required: name")
+	}
+	if err == nil {
+		if emailErr := validateEmail(f.Email); emailErr != nil {
+			err = scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "%v", emailErr)
+		}
+	}
+	if err != nil {
+		scimError(w, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	if !scimCheckPolicy(w, r, orgID, "users.update_attributes", id, nil, nil) {
+		return
+	}
+	var pendingRoles []string
+	if f.Roles != nil && !sameRoles(f.Roles, current.Roles) {
+		if err := isValidRoleUpdate(f.Roles, currentUserRole); err != nil {
+			scimError(w, scim.NewError(http.StatusForbidden, "", "%v", err))
+			log.Printf("Forbidden: UserType=%s attempted to set roles %v over SCIM", currentUserRole, f.Roles)
+			return
+		}
+		if !scimCheckPolicy(w, r, orgID, "users.update_roles", id, nil, map[string]any{"roles": f.Roles}) {
+			return
+		}
+		if requiresApproval(current.Roles, f.Roles) {
+			pendingRoles, f.Roles = f.Roles, nil
+		}
+	} else {
+		f.Roles = nil
+	}
+
+	updated, err := ReplaceSCIMUser(orgID, id, f, currentActor(r), ifMatch)
+	if err != nil {
+		scimError(w, err)
+		log.Printf("User %s not updated over SCIM: %v", id, err)
+		return
+	}
+	if pendingRoles != nil {
+		requestPendingRoles(orgID, id, pendingRoles, currentActor(r))
+	}
+
+	w.Header().Set("ETag", updated.Meta.Version)
+	scimResponse(w, http.StatusOK, updated)
+	log.Printf("User updated over SCIM: %s", id)
+}
+
+func HandleSCIMDeleteUser(w http.ResponseWriter, r *http.Request, orgID, id string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	if err := scimCanManageUser(orgID, id, currentUserRole); err != nil {
+		scimError(w, err)
+		log.Printf("User %s not deleted over SCIM: %v", id, err)
+		return
+	}
+	if !scimCheckPolicy(w, r, orgID, "users.delete", id, nil, nil) {
+		return
+	}
+
+	ifMatch, err := parseIfMatch(r.Header.Get("If-Match"))
+	if err == nil {
+		err = DeleteUser(orgID, id, currentActor(r), ifMatch)
+	}
+	if err != nil {
+		scimError(w, err)
+		log.Printf("User %s not deleted over SCIM: %v", id, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("UserType=%s deleted user %s over SCIM", currentUserRole, id)
+}
+
+func HandleSCIMListGroups(w http.ResponseWriter, r *http.Request, orgID string) {
+	list := ListSCIMGroups(orgID)
+	resources := make([]any, 0, len(list))
+	for _, g := range list {
+		resources = append(resources, g)
+	}
+	writeSCIMList(w, r, resources)
+}
+
+func HandleSCIMGetGroup(w http.ResponseWriter, r *http.Request, orgID, id string) {
+	g, err := GetSCIMGroup(orgID, id)
+	if err != nil {
+		scimError(w, err)
+		log.Printf("NotFound: Group %s", id)
+		return
+	}
+	scimResponse(w, http.StatusOK, g)
+}
+
+func HandleSCIMCreateGroup(w http.ResponseWriter, r *http.Request, orgID string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+
+	var req SCIMGroup
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimError(w, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, "%v", internalMsgs.ErrInvalidRequestPayload))
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	group := &Group{OrgID: orgID, Name: strings.TrimSpace(req.DisplayName)}
+	if err := group.ValidateRequiredFields(); err != nil {
+		scimError(w, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "%v", err))
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	if !scimCheckPolicy(w, r, orgID, "groups.create", "", nil, nil) {
+		return
+	}
+	members := scimMemberIDs(req.Members)
+	for _, member := range members {
+		if err := scimCanManageMember(orgID, member, currentUserRole); err != nil {
+			scimError(w, err)
+			log.Printf("Group %s not provisioned: %v", group.Name, err)
+			return
+		}
+		if !scimCheckPolicy(w, r, orgID, "groups.add_member", member, nil, nil) {
+			return
+		}
+	}
+
+	if err := CreateGroup(group, currentActor(r)); err != nil {
+		scimError(w, err)
+		log.Printf("Group creation failed: %v", err)
+		return
+	}
+	created, _, err := ReplaceSCIMGroup(orgID, group.ID, group.Name, req.ExternalID, members, currentActor(r))
+	if err != nil {
+		scimError(w, err)
+		log.Printf("Group %s members not set: %v", group.ID, err)
+		return
+	}
+
+	w.Header().Set("Location", created.Meta.Location)
+	scimResponse(w, http.StatusCreated, created)
+	log.Printf("Group provisioned over SCIM: %s", group.ID)
+}
+
+// HandleSCIMReplaceGroup handles PUT and PATCH of a group. Adding members grants them
+// the roles of the group, so the caller must be allowed to assign those roles and to
+// manage every user whose membership changes. New members that the roles of the group
+// need approval for get a role change request instead.
+func HandleSCIMReplaceGroup(w http.ResponseWriter, r *http.Request, orgID, id string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+
+	current, err := GetSCIMGroup(orgID, id)
+	if err != nil {
+		scimError(w, err)
+		log.Printf("NotFound: Group %s", id)
+		return
+	}
+	group, err := GetGroup(orgID, id)
+	if err != nil {
+		scimError(w, err)
+		log.Printf("NotFound: Group %s", id)
+		return
+	}
+
+	var req SCIMGroup
+	if r.Method == http.MethodPatch {
+		if err := applySCIMPatch(r, current, &req); err != nil {
+			scimError(w, err)
+			log.Printf("BadRequest: %v", err)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		scimError(w, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, "%v", internalMsgs.ErrInvalidRequestPayload))
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	name := strings.TrimSpace(req.DisplayName)
+	if name == "" {
+		scimError(w, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "fields required: displayName"))
+		log.Printf("BadRequest: group %s without displayName", id)
+		return
+	}
+
+	if !scimCheckPolicy(w, r, orgID, "groups.update", "", nil, map[string]any{"group_id": id, "roles": group.Roles}) {
+		return
+	}
+	members := scimMemberIDs(req.Members)
+	added := 0
+	for _, member := range members {
+		if !group.hasMember(member) {
+			added++
+			if err := scimCanManageMember(orgID, member, currentUserRole); err != nil {
+				scimError(w, err)
+				log.Printf("Group %s not updated: %v", id, err)
+				return
+			}
+			if !scimCheckPolicy(w, r, orgID, "groups.add_member", member, nil, nil) {
+				return
+			}
+		}
+	}
+	for _, member := range group.Members {
+		if !containsString(members, member) {
+			if err := scimCanManageMember(orgID, member, currentUserRole); err != nil {
+				scimError(w, err)
+				log.Printf("Group %s not updated: %v", id, err)
+				return
+			}
+			if !scimCheckPolicy(w, r, orgID, "groups.remove_member", member, nil, nil) {
+				return
+			}
+		}
+	}
+	if added > 0 {
+		if err := isValidRoleUpdate(group.Roles, currentUserRole); err != nil {
+			scimError(w, scim.NewError(http.StatusForbidden, "", "%v", internalMsgs.ErrInsufficientPermissions))
+			log.Printf("Forbidden: %v", err)
+			return
+		}
+	}
+
+	updated, held, err := ReplaceSCIMGroup(orgID, id, name, req.ExternalID, members, currentActor(r))
+	if err != nil {
+		scimError(w, err)
+		log.Printf("Group %s not updated over SCIM: %v", id, err)
+		return
+	}
+	for _, member := range held {
+		if pending, err := CreateGroupMembershipRequest(orgID, id, member, currentActor(r)); err != nil {
+			log.Printf("Failed to request membership of user %s in group %s: %v", member, id, err)
+		} else {
+			log.Printf("Membership of user %s in group %s is pending approval: %s", member, id, pending.ID)
+		}
+	}
+
+	scimResponse(w, http.StatusOK, updated)
+	log.Printf("Group updated over SCIM: %s", id)
+}
+
+func HandleSCIMDeleteGroup(w http.ResponseWriter, r *http.Request, orgID, id string) {
+	if !scimCheckPolicy(w, r, orgID, "groups.delete", "", nil, map[string]any{"group_id": id}) {
+		return
+	}
+	if err := DeleteGroup(orgID, id); err != nil {
+		scimError(w, err)
+		log.Printf("NotFound: Group %s", id)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("UserType=%s deleted group %s over SCIM", r.Header.Get("X-User-Type"), id)
+}
+
+// scimCheckPolicy is checkPolicy with a SCIM error response.
+func scimCheckPolicy(w http.ResponseWriter, r *http.Request, orgID, action, targetID string, pending *User, extra map[string]any) bool {
+	if err := policyDenial(r, orgID, action, targetID, pending, extra); err != nil {
+		scimError(w, scim.NewError(http.StatusForbidden, "", "%v", err))
+		return false
+	}
+	return true
+}
+
+// scimCanManageUser checks that the caller's role may manage the user's current role.
+func scimCanManageUser(orgID, id, currentUserRole string) error {
+	targetUserRole, err := getUserTypeByID(orgID, id)
+	if err != nil {
+		return err
+	}
+	if !isRoleExists(currentUserRole) || !isValidCrudOperation(currentUserRole, targetUserRole) {
+		return scim.NewError(http.StatusForbidden, "", "%v", internalMsgs.ErrForbidden)
+	}
+	return nil
+}
+
+// scimCanManageMember is scimCanManageUser for a user referenced as a group member, where
+// an unknown user is an invalid value rather than a missing resource.
+func scimCanManageMember(orgID, id, currentUserRole string) error {
+	err := scimCanManageUser(orgID, id, currentUserRole)
+	if errors.Is(err, internalMsgs.ErrUserNotFound) {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "%v: %s", internalMsgs.ErrUserNotFound, id)
+	}
+	return err
+}
+
+func scimMemberIDs(members []SCIMValue) []string {
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.Value != "" && !containsString(ids, member.Value) {
+			ids = append(ids, member.Value)
+		}
+	}
+	return ids
+}
+
+func sameRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// applySCIMPatch applies the PATCH request in r to the current representation of a
+// resource and decodes the result into v.
+func applySCIMPatch(r *http.Request, current any, v any) error {
+	var req scim.PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Operations) == 0 {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, "%v", internalMsgs.ErrInvalidRequestPayload)
+	}
+	resource, err := scim.ToMap(current)
+	if err != nil {
+		return err
+	}
+	if err := scim.ApplyPatch(resource, req.Operations); err != nil {
+		return err
+	}
+	return scim.FromMap(resource, v)
+}
+
+// writeSCIMList filters and paginates resources according to the filter, startIndex and
+// count query parameters.
+func writeSCIMList(w http.ResponseWriter, r *http.Request, resources []any) {
+	query := r.URL.Query()
+	start, count, err := scim.Page(query.Get("startIndex"), query.Get("count"), scimDefaultPageSize, scimMaxResults)
+	if err != nil {
+		scimError(w, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	if expr := query.Get("filter"); expr != "" {
+		filter, err := scim.ParseFilter(expr)
+		if err != nil {
+			scimError(w, err)
+			log.Printf("BadRequest: %v", err)
+			return
+		}
+		matched := make([]any, 0)
+		for _, resource := range resources {
+			if m, err := scim.ToMap(resource); err == nil && filter.Match(m) {
+				matched = append(matched, resource)
+			}
+		}
+		resources = matched
+	}
+
+	page := []any{}
+	if start <= len(resources) {
+		page = resources[start-1 : min(start-1+count, len(resources))]
+	}
+	scimResponse(w, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	})
+}
+
+func scimResponse(w http.ResponseWriter, code int, payload any) {
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// scimError writes err as a SCIM error response, mapping the service's errors onto
+// HTTP statuses and SCIM error types.
+func scimError(w http.ResponseWriter, err error) {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		switch {
+		case errors.Is(err, internalMsgs.ErrUserNotFound), errors.Is(err, internalMsgs.ErrGroupNotFound):
+			scimErr = scim.NewError(http.StatusNotFound, "", "%v", err)
+		case errors.Is(err, internalMsgs.ErrUserAlreadyExists), errors.Is(err, internalMsgs.ErrGroupAlreadyExists), errors.Is(err, internalMsgs.ErrAttributeNotUnique):
+			scimErr = scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "%v", err)
+		case errors.Is(err, internalMsgs.ErrPreconditionFailed):
+			scimErr = scim.NewError(http.StatusPreconditionFailed, "", "%v", err)
+		case errors.Is(err, internalMsgs.ErrOrganizationNotFound):
+			scimErr = scim.NewError(http.StatusNotFound, "", "%v", err)
+		default:
+			log.Printf("SCIM request failed: %v", err)
+			scimErr = scim.NewError(http.StatusInternalServerError, "", "%v", internalMsgs.ErrInternalServerError)
+		}
+	}
+	status, _ := strconv.Atoi(scimErr.Status)
+	scimResponse(w, status, scimErr)
+}
@@ -0,0 +1,146 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// scimHeaders returns the headers of an identity provider that authenticates with the
+// API key of a new service account holding role.
+func scimHeaders(t *testing.T, role string) map[string]string {
+	t.Helper()
+	account := &ServiceAccount{OrgID: DefaultOrgID, Name: fmt.Sprintf("idp-%d", len(ListServiceAccounts(DefaultOrgID))), Roles: []string{role}}
+	if err := CreateServiceAccount(account, "system"); err != nil {
+		t.Fatalf("failed to create service account: %v", err)
+	}
+	_, key, err := CreateAPIKey(DefaultOrgID, account.ID, &APIKey{Name: "scim"}, "system")
+	if err != nil {
+		t.Fatalf("failed to create API key: %v", err)
+	}
+	return map[string]string{"X-API-Key": key}
+}
+
+func TestSCIMProvisioning(t *testing.T) {
+	setupTestStorageWithUsers()
+
+	rr := doRequest(t, "GET", "/scim/v2/ServiceProviderConfig", nil, "")
+	resp := decodeResponse(rr)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/scim+json" || resp["patch"].(map[string]any)["supported"] != true {
+		t.Errorf("unexpected ServiceProviderConfig %v: %v", rr.Code, resp)
+	}
+	if rr := doRequest(t, "GET", "/scim/v2/ResourceTypes", nil, ""); rr.Code != http.StatusOK || decodeResponse(rr)["totalResults"] != float64(2) {
+		t.Errorf("unexpected ResourceTypes %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, "GET", "/scim/v2/Schemas/urn:ietf:params:scim:schemas:core:2.0:User", nil, ""); rr.Code != http.StatusOK {
+		t.Errorf("expected User schema, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "GET", "/scim/v2/Users", scimHeaders(t, "Watcher"), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected non-admin to be forbidden, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "GET", "/scim/v2/Users", callerHeaders("Admin", ""), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a caller without an API key to be rejected, got %v", rr.Code)
+	}
+	idp := scimHeaders(t, "Admin")
+
+	ahsoka := `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"externalId":"okta-1","userName":"ahsoka@example.com",
+		"name":{"givenName":"Ahsoka","familyName":"Tano"},"emails":[{"value":"Ahsoka@Example.com","primary":true}]}`
+	rr = doRequest(t, "POST", "/scim/v2/Users", idp, ahsoka)
+	resp = decodeResponse(rr)
+	if rr.Code != http.StatusCreated || resp["userName"] != "Ahsoka@example.com" || resp["displayName"] != "Ahsoka Tano" {
+		t.Fatalf("expected user to be provisioned, got %v: %v", rr.Code, resp)
+	}
+	id := resp["id"].(string)
+	if roles := resp["roles"].([]any); len(roles) != 1 || roles[0].(map[string]any)["value"] != "Watcher" {
+		t.Errorf("expected provisioned user to get the lowest role, got %v", roles)
+	}
+	if rr.Header().Get("Location") != "/scim/v2/Users/"+id {
+		t.Errorf("unexpected Location: %s", rr.Header().Get("Location"))
+	}
+	if rr := doRequest(t, "POST", "/scim/v2/Users", idp, ahsoka); rr.Code != http.StatusConflict || decodeResponse(rr)["scimType"] != "uniqueness" {
+		t.Errorf("expected duplicate user to conflict, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, "POST", "/scim/v2/Users", idp, `{"userName":"palpatine@example.com","displayName":"Palpatine","roles":[{"value":"superadmin"}]}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected Admin to be unable to provision a SuperAdmin, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", "/scim/v2/Users", idp, `{"userName":"x@example.com","displayName":"X","roles":[{"value":"Jedi"}]}`); rr.Code != http.StatusBadRequest || decodeResponse(rr)["scimType"] != "invalidValue" {
+		t.Errorf("expected unknown role to be rejected, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	listTests := []struct {
+		query        string
+		total, items int
+	}{
+		{query: "", total: 7, items: 7},
+		{query: "?count=2&startIndex=3", total: 7, items: 2},
+		{query: "?startIndex=10", total: 7, items: 0},
+		{query: "?filter=" + url.QueryEscape(`userName eq "AHSOKA@example.com"`), total: 1, items: 1},
+		{query: "?filter=" + url.QueryEscape(`externalId eq "okta-1" and active eq true`), total: 1, items: 1},
+		{query: "?filter=" + url.QueryEscape(`roles[value eq "Admin"]`), total: 2, items: 2},
+	}
+	for _, tt := range listTests {
+		t.Run("List"+tt.query, func(t *testing.T) {
+			rr := doRequest(t, "GET", "/scim/v2/Users"+tt.query, idp, "")
+			resp := decodeResponse(rr)
+			if rr.Code != http.StatusOK || resp["totalResults"] != float64(tt.total) || resp["itemsPerPage"] != float64(tt.items) {
+				t.Errorf("unexpected list response %v: %v", rr.Code, resp)
+			}
+		})
+	}
+	if rr := doRequest(t, "GET", "/scim/v2/Users?filter="+url.QueryEscape(`userName eq`), idp, ""); rr.Code != http.StatusBadRequest || decodeResponse(rr)["scimType"] != "invalidFilter" {
+		t.Errorf("expected invalid filter to be rejected, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	patch := `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+		{"op":"Replace","path":"active","value":false},
+		{"op":"replace","path":"roles","value":[{"value":"modifier"}]}]}`
+	rr = doRequest(t, "PATCH", "/scim/v2/Users/"+id, idp, patch)
+	resp = decodeResponse(rr)
+	if rr.Code != http.StatusOK || resp["active"] != false {
+		t.Fatalf("expected user to be deactivated, got %v: %v", rr.Code, resp)
+	}
+	if user, _ := GetUser(DefaultOrgID, id); user.Status != UserStatusDisabled || !reflect.DeepEqual(user.Roles, []string{"Modifier"}) {
+		t.Errorf("expected disabled Modifier, got %s %v", user.Status, user.Roles)
+	}
+	rr = doRequest(t, "PUT", "/scim/v2/Users/"+id, idp, `{"userName":"ahsoka@example.com","displayName":"Ahsoka","active":true}`)
+	resp = decodeResponse(rr)
+	if rr.Code != http.StatusOK || resp["active"] != true || resp["displayName"] != "Ahsoka" {
+		t.Errorf("expected user to be replaced, got %v: %v", rr.Code, resp)
+	}
+	if user, _ := GetUser(DefaultOrgID, id); !reflect.DeepEqual(user.Roles, []string{"Modifier"}) {
+		t.Errorf("expected roles to be kept when PUT has none, got %v", user.Roles)
+	}
+
+	rr = doRequest(t, "POST", "/scim/v2/Groups", idp, `{"displayName":"Jedi","members":[{"value":"2"}]}`)
+	resp = decodeResponse(rr)
+	if rr.Code != http.StatusCreated || len(resp["members"].([]any)) != 1 {
+		t.Fatalf("expected group to be provisioned, got %v: %v", rr.Code, resp)
+	}
+	groupID := resp["id"].(string)
+	if rr := doRequest(t, "POST", "/scim/v2/Groups", idp, `{"displayName":"Sith","members":[{"value":"999"}]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected unknown member to be rejected, got %v", rr.Code)
+	}
+	groupPatch := `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+		{"op":"add","path":"members","value":[{"value":"` + id + `"}]},
+		{"op":"remove","path":"members[value eq \"2\"]"}]}`
+	rr = doRequest(t, "PATCH", "/scim/v2/Groups/"+groupID, idp, groupPatch)
+	resp = decodeResponse(rr)
+	if members := resp["members"].([]any); rr.Code != http.StatusOK || len(members) != 1 || members[0].(map[string]any)["value"] != id {
+		t.Errorf("expected membership to be patched, got %v: %v", rr.Code, resp)
+	}
+	if resp := decodeResponse(doRequest(t, "GET", "/scim/v2/Users/"+id, idp, "")); len(resp["groups"].([]any)) != 1 {
+		t.Errorf("expected user to list the group, got %v", resp["groups"])
+	}
+
+	if rr := doRequest(t, "DELETE", "/scim/v2/Users/"+id, idp, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected user to be deleted, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "GET", "/scim/v2/Users/"+id, idp, ""); rr.Code != http.StatusNotFound || decodeResponse(rr)["status"] != "404" {
+		t.Errorf("expected deleted user to be gone, got %v: %s", rr.Code, rr.Body.String())
+	}
+	if rr := doRequest(t, "DELETE", "/scim/v2/Groups/"+groupID, idp, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected group to be deleted, got %v", rr.Code)
+	}
+}
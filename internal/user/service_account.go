@@ -0,0 +1,395 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	"zpe-cloud-user-management-service/internal/ids"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// ServiceAccount is a non-human principal used by automation. It authenticates with
+// API keys, each limited to a subset of the account's roles.
+type ServiceAccount struct {
+	ID          string    `json:"id"`
+	OrgID       string    `json:"org_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Roles       []string  `json:"roles"`
+	CreatedAt   time.Time `json:"created_at"`
+	CreatedBy   string    `json:"created_by"`
+}
+
+// APIKey is a credential of a service account. Only a hash of its secret is stored.
+type APIKey struct {
+	ID               string     `json:"id"`
+	ServiceAccountID string     `json:"service_account_id"`
+	OrgID            string     `json:"org_id"`
+	Name             string     `json:"name"`
+	Roles            []string   `json:"roles"`
+	AllowedIPs       []string   `json:"allowed_ips,omitempty"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	CreatedBy        string     `json:"created_by"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+
+	secretHash string
+	prefixes   []netip.Prefix
+}
+
+// APIKeyPrefix starts every API key so that keys are easy to recognize and to tell apart
+// from other bearer tokens.
+const APIKeyPrefix = "zpe_"
+
+// serviceAccountIDPrefix keeps service account IDs apart from user IDs, which matters
+// because both are used as X-User-ID.
+const serviceAccountIDPrefix = "sa_"
+
+// Audit event types emitted for service accounts and API keys.
+const (
+	AuditServiceAccountCreated = "service_account.created"
+	AuditServiceAccountDeleted = "service_account.deleted"
+	AuditAPIKeyCreated         = "api_key.created"
+	AuditAPIKeyRevoked         = "api_key.revoked"
+	AuditAPIKeyUsed            = "api_key.used"
+)
+
+// serviceAccounts, apiKeys and their ID generators are guarded by mu.
+var (
+	serviceAccounts                         = make(map[string]*ServiceAccount)
+	apiKeys                                 = make(map[string]*APIKey)
+	serviceAccountIDGenerator ids.Generator = ids.NewSequential()
+)
+
+// ValidateRequiredFields checks that the service account has a name and only known roles.
+func (a *ServiceAccount) ValidateRequiredFields() error {
+	var missingFields []string
+	if strings.TrimSpace(a.Name) == "" {
+		missingFields = append(missingFields, "name")
+	}
+	if len(a.Roles) == 0 {
+		missingFields = append(missingFields, "roles")
+	}
+	if len(missingFields) > 0 {
+		return errors.New("fields required: " + strings.Join(missingFields, ", "))
+	}
+	for _, role := range a.Roles {
+		if !isRoleExists(role) {
+			return fmt.Errorf("%w: %s", internalErrors.ErrInvalidRole, role)
+		}
+	}
+	return nil
+}
+
+func (a *ServiceAccount) clone() *ServiceAccount {
+	c := *a
+	c.Roles = append([]string{}, a.Roles...)
+	return &c
+}
+
+func (k *APIKey) clone() *APIKey {
+	c := *k
+	c.Roles = append([]string{}, k.Roles...)
+	c.AllowedIPs = append([]string(nil), k.AllowedIPs...)
+	return &c
+}
+
+// active reports whether the key can still be used at time t.
+func (k *APIKey) active(t time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || t.Before(*k.ExpiresAt))
+}
+
+// allowsIP reports whether the key may be used from ip. Keys without an allowlist can
+// be used from anywhere.
+func (k *APIKey) allowsIP(ip netip.Addr) bool {
+	if len(k.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range k.prefixes {
+		if prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowedIPs parses an allowlist of IP addresses and CIDR ranges.
+func parseAllowedIPs(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid IP address or range %q", internalErrors.ErrInvalidAPIKey, item)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// CreateServiceAccount stores a new service account in the organization named by account.OrgID.
+func CreateServiceAccount(account *ServiceAccount, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := orgs[account.OrgID]; !exists {
+		return internalErrors.ErrOrganizationNotFound
+	}
+	for _, other := range serviceAccounts {
+		if other.OrgID == account.OrgID && strings.EqualFold(other.Name, account.Name) {
+			return internalErrors.ErrServiceAccountAlreadyExists
+		}
+	}
+
+	account.ID = serviceAccountIDPrefix + serviceAccountIDGenerator.NewID()
+	account.CreatedAt = time.Now().UTC()
+	account.CreatedBy = actor
+	serviceAccounts[account.ID] = account
+	audit.Record(audit.Event{Type: AuditServiceAccountCreated, OrgID: account.OrgID, Actor: actor, Target: account.ID, Details: map[string]string{"roles": strings.Join(account.Roles, ",")}})
+	return nil
+}
+
+// lookupServiceAccount returns the stored account with the given ID if it belongs to orgID.
+// The caller must hold mu.
+func lookupServiceAccount(orgID, id string) (*ServiceAccount, bool) {
+	account, exists := serviceAccounts[id]
+	if !exists || account.OrgID != orgID {
+		return nil, false
+	}
+	return account, true
+}
+
+// ownsServiceAccount reports whether id is a service account created by owner. The
+// caller must hold mu.
+func ownsServiceAccount(orgID, owner, id string) bool {
+	account, exists := lookupServiceAccount(orgID, id)
+	return exists && account.CreatedBy == owner
+}
+
+// setServiceAccountRoles replaces the roles of a service account for an approved
+// request. Its keys keep the roles they were created with, but only act with those the
+// account still holds. The caller must hold mu.
+func setServiceAccountRoles(orgID, id string, roles []string) error {
+	account, exists := lookupServiceAccount(orgID, id)
+	if !exists {
+		return internalErrors.ErrServiceAccountNotFound
+	}
+	account.Roles = append([]string{}, roles...)
+	return nil
+}
+
+func GetServiceAccount(orgID, id string) (*ServiceAccount, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	account, exists := lookupServiceAccount(orgID, id)
+	if !exists {
+		return nil, internalErrors.ErrServiceAccountNotFound
+	}
+	return account.clone(), nil
+}
+
+func ListServiceAccounts(orgID string) []*ServiceAccount {
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := make([]*ServiceAccount, 0)
+	for _, account := range serviceAccounts {
+		if account.OrgID == orgID {
+			list = append(list, account.clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// DeleteServiceAccount removes a service account together with all of its keys.
+func DeleteServiceAccount(orgID, id, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := lookupServiceAccount(orgID, id); !exists {
+		return internalErrors.ErrServiceAccountNotFound
+	}
+	for keyID, key := range apiKeys {
+		if key.ServiceAccountID == id {
+			delete(apiKeys, keyID)
+		}
+	}
+	delete(serviceAccounts, id)
+	audit.Record(audit.Event{Type: AuditServiceAccountDeleted, OrgID: orgID, Actor: actor, Target: id})
+	return nil
+}
+
+// CreateAPIKey issues a key for a service account and returns it together with the
+// plaintext key, which is not stored and cannot be shown again. The key's roles must be
+// a subset of the account's roles; no roles means all of them.
+func CreateAPIKey(orgID, accountID string, key *APIKey, actor string) (*APIKey, string, error) {
+	prefixes, err := parseAllowedIPs(key.AllowedIPs)
+	if err != nil {
+		return nil, "", err
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expires_at must be in the future", internalErrors.ErrInvalidAPIKey)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	account, exists := lookupServiceAccount(orgID, accountID)
+	if !exists {
+		return nil, "", internalErrors.ErrServiceAccountNotFound
+	}
+	if len(key.Roles) == 0 {
+		key.Roles = append([]string{}, account.Roles...)
+	}
+	for _, role := range key.Roles {
+		if !containsString(account.Roles, role) {
+			return nil, "", fmt.Errorf("%w: role %s is not held by the service account", internalErrors.ErrInvalidAPIKey, role)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	keyID := make([]byte, 8)
+	if _, err := rand.Read(keyID); err != nil {
+		return nil, "", err
+	}
+	encodedSecret := base64.RawURLEncoding.EncodeToString(secret)
+
+	key.ID = hex.EncodeToString(keyID)
+	key.ServiceAccountID = accountID
+	key.OrgID = orgID
+	key.CreatedAt = time.Now().UTC()
+	key.CreatedBy = actor
+	key.LastUsedAt = nil
+	key.RevokedAt = nil
+	key.secretHash = hashAPIKeySecret(encodedSecret)
+	key.prefixes = prefixes
+	apiKeys[key.ID] = key
+
+	audit.Record(audit.Event{Type: AuditAPIKeyCreated, OrgID: orgID, Actor: actor, Target: accountID, Details: map[string]string{"key_id": key.ID, "roles": strings.Join(key.Roles, ",")}})
+	return key.clone(), APIKeyPrefix + key.ID + "_" + encodedSecret, nil
+}
+
+// ListAPIKeys returns the keys of a service account, including revoked ones, newest first.
+func ListAPIKeys(orgID, accountID string) ([]*APIKey, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := lookupServiceAccount(orgID, accountID); !exists {
+		return nil, internalErrors.ErrServiceAccountNotFound
+	}
+	list := make([]*APIKey, 0)
+	for _, key := range apiKeys {
+		if key.ServiceAccountID == accountID {
+			list = append(list, key.clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+// RevokeAPIKey permanently disables a key.
+func RevokeAPIKey(orgID, accountID, keyID, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key, exists := apiKeys[keyID]
+	if !exists || key.OrgID != orgID || key.ServiceAccountID != accountID {
+		return internalErrors.ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+		audit.Record(audit.Event{Type: AuditAPIKeyRevoked, OrgID: orgID, Actor: actor, Target: accountID, Details: map[string]string{"key_id": keyID}})
+	}
+	return nil
+}
+
+// APIKeyPrincipal is who an authenticated API key acts as.
+type APIKeyPrincipal struct {
+	KeyID            string
+	ServiceAccountID string
+	OrgID            string
+	// Role is the highest-ranked role of the key, used for permission checks.
+	Role string
+}
+
+// AuthenticateAPIKey checks a plaintext API key presented from remoteAddr and returns
+// the principal it acts as. The key acts with those of its roles that the service
+// account still holds, so that demoting the account demotes its keys.
+func AuthenticateAPIKey(plaintext, remoteAddr string) (*APIKeyPrincipal, error) {
+	rest, ok := strings.CutPrefix(plaintext, APIKeyPrefix)
+	if !ok {
+		return nil, internalErrors.ErrInvalidAPIKey
+	}
+	keyID, secret, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, internalErrors.ErrInvalidAPIKey
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now().UTC()
+	key, exists := apiKeys[keyID]
+	if !exists || subtle.ConstantTimeCompare([]byte(key.secretHash), []byte(hashAPIKeySecret(secret))) != 1 || !key.active(now) {
+		return nil, internalErrors.ErrInvalidAPIKey
+	}
+	if !key.allowsIP(remoteIP(remoteAddr)) {
+		return nil, internalErrors.ErrAPIKeyIPNotAllowed
+	}
+
+	account, exists := lookupServiceAccount(key.OrgID, key.ServiceAccountID)
+	if !exists {
+		return nil, internalErrors.ErrInvalidAPIKey
+	}
+	principal := &APIKeyPrincipal{KeyID: key.ID, ServiceAccountID: key.ServiceAccountID, OrgID: key.OrgID}
+	for _, role := range key.Roles {
+		if containsString(account.Roles, role) && (principal.Role == "" || roleRank(role) > roleRank(principal.Role)) {
+			principal.Role = role
+		}
+	}
+	if principal.Role == "" {
+		return nil, internalErrors.ErrInvalidAPIKey
+	}
+	key.LastUsedAt = &now
+	return principal, nil
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// remoteIP extracts the IP address from an http.Request RemoteAddr.
+func remoteIP(remoteAddr string) netip.Addr {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
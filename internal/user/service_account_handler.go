@@ -0,0 +1,323 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+type ServiceAccountRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Roles       []string `json:"roles"`
+}
+
+type APIKeyRequest struct {
+	Name       string     `json:"name"`
+	Roles      []string   `json:"roles"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	AllowedIPs []string   `json:"allowed_ips"`
+}
+
+// CreatedAPIKey is returned once when a key is created; it is the only response that
+// carries the plaintext key.
+type CreatedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+// HandleServiceAccounts handles HTTP requests for the /service-accounts endpoint.
+func HandleServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HandleListServiceAccounts(w, r)
+	case http.MethodPost:
+		HandleCreateServiceAccount(w, r)
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+	}
+}
+
+// HandleServiceAccount handles HTTP requests for /service-accounts/{id},
+// /service-accounts/{id}/keys and /service-accounts/{id}/keys/{keyID}.
+func HandleServiceAccount(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/service-accounts/"), "/")
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet:
+			HandleGetServiceAccount(w, r, parts[0])
+		case http.MethodDelete:
+			HandleDeleteServiceAccount(w, r, parts[0])
+		default:
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+		}
+	case len(parts) == 2 && parts[1] == "keys":
+		switch r.Method {
+		case http.MethodGet:
+			HandleListAPIKeys(w, r, parts[0])
+		case http.MethodPost:
+			HandleCreateAPIKey(w, r, parts[0])
+		default:
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+		}
+	case len(parts) == 3 && parts[1] == "keys":
+		if r.Method != http.MethodDelete {
+			errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+			log.Printf("Method not allowed: %s", r.Method)
+			return
+		}
+		HandleRevokeAPIKey(w, r, parts[0], parts[2])
+	default:
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrNotFound)
+		log.Printf("NotFound: %s", r.URL.Path)
+	}
+}
+
+func HandleListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list service accounts", currentUserRole)
+		return
+	}
+
+	list := ListServiceAccounts(orgID)
+	jsonResponse(w, http.StatusOK, list)
+	log.Printf("Service accounts listed: %d accounts", len(list))
+}
+
+func HandleGetServiceAccount(w http.ResponseWriter, r *http.Request, id string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to get a service account", currentUserRole)
+		return
+	}
+
+	account, err := GetServiceAccount(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Service account %s", id)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, account)
+}
+
+func HandleCreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to create a service account", currentUserRole)
+		return
+	}
+
+	var req ServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	account := &ServiceAccount{OrgID: orgID, Name: strings.TrimSpace(req.Name), Description: req.Description, Roles: req.Roles}
+	if err := account.ValidateRequiredFields(); err != nil {
+		errResponse(w, http.StatusBadRequest, err)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+	if err := isValidRoleUpdate(account.Roles, currentUserRole); err != nil {
+		errResponse(w, http.StatusForbidden, err)
+		log.Printf("Forbidden: UserType=%s attempted to create a service account with roles %v", currentUserRole, account.Roles)
+		return
+	}
+	if !checkPolicy(w, r, orgID, "service_accounts.create", "", nil, map[string]any{"roles": account.Roles}) {
+		return
+	}
+
+	// Roles that need approval are requested; until then the account only has the lowest role.
+	var pendingRoles []string
+	if requiresApproval(nil, account.Roles) {
+		pendingRoles, account.Roles = account.Roles, []string{lowestRole()}
+	}
+	if err := CreateServiceAccount(account, currentActor(r)); err != nil {
+		if errors.Is(err, internalMsgs.ErrOrganizationNotFound) {
+			errResponse(w, http.StatusNotFound, err)
+		} else {
+			errResponse(w, http.StatusConflict, err)
+		}
+		log.Printf("Service account creation failed: %v", err)
+		return
+	}
+
+	resp := map[string]string{
+		"id":      account.ID,
+		"message": "Service account created successfully",
+	}
+	if pendingRoles != nil {
+		if req, err := CreateServiceAccountRoleRequest(orgID, account.ID, pendingRoles, currentActor(r)); err != nil {
+			log.Printf("Failed to request roles for service account %s: %v", account.ID, err)
+		} else {
+			resp["role_change_request_id"] = req.ID
+			log.Printf("Roles of service account %s are pending approval: %s", account.ID, req.ID)
+		}
+	}
+	jsonResponse(w, http.StatusCreated, resp)
+	log.Printf("Service account created: %s", account.Name)
+}
+
+func HandleDeleteServiceAccount(w http.ResponseWriter, r *http.Request, id string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to delete a service account", currentUserRole)
+		return
+	}
+	if !canManageServiceAccount(w, orgID, id, currentUserRole) {
+		return
+	}
+	if !checkPolicy(w, r, orgID, "service_accounts.delete", "", nil, map[string]any{"service_account_id": id}) {
+		return
+	}
+
+	if err := DeleteServiceAccount(orgID, id, currentActor(r)); err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Service account %s", id)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("UserType=%s deleted service account %s", currentUserRole, id)
+}
+
+func HandleListAPIKeys(w http.ResponseWriter, r *http.Request, accountID string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to list API keys", currentUserRole)
+		return
+	}
+
+	list, err := ListAPIKeys(orgID, accountID)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Service account %s", accountID)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, list)
+	log.Printf("API keys listed: %d keys for service account %s", len(list), accountID)
+}
+
+func HandleCreateAPIKey(w http.ResponseWriter, r *http.Request, accountID string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to create an API key", currentUserRole)
+		return
+	}
+	if !canManageServiceAccount(w, orgID, accountID, currentUserRole) {
+		return
+	}
+
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errResponse(w, http.StatusBadRequest, internalMsgs.ErrInvalidRequestPayload)
+		log.Printf("BadRequest: %v", err)
+		return
+	}
+
+	if !checkPolicy(w, r, orgID, "service_accounts.create_api_key", "", nil, map[string]any{"service_account_id": accountID}) {
+		return
+	}
+
+	key := &APIKey{Name: strings.TrimSpace(req.Name), Roles: req.Roles, ExpiresAt: req.ExpiresAt, AllowedIPs: req.AllowedIPs}
+	created, plaintext, err := CreateAPIKey(orgID, accountID, key, currentActor(r))
+	if err != nil {
+		if errors.Is(err, internalMsgs.ErrServiceAccountNotFound) {
+			errResponse(w, http.StatusNotFound, err)
+		} else if errors.Is(err, internalMsgs.ErrInvalidAPIKey) {
+			errResponse(w, http.StatusBadRequest, err)
+		} else {
+			errResponse(w, http.StatusInternalServerError, internalMsgs.ErrInternalServerError)
+		}
+		log.Printf("API key creation failed: %v", err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, CreatedAPIKey{APIKey: created, Key: plaintext})
+	log.Printf("API key %s created for service account %s", created.ID, accountID)
+}
+
+func HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request, accountID, keyID string) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	if !isAdmin(currentUserRole) {
+		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
+		log.Printf("Forbidden: UserType=%s attempted to revoke an API key", currentUserRole)
+		return
+	}
+	if !checkPolicy(w, r, orgID, "service_accounts.revoke_api_key", "", nil, map[string]any{"service_account_id": accountID, "key_id": keyID}) {
+		return
+	}
+
+	if err := RevokeAPIKey(orgID, accountID, keyID, currentActor(r)); err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: API key %s", keyID)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	log.Printf("UserType=%s revoked API key %s", currentUserRole, keyID)
+}
+
+// canManageServiceAccount checks that the caller may assign every role of the service
+// account, so that an Admin cannot mint keys for a SuperAdmin account.
+func canManageServiceAccount(w http.ResponseWriter, orgID, id, currentUserRole string) bool {
+	account, err := GetServiceAccount(orgID, id)
+	if err != nil {
+		errResponse(w, http.StatusNotFound, err)
+		log.Printf("NotFound: Service account %s", id)
+		return false
+	}
+	if err := isValidRoleUpdate(account.Roles, currentUserRole); err != nil {
+		errResponse(w, http.StatusForbidden, err)
+		log.Printf("Forbidden: UserType=%s attempted to manage service account %s", currentUserRole, id)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"zpe-cloud-user-management-service/internal/audit"
+)
+
+func TestServiceAccountsAndAPIKeys(t *testing.T) {
+	setupTestStorageWithUsers()
+	audit.Reset()
+
+	admin := callerHeaders("Admin", "")
+
+	if rr := doRequest(t, "POST", "/service-accounts", callerHeaders("Modifier", ""), `{"name":"ci","roles":["Modifier"]}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected non-admin to be forbidden, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", "/service-accounts", admin, `{"name":"root","roles":["SuperAdmin"]}`); rr.Code != http.StatusForbidden {
+		t.Errorf("expected Admin to be unable to create a SuperAdmin account, got %v", rr.Code)
+	}
+	rr := doRequest(t, "POST", "/service-accounts", admin, `{"name":"ci","roles":["Admin","Watcher"]}`)
+	var created map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("expected service account to be created, got %v: %v", rr.Code, err)
+	}
+	accountID := created["id"]
+	if !strings.HasPrefix(accountID, "sa_") {
+		t.Errorf("expected service account ID to be prefixed, got %s", accountID)
+	}
+	if rr := doRequest(t, "POST", "/service-accounts", admin, `{"name":"CI","roles":["Watcher"]}`); rr.Code != http.StatusConflict {
+		t.Errorf("expected duplicate name to conflict, got %v", rr.Code)
+	}
+
+	keysPath := "/service-accounts/" + accountID + "/keys"
+	if rr := doRequest(t, "POST", keysPath, admin, `{"name":"bad","roles":["Modifier"]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected key with a role outside the account to be rejected, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "POST", keysPath, admin, `{"name":"bad","allowed_ips":["not-an-ip"]}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid allowlist to be rejected, got %v", rr.Code)
+	}
+	newKey := func(body string) CreatedAPIKey {
+		rr := doRequest(t, "POST", keysPath, admin, body)
+		var key CreatedAPIKey
+		if err := json.NewDecoder(rr.Body).Decode(&key); err != nil || rr.Code != http.StatusCreated {
+			t.Fatalf("expected key to be created, got %v: %v", rr.Code, err)
+		}
+		return key
+	}
+	readOnly := newKey(`{"name":"read-only","roles":["Watcher"]}`)
+	full := newKey(`{"name":"full","allowed_ips":["10.0.0.0/8"]}`)
+	if !strings.HasPrefix(readOnly.Key, APIKeyPrefix+readOnly.ID+"_") {
+		t.Errorf("unexpected key format: %s", readOnly.Key)
+	}
+
+	rr = doRequest(t, "GET", keysPath, admin, "")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), readOnly.Key) || strings.Contains(rr.Body.String(), `"key"`) {
+		t.Errorf("expected listed keys to omit the secret, got %v: %s", rr.Code, rr.Body.String())
+	}
+
+	// echo records the caller headers AuthMiddleware passes on.
+	var seen http.Header
+	echo := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		name           string
+		header         map[string]string
+		expectedStatus int
+		expectedRole   string
+	}{
+		{name: "No key passes through", header: callerHeaders("Watcher", ""), expectedStatus: http.StatusOK, expectedRole: "Watcher"},
+		{name: "Bearer key", header: map[string]string{"Authorization": "Bearer " + readOnly.Key}, expectedStatus: http.StatusOK, expectedRole: "Watcher"},
+		{name: "X-API-Key header", header: map[string]string{"X-API-Key": full.Key, remoteAddrHeader: "10.1.2.3:5000"}, expectedStatus: http.StatusOK, expectedRole: "Admin"},
+		{name: "Address outside allowlist", header: map[string]string{"X-API-Key": full.Key, remoteAddrHeader: "192.168.1.1:5000"}, expectedStatus: http.StatusForbidden},
+		{name: "Wrong secret", header: map[string]string{"X-API-Key": readOnly.Key + "x"}, expectedStatus: http.StatusUnauthorized},
+		{name: "Unknown key", header: map[string]string{"X-API-Key": APIKeyPrefix + "0000_secret"}, expectedStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			rr := httptest.NewRecorder()
+			echo.ServeHTTP(rr, newTestRequest("GET", "/users", tt.header, ""))
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+			if tt.expectedRole != "" && seen.Get("X-User-Type") != tt.expectedRole {
+				t.Errorf("expected role %s, got %s", tt.expectedRole, seen.Get("X-User-Type"))
+			}
+		})
+	}
+
+	echo.ServeHTTP(httptest.NewRecorder(), newTestRequest("GET", "/users", map[string]string{"X-API-Key": readOnly.Key, "X-User-Type": "SuperAdmin", "X-User-ID": "1", "X-Org-ID": "other"}, ""))
+	if seen.Get("X-User-Type") != "Watcher" || seen.Get("X-User-ID") != accountID || seen.Get("X-Org-ID") != DefaultOrgID || seen.Get("X-API-Key-ID") != readOnly.ID {
+		t.Errorf("expected caller headers to be replaced by the key identity, got %v", seen)
+	}
+	if events := audit.Events(AuditAPIKeyUsed); len(events) == 0 || events[len(events)-1].Details["key_id"] != readOnly.ID {
+		t.Errorf("expected key usage to be audited, got %v", events)
+	}
+
+	// Keys only act with the roles their account still holds.
+	setRoles := func(roles ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := setServiceAccountRoles(DefaultOrgID, accountID, roles); err != nil {
+			t.Fatalf("failed to set roles: %v", err)
+		}
+	}
+	setRoles("Watcher")
+	echo.ServeHTTP(httptest.NewRecorder(), newTestRequest("GET", "/users", map[string]string{"X-API-Key": full.Key, remoteAddrHeader: "10.1.2.3:5000"}, ""))
+	if seen.Get("X-User-Type") != "Watcher" {
+		t.Errorf("expected a key of a demoted account to act as Watcher, got %s", seen.Get("X-User-Type"))
+	}
+	setRoles("Modifier")
+	if rr := doRequest(t, "GET", "/users", map[string]string{"X-API-Key": readOnly.Key}, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a key without any role of its account to be rejected, got %v", rr.Code)
+	}
+	setRoles("Admin", "Watcher")
+
+	if rr := doRequest(t, "DELETE", keysPath+"/"+readOnly.ID, admin, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected key to be revoked, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "GET", "/users", map[string]string{"X-API-Key": readOnly.Key}, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoked key to be rejected, got %v", rr.Code)
+	}
+
+	if rr := doRequest(t, "DELETE", "/service-accounts/"+accountID, admin, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expected service account to be deleted, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "GET", "/users", map[string]string{"X-API-Key": full.Key, remoteAddrHeader: "10.0.0.1:1"}, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected keys of a deleted account to be rejected, got %v", rr.Code)
+	}
+}
@@ -0,0 +1,344 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+	internalErrors "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// SessionConfig controls the lifetime of login sessions.
+type SessionConfig struct {
+	// TTL is the absolute lifetime of a session.
+	TTL time.Duration
+	// IdleTimeout ends sessions that have not been used for this long. Zero disables it.
+	IdleTimeout time.Duration
+}
+
+// Session is the server-side record of a login. Every token derived from the login,
+// including OpenID Connect access tokens, stops working once the session is revoked.
+type Session struct {
+	ID         string    `json:"id"`
+	OrgID      string    `json:"org_id"`
+	UserID     string    `json:"user_id"`
+	Device     string    `json:"device"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	Methods    []string  `json:"amr"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// ImpersonatorID is the admin acting as the user, for impersonation sessions.
+	ImpersonatorID string `json:"impersonator_id,omitempty"`
+	// Current is set when the session is the one making the request.
+	Current bool `json:"current,omitempty"`
+
+	revokedAt  *time.Time
+	secretHash string
+}
+
+// SessionPrincipal is the identity a session token authenticates.
+type SessionPrincipal struct {
+	SessionID string
+	UserID    string
+	OrgID     string
+	// Role is the highest effective role of the user at the time of the request.
+	Role string
+	// ImpersonatorID is the admin acting as the user, for impersonation sessions.
+	ImpersonatorID string
+	// EnrollmentOnly is set while the user has to enroll in MFA before the session can
+	// be used for anything else.
+	EnrollmentOnly bool
+}
+
+// SessionTokenPrefix starts every session token, so that they are easy to recognize.
+const SessionTokenPrefix = "zps_"
+
+// Reasons recorded when sessions are revoked.
+const (
+	SessionRevokedByRequest  = "revoked"
+	SessionRevokedUserDelete = "user_deleted"
+	SessionRevokedDowngrade  = "role_downgrade"
+	SessionRevokedDisabled   = "user_disabled"
+)
+
+// Audit event types emitted for sessions.
+const (
+	AuditSessionCreated = "session.created"
+	AuditSessionRevoked = "session.revoked"
+)
+
+var (
+	sessionMu     sync.RWMutex
+	sessionConfig = SessionConfig{TTL: 12 * time.Hour, IdleTimeout: time.Hour}
+)
+
+// sessions is guarded by mu.
+var sessions = make(map[string]*Session)
+
+// SetSessionConfig replaces the session lifetime settings.
+func SetSessionConfig(cfg SessionConfig) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	sessionConfig = cfg
+}
+
+func currentSessionConfig() SessionConfig {
+	sessionMu.RLock()
+	defer sessionMu.RUnlock()
+	return sessionConfig
+}
+
+func (s *Session) clone() *Session {
+	c := *s
+	c.Methods = append([]string{}, s.Methods...)
+	return &c
+}
+
+// active reports whether the session can still be used at now.
+func (s *Session) active(now time.Time, cfg SessionConfig) bool {
+	if s.revokedAt != nil || !now.Before(s.ExpiresAt) {
+		return false
+	}
+	return cfg.IdleTimeout == 0 || now.Sub(s.LastSeenAt) < cfg.IdleTimeout
+}
+
+// CreateSession records a session for a successful login from the client at remoteAddr
+// and returns it with the plaintext session token, which is not stored.
+func CreateSession(auth *Authentication, remoteAddr, userAgent string) (*Session, string, error) {
+	id, secret, err := newSessionCredentials()
+	if err != nil {
+		return nil, "", err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(auth.OrgID, auth.UserID)
+	if !exists || user.IsDeleted() {
+		return nil, "", internalErrors.ErrUserNotFound
+	}
+
+	s, tok := addSession(user, id, secret, auth.Methods, remoteAddr, userAgent, currentSessionConfig().TTL)
+	audit.Record(audit.Event{Type: AuditSessionCreated, OrgID: s.OrgID, Actor: s.UserID, Target: s.UserID, Details: map[string]string{"session_id": s.ID, "ip": s.IPAddress, "device": s.Device}})
+	return s.clone(), tok, nil
+}
+
+// newSessionCredentials returns a random session ID and encoded secret.
+func newSessionCredentials() (id, secret string, err error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", err
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(idBytes), base64.RawURLEncoding.EncodeToString(secretBytes), nil
+}
+
+// addSession stores a session of user that lasts ttl and returns it with its plaintext
+// token. Expired sessions are dropped on the way. The caller must hold mu.
+func addSession(user *User, id, secret string, methods []string, remoteAddr, userAgent string, ttl time.Duration) (*Session, string) {
+	now := time.Now().UTC()
+	for sid, s := range sessions {
+		if !now.Before(s.ExpiresAt) {
+			delete(sessions, sid)
+		}
+	}
+	var ip string
+	if addr := remoteIP(remoteAddr); addr.IsValid() {
+		ip = addr.String()
+	}
+	s := &Session{
+		ID:         id,
+		OrgID:      user.OrgID,
+		UserID:     user.ID,
+		Device:     deviceName(userAgent),
+		IPAddress:  ip,
+		UserAgent:  userAgent,
+		Methods:    append([]string{}, methods...),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+		secretHash: hashAPIKeySecret(secret),
+	}
+	sessions[s.ID] = s
+	return s, SessionTokenPrefix + s.ID + "_" + secret
+}
+
+// AuthenticateSession checks a plaintext session token and returns the principal it
+// authenticates, recording the use of the session.
+func AuthenticateSession(plaintext string) (*SessionPrincipal, error) {
+	rest, ok := strings.CutPrefix(plaintext, SessionTokenPrefix)
+	if !ok {
+		return nil, internalErrors.ErrInvalidToken
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil, internalErrors.ErrInvalidToken
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, exists := sessions[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(s.secretHash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return nil, internalErrors.ErrInvalidToken
+	}
+	user, err := useSession(s.OrgID, s.ID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	principal := &SessionPrincipal{SessionID: s.ID, UserID: user.ID, OrgID: user.OrgID, ImpersonatorID: s.ImpersonatorID}
+	principal.EnrollmentOnly = !user.mfa.enabled() && mfaRequired(user)
+	if roles := effectiveRoles(user); len(roles) > 0 {
+		principal.Role = roles[0].Role
+	}
+	return principal, nil
+}
+
+// useSession checks that a session is still active and its user can still log in,
+// and records it as seen at now. Impersonation sessions also need the impersonator to
+// still outrank the user. The caller must hold mu.
+func useSession(orgID, id string, now time.Time) (*User, error) {
+	s, exists := sessions[id]
+	if !exists || s.OrgID != orgID || !s.active(now, currentSessionConfig()) {
+		return nil, internalErrors.ErrInvalidToken
+	}
+	user, exists := lookupUser(s.OrgID, s.UserID)
+	if !exists || user.IsDeleted() || user.Status != UserStatusActive {
+		return nil, internalErrors.ErrInvalidToken
+	}
+	if s.ImpersonatorID != "" {
+		impersonator, exists := lookupUser(s.OrgID, s.ImpersonatorID)
+		if !exists || impersonator.IsDeleted() || impersonator.Status != UserStatusActive || !mayImpersonate(impersonator, user) {
+			return nil, internalErrors.ErrInvalidToken
+		}
+	}
+	s.LastSeenAt = now
+	return user, nil
+}
+
+// ListSessions returns the active sessions of a user, most recently used first.
+func ListSessions(orgID, userID string) ([]*Session, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if user, exists := lookupUser(orgID, userID); !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	now, cfg := time.Now().UTC(), currentSessionConfig()
+	list := []*Session{}
+	for _, s := range sessions {
+		if s.OrgID == orgID && s.UserID == userID && s.active(now, cfg) {
+			list = append(list, s.clone())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].LastSeenAt.Equal(list[j].LastSeenAt) {
+			return list[i].LastSeenAt.After(list[j].LastSeenAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// RevokeSession ends one active session of a user.
+func RevokeSession(orgID, userID, sessionID, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	s, exists := sessions[sessionID]
+	if !exists || s.OrgID != orgID || s.UserID != userID || !s.active(time.Now().UTC(), currentSessionConfig()) {
+		return internalErrors.ErrSessionNotFound
+	}
+	revokeSession(s, actor, SessionRevokedByRequest, time.Now().UTC())
+	return nil
+}
+
+// RevokeUserSessions ends all active sessions of a user and returns how many there were.
+func RevokeUserSessions(orgID, userID, actor string) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, userID)
+	if !exists || user.IsDeleted() {
+		return 0, internalErrors.ErrUserNotFound
+	}
+	return revokeUserSessions(user, actor, SessionRevokedByRequest, time.Now().UTC()), nil
+}
+
+// revokeUserSessions ends all active sessions of user. The caller must hold mu.
+func revokeUserSessions(user *User, actor, reason string, now time.Time) int {
+	cfg := currentSessionConfig()
+	revoked := 0
+	for _, s := range sessions {
+		if s.UserID == user.ID && s.OrgID == user.OrgID && s.active(now, cfg) {
+			revokeSession(s, actor, reason, now)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// revokeSessionsOnDowngrade ends the sessions of user if its highest direct role is
+// lower than before, so that tokens issued under the old roles stop working at once.
+// The caller must hold mu.
+func revokeSessionsOnDowngrade(user *User, previous []string, actor string, now time.Time) {
+	if highestRank(user.Roles) < highestRank(previous) {
+		revokeUserSessions(user, actor, SessionRevokedDowngrade, now)
+	}
+}
+
+func highestRank(roles []string) int {
+	rank := -1
+	for _, role := range roles {
+		rank = max(rank, roleRank(role))
+	}
+	return rank
+}
+
+// revokeSession marks s revoked. The caller must hold mu.
+func revokeSession(s *Session, actor, reason string, now time.Time) {
+	s.revokedAt = &now
+	audit.Record(audit.Event{Type: AuditSessionRevoked, OrgID: s.OrgID, Actor: actor, Target: s.UserID, Details: map[string]string{"session_id": s.ID, "reason": reason}})
+}
+
+// deviceName summarizes a User-Agent header as the browser or client and the operating system.
+func deviceName(userAgent string) string {
+	var client, os string
+	for _, c := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"curl/", "curl"}, {"Go-http-client/", "Go HTTP client"},
+	} {
+		if strings.Contains(userAgent, c.token) {
+			client = c.name
+			break
+		}
+	}
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Android", "Android"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			os = o.name
+			break
+		}
+	}
+	switch {
+	case client != "" && os != "":
+		return client + " on " + os
+	case client != "":
+		return client
+	case os != "":
+		return os
+	}
+	return "Unknown device"
+}
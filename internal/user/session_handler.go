@@ -0,0 +1,100 @@
+package user
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
+)
+
+// LoginResponse is returned by a successful login: who logged in and the token of the
+// session the login started, to be sent as "Authorization: Bearer <token>".
+type LoginResponse struct {
+	*Authentication
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// MFAEnrollmentLoginResponse is returned with 403 Forbidden by a login with the right
+// password from a user who has to enroll in MFA. Its session can only be used for the
+// user's MFA endpoints until the enrollment is confirmed.
+type MFAEnrollmentLoginResponse struct {
+	Message string `json:"message"`
+	*LoginResponse
+}
+
+// startSession records a session for auth, which is updated with its ID.
+func startSession(r *http.Request, auth *Authentication) (*LoginResponse, error) {
+	session, tok, err := CreateSession(auth, r.RemoteAddr, r.UserAgent())
+	if err != nil {
+		return nil, err
+	}
+	auth.SessionID = session.ID
+	return &LoginResponse{Authentication: auth, Token: tok, ExpiresAt: session.ExpiresAt}, nil
+}
+
+// HandleUserSessions handles HTTP requests for /users/{id}/sessions, which lists or
+// revokes all sessions of a user, and /users/{id}/sessions/{sessionID}, which revokes
+// one. Users logged in with a session can manage their own sessions; others need
+// permission over the user.
+func HandleUserSessions(w http.ResponseWriter, r *http.Request) {
+	currentUserRole := r.Header.Get("X-User-Type")
+	orgID, ok := callerOrg(w, r)
+	if !ok {
+		return
+	}
+	id, action := splitUserPath(r.URL.Path)
+	sessionID := strings.TrimPrefix(strings.TrimPrefix(action, "sessions"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && sessionID == "":
+	case r.Method == http.MethodDelete:
+	default:
+		errResponse(w, http.StatusMethodNotAllowed, internalMsgs.ErrMethodNotAllowed)
+		log.Printf("Method not allowed: %s", r.Method)
+		return
+	}
+	if !isSessionSelf(r, id) {
+		targetUserRole, err := getUserTypeByID(orgID, id)
+		if err != nil {
+			errResponse(w, http.StatusNotFound, internalMsgs.ErrUserNotFound)
+			log.Printf("User not found: %s", id)
+			return
+		}
+		if !checkUserPermission(w, r, orgID, "users.sessions", id, currentUserRole, targetUserRole) {
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodGet:
+		list, err := ListSessions(orgID, id)
+		if err != nil {
+			errResponse(w, http.StatusNotFound, err)
+			log.Printf("NotFound: User %s", id)
+			return
+		}
+		for _, s := range list {
+			s.Current = s.ID == r.Header.Get("X-Session-ID")
+		}
+		jsonResponse(w, http.StatusOK, list)
+	case sessionID == "":
+		revoked, err := RevokeUserSessions(orgID, id, currentActor(r))
+		if err != nil {
+			errResponse(w, http.StatusNotFound, err)
+			log.Printf("NotFound: User %s", id)
+			return
+		}
+		jsonResponse(w, http.StatusOK, map[string]int{"revoked": revoked})
+		log.Printf("Revoked %d sessions of user %s", revoked, id)
+	default:
+		if err := RevokeSession(orgID, id, sessionID, currentActor(r)); err != nil {
+			errResponse(w, http.StatusNotFound, err)
+			log.Printf("NotFound: Session %s of user %s", sessionID, id)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		log.Printf("Session %s of user %s revoked", sessionID, id)
+	}
+}
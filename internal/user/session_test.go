@@ -0,0 +1,117 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"zpe-cloud-user-management-service/internal/audit"
+)
+
+func TestSessions(t *testing.T) {
+	setupTestStorageWithUsers()
+	audit.Reset()
+	setPassword(t, "2", "obi-wan password")
+	setPassword(t, "3", "r2-d2 password")
+
+	login := func(email, pw, userAgent string) LoginResponse {
+		t.Helper()
+		rr := doRequest(t, "POST", "/login", map[string]string{"User-Agent": userAgent}, fmt.Sprintf(`{"email":%q,"password":%q}`, email, pw))
+		var resp LoginResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || rr.Code != http.StatusOK || !strings.HasPrefix(resp.Token, SessionTokenPrefix) {
+			t.Fatalf("expected login to start a session, got %v: %+v", rr.Code, resp)
+		}
+		return resp
+	}
+	bearer := func(tok string) map[string]string { return map[string]string{"Authorization": "Bearer " + tok} }
+	admin := map[string]string{"X-User-Type": "Admin", "X-User-ID": "1"}
+
+	laptop := login("r2-d2@example.com", "r2-d2 password", "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0")
+	phone := login("r2-d2@example.com", "r2-d2 password", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) Version/17.5 Mobile/15E148 Safari/604.1")
+
+	// Users see their own sessions through their session token.
+	rr := doRequest(t, "GET", "/users/3/sessions", bearer(laptop.Token), "")
+	var list []Session
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list) != 2 {
+		t.Fatalf("expected two sessions, got %v: %+v", rr.Code, list)
+	}
+	for _, s := range list {
+		if s.IPAddress != "192.0.2.10" || s.Current != (s.ID == laptop.SessionID) {
+			t.Errorf("unexpected session %+v", s)
+		}
+		if s.ID == phone.SessionID && s.Device != "Safari on iOS" {
+			t.Errorf("unexpected device %q", s.Device)
+		}
+	}
+	if rr := doRequest(t, "GET", "/users/2/sessions", bearer(laptop.Token), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a Watcher to be unable to list another user's sessions, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "DELETE", "/users/2/sessions", callerHeaders("Watcher", "2"), ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a claimed X-User-ID not to count as the user's own session, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "GET", "/users/3/sessions", map[string]string{"Authorization": "Bearer " + SessionTokenPrefix + laptop.SessionID + "_forged"}, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a forged session token to be rejected, got %v", rr.Code)
+	}
+
+	// Revoking one session only ends that session.
+	if rr := doRequest(t, "DELETE", "/users/3/sessions/"+laptop.SessionID, admin, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected the session to be revoked, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "GET", "/users/3/sessions", bearer(laptop.Token), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the revoked session to be rejected, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "GET", "/users/3/sessions", bearer(phone.Token), ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the other session to keep working, got %v", rr.Code)
+	}
+	if rr := doRequest(t, "DELETE", "/users/3/sessions/"+laptop.SessionID, admin, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a revoked session to be gone, got %v", rr.Code)
+	}
+	if events := audit.Events(AuditSessionRevoked); len(events) != 1 || events[0].Actor != "1" || events[0].Details["session_id"] != laptop.SessionID {
+		t.Errorf("expected one revocation audit event, got %+v", events)
+	}
+
+	// Upgrades keep sessions and take effect at once; downgrades end them.
+	if _, err := UpdateUserRoles(DefaultOrgID, "3", []string{"Modifier"}, "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rr := doRequest(t, "GET", "/users/5/sessions", bearer(phone.Token), ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the upgraded user to manage a Watcher's sessions, got %v", rr.Code)
+	}
+	if _, err := UpdateUserRoles(DefaultOrgID, "3", []string{"Watcher"}, "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rr := doRequest(t, "GET", "/users/3/sessions", bearer(phone.Token), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a downgrade to revoke the session, got %v", rr.Code)
+	}
+
+	// Sessions end when idle for too long.
+	idle := login("r2-d2@example.com", "r2-d2 password", "curl/8.5.0")
+	mu.Lock()
+	sessions[idle.SessionID].LastSeenAt = time.Now().Add(-2 * time.Hour)
+	mu.Unlock()
+	if rr := doRequest(t, "GET", "/users/3/sessions", bearer(idle.Token), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected an idle session to be rejected, got %v", rr.Code)
+	}
+
+	// Revoking all sessions and deleting a user end every session.
+	first := login("obi-wan@example.com", "obi-wan password", "curl/8.5.0")
+	login("obi-wan@example.com", "obi-wan password", "curl/8.5.0")
+	rr = doRequest(t, "DELETE", "/users/2/sessions", admin, "")
+	var revoked map[string]int
+	if json.NewDecoder(rr.Body).Decode(&revoked); rr.Code != http.StatusOK || revoked["revoked"] != 2 {
+		t.Errorf("expected both sessions to be revoked, got %v: %v", rr.Code, revoked)
+	}
+	if rr := doRequest(t, "GET", "/users/2/sessions", bearer(first.Token), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoked sessions to be rejected, got %v", rr.Code)
+	}
+	last := login("obi-wan@example.com", "obi-wan password", "curl/8.5.0")
+	if err := DeleteUser(DefaultOrgID, "2", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rr := doRequest(t, "GET", "/users/2/sessions", bearer(last.Token), ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected deleting the user to revoke the session, got %v", rr.Code)
+	}
+}
@@ -2,89 +2,392 @@ package user
 
 import (
 	"sort"
-	"strconv"
 	"sync"
+	"time"
+	"zpe-cloud-user-management-service/internal/ids"
 	internalErrors "zpe-cloud-user-management-service/internal/msgs"
 )
 
 var (
-	mu        sync.Mutex
-	users     = make(map[string]*User)
-	idCounter int
+	mu    sync.Mutex
+	users = make(map[string]*User)
+	// emailIndex maps organization-scoped canonical email addresses to user IDs.
+	// Soft-deleted users keep their address reserved until they are purged.
+	emailIndex                = make(map[string]string)
+	idGenerator ids.Generator = ids.NewSequential()
 )
 
-// InitializeStorage sets up the in-memory storage for users.
+// InitializeStorage sets up the in-memory storage for organizations, users, groups, role
+// change requests, invitations, service accounts, API keys, sessions, authorization codes,
+// federated logins and identities, access reviews and failed logins.
+// Only the default organization exists afterwards.
+// IDs are assigned sequentially until SetIDGenerator installs another generator.
 func InitializeStorage() {
 	mu.Lock()
 	defer mu.Unlock()
+	orgs = map[string]*Organization{DefaultOrgID: newDefaultOrganization()}
 	users = make(map[string]*User)
-	idCounter = 0
+	emailIndex = make(map[string]string)
+	idGenerator = ids.NewSequential()
+	groups = make(map[string]*Group)
+	groupIDGenerator = ids.NewSequential()
+	roleChangeRequests = make(map[string]*RoleChangeRequest)
+	roleChangeIDGenerator = ids.NewSequential()
+	invitations = make(map[string]*Invitation)
+	invitationIDGenerator = ids.NewSequential()
+	serviceAccounts = make(map[string]*ServiceAccount)
+	apiKeys = make(map[string]*APIKey)
+	sessions = make(map[string]*Session)
+	authorizationCodes = make(map[string]*authorizationCode)
+	federationLogins = make(map[string]*federationLogin)
+	federatedIdentities = make(map[string]string)
+	serviceAccountIDGenerator = ids.NewSequential()
+	accessReviews = make(map[string]*AccessReview)
+	accessReviewIDGenerator = ids.NewSequential()
+	resetLoginThrottle()
 }
 
-func CreateUser(user *User) error {
+// SetIDGenerator replaces the generator used to assign IDs to new users, groups, role
+// change requests, invitations, service accounts and access reviews.
+func SetIDGenerator(g ids.Generator) {
 	mu.Lock()
 	defer mu.Unlock()
+	idGenerator = g
+	groupIDGenerator = g
+	roleChangeIDGenerator = g
+	invitationIDGenerator = g
+	serviceAccountIDGenerator = g
+	accessReviewIDGenerator = g
+}
 
-	for _, u := range users {
-		if u.Email == user.Email {
-			return internalErrors.ErrUserAlreadyExists
-		}
+// lookupUser returns the stored user with the given ID if it belongs to orgID.
+// The caller must hold mu.
+func lookupUser(orgID, id string) (*User, bool) {
+	user, exists := users[id]
+	if !exists || user.OrgID != orgID {
+		return nil, false
+	}
+	return user, true
+}
+
+// emailIndexKey scopes a canonical email address to an organization.
+func emailIndexKey(orgID, email string) string {
+	return orgID + "\x00" + canonicalEmail(email)
+}
+
+// CreateUser stores a new user in the organization named by user.OrgID.
+// Email addresses and unique attributes only need to be unique within that organization.
+func CreateUser(user *User, actor string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := orgs[user.OrgID]; !exists {
+		return internalErrors.ErrOrganizationNotFound
+	}
+	emailKey := emailIndexKey(user.OrgID, user.Email)
+	if _, exists := emailIndex[emailKey]; exists {
+		return internalErrors.ErrUserAlreadyExists
+	}
+	if err := uniqueAttributeConflict(user); err != nil {
+		return err
 	}
 
 	// Assign a new unique ID to the user and add them to the storage.
-	idCounter++
-	user.ID = strconv.Itoa(idCounter)
+	user.ID = idGenerator.NewID()
+
+	now := time.Now().UTC()
+	user.CreatedAt = now
+	user.CreatedBy = actor
+	user.Version = 0
+	user.RoleGrants = nil
+	user.DeletedAt = nil
+	user.DeletedBy = ""
+	if user.Status != UserStatusInvited && user.Status != UserStatusDisabled {
+		user.Status = UserStatusActive
+	}
+	user.touch(actor, now)
+
+	user.emailKey = emailKey
 	users[user.ID] = user
+	emailIndex[emailKey] = user.ID
 	return nil
 }
 
-func GetUser(id string) (*User, error) {
+// GetUser returns a snapshot of the user with the given ID in the organization.
+func GetUser(orgID, id string) (*User, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	user, exists := users[id]
-	if !exists {
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
 		return nil, internalErrors.ErrUserNotFound
 	}
-	return user, nil
+	return user.clone(), nil
 }
 
-func ListUsers() ([]*User, error) {
+// checkVersion enforces an If-Match style precondition: when ifMatch is non-empty,
+// the user's current version must be one of the listed versions.
+func checkVersion(user *User, ifMatch []int64) error {
+	if len(ifMatch) == 0 {
+		return nil
+	}
+	for _, version := range ifMatch {
+		if user.Version == version {
+			return nil
+		}
+	}
+	return internalErrors.ErrPreconditionFailed
+}
+
+// ListOptions controls which users are returned by ListUsers and in which order.
+// Zero-valued filters are ignored.
+type ListOptions struct {
+	IncludeDeleted bool
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	UpdatedAfter   time.Time
+	UpdatedBefore  time.Time
+	CreatedBy      string
+	UpdatedBy      string
+	// Attributes filters on custom attribute values compared in their textual form.
+	Attributes map[string]string
+	SortBy     string
+	Descending bool
+}
+
+// sortFields maps the accepted SortBy values to their ordering functions.
+var sortFields = map[string]func(a, b *User) bool{
+	"id":         func(a, b *User) bool { return a.ID < b.ID },
+	"name":       func(a, b *User) bool { return a.Name < b.Name },
+	"email":      func(a, b *User) bool { return a.Email < b.Email },
+	"created_at": func(a, b *User) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"updated_at": func(a, b *User) bool { return a.UpdatedAt.Before(b.UpdatedAt) },
+	"version":    func(a, b *User) bool { return a.Version < b.Version },
+}
+
+// IsValidSortField reports whether field can be used as ListOptions.SortBy.
+func IsValidSortField(field string) bool {
+	_, ok := sortFields[field]
+	return ok
+}
+
+func (opts ListOptions) matches(user *User) bool {
+	if user.IsDeleted() && !opts.IncludeDeleted {
+		return false
+	}
+	if !opts.CreatedAfter.IsZero() && !user.CreatedAt.After(opts.CreatedAfter) {
+		return false
+	}
+	if !opts.CreatedBefore.IsZero() && !user.CreatedAt.Before(opts.CreatedBefore) {
+		return false
+	}
+	if !opts.UpdatedAfter.IsZero() && !user.UpdatedAt.After(opts.UpdatedAfter) {
+		return false
+	}
+	if !opts.UpdatedBefore.IsZero() && !user.UpdatedAt.Before(opts.UpdatedBefore) {
+		return false
+	}
+	if opts.CreatedBy != "" && user.CreatedBy != opts.CreatedBy {
+		return false
+	}
+	if opts.UpdatedBy != "" && user.UpdatedBy != opts.UpdatedBy {
+		return false
+	}
+	for name, want := range opts.Attributes {
+		value, present := user.Attributes[name]
+		if !present || formatAttribute(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// ListUsers returns the users of an organization that match opts.
+func ListUsers(orgID string, opts ListOptions) ([]*User, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
 	userList := make([]*User, 0, len(users))
 	for _, user := range users {
-		userList = append(userList, user)
+		if user.OrgID == orgID && opts.matches(user) {
+			userList = append(userList, user.clone())
+		}
 	}
 
+	less, ok := sortFields[opts.SortBy]
+	if !ok {
+		less = sortFields["id"]
+	}
+	// Ties are broken by ID so the order is deterministic.
 	sort.Slice(userList, func(i, j int) bool {
-		return userList[i].ID < userList[j].ID
+		a, b := userList[i], userList[j]
+		if opts.Descending {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.ID < b.ID
 	})
 
 	return userList, nil
 }
-func UpdateUserRoles(id string, roles []string) error {
+
+// UpdateUserRoles replaces the roles of a user and returns the updated snapshot.
+// A downgrade revokes the user's sessions.
+// A non-empty ifMatch makes the update conditional on the user's current version.
+func UpdateUserRoles(orgID, id string, roles []string, actor string, ifMatch []int64) (*User, error) {
 	mu.Lock()
 	defer mu.Unlock()
+	return updateUserRoles(orgID, id, roles, actor, ifMatch)
+}
 
-	user, exists := users[id]
-	if !exists {
-		return internalErrors.ErrUserNotFound
+// updateUserRoles is UpdateUserRoles for callers that hold mu.
+func updateUserRoles(orgID, id string, roles []string, actor string, ifMatch []int64) (*User, error) {
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	if err := checkVersion(user, ifMatch); err != nil {
+		return nil, err
 	}
 
+	now := time.Now().UTC()
+	previous := user.Roles
 	user.Roles = roles
-	return nil
+	user.touch(actor, now)
+	revokeSessionsOnDowngrade(user, previous, actor, now)
+	return user.clone(), nil
+}
+
+// UpdateUserAttributes replaces the custom attributes of a user and returns the updated snapshot.
+// The attributes must already be validated against the schema; uniqueness is checked here.
+// A non-empty ifMatch makes the update conditional on the user's current version.
+func UpdateUserAttributes(orgID, id string, attributes map[string]interface{}, actor string, ifMatch []int64) (*User, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	if err := checkVersion(user, ifMatch); err != nil {
+		return nil, err
+	}
+	if err := uniqueAttributeConflict(&User{ID: id, OrgID: orgID, Attributes: attributes}); err != nil {
+		return nil, err
+	}
+
+	user.Attributes = attributes
+	user.touch(actor, time.Now().UTC())
+	return user.clone(), nil
+}
+
+// UpdateUserEmail changes the email address of a user and returns the updated snapshot.
+// The new address is unverified and earlier verification and password reset tokens stop
+// working.
+// A non-empty ifMatch makes the update conditional on the user's current version.
+func UpdateUserEmail(orgID, id, email, actor string, ifMatch []int64) (*User, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	if err := checkVersion(user, ifMatch); err != nil {
+		return nil, err
+	}
+	emailKey := emailIndexKey(orgID, email)
+	if owner, exists := emailIndex[emailKey]; exists && owner != id {
+		return nil, internalErrors.ErrUserAlreadyExists
+	}
+
+	delete(emailIndex, user.emailKey)
+	emailIndex[emailKey] = id
+	user.emailKey = emailKey
+	user.Email = email
+	user.EmailVerified = false
+	user.verificationTokenID = ""
+	user.resetTokenID = ""
+	user.touch(actor, time.Now().UTC())
+	return user.clone(), nil
 }
 
-func DeleteUser(id string) error {
+// DeleteUser soft-deletes a user, recording when and by whom it was deleted, and
+// revokes the user's sessions. The record stays in storage until it is restored or purged.
+// A non-empty ifMatch makes the deletion conditional on the user's current version.
+func DeleteUser(orgID, id, actor string, ifMatch []int64) error {
 	mu.Lock()
 	defer mu.Unlock()
 
-	if _, exists := users[id]; !exists {
+	user, exists := lookupUser(orgID, id)
+	if !exists || user.IsDeleted() {
 		return internalErrors.ErrUserNotFound
 	}
+	if err := checkVersion(user, ifMatch); err != nil {
+		return err
+	}
 
-	delete(users, id)
+	deletedAt := time.Now().UTC()
+	user.DeletedAt = &deletedAt
+	user.DeletedBy = actor
+	user.touch(actor, deletedAt)
+	revokeUserSessions(user, actor, SessionRevokedUserDelete, deletedAt)
 	return nil
 }
+
+// RestoreUser clears the deletion marker of a soft-deleted user and returns the restored snapshot.
+// A non-empty ifMatch makes the restore conditional on the user's current version.
+func RestoreUser(orgID, id, actor string, ifMatch []int64) (*User, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists {
+		return nil, internalErrors.ErrUserNotFound
+	}
+	if !user.IsDeleted() {
+		return nil, internalErrors.ErrUserNotDeleted
+	}
+	if err := checkVersion(user, ifMatch); err != nil {
+		return nil, err
+	}
+
+	user.DeletedAt = nil
+	user.DeletedBy = ""
+	user.touch(actor, time.Now().UTC())
+	return user.clone(), nil
+}
+
+// PurgeDeletedUsers permanently removes users that were soft-deleted before the given time.
+// It returns the number of purged users.
+func PurgeDeletedUsers(before time.Time) int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	purged := 0
+	for _, user := range users {
+		if user.IsDeleted() && user.DeletedAt.Before(before) {
+			removeUser(user)
+			purged++
+		}
+	}
+	return purged
+}
+
+// removeUser drops a user from the store, releasing their email address and group
+// memberships. The caller must hold mu.
+func removeUser(user *User) {
+	delete(users, user.ID)
+	delete(emailIndex, user.emailKey)
+	removeFromAllGroups(user.ID)
+	for id, s := range sessions {
+		if s.UserID == user.ID {
+			delete(sessions, id)
+		}
+	}
+}
@@ -6,14 +6,20 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 	internalMsgs "zpe-cloud-user-management-service/internal/msgs"
 )
 
 // roleHierarchy defines the hierarchy of roles and their allowed subordinate roles.
+// SuperAdmin is a platform-level role that can also manage organizations.
 var roleHierarchy = map[string][]string{
-	"Admin":    {"Modifier", "Watcher"},
-	"Modifier": {"Watcher"},
-	"Watcher":  {},
+	"SuperAdmin": {"Admin", "Modifier", "Watcher"},
+	"Admin":      {"Modifier", "Watcher"},
+	"Modifier":   {"Watcher"},
+	"Watcher":    {},
 }
 
 // isRoleExists checks if a role exists in the role hierarchy.
@@ -22,26 +28,32 @@ func isRoleExists(role string) bool {
 	return exists
 }
 
-// isValidCrudOperation checks if the current user's role can perform CRUD operations on the target user's role.
-func isValidCrudOperation(currentUserRole, targetUserRole string) bool {
-	if currentUserRole == "Admin" {
-		return true
+// roleRank orders roles by how many roles they may manage; unknown roles rank lowest.
+func roleRank(role string) int {
+	subordinates, exists := roleHierarchy[role]
+	if !exists {
+		return -1
 	}
+	return len(subordinates)
+}
 
-	allowedRoles := roleHierarchy[currentUserRole]
-	for _, role := range allowedRoles {
-		if role == targetUserRole {
-			return true
-		}
-	}
-	return false
+// isAdmin reports whether a role has administrative rights within an organization.
+func isAdmin(role string) bool {
+	return role == "Admin" || role == "SuperAdmin"
+}
+
+// isValidCrudOperation checks if the current user's role can perform CRUD operations on the target user's role.
+func isValidCrudOperation(currentUserRole, targetUserRole string) bool {
+	ok, _ := manageRule(currentUserRole, targetUserRole)
+	return ok
 }
 
 // checkPermission checks if the current user has permission to perform actions on the required role.
 func checkPermission(w http.ResponseWriter, currentUserRole, requiredRole string) bool {
-	if !isRoleExists(currentUserRole) || !isValidCrudOperation(currentUserRole, requiredRole) {
+	var d AuthzDecision
+	if !d.permission(currentUserRole, requiredRole) {
 		errResponse(w, http.StatusForbidden, internalMsgs.ErrForbidden)
-		log.Printf("Forbidden: UserType=%s does not have permission for role %s", currentUserRole, requiredRole)
+		log.Printf("Forbidden: UserType=%s does not have permission for role %s (%s)", currentUserRole, requiredRole, d.reason())
 		return false
 	}
 	return true
@@ -49,18 +61,8 @@ func checkPermission(w http.ResponseWriter, currentUserRole, requiredRole string
 
 // isValidRoleUpdate checks if the new roles can be assigned by the current user.
 func isValidRoleUpdate(newRoles []string, sessionUserRole string) error {
-	for _, role := range newRoles {
-		if !isRoleExists(role) {
-			return fmt.Errorf("%w: %s", internalMsgs.ErrInvalidRole, role)
-		}
-		if role == "Admin" && sessionUserRole != "Admin" {
-			return fmt.Errorf("%w: %s", internalMsgs.ErrInsufficientPermissions, role)
-		}
-		if !isValidCrudOperation(sessionUserRole, role) {
-			return fmt.Errorf("%w: %s", internalMsgs.ErrInsufficientPermissions, role)
-		}
-	}
-	return nil
+	var d AuthzDecision
+	return d.roleAssignment(newRoles, sessionUserRole)
 }
 
 func jsonResponse(w http.ResponseWriter, code int, payload interface{}) {
@@ -76,13 +78,188 @@ func errResponse(w http.ResponseWriter, code int, err error) {
 	jsonResponse(w, code, map[string]string{"message": err.Error()})
 }
 
-func getUserTypeByID(id string) (string, error) {
-	user, err := GetUser(id)
+// splitUserPath splits a /users/{id}/{action} path into the user ID and the optional action.
+func splitUserPath(path string) (id, action string) {
+	rest := strings.TrimPrefix(path, "/users/")
+	id, action, _ = strings.Cut(rest, "/")
+	return id, action
+}
+
+// parseListOptions builds ListOptions from the query parameters of a list request.
+// Time filters are RFC 3339 timestamps and order is either "asc" or "desc".
+func parseListOptions(query url.Values) (ListOptions, error) {
+	opts := ListOptions{
+		IncludeDeleted: query.Get("include_deleted") == "true",
+		CreatedBy:      query.Get("created_by"),
+		UpdatedBy:      query.Get("updated_by"),
+		SortBy:         query.Get("sort"),
+	}
+
+	for param, values := range query {
+		if name, ok := strings.CutPrefix(param, "attr."); ok && len(values) > 0 {
+			if opts.Attributes == nil {
+				opts.Attributes = make(map[string]string)
+			}
+			opts.Attributes[name] = values[0]
+		}
+	}
+
+	if opts.SortBy != "" && !IsValidSortField(opts.SortBy) {
+		return opts, fmt.Errorf("invalid sort field: %s", opts.SortBy)
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, fmt.Errorf("invalid sort order: %s", order)
+	}
+
+	timeFilters := map[string]*time.Time{
+		"created_after":  &opts.CreatedAfter,
+		"created_before": &opts.CreatedBefore,
+		"updated_after":  &opts.UpdatedAfter,
+		"updated_before": &opts.UpdatedBefore,
+	}
+	for param, target := range timeFilters {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", param, err)
+		}
+		*target = t
+	}
+
+	return opts, nil
+}
+
+// splitGroupPath splits a /groups/{id}/{sub}/{memberID} path into its parts.
+func splitGroupPath(path string) (id, sub, memberID string) {
+	rest := strings.TrimPrefix(path, "/groups/")
+	id, rest, _ = strings.Cut(rest, "/")
+	sub, memberID, _ = strings.Cut(rest, "/")
+	return id, sub, memberID
+}
+
+// etag formats a user version as a strong entity tag.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch converts an If-Match header into the versions a mutation may apply to.
+// An absent header or "*" imposes no precondition. A header that names no valid
+// version can never match and yields ErrPreconditionFailed.
+func parseIfMatch(header string) ([]int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	var versions []int64
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		return nil, internalMsgs.ErrPreconditionFailed
+	}
+	return versions, nil
+}
+
+// etagMatchesNone reports whether an If-None-Match header matches the given version,
+// using the weak comparison that HTTP allows for GET requests.
+func etagMatchesNone(header string, version int64) bool {
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// callerOrg resolves the organization a request acts on from the X-Org-ID header,
+// defaulting to DefaultOrgID. It writes a 404 response and returns false when the
+// organization does not exist.
+func callerOrg(w http.ResponseWriter, r *http.Request) (string, bool) {
+	orgID := r.Header.Get("X-Org-ID")
+	if orgID == "" {
+		orgID = DefaultOrgID
+	}
+	if !OrganizationExists(orgID) {
+		errResponse(w, http.StatusNotFound, internalMsgs.ErrOrganizationNotFound)
+		log.Printf("NotFound: Organization %s", orgID)
+		return "", false
+	}
+	return orgID, true
+}
+
+// currentActor identifies the caller of a request for attribution purposes.
+// It prefers the X-User-ID header and falls back to the caller's role.
+func currentActor(r *http.Request) string {
+	if id := r.Header.Get("X-User-ID"); id != "" {
+		return id
+	}
+	return r.Header.Get("X-User-Type")
+}
+
+// authenticatedActor returns the ID of the user or service account the caller proved to
+// be with a session token or an API key, or "" when X-User-ID is only claimed.
+// X-Session-ID and X-API-Key-ID are only set by AuthMiddleware.
+func authenticatedActor(r *http.Request) string {
+	if r.Header.Get("X-Session-ID") == "" && r.Header.Get("X-API-Key-ID") == "" {
+		return ""
+	}
+	return r.Header.Get("X-User-ID")
+}
+
+// requireAuthenticatedActor is authenticatedActor for handlers that must know who the
+// caller is. It writes a 401 response and returns "" when the caller did not prove it.
+func requireAuthenticatedActor(w http.ResponseWriter, r *http.Request, attempt string) string {
+	actor := authenticatedActor(r)
+	if actor == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		errResponse(w, http.StatusUnauthorized, internalMsgs.ErrAuthenticationRequired)
+		log.Printf("Unauthorized: UserType=%s attempted to %s without a session or API key", r.Header.Get("X-User-Type"), attempt)
+	}
+	return actor
+}
+
+// getUserTypeByID returns the highest-ranked effective role of a user, taking
+// roles inherited from groups into account.
+func getUserTypeByID(orgID, id string) (string, error) {
+	roles, err := GetEffectiveRoles(orgID, id)
 	if err != nil {
 		return "", internalMsgs.ErrUserNotFound
 	}
-	if len(user.Roles) > 0 {
-		return user.Roles[0], nil
+	if len(roles) > 0 {
+		return roles[0].Role, nil
+	}
+	return "", errors.New("user has no roles")
+}
+
+// getDeletedUserTypeByID is getUserTypeByID for a user that may be deleted.
+func getDeletedUserTypeByID(orgID, id string) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	user, exists := lookupUser(orgID, id)
+	if !exists {
+		return "", internalMsgs.ErrUserNotFound
+	}
+	if role := topRole(user); role != "" {
+		return role, nil
 	}
 	return "", errors.New("user has no roles")
 }